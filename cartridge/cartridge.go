@@ -0,0 +1,437 @@
+package cartridge
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// BankSize is the size of a single ROM bank.
+const BankSize = 0x4000
+
+// RAMBankSize is the size of a single bank of external RAM.
+const RAMBankSize = 0x2000
+
+// Cartridge is a cartridge inserted into the Gameboy: its ROM, external RAM, and the memory bank controller that maps
+// them into the address space at $0000-$7FFF and $A000-$BFFF.
+type Cartridge interface {
+	// Read returns the value at an address within $0000-$7FFF or $A000-$BFFF.
+	Read(addr uint16) uint8
+	// Write writes to the registers of the memory bank controller at $0000-$7FFF, or to external RAM at $A000-$BFFF.
+	Write(addr uint16, v uint8)
+
+	// Header returns the header of the ROM.
+	Header() *Header
+	// ROMBank returns the bank currently mapped into $4000-$7FFF.
+	ROMBank() int
+	// RAM returns the external RAM, which is preserved by a battery on some cartridges.
+	RAM() []byte
+
+	// SaveState encodes the external RAM, and the registers of the memory bank controller.
+	SaveState(e *state.Encoder)
+	// LoadState decodes a state encoded by SaveState, with the version of the encoding.
+	LoadState(d *state.Decoder, version int) error
+}
+
+// Clock is implemented by cartridges with a real time clock. The clock keeps virtual time: it advances only as the
+// machine steps it with the clock cycles it executes, so that it runs at the speed of the emulation, and the same
+// every time a ROM runs.
+type Clock interface {
+	// Step advances the clock by a number of clock cycles.
+	Step(cycles int)
+}
+
+// LowBanked is implemented by cartridges that can map a bank other than 0 into $0000-$3FFF, as MBC1 does in its second
+// banking mode.
+type LowBanked interface {
+	// LowROMBank returns the bank mapped into $0000-$3FFF.
+	LowROMBank() int
+}
+
+// New constructs the cartridge described by the header of rom.
+func New(rom []byte) (Cartridge, error) {
+	h, err := ParseHeader(rom)
+	if err != nil {
+		return nil, err
+	}
+	b := base{header: h, rom: rom, ram: make([]byte, h.RAMBytes())}
+
+	switch h.Type {
+	case ROMOnly, ROMRAM, ROMRAMBattery:
+		return &romOnly{base: b}, nil
+
+	case MBC1, MBC1RAM, MBC1RAMBattery:
+		return &mbc1{base: b, bank: 1}, nil
+
+	case MBC2, MBC2Battery:
+		// MBC2 has 512 half bytes of RAM built in
+		b.ram = make([]byte, 512)
+		return &mbc2{base: b, bank: 1}, nil
+
+	case MBC3TimerBattery, MBC3TimerRAMBattery, MBC3, MBC3RAM, MBC3RAMBattery:
+		return &mbc3{base: b, bank: 1}, nil
+
+	case MBC5, MBC5RAM, MBC5RAMBattery, MBC5Rumble, MBC5RumbleRAM, MBC5RumbleRAMBattery:
+		return &mbc5{base: b, bank: 1}, nil
+
+	default:
+		return nil, fmt.Errorf("cartridge: unsupported cartridge type %s", h.Type)
+	}
+}
+
+// base holds what every memory bank controller has in common.
+type base struct {
+	header *Header
+	rom    []byte
+	ram    []byte
+}
+
+func (b *base) Header() *Header {
+	return b.header
+}
+
+func (b *base) RAM() []byte {
+	return b.ram
+}
+
+// romByte reads from a ROM bank, wrapping around banks the ROM does not have.
+func (b *base) romByte(bank int, addr uint16) uint8 {
+	banks := len(b.rom) / BankSize
+	if banks == 0 {
+		banks = 1
+	}
+	off := (bank%banks)*BankSize + int(addr&(BankSize-1))
+	if off >= len(b.rom) {
+		return 0xFF
+	}
+	return b.rom[off]
+}
+
+// ramOffset returns the offset of an external RAM address within a RAM bank, wrapping around banks the cartridge does
+// not have.
+func (b *base) ramOffset(bank int, addr uint16) (int, bool) {
+	if len(b.ram) == 0 {
+		return 0, false
+	}
+	off := bank*RAMBankSize + int(addr-0xA000)
+	return off % len(b.ram), true
+}
+
+func (b *base) readRAM(enabled bool, bank int, addr uint16) uint8 {
+	off, ok := b.ramOffset(bank, addr)
+	if !enabled || !ok {
+		return 0xFF
+	}
+	return b.ram[off]
+}
+
+func (b *base) writeRAM(enabled bool, bank int, addr uint16, v uint8) {
+	if off, ok := b.ramOffset(bank, addr); enabled && ok {
+		b.ram[off] = v
+	}
+}
+
+// romOnly is a cartridge without a memory bank controller, with up to 32 KiB of ROM and 8 KiB of RAM.
+type romOnly struct {
+	base
+}
+
+func (c *romOnly) Read(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return c.romByte(int(addr/BankSize), addr)
+	}
+	return c.readRAM(true, 0, addr)
+}
+
+func (c *romOnly) Write(addr uint16, v uint8) {
+	if addr >= 0xA000 {
+		c.writeRAM(true, 0, addr, v)
+	}
+}
+
+func (c *romOnly) ROMBank() int {
+	return 1
+}
+
+// mbc1 supports up to 2 MiB of ROM, and 32 KiB of RAM.
+type mbc1 struct {
+	base
+	ramEnabled bool
+	// bank is the low 5 bits of the ROM bank
+	bank int
+	// upper is the 2 bit register selecting either the RAM bank, or the upper bits of the ROM bank
+	upper int
+	// mode 1 applies upper to $0000-$3FFF and RAM too
+	mode int
+}
+
+func (c *mbc1) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x4000:
+		if c.mode == 1 {
+			return c.romByte(c.upper<<5, addr)
+		}
+		return c.romByte(0, addr)
+
+	case addr < 0x8000:
+		return c.romByte(c.ROMBank(), addr)
+
+	default:
+		return c.readRAM(c.ramEnabled, c.ramBank(), addr)
+	}
+}
+
+func (c *mbc1) Write(addr uint16, v uint8) {
+	switch {
+	case addr < 0x2000:
+		c.ramEnabled = v&0x0F == 0x0A
+
+	case addr < 0x4000:
+		c.bank = int(v & 0x1F)
+		if c.bank == 0 {
+			c.bank = 1
+		}
+
+	case addr < 0x6000:
+		c.upper = int(v & 0x03)
+
+	case addr < 0x8000:
+		c.mode = int(v & 0x01)
+
+	default:
+		c.writeRAM(c.ramEnabled, c.ramBank(), addr, v)
+	}
+}
+
+func (c *mbc1) ROMBank() int {
+	return c.upper<<5 | c.bank
+}
+
+// LowROMBank implements LowBanked.
+func (c *mbc1) LowROMBank() int {
+	if c.mode == 1 {
+		return c.upper << 5
+	}
+	return 0
+}
+
+func (c *mbc1) ramBank() int {
+	if c.mode == 1 {
+		return c.upper
+	}
+	return 0
+}
+
+// mbc2 supports up to 256 KiB of ROM, and has 512 half bytes of RAM built in.
+type mbc2 struct {
+	base
+	ramEnabled bool
+	bank       int
+}
+
+func (c *mbc2) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x4000:
+		return c.romByte(0, addr)
+
+	case addr < 0x8000:
+		return c.romByte(c.bank, addr)
+
+	default:
+		if !c.ramEnabled {
+			return 0xFF
+		}
+		// only the low 4 bits are stored, and the built in RAM repeats across $A000-$BFFF
+		return c.ram[addr&0x1FF] | 0xF0
+	}
+}
+
+func (c *mbc2) Write(addr uint16, v uint8) {
+	switch {
+	case addr < 0x4000:
+		// bit 8 of the address selects the register
+		if addr&0x100 == 0 {
+			c.ramEnabled = v&0x0F == 0x0A
+		} else {
+			c.bank = int(v & 0x0F)
+			if c.bank == 0 {
+				c.bank = 1
+			}
+		}
+
+	case addr >= 0xA000 && c.ramEnabled:
+		c.ram[addr&0x1FF] = v & 0x0F
+	}
+}
+
+func (c *mbc2) ROMBank() int {
+	return c.bank
+}
+
+// mbc3 supports up to 2 MiB of ROM, 32 KiB of RAM, and a real time clock on some cartridges.
+type mbc3 struct {
+	base
+	ramEnabled bool
+	bank       int
+	// ramSelect selects either a RAM bank from 0 to 3, or a clock register from $08 to $0C.
+	ramSelect int
+	// rtc holds the clock registers: seconds, minutes, hours, and the low and high bits of the day counter
+	rtc     [5]uint8
+	latched [5]uint8
+	latch   uint8
+	// rtcCycles counts the clock cycles since the seconds last ticked.
+	rtcCycles int
+}
+
+func (c *mbc3) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x4000:
+		return c.romByte(0, addr)
+
+	case addr < 0x8000:
+		return c.romByte(c.bank, addr)
+
+	case c.ramSelect >= 0x08 && c.ramSelect <= 0x0C:
+		if !c.ramEnabled {
+			return 0xFF
+		}
+		return c.latched[c.ramSelect-0x08]
+
+	default:
+		return c.readRAM(c.ramEnabled, c.ramSelect&0x03, addr)
+	}
+}
+
+func (c *mbc3) Write(addr uint16, v uint8) {
+	switch {
+	case addr < 0x2000:
+		c.ramEnabled = v&0x0F == 0x0A
+
+	case addr < 0x4000:
+		c.bank = int(v & 0x7F)
+		if c.bank == 0 {
+			c.bank = 1
+		}
+
+	case addr < 0x6000:
+		c.ramSelect = int(v & 0x0F)
+
+	case addr < 0x8000:
+		// writing 0 then 1 latches the clock registers
+		if c.latch == 0 && v == 1 {
+			c.latched = c.rtc
+		}
+		c.latch = v
+
+	case c.ramSelect >= 0x08 && c.ramSelect <= 0x0C:
+		if c.ramEnabled {
+			c.rtc[c.ramSelect-0x08] = v
+			c.latched[c.ramSelect-0x08] = v
+			// writing the seconds resets the divider of the clock
+			if c.ramSelect == 0x08 {
+				c.rtcCycles = 0
+			}
+		}
+
+	default:
+		c.writeRAM(c.ramEnabled, c.ramSelect&0x03, addr, v)
+	}
+}
+
+func (c *mbc3) ROMBank() int {
+	return c.bank
+}
+
+// Bits of the high register of the day counter.
+const (
+	rtcHalt  = 1 << 6
+	rtcCarry = 1 << 7
+)
+
+// rtcRate is the number of clock cycles per second of the real time clock.
+const rtcRate = 4194304
+
+// Step implements Clock.
+func (c *mbc3) Step(cycles int) {
+	if c.rtc[4]&rtcHalt != 0 {
+		return
+	}
+	c.rtcCycles += cycles
+	for c.rtcCycles >= rtcRate {
+		c.rtcCycles -= rtcRate
+		c.tick()
+	}
+}
+
+// tick advances the clock by a second. Registers written with values out of their range count up to the limit of
+// their bits, and wrap to 0 without carrying, as on the hardware.
+func (c *mbc3) tick() {
+	c.rtc[0] = (c.rtc[0] + 1) & 0x3F
+	if c.rtc[0] != 60 {
+		return
+	}
+	c.rtc[0] = 0
+	c.rtc[1] = (c.rtc[1] + 1) & 0x3F
+	if c.rtc[1] != 60 {
+		return
+	}
+	c.rtc[1] = 0
+	c.rtc[2] = (c.rtc[2] + 1) & 0x1F
+	if c.rtc[2] != 24 {
+		return
+	}
+	c.rtc[2] = 0
+	day := (int(c.rtc[4]&1)<<8 | int(c.rtc[3])) + 1
+	if day == 512 {
+		day = 0
+		c.rtc[4] |= rtcCarry
+	}
+	c.rtc[3] = uint8(day)
+	c.rtc[4] = c.rtc[4]&^1 | uint8(day>>8)
+}
+
+// mbc5 supports up to 8 MiB of ROM, and 128 KiB of RAM.
+type mbc5 struct {
+	base
+	ramEnabled bool
+	bank       int
+	ramBank    int
+}
+
+func (c *mbc5) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x4000:
+		return c.romByte(0, addr)
+
+	case addr < 0x8000:
+		return c.romByte(c.bank, addr)
+
+	default:
+		return c.readRAM(c.ramEnabled, c.ramBank, addr)
+	}
+}
+
+func (c *mbc5) Write(addr uint16, v uint8) {
+	switch {
+	case addr < 0x2000:
+		c.ramEnabled = v == 0x0A
+
+	case addr < 0x3000:
+		c.bank = c.bank&0x100 | int(v)
+
+	case addr < 0x4000:
+		c.bank = c.bank&0xFF | int(v&1)<<8
+
+	case addr < 0x6000:
+		c.ramBank = int(v & 0x0F)
+
+	case addr < 0x8000:
+
+	default:
+		c.writeRAM(c.ramEnabled, c.ramBank, addr, v)
+	}
+}
+
+func (c *mbc5) ROMBank() int {
+	return c.bank
+}
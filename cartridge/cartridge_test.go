@@ -0,0 +1,141 @@
+package cartridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testROM returns a ROM of the given type, where the first byte of every bank is the bank number.
+func testROM(typ Type, banks int, ramSize uint8) []byte {
+	rom := make([]byte, banks*BankSize)
+	for bank := 0; bank < banks; bank++ {
+		rom[bank*BankSize] = uint8(bank)
+	}
+	rom[TypeOffset] = byte(typ)
+	rom[ROMSizeOffset] = ROMSizeCode(banks)
+	rom[RAMSizeOffset] = ramSize
+	return rom
+}
+
+func TestROMOnly(t *testing.T) {
+	c, err := New(testROM(ROMOnly, 2, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), c.Read(0x4000))
+	c.Write(0x2000, 5)
+	assert.Equal(t, uint8(1), c.Read(0x4000))
+	assert.Equal(t, 1, c.ROMBank())
+	assert.Equal(t, uint8(0xFF), c.Read(0xA000))
+}
+
+func TestMBC1(t *testing.T) {
+	c, err := New(testROM(MBC1RAMBattery, 64, 0x03))
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), c.Read(0x4000))
+
+	c.Write(0x2000, 0)
+	assert.Equal(t, 1, c.ROMBank())
+	c.Write(0x2000, 0x1F)
+	c.Write(0x4000, 1)
+	assert.Equal(t, 0x3F, c.ROMBank())
+	assert.Equal(t, uint8(0x3F), c.Read(0x4000))
+	assert.Equal(t, uint8(0), c.Read(0x0000))
+
+	// RAM is disabled until $0A is written to $0000-$1FFF
+	c.Write(0xA000, 0x42)
+	assert.Equal(t, uint8(0xFF), c.Read(0xA000))
+	c.Write(0x0000, 0x0A)
+	c.Write(0xA000, 0x42)
+	assert.Equal(t, uint8(0x42), c.Read(0xA000))
+
+	// in mode 1, the upper bits select the RAM bank, and the bank of $0000-$3FFF
+	assert.Equal(t, 0, c.(LowBanked).LowROMBank())
+	c.Write(0x6000, 1)
+	assert.Equal(t, uint8(0x20), c.Read(0x0000))
+	assert.Equal(t, 0x20, c.(LowBanked).LowROMBank())
+	assert.Equal(t, uint8(0), c.Read(0xA000))
+	c.Write(0x4000, 0)
+	assert.Equal(t, uint8(0x42), c.Read(0xA000))
+	assert.Equal(t, uint8(0x42), c.RAM()[0])
+}
+
+func TestMBC2(t *testing.T) {
+	c, err := New(testROM(MBC2Battery, 16, 0))
+	assert.NoError(t, err)
+	c.Write(0x2100, 5)
+	assert.Equal(t, uint8(5), c.Read(0x4000))
+	c.Write(0x0000, 0x0A)
+	c.Write(0xA001, 0x3C)
+	assert.Equal(t, uint8(0xFC), c.Read(0xA001))
+	assert.Equal(t, uint8(0xFC), c.Read(0xA201))
+}
+
+func TestMBC3(t *testing.T) {
+	c, err := New(testROM(MBC3TimerRAMBattery, 128, 0x03))
+	assert.NoError(t, err)
+	c.Write(0x2000, 0x7F)
+	assert.Equal(t, uint8(0x7F), c.Read(0x4000))
+	c.Write(0x0000, 0x0A)
+	c.Write(0x4000, 0x08)
+	c.Write(0xA000, 30)
+	c.Write(0x4000, 0x02)
+	c.Write(0xA000, 0x55)
+	assert.Equal(t, uint8(0x55), c.Read(0xA000))
+	c.Write(0x4000, 0x08)
+	assert.Equal(t, uint8(30), c.Read(0xA000))
+}
+
+func TestMBC3Clock(t *testing.T) {
+	c, err := New(testROM(MBC3TimerRAMBattery, 2, 0x03))
+	require.NoError(t, err)
+	c.Write(0x0000, 0x0A)
+	// 23:59:59 on day 511
+	for i, v := range []uint8{59, 59, 23, 0xFF, 0x01} {
+		c.Write(0x4000, uint8(0x08+i))
+		c.Write(0xA000, v)
+	}
+	latch := func() []uint8 {
+		c.Write(0x6000, 0)
+		c.Write(0x6000, 1)
+		var regs []uint8
+		for i := 0; i < 5; i++ {
+			c.Write(0x4000, uint8(0x08+i))
+			regs = append(regs, c.Read(0xA000))
+		}
+		return regs
+	}
+
+	clock := c.(Clock)
+	clock.Step(rtcRate - 1)
+	assert.Equal(t, []uint8{59, 59, 23, 0xFF, 0x01}, latch())
+	// the day counter overflows
+	clock.Step(1)
+	assert.Equal(t, []uint8{0, 0, 0, 0, rtcCarry}, latch())
+	clock.Step(61 * rtcRate)
+	assert.Equal(t, []uint8{1, 1, 0, 0, rtcCarry}, latch())
+
+	// the clock does not advance while it is halted
+	c.Write(0x4000, 0x0C)
+	c.Write(0xA000, rtcHalt)
+	clock.Step(10 * rtcRate)
+	assert.Equal(t, []uint8{1, 1, 0, 0, rtcHalt}, latch())
+}
+
+func TestMBC5(t *testing.T) {
+	c, err := New(testROM(MBC5RAM, 512, 0x04))
+	assert.NoError(t, err)
+	c.Write(0x2000, 0x00)
+	assert.Equal(t, uint8(0), c.Read(0x4000))
+	c.Write(0x2000, 0x23)
+	c.Write(0x3000, 0x01)
+	assert.Equal(t, 0x123, c.ROMBank())
+	assert.Equal(t, uint8(0x23), c.Read(0x4000))
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(make([]byte, 0x100))
+	assert.ErrorIs(t, err, ErrShortROM)
+	_, err = New(testROM(PocketCamera, 2, 0))
+	assert.EqualError(t, err, "cartridge: unsupported cartridge type POCKET CAMERA")
+}
@@ -0,0 +1,170 @@
+// Package cartridge implements Gameboy cartridges: the ROM header and memory bank controllers.
+package cartridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Offsets of the fields of the cartridge header, which occupies $0100-$014F of the ROM.
+const (
+	EntryPointOffset     = 0x100
+	LogoOffset           = 0x104
+	TitleOffset          = 0x134
+	CGBFlagOffset        = 0x143
+	NewLicenseeOffset    = 0x144
+	SGBFlagOffset        = 0x146
+	TypeOffset           = 0x147
+	ROMSizeOffset        = 0x148
+	RAMSizeOffset        = 0x149
+	DestinationOffset    = 0x14A
+	OldLicenseeOffset    = 0x14B
+	VersionOffset        = 0x14C
+	HeaderChecksumOffset = 0x14D
+	GlobalChecksumOffset = 0x14E
+	// HeaderEnd is the first byte following the header.
+	HeaderEnd = 0x150
+)
+
+// TitleLength is the maximum length of a title. Newer cartridges use the last bytes of the title for the
+// manufacturer code and CGB flag, shortening it to 11 or 15 characters.
+const TitleLength = 16
+
+// Logo is the Nintendo logo, which the boot ROM verifies before starting a cartridge.
+var Logo = [48]byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// CGB flag values
+const (
+	// CGBSupported marks a cartridge that is enhanced by, but also runs on, the original Gameboy.
+	CGBSupported = 0x80
+	// CGBOnly marks a cartridge that only runs on the Gameboy Color.
+	CGBOnly = 0xC0
+)
+
+// Header is the decoded cartridge header.
+type Header struct {
+	Title          string
+	CGBFlag        uint8
+	SGBFlag        uint8
+	Type           Type
+	ROMSize        uint8
+	RAMSize        uint8
+	Destination    uint8
+	OldLicensee    uint8
+	NewLicensee    string
+	Version        uint8
+	HeaderChecksum uint8
+	GlobalChecksum uint16
+}
+
+// ErrShortROM is returned when a ROM is too small to contain a header.
+var ErrShortROM = errors.New("cartridge: ROM is too small to contain a header")
+
+// ParseHeader decodes the header of a ROM image.
+func ParseHeader(rom []byte) (*Header, error) {
+	if len(rom) < HeaderEnd {
+		return nil, ErrShortROM
+	}
+	title := rom[TitleOffset : TitleOffset+TitleLength]
+	if rom[CGBFlagOffset]&0x80 != 0 {
+		title = title[:CGBFlagOffset-TitleOffset]
+	}
+	return &Header{
+		Title:          strings.TrimRight(string(title), "\x00"),
+		CGBFlag:        rom[CGBFlagOffset],
+		SGBFlag:        rom[SGBFlagOffset],
+		Type:           Type(rom[TypeOffset]),
+		ROMSize:        rom[ROMSizeOffset],
+		RAMSize:        rom[RAMSizeOffset],
+		Destination:    rom[DestinationOffset],
+		OldLicensee:    rom[OldLicenseeOffset],
+		NewLicensee:    string(rom[NewLicenseeOffset : NewLicenseeOffset+2]),
+		Version:        rom[VersionOffset],
+		HeaderChecksum: rom[HeaderChecksumOffset],
+		GlobalChecksum: binary.BigEndian.Uint16(rom[GlobalChecksumOffset:]),
+	}, nil
+}
+
+// ROMBanks returns the number of 16 KiB ROM banks declared by the header.
+func (h *Header) ROMBanks() int {
+	return 2 << h.ROMSize
+}
+
+// RAMBytes returns the size of the external RAM declared by the header.
+func (h *Header) RAMBytes() int {
+	switch h.RAMSize {
+	case 0x02:
+		return 8 << 10
+	case 0x03:
+		return 32 << 10
+	case 0x04:
+		return 128 << 10
+	case 0x05:
+		return 64 << 10
+	default:
+		return 0
+	}
+}
+
+// ROMSizeCode returns the header ROM size code for a number of 16 KiB banks, rounding up to the next valid size.
+func ROMSizeCode(banks int) uint8 {
+	var code uint8
+	for 2<<code < banks {
+		code++
+	}
+	return code
+}
+
+// HeaderChecksum computes the checksum of the header bytes $0134-$014C, which is verified by the boot ROM.
+func HeaderChecksum(rom []byte) uint8 {
+	var x uint8
+	for _, b := range rom[TitleOffset:HeaderChecksumOffset] {
+		x = x - b - 1
+	}
+	return x
+}
+
+// GlobalChecksum computes the sum of every byte of the ROM, excluding the global checksum itself.
+func GlobalChecksum(rom []byte) uint16 {
+	var sum uint16
+	for i, b := range rom {
+		if i != GlobalChecksumOffset && i != GlobalChecksumOffset+1 {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// Verify checks the logo and the checksums of a ROM image.
+func Verify(rom []byte) error {
+	if len(rom) < HeaderEnd {
+		return ErrShortROM
+	}
+	if [48]byte(rom[LogoOffset:LogoOffset+len(Logo)]) != Logo {
+		return errors.New("cartridge: bad logo")
+	}
+	if sum := HeaderChecksum(rom); sum != rom[HeaderChecksumOffset] {
+		return fmt.Errorf("cartridge: bad header checksum $%02X, expected $%02X", rom[HeaderChecksumOffset], sum)
+	}
+	if sum := GlobalChecksum(rom); sum != binary.BigEndian.Uint16(rom[GlobalChecksumOffset:]) {
+		return fmt.Errorf("cartridge: bad global checksum $%04X, expected $%04X",
+			binary.BigEndian.Uint16(rom[GlobalChecksumOffset:]), sum)
+	}
+	return nil
+}
+
+// FixChecksums writes the header and global checksums into a ROM image.
+func FixChecksums(rom []byte) error {
+	if len(rom) < HeaderEnd {
+		return ErrShortROM
+	}
+	rom[HeaderChecksumOffset] = HeaderChecksum(rom)
+	binary.BigEndian.PutUint16(rom[GlobalChecksumOffset:], GlobalChecksum(rom))
+	return nil
+}
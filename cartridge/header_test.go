@@ -0,0 +1,48 @@
+package cartridge
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[LogoOffset:], Logo[:])
+	copy(rom[TitleOffset:], "GOPHER")
+	rom[CGBFlagOffset] = CGBSupported
+	rom[TypeOffset] = byte(MBC3TimerRAMBattery)
+	rom[ROMSizeOffset] = ROMSizeCode(4)
+	rom[RAMSizeOffset] = 0x03
+	rom[0x7FFF] = 0xAB
+
+	assert.Error(t, Verify(rom))
+	assert.NoError(t, FixChecksums(rom))
+	assert.NoError(t, Verify(rom))
+
+	h, err := ParseHeader(rom)
+	assert.NoError(t, err)
+	assert.Equal(t, "GOPHER", h.Title)
+	assert.Equal(t, uint8(CGBSupported), h.CGBFlag)
+	assert.Equal(t, MBC3TimerRAMBattery, h.Type)
+	assert.Equal(t, "MBC3+TIMER+RAM+BATTERY", h.Type.String())
+	assert.Equal(t, 4, h.ROMBanks())
+	assert.Equal(t, 32<<10, h.RAMBytes())
+	assert.Equal(t, HeaderChecksum(rom), h.HeaderChecksum)
+	assert.Equal(t, GlobalChecksum(rom), h.GlobalChecksum)
+
+	rom[0x7FFF]++
+	assert.EqualError(t, Verify(rom), fmt.Sprintf("cartridge: bad global checksum $%04X, expected $%04X",
+		h.GlobalChecksum, GlobalChecksum(rom)))
+
+	_, err = ParseHeader(rom[:0x100])
+	assert.ErrorIs(t, err, ErrShortROM)
+}
+
+func TestROMSizeCode(t *testing.T) {
+	assert.Equal(t, uint8(0), ROMSizeCode(1))
+	assert.Equal(t, uint8(0), ROMSizeCode(2))
+	assert.Equal(t, uint8(1), ROMSizeCode(3))
+	assert.Equal(t, uint8(5), ROMSizeCode(64))
+}
@@ -0,0 +1,114 @@
+package cartridge
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of cartridges in save states.
+const StateVersion = 2
+
+func (b *base) saveState(e *state.Encoder) {
+	e.Slice(b.ram)
+}
+
+func (b *base) loadState(d *state.Decoder) {
+	d.Slice(b.ram)
+}
+
+// checkVersion reports an error for states encoded by newer versions.
+func checkVersion(version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("cartridge: unsupported state version %d", version)
+	}
+	return nil
+}
+
+func (c *romOnly) SaveState(e *state.Encoder) {
+	c.saveState(e)
+}
+
+func (c *romOnly) LoadState(d *state.Decoder, version int) error {
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	c.loadState(d)
+	return d.Err()
+}
+
+func (c *mbc1) SaveState(e *state.Encoder) {
+	c.saveState(e)
+	e.Bool(c.ramEnabled)
+	e.Int(c.bank)
+	e.Int(c.upper)
+	e.Int(c.mode)
+}
+
+func (c *mbc1) LoadState(d *state.Decoder, version int) error {
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	c.loadState(d)
+	c.ramEnabled, c.bank, c.upper, c.mode = d.Bool(), d.Int(), d.Int(), d.Int()
+	return d.Err()
+}
+
+func (c *mbc2) SaveState(e *state.Encoder) {
+	c.saveState(e)
+	e.Bool(c.ramEnabled)
+	e.Int(c.bank)
+}
+
+func (c *mbc2) LoadState(d *state.Decoder, version int) error {
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	c.loadState(d)
+	c.ramEnabled, c.bank = d.Bool(), d.Int()
+	return d.Err()
+}
+
+func (c *mbc3) SaveState(e *state.Encoder) {
+	c.saveState(e)
+	e.Bool(c.ramEnabled)
+	e.Int(c.bank)
+	e.Int(c.ramSelect)
+	e.Slice(c.rtc[:])
+	e.Slice(c.latched[:])
+	e.Uint8(c.latch)
+	e.Int(c.rtcCycles)
+}
+
+func (c *mbc3) LoadState(d *state.Decoder, version int) error {
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	c.loadState(d)
+	c.ramEnabled, c.bank, c.ramSelect = d.Bool(), d.Int(), d.Int()
+	d.Slice(c.rtc[:])
+	d.Slice(c.latched[:])
+	c.latch = d.Uint8()
+	// version 1 did not keep the time within a second
+	c.rtcCycles = 0
+	if version >= 2 {
+		c.rtcCycles = d.Int()
+	}
+	return d.Err()
+}
+
+func (c *mbc5) SaveState(e *state.Encoder) {
+	c.saveState(e)
+	e.Bool(c.ramEnabled)
+	e.Int(c.bank)
+	e.Int(c.ramBank)
+}
+
+func (c *mbc5) LoadState(d *state.Decoder, version int) error {
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	c.loadState(d)
+	c.ramEnabled, c.bank, c.ramBank = d.Bool(), d.Int(), d.Int()
+	return d.Err()
+}
@@ -0,0 +1,42 @@
+package cartridge
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	for _, test := range []struct {
+		typ    Type
+		writes [][2]uint16
+	}{
+		{ROMRAM, [][2]uint16{{0xA000, 0x42}}},
+		{MBC1RAM, [][2]uint16{{0x0000, 0x0A}, {0x2000, 3}, {0x4000, 1}, {0x6000, 1}, {0xA000, 0x42}}},
+		{MBC2, [][2]uint16{{0x0000, 0x0A}, {0x0100, 3}, {0xA000, 0x42}}},
+		{MBC3TimerRAMBattery, [][2]uint16{{0x0000, 0x0A}, {0x2000, 3}, {0x4000, 0x08}, {0xA000, 0x42}, {0x6000, 0}}},
+		{MBC5RAM, [][2]uint16{{0x0000, 0x0A}, {0x2000, 3}, {0x4000, 1}, {0xA000, 0x42}}},
+	} {
+		t.Run(test.typ.String(), func(t *testing.T) {
+			rom := testROM(test.typ, 64, 0x03)
+			c, err := New(rom)
+			require.NoError(t, err)
+			for _, w := range test.writes {
+				c.Write(w[0], uint8(w[1]))
+			}
+			var e state.Encoder
+			c.SaveState(&e)
+
+			restored, err := New(rom)
+			require.NoError(t, err)
+			require.NoError(t, restored.LoadState(state.NewDecoder(e.Bytes()), StateVersion))
+			assert.Equal(t, c, restored)
+			assert.Equal(t, c.Read(0xA000), restored.Read(0xA000))
+
+			assert.Error(t, restored.LoadState(state.NewDecoder(e.Bytes()), StateVersion+1))
+			assert.Error(t, restored.LoadState(state.NewDecoder(e.Bytes()[:len(e.Bytes())-1]), StateVersion))
+		})
+	}
+}
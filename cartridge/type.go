@@ -0,0 +1,78 @@
+package cartridge
+
+import "fmt"
+
+// Type is the cartridge type, found in the header. It identifies the memory bank controller, and any additional
+// hardware found in the cartridge.
+type Type uint8
+
+// Cartridge types
+const (
+	ROMOnly                    Type = 0x00
+	MBC1                       Type = 0x01
+	MBC1RAM                    Type = 0x02
+	MBC1RAMBattery             Type = 0x03
+	MBC2                       Type = 0x05
+	MBC2Battery                Type = 0x06
+	ROMRAM                     Type = 0x08
+	ROMRAMBattery              Type = 0x09
+	MMM01                      Type = 0x0B
+	MMM01RAM                   Type = 0x0C
+	MMM01RAMBattery            Type = 0x0D
+	MBC3TimerBattery           Type = 0x0F
+	MBC3TimerRAMBattery        Type = 0x10
+	MBC3                       Type = 0x11
+	MBC3RAM                    Type = 0x12
+	MBC3RAMBattery             Type = 0x13
+	MBC5                       Type = 0x19
+	MBC5RAM                    Type = 0x1A
+	MBC5RAMBattery             Type = 0x1B
+	MBC5Rumble                 Type = 0x1C
+	MBC5RumbleRAM              Type = 0x1D
+	MBC5RumbleRAMBattery       Type = 0x1E
+	MBC6                       Type = 0x20
+	MBC7SensorRumbleRAMBattery Type = 0x22
+	PocketCamera               Type = 0xFC
+	BandaiTAMA5                Type = 0xFD
+	HuC3                       Type = 0xFE
+	HuC1RAMBattery             Type = 0xFF
+)
+
+var typeStrs = map[Type]string{
+	ROMOnly:                    "ROM ONLY",
+	MBC1:                       "MBC1",
+	MBC1RAM:                    "MBC1+RAM",
+	MBC1RAMBattery:             "MBC1+RAM+BATTERY",
+	MBC2:                       "MBC2",
+	MBC2Battery:                "MBC2+BATTERY",
+	ROMRAM:                     "ROM+RAM",
+	ROMRAMBattery:              "ROM+RAM+BATTERY",
+	MMM01:                      "MMM01",
+	MMM01RAM:                   "MMM01+RAM",
+	MMM01RAMBattery:            "MMM01+RAM+BATTERY",
+	MBC3TimerBattery:           "MBC3+TIMER+BATTERY",
+	MBC3TimerRAMBattery:        "MBC3+TIMER+RAM+BATTERY",
+	MBC3:                       "MBC3",
+	MBC3RAM:                    "MBC3+RAM",
+	MBC3RAMBattery:             "MBC3+RAM+BATTERY",
+	MBC5:                       "MBC5",
+	MBC5RAM:                    "MBC5+RAM",
+	MBC5RAMBattery:             "MBC5+RAM+BATTERY",
+	MBC5Rumble:                 "MBC5+RUMBLE",
+	MBC5RumbleRAM:              "MBC5+RUMBLE+RAM",
+	MBC5RumbleRAMBattery:       "MBC5+RUMBLE+RAM+BATTERY",
+	MBC6:                       "MBC6",
+	MBC7SensorRumbleRAMBattery: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	PocketCamera:               "POCKET CAMERA",
+	BandaiTAMA5:                "BANDAI TAMA5",
+	HuC3:                       "HuC3",
+	HuC1RAMBattery:             "HuC1+RAM+BATTERY",
+}
+
+// String implements fmt.Stringer
+func (t Type) String() string {
+	if s, ok := typeStrs[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("<unknown type $%02X>", uint8(t))
+}
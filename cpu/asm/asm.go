@@ -4,12 +4,15 @@ package asm
 import (
 	"bytes"
 	"fmt"
+	"io/fs"
 	"strconv"
 	"strings"
 )
 
 // Assembler is a Gameboy Z-80-like assembler.
 type Assembler struct {
+	// FS is used to read the files included by source code. It may be nil if no source includes other files.
+	FS fs.FS
 }
 
 // NewAssembler constructs a new [Assembler] object.
@@ -48,7 +51,9 @@ func (a *Assembler) Assemble(instrs ...*Instruction) ([]byte, error) {
 			}
 
 		default:
-			return nil, badInstr("unknown mnemnonic")
+			if err := a.encode(i, instr, &buf); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -93,7 +98,7 @@ func (i *Instruction) String() string {
 	return builder.String()
 }
 
-// Operand is One of: [Register, Immediate, Pointer]
+// Operand is One of: [Register, Immediate, Pointer, Condition, Relative8, Bit]
 type Operand interface {
 	operand()
 	fmt.Stringer
@@ -108,6 +113,11 @@ type (
 	Imm8        = Immediate8
 	Immediate16 uint16
 	Imm16       = Immediate16
+	Condition   int
+	Cond        = Condition
+	Relative8   int8
+	Rel8        = Relative8
+	Bit         uint8
 )
 
 // SImm8 is a helper that constructs an Imm8 from a signed 8 bit integer.
@@ -168,7 +178,7 @@ func (r Register16) String() string {
 		if offset > 0 {
 			return "SP + " + strconv.Itoa(offset)
 		} else {
-			return "SP - " + strconv.Itoa(int(r-SP))
+			return "SP - " + strconv.Itoa(-offset)
 		}
 
 	default:
@@ -190,6 +200,45 @@ func (i Immediate16) String() string {
 	return fmt.Sprintf("$%X", uint16(i))
 }
 
+func (Condition) operand() {}
+
+// String implements fmt.Stringer
+func (c Condition) String() string {
+	switch {
+	case c >= NZ && c <= CY:
+		return conditionStrs[c]
+
+	default:
+		return "<invalid condition>"
+	}
+}
+
+func (Relative8) operand() {}
+
+// String implements fmt.Stringer.
+// A relative jump is printed relative to the start of its instruction, which is always 2 bytes long, so that the
+// output can be read back by an RGBDS compatible assembler. For example, JR $FE is printed as "@", a jump to itself.
+func (r Relative8) String() string {
+	offset := int(r) + 2
+	switch {
+	case offset > 0:
+		return "@+" + strconv.Itoa(offset)
+
+	case offset < 0:
+		return "@-" + strconv.Itoa(-offset)
+
+	default:
+		return "@"
+	}
+}
+
+func (Bit) operand() {}
+
+// String implements fmt.Stringer
+func (b Bit) String() string {
+	return strconv.Itoa(int(b))
+}
+
 // Pointer represents a Pointer to a Reference Operand.
 // In some operations, The gameoby CPU can increment or decrement Pointer value, which can be
 // represented by the Delta field.
@@ -271,5 +320,15 @@ const (
 	spMax            = SP + 0x7F
 )
 
+// Branch conditions
+const (
+	NZ Condition = iota
+	Z
+	NC
+	// CY is the carry condition, written as "C" in assembly. The name C is already taken by the C register.
+	CY
+)
+
 var registerStrs = []string{"A", "F", "B", "C", "D", "E", "H", "L"}
 var compoundStrs = []string{"AF", "BC", "DE", "HL", "PC"}
+var conditionStrs = []string{"NZ", "Z", "NC", "C"}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrIllegalOpcode is returned when decoding one of the opcodes the Gameboy CPU does not implement, such as $D3.
+var ErrIllegalOpcode = errors.New("illegal opcode")
+
+// decode reads the operands of the opcode from b, which holds the encoded instruction without its opcode bytes.
+func (o *opcode) decode(b []byte) []Operand {
+	ops := make([]Operand, 0, len(o.slots))
+	for _, s := range o.slots {
+		switch s.kind {
+		case slotExact:
+			ops = append(ops, s.exact)
+
+		case slotImm8:
+			ops = append(ops, Imm8(b[0]))
+
+		case slotImm16:
+			ops = append(ops, Imm16(binary.LittleEndian.Uint16(b)))
+
+		case slotAddr16:
+			ops = append(ops, Ptr(Imm16(binary.LittleEndian.Uint16(b))))
+
+		case slotHighAddr:
+			ops = append(ops, Ptr(0xFF00|Imm16(b[0])))
+
+		case slotRel8:
+			ops = append(ops, Rel8(int8(b[0])))
+
+		case slotSPOffset:
+			ops = append(ops, SP+Reg16(int8(b[0])))
+		}
+	}
+	return ops
+}
+
+// Decode decodes the instruction at the start of b.
+//
+// It returns [ErrIllegalOpcode] for the opcodes the CPU does not implement, and [io.ErrUnexpectedEOF] if b ends
+// before the operands of the instruction.
+func Decode(b []byte) (*Instruction, error) {
+	if len(b) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	prefixed := b[0] == 0xCB
+	code := b[0]
+	if prefixed {
+		if len(b) < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		code = b[1]
+	}
+
+	o := opcodeFor(prefixed, code)
+	if o == nil {
+		return nil, fmt.Errorf("$%02X: %w", code, ErrIllegalOpcode)
+	}
+	if len(b) < o.Info.Bytes {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return &Instruction{
+		Mnemonic: o.Info.Mnemonic,
+		Bytes:    o.Info.Bytes,
+		Cycles:   o.Info.Cycles[0],
+		Operands: o.decode(b[1+boolInt(prefixed) : o.Info.Bytes]),
+	}, nil
+}
+
+// Disassemble decodes a stream of instructions, which must end on an instruction boundary.
+// Assembling the result produces b again.
+func Disassemble(b []byte) ([]*Instruction, error) {
+	var instrs []*Instruction
+	for offset := 0; offset < len(b); {
+		instr, err := Decode(b[offset:])
+		if err != nil {
+			return instrs, fmt.Errorf("disassembling at offset $%X: %w", offset, err)
+		}
+		instrs = append(instrs, instr)
+		offset += instr.Bytes
+	}
+	return instrs, nil
+}
+
+// DisassembleAt decodes the instructions within n bytes of r, starting at off. This can be used to disassemble a
+// range of the cpu package Memory.
+func DisassembleAt(r io.ReaderAt, off int64, n int) ([]*Instruction, error) {
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return Disassemble(buf)
+}
@@ -0,0 +1,372 @@
+// Package disasm disassembles whole cartridge ROMs into labeled source, by following the code paths of the ROM.
+// The source reassembles byte-identically with package asm and package link.
+package disasm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/sym"
+)
+
+// Location is an address within a ROM bank. Addresses in bank 0 are within $0000-$3FFF, and addresses in every
+// other bank are within $4000-$7FFF.
+type Location struct {
+	Bank int
+	Addr int
+}
+
+// String implements fmt.Stringer, in the bank:address form of symbol files.
+func (l Location) String() string {
+	return fmt.Sprintf("%02X:%04X", l.Bank, l.Addr)
+}
+
+// vectors are the code entry points the CPU jumps to on its own: the entry point, and interrupt handlers.
+var vectors = []struct {
+	name string
+	addr int
+}{
+	{"VBlankInterrupt", 0x40},
+	{"STATInterrupt", 0x48},
+	{"TimerInterrupt", 0x50},
+	{"SerialInterrupt", 0x58},
+	{"JoypadInterrupt", 0x60},
+	{"Entry", 0x100},
+}
+
+// byteKind classifies each byte of the ROM.
+type byteKind uint8
+
+const (
+	// kindData is a byte no code path reaches.
+	kindData byteKind = iota
+	// kindCode is the first byte of an instruction.
+	kindCode
+	// kindOperand is any other byte of an instruction.
+	kindOperand
+)
+
+// reference is how code refers to a location, which determines the name of its label.
+type reference int
+
+const (
+	refJump reference = iota + 1
+	refCall
+)
+
+// path is the state of the CPU while tracing a code path.
+type path struct {
+	at Location
+	// romx is the bank mapped into $4000-$7FFF, or -1 if it is unknown.
+	romx int
+	// a is the value of the A register, or -1 if it is unknown. It is used to follow bank switches.
+	a int
+}
+
+// ROM is a cartridge ROM, whose code was traced.
+type ROM struct {
+	data  []byte
+	kinds []byteKind
+	// instrs holds the instructions that were decoded, by ROM offset.
+	instrs map[int]*asm.Instruction
+	// targets holds where branch instructions go, by the ROM offset of the instruction.
+	targets map[int]Location
+	refs    map[Location]reference
+	names   map[Location]string
+}
+
+// Trace disassembles rom by following every code path from the entry point and the interrupt vectors, across the
+// bank switches it can infer. Bytes no path reaches are data.
+func Trace(rom []byte) (*ROM, error) {
+	if len(rom) < 2*cartridge.BankSize || len(rom)%cartridge.BankSize != 0 {
+		return nil, errors.New("disasm: ROM size must be a multiple of 16 KiB, with at least 2 banks")
+	}
+	r := &ROM{
+		data:    rom,
+		kinds:   make([]byteKind, len(rom)),
+		instrs:  make(map[int]*asm.Instruction),
+		targets: make(map[int]Location),
+		refs:    make(map[Location]reference),
+		names:   make(map[Location]string),
+	}
+
+	var queue []path
+	for _, v := range vectors {
+		at := Location{Bank: 0, Addr: v.addr}
+		r.names[at] = v.name
+		queue = append(queue, path{at: at, romx: r.defaultROMX(), a: -1})
+	}
+	for len(queue) > 0 {
+		p := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		queue = r.trace(p, queue)
+	}
+
+	// only name the targets that were decoded as the start of an instruction
+	for at, ref := range r.refs {
+		if _, named := r.names[at]; named || !r.IsCode(at) {
+			continue
+		}
+		switch ref {
+		case refCall:
+			r.names[at] = fmt.Sprintf("Call_%03X_%04X", at.Bank, at.Addr)
+
+		default:
+			r.names[at] = fmt.Sprintf("Jump_%03X_%04X", at.Bank, at.Addr)
+		}
+	}
+	for at := range r.names {
+		if !r.IsCode(at) {
+			delete(r.names, at)
+		}
+	}
+	return r, nil
+}
+
+// Banks returns the number of banks of the ROM.
+func (r *ROM) Banks() int {
+	return len(r.data) / cartridge.BankSize
+}
+
+// IsCode reports whether an instruction starts at the location.
+func (r *ROM) IsCode(at Location) bool {
+	off, ok := r.checkedOffset(at)
+	return ok && r.kinds[off] == kindCode
+}
+
+// Instruction returns the instruction that starts at the location.
+func (r *ROM) Instruction(at Location) (*asm.Instruction, bool) {
+	off, ok := r.checkedOffset(at)
+	if !ok {
+		return nil, false
+	}
+	instr, ok := r.instrs[off]
+	return instr, ok
+}
+
+// Label returns the name of the label at the location, if code refers to it.
+func (r *ROM) Label(at Location) (string, bool) {
+	name, ok := r.names[at]
+	return name, ok
+}
+
+// defaultROMX is the bank mapped into $4000-$7FFF when nothing is known: only known for ROMs without banking.
+func (r *ROM) defaultROMX() int {
+	if r.Banks() == 2 {
+		return 1
+	}
+	return -1
+}
+
+func (r *ROM) offset(at Location) int {
+	if at.Bank == 0 {
+		return at.Addr
+	}
+	return at.Bank*cartridge.BankSize + at.Addr - cartridge.BankSize
+}
+
+func (r *ROM) checkedOffset(at Location) (int, bool) {
+	switch {
+	case at.Bank == 0 && at.Addr >= 0 && at.Addr < cartridge.BankSize:
+	case at.Bank > 0 && at.Bank < r.Banks() && at.Addr >= cartridge.BankSize && at.Addr < 2*cartridge.BankSize:
+	default:
+		return 0, false
+	}
+	return r.offset(at), true
+}
+
+// locate returns the location of an address the CPU branches to, which is outside of the ROM if the address is
+// negative, as that of a relative jump back from near $0000.
+func (p path) locate(addr int) (Location, bool) {
+	switch {
+	case addr < 0:
+		return Location{}, false
+
+	case addr < cartridge.BankSize:
+		return Location{Bank: 0, Addr: addr}, true
+
+	case addr < 2*cartridge.BankSize && p.romx > 0:
+		return Location{Bank: p.romx, Addr: addr}, true
+
+	default:
+		// code in RAM, or in an unknown bank
+		return Location{}, false
+	}
+}
+
+// trace decodes the instructions of a code path, until it ends or reaches code that was already traced. It returns
+// queue with the paths that branch off it.
+func (r *ROM) trace(p path, queue []path) []path {
+	for {
+		off, ok := r.checkedOffset(p.at)
+		if !ok || r.kinds[off] != kindData {
+			return queue
+		}
+		end := (off/cartridge.BankSize + 1) * cartridge.BankSize
+		instr, err := asm.Decode(r.data[off:end])
+		if err != nil {
+			return queue
+		}
+		for i := off + 1; i < off+instr.Bytes; i++ {
+			if r.kinds[i] != kindData {
+				// the instruction would overlap code decoded from another path
+				return queue
+			}
+		}
+		r.kinds[off] = kindCode
+		for i := off + 1; i < off+instr.Bytes; i++ {
+			r.kinds[i] = kindOperand
+		}
+		r.instrs[off] = instr
+
+		next := p
+		next.at.Addr += instr.Bytes
+		next.follow(instr, r.Banks())
+
+		if addr, ref, ok := branch(instr, p.at.Addr); ok {
+			if target, ok := p.locate(addr); ok {
+				r.targets[off] = target
+				if r.refs[target] < ref {
+					r.refs[target] = ref
+				}
+				queue = append(queue, path{at: target, romx: p.romxAt(target, r), a: -1})
+			}
+		}
+		if ends(instr) {
+			return queue
+		}
+		p = next
+	}
+}
+
+// romxAt returns the bank mapped into $4000-$7FFF when branching to target.
+func (p path) romxAt(target Location, r *ROM) int {
+	if target.Bank > 0 {
+		return target.Bank
+	}
+	if p.romx > 0 {
+		return p.romx
+	}
+	return r.defaultROMX()
+}
+
+// follow updates the known state of the CPU after executing instr, on a ROM of a number of banks.
+func (p *path) follow(instr *asm.Instruction, banks int) {
+	ops := instr.Operands
+	switch {
+	case instr.Mnemonic == "LD" && len(ops) == 2 && ops[0] == asm.A:
+		if n, ok := ops[1].(asm.Imm8); ok {
+			p.a = int(n)
+		} else {
+			p.a = -1
+		}
+
+	case instr.Mnemonic == "XOR" && len(ops) == 2 && ops[0] == asm.A && ops[1] == asm.A:
+		p.a = 0
+
+	case instr.Mnemonic == "LD" && len(ops) == 2 && ops[1] == asm.A:
+		// writing to $2000-$3FFF selects the ROM bank on every memory bank controller
+		ptr, ok := ops[0].(asm.Pointer[asm.Imm16])
+		if !ok || ptr.Ref < 0x2000 || ptr.Ref >= 0x4000 {
+			return
+		}
+		if p.a < 0 {
+			p.romx = -1
+			return
+		}
+		// the memory bank controller wraps banks past the end of the ROM around
+		p.romx = p.a % banks
+		if p.romx == 0 {
+			p.romx = 1
+		}
+
+	case len(ops) > 0 && ops[0] == asm.A,
+		instr.Mnemonic == "CALL", instr.Mnemonic == "RST", instr.Mnemonic == "POP" && ops[0] == asm.AF,
+		instr.Mnemonic == "CPL", instr.Mnemonic == "DAA",
+		instr.Mnemonic == "RLA", instr.Mnemonic == "RRA", instr.Mnemonic == "RLCA", instr.Mnemonic == "RRCA":
+		p.a = -1
+	}
+}
+
+// branch returns the address an instruction at addr may branch to, and how it refers to it.
+func branch(instr *asm.Instruction, addr int) (int, reference, bool) {
+	if len(instr.Operands) == 0 {
+		return 0, 0, false
+	}
+	switch op := instr.Operands[len(instr.Operands)-1].(type) {
+	case asm.Imm16:
+		switch instr.Mnemonic {
+		case "JP":
+			return int(op), refJump, true
+		case "CALL":
+			return int(op), refCall, true
+		}
+
+	case asm.Rel8:
+		return addr + instr.Bytes + int(op), refJump, true
+
+	case asm.Imm8:
+		if instr.Mnemonic == "RST" {
+			return int(op), refCall, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ends reports whether execution never continues after the instruction.
+func ends(instr *asm.Instruction) bool {
+	switch instr.Mnemonic {
+	case "JP", "JR", "RET":
+		if len(instr.Operands) > 0 {
+			_, conditional := instr.Operands[0].(asm.Cond)
+			return !conditional
+		}
+		return true
+
+	case "RETI":
+		return true
+
+	default:
+		return false
+	}
+}
+
+// UseSymbols names the code of the ROM after the symbols of a symbol file, instead of the generated names. Symbols
+// that do not name the start of an instruction, or that are not valid labels, are ignored.
+func (r *ROM) UseSymbols(t *sym.Table) {
+	used := make(map[string]bool)
+	named := make(map[Location]bool)
+	for _, s := range t.Symbols() {
+		at := Location{Bank: s.Bank, Addr: s.Addr}
+		if used[s.Name] || named[at] || !validLabel(s.Name) || !r.IsCode(at) {
+			continue
+		}
+		used[s.Name] = true
+		named[at] = true
+		r.names[at] = s.Name
+	}
+}
+
+// Symbols returns the labels of the code, to be written as a symbol file.
+func (r *ROM) Symbols() *sym.Table {
+	t := sym.NewTable()
+	for at, name := range r.names {
+		t.Add(at.Bank, at.Addr, name)
+	}
+	return t
+}
+
+// validLabel reports whether name can be defined as a global label.
+func validLabel(name string) bool {
+	for i, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.' || c == '#' || c == '@'):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
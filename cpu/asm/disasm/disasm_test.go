@@ -0,0 +1,218 @@
+package disasm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSource = `
+SECTION "RST", ROM0[$08]
+	ret
+
+SECTION "VBlank", ROM0[$40]
+	reti
+
+SECTION "Header", ROM0[$100]
+	nop
+	jp Start
+
+SECTION "Start", ROM0[$150]
+Start:
+	ld a, BANK(Far)
+	ld [$2000], a
+	call Far
+	rst $08
+	ld hl, $FF80
+.loop
+	halt
+	jp nz, Start.loop
+	jr .loop
+Message:
+	db "HELLO"
+
+SECTION "Far", ROMX[$4000], BANK[2]
+Far:
+	ld hl, Message
+	jr nz, .skip
+	inc a
+.skip
+	ret
+	ds 64, $FF
+`
+
+func build(t *testing.T, name, src string) *link.Image {
+	t.Helper()
+	obj, err := asm.AssembleObject(name, strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := link.Link(link.Options{Fix: true, Title: "DISASM", Type: cartridge.MBC1}, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestTrace(t *testing.T) {
+	rom := build(t, "test.asm", testSource).ROM
+	r, err := Trace(rom)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 4, r.Banks())
+
+	for _, at := range []Location{{0, 0x08}, {0, 0x40}, {0, 0x100}, {0, 0x101}, {0, 0x150}, {2, 0x4000}, {2, 0x4006}} {
+		assert.True(t, r.IsCode(at), "%s", at)
+	}
+	for _, at := range []Location{{0, 0x102}, {0, 0x104}, {0, 0x134}, {2, 0x4007}, {1, 0x4000}, {3, 0x4000}} {
+		assert.False(t, r.IsCode(at), "%s", at)
+	}
+
+	instr, ok := r.Instruction(Location{2, 0x4000})
+	assert.True(t, ok)
+	assert.Equal(t, "LD HL, $162", instr.String())
+
+	for at, name := range map[Location]string{
+		{0, 0x0008}: "Call_000_0008",
+		{0, 0x0040}: "VBlankInterrupt",
+		{0, 0x0100}: "Entry",
+		{0, 0x0150}: "Jump_000_0150",
+		{2, 0x4000}: "Call_002_4000",
+		{2, 0x4006}: "Jump_002_4006",
+	} {
+		label, ok := r.Label(at)
+		assert.True(t, ok, "%s", at)
+		assert.Equal(t, name, label, "%s", at)
+	}
+}
+
+func TestReassemble(t *testing.T) {
+	rom := build(t, "test.asm", testSource).ROM
+	r, err := Trace(rom)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var src bytes.Buffer
+	_, err = r.WriteTo(&src)
+	assert.NoError(t, err)
+
+	out := src.String()
+	for _, line := range []string{
+		"SECTION \"ROM Bank $000\", ROM0[$0000]\n",
+		"SECTION \"ROM Bank $002\", ROMX[$4000], BANK[$2]\n",
+		"\tCALL Call_002_4000\n",
+		"\tRST $8\n",
+		"\tJP NZ, Jump_000_015C\n",
+		"\tJR Jump_000_015C\n",
+		"\tJR NZ, Jump_002_4006\n",
+		"\tdb $48, $45, $4C, $4C, $4F\n",
+		"\tds 64, $FF\n",
+	} {
+		assert.Contains(t, out, line)
+	}
+
+	obj, err := asm.AssembleObject("rom.asm", &src)
+	if !assert.NoError(t, err) {
+		return
+	}
+	img, err := link.Link(link.Options{}, obj)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(rom, img.ROM), "reassembled ROM differs")
+}
+
+func TestTraceErrors(t *testing.T) {
+	_, err := Trace(make([]byte, cartridge.BankSize))
+	assert.Error(t, err)
+	_, err = Trace(make([]byte, 3*cartridge.BankSize-1))
+	assert.Error(t, err)
+}
+
+func TestTraceOutOfROM(t *testing.T) {
+	// a relative jump back from near $0000 goes below the ROM
+	rom := make([]byte, 2*cartridge.BankSize)
+	copy(rom[0x40:], []byte{0x18, 0x80})
+	r, err := Trace(rom)
+	if assert.NoError(t, err) {
+		assert.True(t, r.IsCode(Location{0, 0x40}))
+	}
+
+	// a bank past the end of the ROM wraps around
+	rom = make([]byte, 2*cartridge.BankSize)
+	copy(rom[0x100:], []byte{0x3E, 0x05, 0xEA, 0x00, 0x20, 0xC3, 0x00, 0x40})
+	r, err = Trace(rom)
+	if assert.NoError(t, err) {
+		assert.True(t, r.IsCode(Location{1, 0x4000}))
+		label, ok := r.Label(Location{1, 0x4000})
+		assert.True(t, ok)
+		assert.Equal(t, "Jump_001_4000", label)
+	}
+}
+
+func FuzzTrace(f *testing.F) {
+	f.Add([]byte{0x18, 0x80}, uint16(0x40), uint8(0))
+	f.Add([]byte{0x3E, 0x05, 0xEA, 0x00, 0x20, 0xC3, 0x00, 0x40}, uint16(0x100), uint8(0))
+	f.Add([]byte{0x3E, 0x07, 0xEA, 0x00, 0x20, 0xCD, 0xFF, 0x7F}, uint16(0x100), uint8(2))
+
+	// any code, anywhere in a ROM of 2 to 9 banks, disassembles
+	f.Fuzz(func(t *testing.T, code []byte, at uint16, banks uint8) {
+		rom := make([]byte, (2+int(banks)%8)*cartridge.BankSize)
+		copy(rom[int(at)%len(rom):], code)
+		r, err := Trace(rom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.WriteTo(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestUseSymbols(t *testing.T) {
+	img := build(t, "test.asm", testSource)
+	r, err := Trace(img.ROM)
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.UseSymbols(img.SymbolTable())
+
+	for at, name := range map[Location]string{
+		{0, 0x0100}: "Entry",
+		{0, 0x0150}: "Start",
+		{0, 0x015C}: "Start.loop",
+		{2, 0x4000}: "Far",
+		{2, 0x4006}: "Far.skip",
+	} {
+		label, ok := r.Label(at)
+		assert.True(t, ok, "%s", at)
+		assert.Equal(t, name, label, "%s", at)
+	}
+	// Message is data, so it has no label
+	_, ok := r.Label(Location{0, 0x0162})
+	assert.False(t, ok)
+
+	syms := r.Symbols()
+	s, ok := syms.Lookup("Far.skip")
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Bank)
+
+	var src bytes.Buffer
+	_, err = r.WriteTo(&src)
+	assert.NoError(t, err)
+	assert.Contains(t, src.String(), "\tCALL Far\n")
+	assert.Contains(t, src.String(), "\tJR NZ, Far.skip\n")
+
+	obj, err := asm.AssembleObject("rom.asm", &src)
+	if !assert.NoError(t, err) {
+		return
+	}
+	out, err := link.Link(link.Options{}, obj)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(img.ROM, out.ROM), "reassembled ROM differs")
+}
@@ -0,0 +1,133 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+const (
+	// bytesPerLine is the number of data bytes written by each DB directive.
+	bytesPerLine = 16
+	// minFill is the length of a run of a repeated byte, from which the run is written as a DS directive.
+	minFill = 32
+)
+
+// WriteTo implements io.WriterTo, writing the ROM as source: a fixed section per bank, labels for the branch targets,
+// and DB or DS directives for data. Assembling the source with [asm.AssembleObject], and linking it without fixing
+// the header produces the ROM again.
+func (r *ROM) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for bank := 0; bank < r.Banks(); bank++ {
+		if bank == 0 {
+			fmt.Fprintf(bw, "SECTION \"ROM Bank $%03X\", ROM0[$0000]\n", bank)
+		} else {
+			fmt.Fprintf(bw, "\nSECTION \"ROM Bank $%03X\", ROMX[$4000], BANK[$%X]\n", bank, bank)
+		}
+		r.writeBank(bw, bank)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// writeBank writes the contents of a bank.
+func (r *ROM) writeBank(w *bufio.Writer, bank int) {
+	base := Location{Bank: bank}
+	if bank > 0 {
+		base.Addr = cartridge.BankSize
+	}
+	start := r.offset(base)
+	end := start + cartridge.BankSize
+
+	for off := start; off < end; {
+		at := Location{Bank: bank, Addr: base.Addr + off - start}
+		if name, ok := r.names[at]; ok {
+			fmt.Fprintf(w, "\n%s:\n", name)
+		}
+
+		if instr, ok := r.instrs[off]; ok {
+			fmt.Fprintf(w, "\t%s\n", r.format(off, instr))
+			off += instr.Bytes
+			continue
+		}
+
+		// data runs until the next instruction
+		n := 1
+		for off+n < end && r.kinds[off+n] == kindData {
+			n++
+		}
+		r.writeData(w, r.data[off:off+n])
+		off += n
+	}
+}
+
+// writeData writes a run of data, using DS for long runs of a repeated byte.
+func (r *ROM) writeData(w *bufio.Writer, data []byte) {
+	for len(data) > 0 {
+		fill := 1
+		for fill < len(data) && data[fill] == data[0] {
+			fill++
+		}
+		if fill >= minFill {
+			fmt.Fprintf(w, "\tds %d, $%02X\n", fill, data[0])
+			data = data[fill:]
+			continue
+		}
+
+		// stop the line before the next long run
+		n := 0
+		for n < len(data) && n < bytesPerLine {
+			run := 1
+			for n+run < len(data) && data[n+run] == data[n] {
+				run++
+			}
+			if run >= minFill {
+				break
+			}
+			n++
+		}
+		strs := make([]string, n)
+		for i, b := range data[:n] {
+			strs[i] = fmt.Sprintf("$%02X", b)
+		}
+		fmt.Fprintf(w, "\tdb %s\n", strings.Join(strs, ", "))
+		data = data[n:]
+	}
+}
+
+// format returns the source of an instruction, with its branch target replaced by a label.
+func (r *ROM) format(off int, instr *asm.Instruction) string {
+	target, ok := r.targets[off]
+	if !ok {
+		return instr.String()
+	}
+	name, ok := r.names[target]
+	if !ok || instr.Mnemonic == "RST" {
+		return instr.String()
+	}
+
+	ops := make([]string, len(instr.Operands))
+	for i, op := range instr.Operands {
+		ops[i] = op.String()
+	}
+	ops[len(ops)-1] = name
+	return instr.Mnemonic + " " + strings.Join(ops, ", ")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
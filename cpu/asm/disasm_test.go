@@ -0,0 +1,104 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleDisassemble() {
+	instrs, err := Disassemble([]byte{0x21, 0x00, 0xC0, 0xCB, 0x7E, 0xF0, 0x44, 0x20, 0xFC, 0xF8, 0xFE})
+	if err != nil {
+		panic(err)
+	}
+	for _, instr := range instrs {
+		fmt.Println(instr)
+	}
+	// Output:
+	// LD HL, $C000
+	// BIT 7, [HL]
+	// LDH A, [$FF44]
+	// JR NZ, @-2
+	// LD HL, SP - 2
+}
+
+// legalEncodings returns an encoding of every legal opcode, with operand bytes filled from operand.
+func legalEncodings(operand ...byte) [][]byte {
+	var encodings [][]byte
+	for prefixed := 0; prefixed < 2; prefixed++ {
+		for code := 0; code < 0x100; code++ {
+			o := opcodeFor(prefixed == 1, uint8(code))
+			if o == nil {
+				continue
+			}
+			b := []byte{uint8(code)}
+			if prefixed == 1 {
+				b = []byte{0xCB, uint8(code)}
+			}
+			for len(b) < o.Info.Bytes {
+				b = append(b, operand[len(b)%len(operand)])
+			}
+			encodings = append(encodings, b)
+		}
+	}
+	return encodings
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	for _, operand := range [][]byte{{0x00}, {0x7F, 0x80}, {0xFE, 0xFF}, {0x34, 0x12}} {
+		encodings := legalEncodings(operand...)
+		assert.Len(t, encodings, 244+256)
+
+		for _, b := range encodings {
+			instr, err := Decode(b)
+			if !assert.NoError(t, err, "% X", b) {
+				continue
+			}
+			assert.Equal(t, len(b), instr.Bytes, "%s", instr)
+
+			out, err := Assemble(instr)
+			assert.NoError(t, err, "%s", instr)
+			assert.Equal(t, b, out, "%s", instr)
+
+			// the text must assemble back into the same instruction too
+			out, err = AssembleSource("test.asm", strings.NewReader(instr.String()))
+			assert.NoError(t, err, "%s", instr)
+			assert.Equal(t, b, out, "%s", instr)
+		}
+
+		all := bytes.Join(encodings, nil)
+		instrs, err := Disassemble(all)
+		assert.NoError(t, err)
+		assert.Len(t, instrs, len(encodings))
+		out, err := Assemble(instrs...)
+		assert.NoError(t, err)
+		assert.Equal(t, all, out)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte{0xD3})
+	assert.ErrorIs(t, err, ErrIllegalOpcode)
+
+	for _, b := range [][]byte{nil, {0xCB}, {0x3E}, {0xC3, 0x00}} {
+		_, err := Decode(b)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "% X", b)
+	}
+
+	instrs, err := Disassemble([]byte{0x00, 0x00, 0xFD})
+	assert.Len(t, instrs, 2)
+	assert.EqualError(t, err, "disassembling at offset $2: $FD: illegal opcode")
+}
+
+func TestDisassembleAt(t *testing.T) {
+	instrs, err := DisassembleAt(bytes.NewReader([]byte{0xFF, 0x18, 0xFE, 0x00}), 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Instruction{NewInstruction("JR", Rel8(-2))}, instrs)
+
+	_, err = DisassembleAt(bytes.NewReader([]byte{0x00}), 0, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,665 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind classifies a token of assembly source.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  int
+}
+
+// tokenize splits a line of source into tokens. Comments must already be removed.
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case c == '"':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					case '0':
+						sb.WriteByte(0)
+					default:
+						sb.WriteByte(s[j])
+					}
+					continue
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String()})
+			i = j + 1
+
+		case isDigit(c) || (c == '$' && i+1 < len(s) && isHexDigit(s[i+1])) ||
+			(c == '%' && i+1 < len(s) && (s[i+1] == '0' || s[i+1] == '1') && !lastIsValue(toks)) ||
+			(c == '&' && i+1 < len(s) && isDigit(s[i+1]) && !lastIsValue(toks)):
+			j := i + 1
+			for j < len(s) && (isIdentChar(s[j])) {
+				j++
+			}
+			n, err := parseNumber(s[i:j])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokNumber, text: s[i:j], num: n})
+			i = j
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: s[i:j]})
+			i = j
+
+		default:
+			op := string(c)
+			for _, multi := range []string{"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||"} {
+				if strings.HasPrefix(s[i:], multi) {
+					op = multi
+					break
+				}
+			}
+			if !strings.Contains("+-*/%&|^~!<>=()[],:", op[:1]) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, token{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+// lastIsValue reports whether the last token ends a value, which makes % and & binary operators.
+func lastIsValue(toks []token) bool {
+	if len(toks) == 0 {
+		return false
+	}
+	last := toks[len(toks)-1]
+	return last.kind != tokOp || last.text == ")" || last.text == "]"
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || c == '@' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '#' || c == '$'
+}
+
+// parseNumber parses the number formats understood by RGBDS: $hex, 0xhex, %binary, 0bbinary, &octal, 0ooctal,
+// and decimal. Digits may be separated with underscores.
+func parseNumber(s string) (int, error) {
+	digits, base := s, 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		digits, base = s[1:], 16
+	case strings.HasPrefix(s, "%"):
+		digits, base = s[1:], 2
+	case strings.HasPrefix(s, "&"):
+		digits, base = s[1:], 8
+	case len(s) > 2 && s[0] == '0':
+		switch s[1] {
+		case 'x', 'X':
+			digits, base = s[2:], 16
+		case 'b', 'B':
+			digits, base = s[2:], 2
+		case 'o', 'O':
+			digits, base = s[2:], 8
+		}
+	}
+	n, err := strconv.ParseUint(strings.ReplaceAll(digits, "_", ""), base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	return int(n), nil
+}
+
+// expr is a node of a parsed expression.
+type expr interface {
+	fmt.Stringer
+}
+
+type (
+	numExpr   int
+	symExpr   string
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+	callExpr struct {
+		fn   string
+		args []expr
+	}
+)
+
+func (e numExpr) String() string { return strconv.Itoa(int(e)) }
+func (e symExpr) String() string { return string(e) }
+func (e unaryExpr) String() string {
+	return e.op + e.x.String()
+}
+func (e binaryExpr) String() string {
+	return "(" + e.x.String() + " " + e.op + " " + e.y.String() + ")"
+}
+func (e callExpr) String() string {
+	var args []string
+	for _, a := range e.args {
+		args = append(args, a.String())
+	}
+	return e.fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+// exprParser is a precedence climbing parser over a token stream.
+type exprParser struct {
+	toks []token
+	pos  int
+	// scope is the enclosing global label, used to expand local labels such as .loop
+	scope string
+	// funcs are the functions provided by the caller, in addition to the builtin functions
+	funcs map[string]bool
+}
+
+func (p *exprParser) peek() token {
+	if p.pos >= len(p.toks) {
+		return token{kind: tokEOF}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(s string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == s
+}
+
+func (p *exprParser) expect(s string) error {
+	if !p.isOp(s) {
+		return fmt.Errorf("expected %q", s)
+	}
+	p.next()
+	return nil
+}
+
+// binary operator precedences, which follow RGBDS rather than C.
+var precedences = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, ">": 3, "<=": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"&": 5, "|": 5, "^": 5,
+	"<<": 6, ">>": 6,
+	"*": 7, "/": 7, "%": 7,
+}
+
+func (p *exprParser) parseExpr() (expr, error) {
+	return p.parseBinary(1)
+}
+
+func (p *exprParser) parseBinary(minPrec int) (expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedences[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if t := p.peek(); t.kind == tokOp && (t.text == "-" || t.text == "+" || t.text == "~" || t.text == "!") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: t.text, x: x}, nil
+	}
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("**") {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op: "**", x: x, y: y}, nil
+	}
+	return x, nil
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return numExpr(t.num), nil
+
+	case tokString:
+		// single character strings are accepted as numbers
+		if len(t.text) == 1 {
+			return numExpr(t.text[0]), nil
+		}
+		return nil, fmt.Errorf("unexpected string %q", t.text)
+
+	case tokIdent:
+		if p.isOp("(") {
+			return p.parseCall(t.text)
+		}
+		return symExpr(p.qualify(t.text)), nil
+
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+		return nil, fmt.Errorf("unexpected %q", t.text)
+
+	default:
+		return nil, errors.New("unexpected end of expression")
+	}
+}
+
+func (p *exprParser) parseCall(fn string) (expr, error) {
+	p.next() // (
+	call := callExpr{fn: strings.ToUpper(fn)}
+	for !p.isOp(")") {
+		if len(call.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	p.next() // )
+
+	switch call.fn {
+	case "HIGH", "LOW", "DEF", "BANK":
+		if len(call.args) != 1 {
+			return nil, fmt.Errorf("%s takes 1 argument", call.fn)
+		}
+	default:
+		if p.funcs[call.fn] {
+			break
+		}
+		return nil, fmt.Errorf("unknown function %s", fn)
+	}
+	return call, nil
+}
+
+// qualify expands a local label name to its fully qualified name.
+func (p *exprParser) qualify(name string) string {
+	return qualify(p.scope, name)
+}
+
+func qualify(scope, name string) string {
+	if strings.HasPrefix(name, ".") && name != "." {
+		return scope + name
+	}
+	return name
+}
+
+// parseExpr parses a complete expression from a string.
+func parseExpr(s string, scope string) (expr, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, scope: scope}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", s, err)
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("%q: unexpected %q", s, p.peek().text)
+	}
+	return x, nil
+}
+
+// symbols resolves symbols during evaluation of an expression.
+type symbols interface {
+	// lookup returns the value of a symbol, or false if it is not (yet) defined.
+	lookup(name string) (int, bool)
+}
+
+// symbolMap is the simplest set of symbols.
+type symbolMap map[string]int
+
+func (m symbolMap) lookup(name string) (int, bool) {
+	v, ok := m[name]
+	return v, ok
+}
+
+// errUndefined is returned when evaluating an expression referring to an undefined symbol.
+type errUndefined string
+
+func (e errUndefined) Error() string {
+	return fmt.Sprintf("undefined symbol %q", string(e))
+}
+
+// bankPrefix is used to look up the bank of a symbol, by looking up the name BANK(symbol).
+const bankPrefix = "BANK("
+
+func bankOf(name string) string {
+	return bankPrefix + name + ")"
+}
+
+// eval evaluates an expression to a constant.
+func eval(e expr, syms symbols) (int, error) {
+	switch e := e.(type) {
+	case numExpr:
+		return int(e), nil
+
+	case symExpr:
+		v, ok := syms.lookup(string(e))
+		if !ok {
+			return 0, errUndefined(e)
+		}
+		return v, nil
+
+	case unaryExpr:
+		x, err := eval(e.x, syms)
+		if err != nil {
+			return 0, err
+		}
+		switch e.op {
+		case "-":
+			return -x, nil
+		case "~":
+			return ^x, nil
+		case "!":
+			return boolInt(x == 0), nil
+		default:
+			return x, nil
+		}
+
+	case binaryExpr:
+		x, err := eval(e.x, syms)
+		if err != nil {
+			return 0, err
+		}
+		y, err := eval(e.y, syms)
+		if err != nil {
+			return 0, err
+		}
+		return binaryOp(e.op, x, y)
+
+	case callExpr:
+		if e.fn == "DEF" {
+			sym, ok := e.args[0].(symExpr)
+			if !ok {
+				return 0, errors.New("DEF takes a symbol")
+			}
+			_, defined := syms.lookup(string(sym))
+			return boolInt(defined), nil
+		}
+		if e.fn == "BANK" {
+			sym, ok := e.args[0].(symExpr)
+			if !ok {
+				return 0, errors.New("BANK takes a symbol")
+			}
+			v, ok := syms.lookup(bankOf(string(sym)))
+			if !ok {
+				return 0, errUndefined(bankOf(string(sym)))
+			}
+			return v, nil
+		}
+		if env, ok := syms.(envSymbols); ok && e.fn != "HIGH" && e.fn != "LOW" {
+			return env.call(e)
+		}
+		x, err := eval(e.args[0], syms)
+		if err != nil {
+			return 0, err
+		}
+		switch e.fn {
+		case "HIGH":
+			return (x >> 8) & 0xFF, nil
+		case "LOW":
+			return x & 0xFF, nil
+		default:
+			return 0, fmt.Errorf("%s is not supported here", e.fn)
+		}
+
+	default:
+		return 0, fmt.Errorf("bad expression %v", e)
+	}
+}
+
+func binaryOp(op string, x, y int) (int, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "**":
+		r := 1
+		for ; y > 0; y-- {
+			r *= x
+		}
+		return r, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "<<":
+		return x << uint(y&63), nil
+	case ">>":
+		return x >> uint(y&63), nil
+	case "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "<":
+		return boolInt(x < y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	case "&&":
+		return boolInt(x != 0 && y != 0), nil
+	case "||":
+		return boolInt(x != 0 || y != 0), nil
+	default:
+		return 0, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fold replaces the symbols that syms can resolve with their current value, leaving the rest of the expression
+// intact. It is used to capture the value of variables such as a FOR loop counter at the point of use.
+func fold(e expr, syms symbols) expr {
+	switch e := e.(type) {
+	case symExpr:
+		if v, ok := syms.lookup(string(e)); ok {
+			return numExpr(v)
+		}
+		return e
+
+	case unaryExpr:
+		return unaryExpr{op: e.op, x: fold(e.x, syms)}
+
+	case binaryExpr:
+		return binaryExpr{op: e.op, x: fold(e.x, syms), y: fold(e.y, syms)}
+
+	case callExpr:
+		if e.fn == "DEF" {
+			v, _ := eval(e, syms)
+			return numExpr(v)
+		}
+		if v, err := eval(e, syms); err == nil {
+			return numExpr(v)
+		}
+		args := make([]expr, len(e.args))
+		for i, a := range e.args {
+			args[i] = fold(a, syms)
+		}
+		return callExpr{fn: e.fn, args: args}
+
+	default:
+		return e
+	}
+}
+
+// Expr is an expression in RGBDS syntax, such as Main.loop + 3 or HIGH(wBuffer), that tools such as debuggers
+// evaluate against symbols of their own.
+type Expr struct {
+	x expr
+}
+
+// Env provides the values an [Expr] is evaluated with.
+type Env struct {
+	// Symbol returns the value of a symbol. BANK(name) looks up the symbol "BANK(name)".
+	Symbol func(name string) (int, bool)
+	// Funcs are the functions an expression may call, in addition to HIGH, LOW, DEF and BANK.
+	// They must have been named when parsing the expression.
+	Funcs map[string]func(args ...int) (int, error)
+}
+
+// ParseExpr parses an expression, which may call the functions named by funcs in addition to the builtin functions.
+func ParseExpr(s string, funcs ...string) (*Expr, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, funcs: make(map[string]bool)}
+	for _, fn := range funcs {
+		p.funcs[strings.ToUpper(fn)] = true
+	}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", s, err)
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("%q: unexpected %q", s, p.peek().text)
+	}
+	return &Expr{x: x}, nil
+}
+
+// Eval evaluates the expression.
+func (e *Expr) Eval(env Env) (int, error) {
+	return eval(e.x, envSymbols{env})
+}
+
+// Symbol returns the name of the symbol if the expression is a single symbol.
+func (e *Expr) Symbol() (string, bool) {
+	s, ok := e.x.(symExpr)
+	return string(s), ok
+}
+
+// String implements fmt.Stringer
+func (e *Expr) String() string {
+	return e.x.String()
+}
+
+// envSymbols adapts an Env to the symbols of expression evaluation.
+type envSymbols struct {
+	env Env
+}
+
+func (s envSymbols) lookup(name string) (int, bool) {
+	if s.env.Symbol == nil {
+		return 0, false
+	}
+	return s.env.Symbol(name)
+}
+
+func (s envSymbols) call(e callExpr) (int, error) {
+	fn, ok := s.env.Funcs[e.fn]
+	if !ok {
+		return 0, fmt.Errorf("%s is not supported here", e.fn)
+	}
+	args := make([]int, len(e.args))
+	for i, a := range e.args {
+		v, err := eval(a, s)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return fn(args...)
+}
@@ -0,0 +1,404 @@
+package asm
+
+import (
+	"strings"
+)
+
+// stmtKind classifies a laid out statement.
+type stmtKind int
+
+const (
+	stmtInstr stmtKind = iota
+	stmtData
+	stmtSpace
+)
+
+// statement is a single instruction or data directive, laid out at an offset within its section.
+type statement struct {
+	kind stmtKind
+	pos  Pos
+	addr int
+	size int
+
+	// instructions
+	mnemonic   string
+	operands   []operandSyntax
+	candidates []*opcode
+
+	// data: DB (width 1) and DW (width 2), where strings are stored as bytes
+	width int
+	items []any
+
+	// DS
+	fill expr
+}
+
+// pcSymbols resolves @ to the address of the current statement, and every other symbol through syms.
+type pcSymbols struct {
+	syms symbols
+	pc   int
+}
+
+func (s pcSymbols) lookup(name string) (int, bool) {
+	if name == "@" {
+		return s.pc, true
+	}
+	return s.syms.lookup(name)
+}
+
+// chainSymbols resolves symbols from the first set that defines them.
+type chainSymbols []symbols
+
+func (c chainSymbols) lookup(name string) (int, bool) {
+	for _, s := range c {
+		if v, ok := s.lookup(name); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// sectionLayout is a section, and the statements laid out within it.
+type sectionLayout struct {
+	*Section
+	index int
+	stmts []*statement
+	pc    int
+}
+
+// bank returns the bank of the section if it is known without linking.
+func (s *sectionLayout) bank() (int, bool) {
+	switch {
+	case s.Bank >= 0:
+		return s.Bank, true
+	case s.Type == ROMX || s.Type == VRAM || s.Type == SRAM || s.Type == WRAMX:
+		return 0, false
+	default:
+		return 0, true
+	}
+}
+
+// label is where a label was defined.
+type label struct {
+	section *sectionLayout
+	offset  int
+}
+
+// labelSymbols resolves the labels whose address is known before linking: those within fixed sections.
+type labelSymbols map[string]label
+
+func (m labelSymbols) lookup(name string) (int, bool) {
+	if strings.HasPrefix(name, bankPrefix) {
+		l, ok := m[strings.TrimSuffix(strings.TrimPrefix(name, bankPrefix), ")")]
+		if !ok {
+			return 0, false
+		}
+		return l.section.bank()
+	}
+	l, ok := m[name]
+	if !ok || !l.section.Fixed() {
+		return 0, false
+	}
+	return l.section.Org + l.offset, true
+}
+
+// layout assigns addresses to the statements of expanded source, and collects the labels and constants it defines.
+type layout struct {
+	sections []*sectionLayout
+	cur      *sectionLayout
+	labels   labelSymbols
+	consts   symbolMap
+	// equs are constants defined with EQU, which may not be redefined.
+	equs     map[string]bool
+	exported map[string]bool
+	scope    string
+}
+
+func newLayout() *layout {
+	return &layout{
+		labels:   make(labelSymbols),
+		consts:   make(symbolMap),
+		equs:     make(map[string]bool),
+		exported: make(map[string]bool),
+	}
+}
+
+// section returns the current section, opening the implicit ROM0[$0000] section if none was declared.
+func (l *layout) section() *sectionLayout {
+	if l.cur == nil {
+		l.cur = &sectionLayout{Section: &Section{Type: ROM0, Org: 0, Bank: 0}, index: len(l.sections)}
+		l.sections = append(l.sections, l.cur)
+	}
+	return l.cur
+}
+
+// known resolves the symbols defined so far, whose value is known before linking.
+func (l *layout) known() symbols {
+	syms := chainSymbols{l.consts, l.labels}
+	if l.cur == nil || !l.cur.Fixed() {
+		return syms
+	}
+	return pcSymbols{syms: syms, pc: l.cur.Org + l.cur.pc}
+}
+
+func (l *layout) defineLabel(pos Pos, name string, exported bool) error {
+	if !strings.HasPrefix(name, ".") {
+		if i := strings.IndexByte(name, '.'); i < 0 {
+			l.scope = name
+		} else {
+			l.scope = name[:i]
+		}
+	}
+	name = qualify(l.scope, name)
+	if _, ok := l.labels[name]; ok {
+		return errorf(pos, "label %q already defined", name)
+	}
+	if _, ok := l.consts[name]; ok {
+		return errorf(pos, "%q already defined as a constant", name)
+	}
+	sec := l.section()
+	l.labels[name] = label{section: sec, offset: sec.pc}
+	if exported {
+		l.exported[name] = true
+	}
+	return nil
+}
+
+func (l *layout) defineConst(pos Pos, def constDef) error {
+	x, err := parseExpr(def.value, l.scope)
+	if err != nil {
+		return errorf(pos, "%v", err)
+	}
+	v, err := eval(x, l.known())
+	if err != nil {
+		return errorf(pos, "%s: %v", def.name, err)
+	}
+	if _, ok := l.labels[def.name]; ok {
+		return errorf(pos, "%q already defined as a label", def.name)
+	}
+	_, defined := l.consts[def.name]
+	switch {
+	case def.redef:
+	case defined && (def.op == "EQU" || l.equs[def.name]):
+		return errorf(pos, "constant %q already defined", def.name)
+	}
+	l.consts[def.name] = v
+	l.equs[def.name] = def.op == "EQU"
+	return nil
+}
+
+// sectionTypes maps the RGBDS section type names to their address ranges.
+var sectionTypes = map[string]SectionType{
+	"ROM0": ROM0, "ROMX": ROMX, "VRAM": VRAM, "SRAM": SRAM, "WRAM0": WRAM0, "WRAMX": WRAMX, "OAM": OAM, "HRAM": HRAM,
+}
+
+// openSection handles SECTION "name", TYPE[addr], BANK[n], ALIGN[n]
+func (l *layout) openSection(pos Pos, args string) error {
+	parts := splitArgs(args)
+	if len(parts) < 2 {
+		return errorf(pos, "SECTION requires a name and a type")
+	}
+	toks, err := tokenize(parts[0])
+	if err != nil || len(toks) != 1 || toks[0].kind != tokString {
+		return errorf(pos, "SECTION name must be a string")
+	}
+	sec := &Section{Name: toks[0].text, Org: -1, Bank: -1}
+	for _, s := range l.sections {
+		if s.Name == sec.Name {
+			return errorf(pos, "section %q already defined", sec.Name)
+		}
+	}
+
+	// option parses NAME[value], where the brackets are optional
+	option := func(s string) (name string, value int, hasValue bool, err error) {
+		i := strings.IndexByte(s, '[')
+		if i < 0 {
+			return strings.ToUpper(strings.TrimSpace(s)), 0, false, nil
+		}
+		if !strings.HasSuffix(s, "]") {
+			return "", 0, false, errorf(pos, "bad section option %q", s)
+		}
+		x, err := parseExpr(s[i+1:len(s)-1], l.scope)
+		if err != nil {
+			return "", 0, false, errorf(pos, "%v", err)
+		}
+		v, err := eval(x, l.known())
+		if err != nil {
+			return "", 0, false, errorf(pos, "%v", err)
+		}
+		return strings.ToUpper(strings.TrimSpace(s[:i])), v, true, nil
+	}
+
+	name, org, hasOrg, err := option(parts[1])
+	if err != nil {
+		return err
+	}
+	typ, ok := sectionTypes[name]
+	if !ok {
+		return errorf(pos, "unknown section type %q", name)
+	}
+	sec.Type = typ
+	if hasOrg {
+		sec.Org = org
+	}
+	for _, part := range parts[2:] {
+		name, v, hasValue, err := option(part)
+		if err != nil {
+			return err
+		}
+		switch {
+		case name == "BANK" && hasValue:
+			sec.Bank = v
+		case name == "ALIGN" && hasValue:
+			sec.Align = v
+		default:
+			return errorf(pos, "unknown section option %q", part)
+		}
+	}
+
+	l.cur = &sectionLayout{Section: sec, index: len(l.sections)}
+	l.sections = append(l.sections, l.cur)
+	return nil
+}
+
+// add lays out a single line of expanded source.
+func (l *layout) add(ln srcLine) error {
+	text := stripComment(ln.text)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	if def, ok := parseConstDef(text); ok {
+		return l.defineConst(ln.pos, def)
+	}
+
+	label, exported, rest := splitLabel(text)
+	if label == "" {
+		// local labels may omit their colon
+		if keyword, r := splitKeyword(text); strings.HasPrefix(keyword, ".") {
+			label, rest = keyword, r
+		}
+	}
+	if label != "" {
+		if err := l.defineLabel(ln.pos, label, exported); err != nil {
+			return err
+		}
+	}
+
+	keyword, args := splitKeyword(rest)
+	if keyword == "" {
+		return nil
+	}
+
+	upper := strings.ToUpper(keyword)
+	switch upper {
+	case "SECTION":
+		return l.openSection(ln.pos, args)
+
+	case "EXPORT", "GLOBAL":
+		for _, name := range splitArgs(args) {
+			l.exported[qualify(l.scope, name)] = true
+		}
+		return nil
+	}
+
+	sec := l.section()
+	stmt := &statement{pos: ln.pos, addr: sec.pc}
+	switch upper {
+	case "DB", "DW":
+		stmt.kind, stmt.width = stmtData, 1
+		if upper == "DW" {
+			stmt.width = 2
+		}
+		for _, arg := range splitArgs(args) {
+			if strings.HasPrefix(arg, `"`) && len(arg) > 2 {
+				toks, err := tokenize(arg)
+				if err != nil || len(toks) != 1 || toks[0].kind != tokString {
+					return errorf(ln.pos, "bad string %s", arg)
+				}
+				stmt.items = append(stmt.items, []byte(toks[0].text))
+				stmt.size += len(toks[0].text) * stmt.width
+				continue
+			}
+			x, err := parseExpr(arg, l.scope)
+			if err != nil {
+				return errorf(ln.pos, "%v", err)
+			}
+			stmt.items = append(stmt.items, fold(x, l.known()))
+			stmt.size += stmt.width
+		}
+
+	case "DS":
+		parts := splitArgs(args)
+		if len(parts) == 0 {
+			return errorf(ln.pos, "DS requires a size")
+		}
+		x, err := parseExpr(parts[0], l.scope)
+		if err != nil {
+			return errorf(ln.pos, "%v", err)
+		}
+		n, err := eval(x, l.known())
+		if err != nil {
+			return errorf(ln.pos, "DS size: %v", err)
+		}
+		if n < 0 {
+			return errorf(ln.pos, "DS size is negative")
+		}
+		stmt.kind, stmt.size, stmt.fill = stmtSpace, n, numExpr(0)
+		if len(parts) > 1 {
+			fill, err := parseExpr(parts[1], l.scope)
+			if err != nil {
+				return errorf(ln.pos, "%v", err)
+			}
+			stmt.fill = fold(fill, l.known())
+		}
+
+	default:
+		var ops []operandSyntax
+		for _, arg := range splitArgs(args) {
+			op, err := parseOperand(arg, l.scope)
+			if err != nil {
+				return errorf(ln.pos, "%v", err)
+			}
+			if op.x != nil {
+				op.x = fold(op.x, l.known())
+			}
+			ops = append(ops, op)
+		}
+
+		stmt.kind = stmtInstr
+		stmt.mnemonic, stmt.operands = normalize(upper, ops)
+		all := opcodes(stmt.mnemonic)
+		if len(all) == 0 {
+			return errorf(ln.pos, "unknown mnemonic or macro %q", keyword)
+		}
+		for _, o := range all {
+			if matchSyntax(o, stmt.operands) {
+				stmt.candidates = append(stmt.candidates, o)
+			}
+		}
+		if len(stmt.candidates) == 0 {
+			return errorf(ln.pos, "%s: illegal operands", strings.TrimSpace(rest))
+		}
+		stmt.size = stmt.candidates[0].Info.Bytes
+	}
+
+	if !sec.Type.IsROM() && stmt.kind != stmtSpace {
+		return errorf(ln.pos, "section %q is in %s, which can only reserve space with DS", sec.Name, sec.Type)
+	}
+	sec.stmts = append(sec.stmts, stmt)
+	sec.pc += stmt.size
+	return nil
+}
+
+func matchSyntax(o *opcode, ops []operandSyntax) bool {
+	if len(ops) != len(o.slots) {
+		return false
+	}
+	for i, s := range o.slots {
+		if !ops[i].match(s) {
+			return false
+		}
+	}
+	return true
+}
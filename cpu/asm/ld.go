@@ -125,6 +125,9 @@ func (a *Assembler) ld(i int, instr *Instruction, buf *bytes.Buffer) error {
 		case ptr.Ref == HL && ptr.Delta == Minus:
 			buf.WriteByte(0x32)
 
+		case ptr.Ref == HL && ptr.Delta == None:
+			buf.WriteByte(0x77)
+
 		default:
 			return illegalOperands()
 		}
@@ -185,6 +188,9 @@ func (a *Assembler) ld(i int, instr *Instruction, buf *bytes.Buffer) error {
 		case ptr.Ref == HL && ptr.Delta == Minus:
 			buf.WriteByte(0x3A)
 
+		case ptr.Ref == HL && ptr.Delta == None:
+			buf.WriteByte(0x7E)
+
 		default:
 			return illegalOperands()
 		}
@@ -432,7 +438,8 @@ func (a *Assembler) ld(i int, instr *Instruction, buf *bytes.Buffer) error {
 		binary.Write(buf, binary.LittleEndian, rh.(Pointer[Imm16]).Ref)
 
 	default:
-		return illegalOperands()
+		// the remaining forms, such as LD SP, HL are found in the opcode table
+		return a.encode(i, instr, buf)
 	}
 	return nil
 }
@@ -0,0 +1,293 @@
+// Package link implements a linker for the objects produced by package asm, producing Gameboy ROM images.
+package link
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/sym"
+)
+
+// region describes the addresses and banks a type of section may be placed in.
+type region struct {
+	start, end       int
+	minBank, maxBank int
+}
+
+var regions = map[asm.SectionType]region{
+	asm.ROM0:  {start: 0x0000, end: 0x4000, minBank: 0, maxBank: 0},
+	asm.ROMX:  {start: 0x4000, end: 0x8000, minBank: 1, maxBank: 511},
+	asm.VRAM:  {start: 0x8000, end: 0xA000, minBank: 0, maxBank: 1},
+	asm.SRAM:  {start: 0xA000, end: 0xC000, minBank: 0, maxBank: 15},
+	asm.WRAM0: {start: 0xC000, end: 0xD000, minBank: 0, maxBank: 0},
+	asm.WRAMX: {start: 0xD000, end: 0xE000, minBank: 1, maxBank: 7},
+	asm.OAM:   {start: 0xFE00, end: 0xFEA0, minBank: 0, maxBank: 0},
+	asm.HRAM:  {start: 0xFF80, end: 0xFFFF, minBank: 0, maxBank: 0},
+}
+
+// Options control how the ROM image is produced.
+type Options struct {
+	// Fix writes the cartridge header, as rgbfix -v does: the Nintendo logo, Title, CGBFlag, Type, the ROM and RAM
+	// sizes, and the header and global checksums. Without Fix, the header is left as the sections define it.
+	Fix      bool
+	Title    string
+	CGBFlag  uint8
+	Type     cartridge.Type
+	RAMSize  uint8
+	Version  uint8
+	Licensee string
+
+	// Pad is the value of every byte of the ROM no section occupies.
+	Pad byte
+}
+
+// Symbol is a label, placed by the linker.
+type Symbol struct {
+	Name string
+	Bank int
+	Addr int
+}
+
+// Line is the source position of an instruction, placed by the linker.
+type Line struct {
+	Bank int
+	Addr int
+	Pos  asm.Pos
+}
+
+// Image is a linked ROM image.
+type Image struct {
+	ROM []byte
+	// Symbols are the labels of every object, sorted by bank and address.
+	Symbols []Symbol
+	// Lines are the source positions of the instructions in ROM, sorted by bank and address.
+	Lines []Line
+}
+
+// placed is a section, and where it was placed.
+type placed struct {
+	*asm.Section
+	obj *asm.Object
+	at  asm.Placement
+}
+
+// span is a range of addresses within a bank.
+type span struct {
+	start, end int
+}
+
+// Linker places the sections of relocatable objects into memory, and produces a ROM image.
+type Linker struct {
+	Options Options
+
+	// used holds the occupied spans of every bank of every section type
+	used map[asm.SectionType]map[int][]span
+}
+
+// NewLinker constructs a new [Linker] object.
+func NewLinker(opts Options) *Linker {
+	return &Linker{Options: opts}
+}
+
+// Link links objects into a ROM image.
+func Link(opts Options, objs ...*asm.Object) (*Image, error) {
+	return NewLinker(opts).Link(objs...)
+}
+
+// Link links objects into a ROM image.
+func (l *Linker) Link(objs ...*asm.Object) (*Image, error) {
+	l.used = make(map[asm.SectionType]map[int][]span)
+
+	var sections []*placed
+	for _, obj := range objs {
+		for _, sec := range obj.Sections {
+			sections = append(sections, &placed{Section: sec, obj: obj})
+		}
+	}
+
+	// place the most constrained sections first, and then the largest
+	constraint := func(p *placed) int {
+		n := 0
+		if p.Org >= 0 {
+			n += 2
+		}
+		if p.Bank >= 0 {
+			n++
+		}
+		return n
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		ci, cj := constraint(sections[i]), constraint(sections[j])
+		if ci != cj {
+			return ci > cj
+		}
+		if sections[i].Align != sections[j].Align {
+			return sections[i].Align > sections[j].Align
+		}
+		return sections[i].Size > sections[j].Size
+	})
+	for _, p := range sections {
+		if err := l.place(p); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := newResolver(objs, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	banks := 2
+	for _, p := range sections {
+		if p.Type == asm.ROMX && p.at.Bank+1 > banks {
+			banks = p.at.Bank + 1
+		}
+	}
+	// ROM sizes are powers of 2
+	for banks&(banks-1) != 0 {
+		banks++
+	}
+
+	rom := make([]byte, banks*cartridge.BankSize)
+	for i := range rom {
+		rom[i] = l.Options.Pad
+	}
+	var lines []Line
+	for _, p := range sections {
+		if !p.Type.IsROM() {
+			continue
+		}
+		for _, line := range p.Lines {
+			lines = append(lines, Line{Bank: p.at.Bank, Addr: p.at.Addr + line.Offset, Pos: line.Pos})
+		}
+		data := append([]byte(nil), p.Data...)
+		for _, reloc := range p.Relocs {
+			if err := reloc.Apply(data, p.at, res.in(p.obj)); err != nil {
+				return nil, err
+			}
+		}
+		offset := p.at.Bank*cartridge.BankSize + p.at.Addr
+		if p.Type == asm.ROMX {
+			offset -= cartridge.BankSize
+		}
+		copy(rom[offset:], data)
+	}
+
+	if l.Options.Fix {
+		if err := l.fix(rom); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Bank != lines[j].Bank {
+			return lines[i].Bank < lines[j].Bank
+		}
+		return lines[i].Addr < lines[j].Addr
+	})
+	return &Image{ROM: rom, Symbols: res.placedSymbols(), Lines: lines}, nil
+}
+
+// place finds a bank and address for a section.
+func (l *Linker) place(p *placed) error {
+	r, ok := regions[p.Type]
+	if !ok {
+		return fmt.Errorf("section %q: unknown type %v", p.Name, p.Type)
+	}
+
+	minBank, maxBank := r.minBank, r.maxBank
+	if p.Bank >= 0 {
+		if p.Bank < r.minBank || p.Bank > r.maxBank {
+			return fmt.Errorf("section %q: bank %d is not valid for %v", p.Name, p.Bank, p.Type)
+		}
+		minBank, maxBank = p.Bank, p.Bank
+	}
+	if p.Org >= 0 && (p.Org < r.start || p.Org+p.Size > r.end) {
+		return fmt.Errorf("section %q: $%04X-$%04X is outside of %v", p.Name, p.Org, p.Org+p.Size-1, p.Type)
+	}
+
+	if l.used[p.Type] == nil {
+		l.used[p.Type] = make(map[int][]span)
+	}
+	align := 1 << p.Align
+	for bank := minBank; bank <= maxBank; bank++ {
+		used := l.used[p.Type][bank]
+		addr, ok := -1, false
+		if p.Org >= 0 {
+			addr, ok = p.Org, p.Org%align == 0 && free(used, p.Org, p.Org+p.Size)
+		} else {
+			addr, ok = firstFit(used, r.start, r.end, p.Size, align)
+		}
+		if !ok {
+			continue
+		}
+		l.used[p.Type][bank] = append(used, span{start: addr, end: addr + p.Size})
+		p.at = asm.Placement{Addr: addr, Bank: bank}
+		return nil
+	}
+	return fmt.Errorf("section %q: no room for %d bytes in %v", p.Name, p.Size, p.Type)
+}
+
+// free reports whether [start, end) overlaps none of the used spans.
+func free(used []span, start, end int) bool {
+	for _, s := range used {
+		if start < s.end && s.start < end {
+			return false
+		}
+	}
+	return true
+}
+
+// firstFit finds the lowest aligned address within [start, end) where size bytes are free.
+func firstFit(used []span, start, end, size, align int) (int, bool) {
+	for addr := (start + align - 1) / align * align; addr+size <= end; addr += align {
+		if free(used, addr, addr+size) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// fix writes the cartridge header into a ROM image.
+func (l *Linker) fix(rom []byte) error {
+	opts := l.Options
+	if len(opts.Title) > cartridge.TitleLength {
+		return fmt.Errorf("title %q is longer than %d characters", opts.Title, cartridge.TitleLength)
+	}
+	if opts.CGBFlag != 0 && len(opts.Title) > cartridge.CGBFlagOffset-cartridge.TitleOffset {
+		return fmt.Errorf("title %q is too long to hold a CGB flag", opts.Title)
+	}
+	copy(rom[cartridge.LogoOffset:], cartridge.Logo[:])
+	title := rom[cartridge.TitleOffset : cartridge.TitleOffset+cartridge.TitleLength]
+	for i := range title {
+		title[i] = 0
+	}
+	copy(title, opts.Title)
+	if opts.CGBFlag != 0 {
+		rom[cartridge.CGBFlagOffset] = opts.CGBFlag
+	}
+	if len(opts.Licensee) > 0 {
+		if len(opts.Licensee) != 2 {
+			return errors.New("licensee must be 2 characters")
+		}
+		copy(rom[cartridge.NewLicenseeOffset:], opts.Licensee)
+		rom[cartridge.OldLicenseeOffset] = 0x33
+	}
+	rom[cartridge.TypeOffset] = byte(opts.Type)
+	rom[cartridge.ROMSizeOffset] = cartridge.ROMSizeCode(len(rom) / cartridge.BankSize)
+	rom[cartridge.RAMSizeOffset] = opts.RAMSize
+	rom[cartridge.VersionOffset] = opts.Version
+	return cartridge.FixChecksums(rom)
+}
+
+// SymbolTable returns the labels of the image as a symbol table, to be written as a .sym file.
+func (img *Image) SymbolTable() *sym.Table {
+	t := sym.NewTable()
+	for _, s := range img.Symbols {
+		t.Add(s.Bank, s.Addr, s.Name)
+	}
+	return t
+}
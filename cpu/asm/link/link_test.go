@@ -0,0 +1,152 @@
+package link
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/stretchr/testify/assert"
+)
+
+func assemble(t *testing.T, name, src string) *asm.Object {
+	t.Helper()
+	obj, err := asm.AssembleObject(name, strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestLink(t *testing.T) {
+	main := assemble(t, "main.asm", `
+SECTION "Entry", ROM0[$100]
+	nop
+	jp Main
+	ds $150 - @
+
+SECTION "Main", ROM0
+Main:
+	call Func
+	ld a, BANK(Func)
+	ldh [hValue], a
+.loop
+	jr .loop
+`)
+	lib := assemble(t, "lib.asm", `
+SECTION "Func", ROMX, BANK[3]
+Func::
+	ld hl, wBuffer
+	ret
+
+SECTION "Buffer", WRAM0, ALIGN[8]
+wBuffer:: ds 16
+
+SECTION "HRAM", HRAM
+hValue:: ds 1
+`)
+
+	img, err := Link(Options{Fix: true, Title: "TEST", Type: cartridge.MBC5}, main, lib)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rom := img.ROM
+
+	assert.Len(t, rom, 4*cartridge.BankSize)
+	assert.NoError(t, cartridge.Verify(rom))
+	header, err := cartridge.ParseHeader(rom)
+	assert.NoError(t, err)
+	assert.Equal(t, "TEST", header.Title)
+	assert.Equal(t, cartridge.MBC5, header.Type)
+	assert.Equal(t, 4, header.ROMBanks())
+
+	// the floating Main section is placed at the start of ROM0, before the fixed entry point
+	assert.Equal(t, []byte{0x00, 0xC3, 0x00, 0x00}, rom[0x100:0x104])
+	assert.Equal(t, []byte{
+		0xCD, 0x00, 0x40, // call Func
+		0x3E, 0x03, // ld a, BANK(Func)
+		0xE0, 0x80, // ldh [hValue], a
+		0x18, 0xFE, // jr .loop
+	}, rom[0x0000:0x0009])
+	assert.Equal(t, []byte{0x21, 0x00, 0xC0, 0xC9}, rom[3*cartridge.BankSize:3*cartridge.BankSize+4])
+
+	assert.Equal(t, []Symbol{
+		{Name: "Main", Bank: 0, Addr: 0x0000},
+		{Name: "Main.loop", Bank: 0, Addr: 0x0007},
+		{Name: "wBuffer", Bank: 0, Addr: 0xC000},
+		{Name: "hValue", Bank: 0, Addr: 0xFF80},
+		{Name: "Func", Bank: 3, Addr: 0x4000},
+	}, img.Symbols)
+
+	assert.Equal(t, []Line{
+		{Bank: 0, Addr: 0x0000, Pos: asm.Pos{File: "main.asm", Line: 9}},
+		{Bank: 0, Addr: 0x0003, Pos: asm.Pos{File: "main.asm", Line: 10}},
+		{Bank: 0, Addr: 0x0005, Pos: asm.Pos{File: "main.asm", Line: 11}},
+		{Bank: 0, Addr: 0x0007, Pos: asm.Pos{File: "main.asm", Line: 13}},
+		{Bank: 0, Addr: 0x0100, Pos: asm.Pos{File: "main.asm", Line: 3}},
+		{Bank: 0, Addr: 0x0101, Pos: asm.Pos{File: "main.asm", Line: 4}},
+		{Bank: 3, Addr: 0x4000, Pos: asm.Pos{File: "lib.asm", Line: 4}},
+		{Bank: 3, Addr: 0x4003, Pos: asm.Pos{File: "lib.asm", Line: 5}},
+	}, img.Lines)
+
+	var syms bytes.Buffer
+	_, err = img.SymbolTable().WriteTo(&syms)
+	assert.NoError(t, err)
+	assert.Equal(t, "00:0000 Main\n00:0007 Main.loop\n00:c000 wBuffer\n00:ff80 hValue\n03:4000 Func\n", syms.String())
+}
+
+func TestLinkWithoutFix(t *testing.T) {
+	obj := assemble(t, "main.asm", `
+SECTION "Start", ROM0[$0]
+	db 1, 2, 3
+`)
+	img, err := Link(Options{Pad: 0xFF}, obj)
+	assert.NoError(t, err)
+	assert.Len(t, img.ROM, 2*cartridge.BankSize)
+	assert.Equal(t, []byte{1, 2, 3, 0xFF}, img.ROM[:4])
+	assert.Error(t, cartridge.Verify(img.ROM))
+}
+
+func TestLinkErrors(t *testing.T) {
+	for name, objs := range map[string][]string{
+		`main.asm:3: undefined symbol "Missing"`: {`
+SECTION "A", ROM0
+	jp Missing
+`},
+		`main.asm:3: undefined symbol "Hidden"`: {`
+SECTION "A", ROM0
+	jp Hidden
+`, `
+SECTION "B", ROM0
+Hidden:
+`},
+		`section "B": no room for 1 bytes in ROM0`: {`
+SECTION "A", ROM0[0]
+	ds $4000
+SECTION "B", ROM0
+	db 0
+`},
+		`symbol "Twice" is exported more than once`: {`
+SECTION "A", ROM0
+Twice::
+`, `
+SECTION "B", ROM0
+Twice::
+`},
+		`main.asm:4: jump target $4000 is out of range`: {`
+SECTION "A", ROM0[$100]
+Start:
+	jr Far
+SECTION "B", ROMX
+Far:
+`},
+	} {
+		var linked []*asm.Object
+		for _, src := range objs {
+			linked = append(linked, assemble(t, "main.asm", src))
+		}
+		_, err := Link(Options{}, linked...)
+		assert.EqualError(t, err, name)
+	}
+}
@@ -0,0 +1,122 @@
+package link
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// definition is a placed symbol.
+type definition struct {
+	sym *asm.Symbol
+	sec *placed
+}
+
+func (d definition) value() int {
+	if d.sec == nil {
+		return d.sym.Value
+	}
+	return d.sec.at.Addr + d.sym.Value
+}
+
+// resolver resolves the symbols of every object once sections are placed. Exported symbols are visible to every
+// object, while the rest are only visible within the object defining them.
+type resolver struct {
+	global map[string]definition
+	local  map[*asm.Object]map[string]definition
+}
+
+func newResolver(objs []*asm.Object, sections []*placed) (*resolver, error) {
+	r := &resolver{
+		global: make(map[string]definition),
+		local:  make(map[*asm.Object]map[string]definition),
+	}
+
+	bySection := make(map[*asm.Section]*placed)
+	for _, p := range sections {
+		bySection[p.Section] = p
+	}
+
+	for _, obj := range objs {
+		r.local[obj] = make(map[string]definition)
+		for _, sym := range obj.Symbols {
+			def := definition{sym: sym}
+			if sym.Section >= 0 {
+				if sym.Section >= len(obj.Sections) {
+					return nil, fmt.Errorf("symbol %q: bad section %d", sym.Name, sym.Section)
+				}
+				def.sec = bySection[obj.Sections[sym.Section]]
+			}
+			r.local[obj][sym.Name] = def
+			if !sym.Exported {
+				continue
+			}
+			if _, ok := r.global[sym.Name]; ok {
+				return nil, fmt.Errorf("symbol %q is exported more than once", sym.Name)
+			}
+			r.global[sym.Name] = def
+		}
+	}
+	return r, nil
+}
+
+// in returns the view of the symbols from within an object.
+func (r *resolver) in(obj *asm.Object) asm.Resolver {
+	return objectResolver{r: r, obj: obj}
+}
+
+func (r *resolver) find(obj *asm.Object, name string) (definition, bool) {
+	if def, ok := r.local[obj][name]; ok {
+		return def, true
+	}
+	def, ok := r.global[name]
+	return def, ok
+}
+
+// placedSymbols lists every label, sorted by bank and address.
+func (r *resolver) placedSymbols() []Symbol {
+	var syms []Symbol
+	for _, defs := range r.local {
+		for name, def := range defs {
+			if def.sec == nil {
+				continue
+			}
+			syms = append(syms, Symbol{Name: name, Bank: def.sec.at.Bank, Addr: def.value()})
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		switch {
+		case syms[i].Bank != syms[j].Bank:
+			return syms[i].Bank < syms[j].Bank
+		case syms[i].Addr != syms[j].Addr:
+			return syms[i].Addr < syms[j].Addr
+		default:
+			return syms[i].Name < syms[j].Name
+		}
+	})
+	return syms
+}
+
+type objectResolver struct {
+	r   *resolver
+	obj *asm.Object
+}
+
+// Symbol implements asm.Resolver.
+func (o objectResolver) Symbol(name string) (int, bool) {
+	def, ok := o.r.find(o.obj, name)
+	if !ok {
+		return 0, false
+	}
+	return def.value(), true
+}
+
+// Bank implements asm.Resolver.
+func (o objectResolver) Bank(name string) (int, bool) {
+	def, ok := o.r.find(o.obj, name)
+	if !ok || def.sec == nil {
+		return 0, false
+	}
+	return def.sec.at.Bank, true
+}
@@ -0,0 +1,405 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SectionType is the memory region a section is placed into.
+type SectionType int
+
+// Section types, named as in RGBDS.
+const (
+	ROM0 SectionType = iota
+	ROMX
+	VRAM
+	SRAM
+	WRAM0
+	WRAMX
+	OAM
+	HRAM
+)
+
+var sectionTypeStrs = []string{"ROM0", "ROMX", "VRAM", "SRAM", "WRAM0", "WRAMX", "OAM", "HRAM"}
+
+// String implements fmt.Stringer
+func (t SectionType) String() string {
+	if t >= ROM0 && t <= HRAM {
+		return sectionTypeStrs[t]
+	}
+	return "<invalid section type>"
+}
+
+// IsROM reports whether the section type is stored in the ROM image.
+func (t SectionType) IsROM() bool {
+	return t == ROM0 || t == ROMX
+}
+
+// Section is a contiguous block of code or data, which a linker places into memory.
+type Section struct {
+	Name string
+	Type SectionType
+	// Org is the fixed address of the section, or -1 if the linker may place it anywhere.
+	Org int
+	// Bank is the fixed bank of the section, or -1 if the linker may choose the bank.
+	Bank int
+	// Align is the number of low address bits that must be zero.
+	Align int
+	// Size of the section in bytes. For ROM sections, this is the length of Data.
+	Size int
+	// Data is the content of a ROM section, with relocated values left as zero.
+	Data   []byte
+	Relocs []*Reloc
+	// Lines are the source positions of the instructions of the section, in order of their offsets.
+	Lines []Line
+}
+
+// Line is the source position of an instruction, at an offset within its section.
+type Line struct {
+	Offset int
+	Pos    Pos
+}
+
+// Fixed reports whether the address of the section is known without linking.
+func (s *Section) Fixed() bool {
+	return s.Org >= 0
+}
+
+// Symbol is a label or constant defined by an object.
+type Symbol struct {
+	Name string
+	// Section is the index of the section defining a label, or -1 for a constant.
+	Section int
+	// Value is the offset of a label within its section, or the value of a constant.
+	Value int
+	// Exported symbols are visible to other objects.
+	Exported bool
+}
+
+// RelocKind is the way a relocated value is stored.
+type RelocKind int
+
+const (
+	// RelocByte stores an 8 bit value
+	RelocByte RelocKind = iota
+	// RelocWord stores a little endian 16 bit value
+	RelocWord
+	// RelocJR stores the 8 bit offset to a JR target, relative to the end of the JR instruction.
+	RelocJR
+	// RelocHigh stores the low byte of an address in the $FF00-$FFFF page, for LDH.
+	RelocHigh
+)
+
+// Reloc is a value that can only be computed once the linker has placed every section.
+type Reloc struct {
+	// Offset is where the value is stored in the section.
+	Offset int
+	Kind   RelocKind
+	// Expr is the expression of the value in RGBDS syntax.
+	Expr string
+	// PC is the offset of the instruction or data directive containing the value, which is what @ refers to.
+	PC  int
+	Pos Pos
+}
+
+// Resolver resolves symbols once sections are placed.
+type Resolver interface {
+	// Symbol returns the value of a symbol.
+	Symbol(name string) (int, bool)
+	// Bank returns the bank of the section a label is placed in.
+	Bank(name string) (int, bool)
+}
+
+// Placement is where a linker placed a section.
+type Placement struct {
+	Addr int
+	Bank int
+}
+
+// resolverSymbols adapts a Resolver to the symbols of expression evaluation.
+type resolverSymbols struct {
+	r  Resolver
+	at Placement
+}
+
+func (s resolverSymbols) lookup(name string) (int, bool) {
+	switch {
+	case name == "@":
+		return s.at.Addr, true
+
+	case name == bankOf("@"):
+		return s.at.Bank, true
+
+	case strings.HasPrefix(name, bankPrefix):
+		return s.r.Bank(strings.TrimSuffix(strings.TrimPrefix(name, bankPrefix), ")"))
+
+	default:
+		return s.r.Symbol(name)
+	}
+}
+
+// Apply computes the relocated value, and stores it into data: the contents of the section, placed at sec.
+func (r *Reloc) Apply(data []byte, sec Placement, res Resolver) error {
+	x, err := parseExpr(r.Expr, "")
+	if err != nil {
+		return errorf(r.Pos, "%v", err)
+	}
+	pc := Placement{Addr: sec.Addr + r.PC, Bank: sec.Bank}
+	v, err := eval(x, resolverSymbols{r: res, at: pc})
+	if err != nil {
+		return errorf(r.Pos, "%v", err)
+	}
+
+	size := 1
+	if r.Kind == RelocWord {
+		size = 2
+	}
+	if r.Offset < 0 || r.Offset+size > len(data) {
+		return errorf(r.Pos, "relocation at offset %d is outside of its section", r.Offset)
+	}
+
+	switch r.Kind {
+	case RelocByte:
+		if v < -0x80 || v > 0xFF {
+			return errorf(r.Pos, "value $%X out of range", v)
+		}
+		data[r.Offset] = byte(v)
+
+	case RelocWord:
+		if v < -0x8000 || v > 0xFFFF {
+			return errorf(r.Pos, "value $%X out of range", v)
+		}
+		binary.LittleEndian.PutUint16(data[r.Offset:], uint16(v))
+
+	case RelocJR:
+		offset := v - (pc.Addr + 2)
+		if offset < -0x80 || offset > 0x7F {
+			return errorf(r.Pos, "jump target $%X is out of range", v)
+		}
+		data[r.Offset] = byte(offset)
+
+	case RelocHigh:
+		if v < 0x100 {
+			v |= 0xFF00
+		}
+		if v < 0xFF00 || v > 0xFFFF {
+			return errorf(r.Pos, "address $%X is not within $FF00-$FFFF", v)
+		}
+		data[r.Offset] = byte(v)
+
+	default:
+		return errorf(r.Pos, "unknown relocation kind %d", r.Kind)
+	}
+	return nil
+}
+
+// Object is a relocatable object, the output of assembling a single source file.
+type Object struct {
+	Sections []*Section
+	Symbols  []*Symbol
+}
+
+const (
+	objectMagic   = "GPOB"
+	objectVersion = 2
+)
+
+// objectWriter writes the primitive values of the object format.
+type objectWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (o *objectWriter) int(v int) {
+	if o.err == nil {
+		o.err = binary.Write(o.w, binary.LittleEndian, int32(v))
+	}
+}
+
+func (o *objectWriter) bool(b bool) {
+	o.int(boolInt(b))
+}
+
+func (o *objectWriter) bytes(b []byte) {
+	o.int(len(b))
+	if o.err == nil {
+		_, o.err = o.w.Write(b)
+	}
+}
+
+func (o *objectWriter) string(s string) {
+	o.bytes([]byte(s))
+}
+
+// WriteTo implements io.WriterTo, writing the object in a binary format that can be read with [ReadObject].
+func (obj *Object) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	o := &objectWriter{w: bufio.NewWriter(cw)}
+	o.w.WriteString(objectMagic)
+	o.int(objectVersion)
+
+	o.int(len(obj.Sections))
+	for _, s := range obj.Sections {
+		o.string(s.Name)
+		o.int(int(s.Type))
+		o.int(s.Org)
+		o.int(s.Bank)
+		o.int(s.Align)
+		o.int(s.Size)
+		o.bytes(s.Data)
+		o.int(len(s.Relocs))
+		for _, r := range s.Relocs {
+			o.int(r.Offset)
+			o.int(int(r.Kind))
+			o.string(r.Expr)
+			o.int(r.PC)
+			o.string(r.Pos.File)
+			o.int(r.Pos.Line)
+		}
+		o.int(len(s.Lines))
+		for _, l := range s.Lines {
+			o.int(l.Offset)
+			o.string(l.Pos.File)
+			o.int(l.Pos.Line)
+		}
+	}
+
+	o.int(len(obj.Symbols))
+	for _, sym := range obj.Symbols {
+		o.string(sym.Name)
+		o.int(sym.Section)
+		o.int(sym.Value)
+		o.bool(sym.Exported)
+	}
+
+	if o.err == nil {
+		o.err = o.w.Flush()
+	}
+	return cw.n, o.err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// objectReader reads the primitive values of the object format.
+type objectReader struct {
+	r   io.Reader
+	err error
+}
+
+// maxObjectLength bounds the length of any single field, to reject corrupt objects before allocating.
+const maxObjectLength = 1 << 24
+
+func (o *objectReader) int() int {
+	var v int32
+	if o.err == nil {
+		o.err = binary.Read(o.r, binary.LittleEndian, &v)
+	}
+	return int(v)
+}
+
+func (o *objectReader) bool() bool {
+	return o.int() != 0
+}
+
+func (o *objectReader) length() int {
+	n := o.int()
+	if o.err == nil && (n < 0 || n > maxObjectLength) {
+		o.err = fmt.Errorf("bad length %d", n)
+	}
+	if o.err != nil {
+		return 0
+	}
+	return n
+}
+
+// lines reads the number of lines of a section, which objects before version 2 do not have.
+func (o *objectReader) lines(version int) int {
+	if version < 2 {
+		return 0
+	}
+	return o.length()
+}
+
+func (o *objectReader) bytes() []byte {
+	n := o.length()
+	if n == 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if o.err == nil {
+		_, o.err = io.ReadFull(o.r, b)
+	}
+	return b
+}
+
+func (o *objectReader) string() string {
+	return string(o.bytes())
+}
+
+// ReadObject reads an object written by [Object.WriteTo].
+func ReadObject(r io.Reader) (*Object, error) {
+	magic := make([]byte, len(objectMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, []byte(objectMagic)) {
+		return nil, errors.New("not an object file")
+	}
+	o := &objectReader{r: bufio.NewReader(r)}
+	// version 1 objects have no line information
+	version := o.int()
+	if o.err == nil && (version < 1 || version > objectVersion) {
+		return nil, fmt.Errorf("unsupported object version %d", version)
+	}
+
+	obj := &Object{}
+	for n := o.length(); n > 0 && o.err == nil; n-- {
+		s := &Section{
+			Name:  o.string(),
+			Type:  SectionType(o.int()),
+			Org:   o.int(),
+			Bank:  o.int(),
+			Align: o.int(),
+			Size:  o.int(),
+			Data:  o.bytes(),
+		}
+		for m := o.length(); m > 0 && o.err == nil; m-- {
+			s.Relocs = append(s.Relocs, &Reloc{
+				Offset: o.int(),
+				Kind:   RelocKind(o.int()),
+				Expr:   o.string(),
+				PC:     o.int(),
+				Pos:    Pos{File: o.string(), Line: o.int()},
+			})
+		}
+		for m := o.lines(version); m > 0 && o.err == nil; m-- {
+			s.Lines = append(s.Lines, Line{Offset: o.int(), Pos: Pos{File: o.string(), Line: o.int()}})
+		}
+		obj.Sections = append(obj.Sections, s)
+	}
+
+	for n := o.length(); n > 0 && o.err == nil; n-- {
+		obj.Symbols = append(obj.Symbols, &Symbol{
+			Name:     o.string(),
+			Section:  o.int(),
+			Value:    o.int(),
+			Exported: o.bool(),
+		})
+	}
+
+	if o.err != nil {
+		return nil, fmt.Errorf("reading object: %v", o.err)
+	}
+	return obj, nil
+}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssembleObject(t *testing.T) {
+	obj, err := AssembleObject("main.asm", strings.NewReader(`
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROMX
+Main::
+	call Func
+	ld a, BANK(Main)
+.loop
+	jr .loop
+	dw Main
+
+SECTION "Vars", WRAM0
+wCounter: ds 2
+`))
+	assert.NoError(t, err)
+	if !assert.Len(t, obj.Sections, 3) {
+		return
+	}
+
+	header, main, vars := obj.Sections[0], obj.Sections[1], obj.Sections[2]
+	assert.Equal(t, &Section{
+		Name: "Header", Type: ROM0, Org: 0x100, Bank: -1, Size: 4,
+		Data: []byte{0x00, 0xC3, 0x00, 0x00},
+		Relocs: []*Reloc{
+			{Offset: 2, Kind: RelocWord, Expr: "Main", PC: 1, Pos: Pos{"main.asm", 4}},
+		},
+		Lines: []Line{{0, Pos{"main.asm", 3}}, {1, Pos{"main.asm", 4}}},
+	}, header)
+
+	assert.Equal(t, []byte{0xCD, 0x00, 0x00, 0x3E, 0x00, 0x18, 0x00, 0x00, 0x00}, main.Data)
+	assert.Equal(t, []*Reloc{
+		{Offset: 1, Kind: RelocWord, Expr: "Func", PC: 0, Pos: Pos{"main.asm", 8}},
+		{Offset: 4, Kind: RelocByte, Expr: "BANK(Main)", PC: 3, Pos: Pos{"main.asm", 9}},
+		{Offset: 6, Kind: RelocJR, Expr: "Main.loop", PC: 5, Pos: Pos{"main.asm", 11}},
+		{Offset: 7, Kind: RelocWord, Expr: "Main", PC: 7, Pos: Pos{"main.asm", 12}},
+	}, main.Relocs)
+	// data has no line information
+	assert.Equal(t, []Line{
+		{0, Pos{"main.asm", 8}},
+		{3, Pos{"main.asm", 9}},
+		{5, Pos{"main.asm", 11}},
+	}, main.Lines)
+
+	assert.Equal(t, 2, vars.Size)
+	assert.Nil(t, vars.Data)
+
+	assert.Equal(t, []*Symbol{
+		{Name: "Main", Section: 1, Value: 0, Exported: true},
+		{Name: "Main.loop", Section: 1, Value: 5},
+		{Name: "wCounter", Section: 2, Value: 0},
+	}, obj.Symbols)
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	obj, err := AssembleObject("main.asm", strings.NewReader(`
+DEF VALUE EQU 3
+EXPORT VALUE
+SECTION "Code", ROM0, ALIGN[8]
+Start::
+	ld hl, Start + VALUE
+`))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = obj.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	read, err := ReadObject(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, obj, read)
+
+	_, err = ReadObject(strings.NewReader("not an object"))
+	assert.Error(t, err)
+}
+
+func TestAssembleObjectErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"SECTION \"A\", ROM0\nSECTION \"A\", ROM0": `test.asm:2: section "A" already defined`,
+		"SECTION \"A\", ROM9":                      `test.asm:1: unknown section type "ROM9"`,
+		"SECTION \"A\", WRAM0\n\tnop":              `test.asm:2: section "A" is in WRAM0, which can only reserve space with DS`,
+		"EXPORT Missing":                           `exported symbol "Missing" is not defined`,
+		"SECTION \"A\", ROM0\n\tbit X, a":          `test.asm:2: undefined symbol "X"`,
+	} {
+		_, err := AssembleObject("test.asm", strings.NewReader(src))
+		assert.EqualError(t, err, want, src)
+	}
+}
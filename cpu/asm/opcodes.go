@@ -0,0 +1,350 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopherpocket/gopherpocket/cpu/opcodedata"
+)
+
+// slotKind classifies an operand slot of an opcode, as described by [opcodedata].
+type slotKind int
+
+const (
+	// slotExact matches a single operand value, such as a register, condition, bit index or RST vector.
+	slotExact slotKind = iota
+	slotImm8
+	slotImm16
+	// slotAddr16 is a pointer to a 16 bit address: [a16]
+	slotAddr16
+	// slotHighAddr is a pointer into the $FF00-$FFFF page, used by LDH: [a8]
+	slotHighAddr
+	slotRel8
+	// slotSPOffset is the SP + e8 operand of LD HL, SP + e8
+	slotSPOffset
+)
+
+type operandSlot struct {
+	kind  slotKind
+	exact Operand
+}
+
+// match reports whether op can be placed into the slot.
+func (s operandSlot) match(op Operand) bool {
+	switch s.kind {
+	case slotExact:
+		return isEq(s.exact, op)
+
+	case slotImm8:
+		return is[Imm8](op)
+
+	case slotImm16:
+		return is[Imm16](op)
+
+	case slotAddr16:
+		return is[Pointer[Imm16]](op)
+
+	case slotHighAddr:
+		if ptr, ok := op.(Pointer[Imm16]); ok {
+			return ptr.Ref >= 0xFF00 && ptr.Delta == None
+		}
+		return is[Pointer[Imm8]](op)
+
+	case slotRel8:
+		return is[Rel8](op)
+
+	case slotSPOffset:
+		r, ok := op.(Reg16)
+		return ok && r >= spMin && r <= spMax
+
+	default:
+		return false
+	}
+}
+
+// opcode is a single encodable entry of the opcode table.
+type opcode struct {
+	Prefixed bool
+	Code     uint8
+	Info     *opcodedata.InstructionInfo
+
+	slots []operandSlot
+}
+
+// match reports whether the operands can be encoded by the opcode.
+func (o *opcode) match(ops []Operand) bool {
+	if len(ops) != len(o.slots) {
+		return false
+	}
+	for i, s := range o.slots {
+		if !s.match(ops[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// encode writes the opcode and its operands into buf. The operands must match the opcode.
+func (o *opcode) encode(ops []Operand, buf *bytes.Buffer) {
+	if o.Prefixed {
+		buf.WriteByte(0xCB)
+	}
+	buf.WriteByte(o.Code)
+
+	for i, s := range o.slots {
+		switch s.kind {
+		case slotImm8:
+			buf.WriteByte(byte(ops[i].(Imm8)))
+
+		case slotImm16:
+			binary.Write(buf, binary.LittleEndian, ops[i].(Imm16))
+
+		case slotAddr16:
+			binary.Write(buf, binary.LittleEndian, ops[i].(Pointer[Imm16]).Ref)
+
+		case slotHighAddr:
+			switch ptr := ops[i].(type) {
+			case Pointer[Imm16]:
+				buf.WriteByte(byte(ptr.Ref))
+
+			case Pointer[Imm8]:
+				buf.WriteByte(byte(ptr.Ref))
+			}
+
+		case slotRel8:
+			buf.WriteByte(byte(ops[i].(Rel8)))
+
+		case slotSPOffset:
+			buf.WriteByte(byte(int8(ops[i].(Reg16) - SP)))
+		}
+	}
+}
+
+var (
+	opcodesOnce sync.Once
+	// opcodesByMnemonic indexes every legal opcode by its mnemonic.
+	opcodesByMnemonic map[string][]*opcode
+	// opcodesByCode indexes every legal opcode by its encoding: unprefixed opcodes first, then CB prefixed opcodes.
+	opcodesByCode [2][256]*opcode
+)
+
+// loadOpcodes builds the opcode tables from [opcodedata] on first use.
+func loadOpcodes() {
+	opcodesOnce.Do(func() {
+		opcodesByMnemonic = make(map[string][]*opcode)
+		add := func(prefixed bool, instructions opcodedata.InstructionMap) {
+			for key, info := range instructions {
+				code, err := strconv.ParseUint(key, 0, 8)
+				if err != nil {
+					panic(fmt.Sprintf("opcodedata: bad opcode %q: %v", key, err))
+				}
+				if info.Mnemonic == "PREFIX" || strings.HasPrefix(info.Mnemonic, "ILLEGAL") {
+					continue
+				}
+				slots, err := slotsFor(info)
+				if err != nil {
+					panic(fmt.Sprintf("opcodedata: %s: %v", key, err))
+				}
+				o := &opcode{
+					Prefixed: prefixed,
+					Code:     uint8(code),
+					Info:     info,
+					slots:    slots,
+				}
+				opcodesByMnemonic[info.Mnemonic] = append(opcodesByMnemonic[info.Mnemonic], o)
+				opcodesByCode[boolInt(prefixed)][code] = o
+			}
+		}
+		add(false, opcodedata.OpcodeData.Unprefixed)
+		add(true, opcodedata.OpcodeData.CBPrefixed)
+
+		// keep the table ordered, so that matching is deterministic
+		for _, ops := range opcodesByMnemonic {
+			sort.Slice(ops, func(i, j int) bool {
+				if ops[i].Prefixed != ops[j].Prefixed {
+					return !ops[i].Prefixed
+				}
+				return ops[i].Code < ops[j].Code
+			})
+		}
+	})
+}
+
+// opcodes returns the opcode table for a mnemonic.
+func opcodes(mnemonic string) []*opcode {
+	loadOpcodes()
+	return opcodesByMnemonic[mnemonic]
+}
+
+// opcodeFor returns the opcode with the given encoding, or nil if it is illegal.
+func opcodeFor(prefixed bool, code uint8) *opcode {
+	loadOpcodes()
+	return opcodesByCode[boolInt(prefixed)][code]
+}
+
+// lookupOpcode finds the opcode that encodes mnemonic with the given operands.
+func lookupOpcode(mnemonic string, ops []Operand) (*opcode, bool) {
+	for _, o := range opcodes(mnemonic) {
+		if o.match(ops) {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+var branches = map[string]bool{"JP": true, "JR": true, "CALL": true, "RET": true}
+
+// slotsFor translates the operands of an opcodedata entry into operand slots.
+func slotsFor(info *opcodedata.InstructionInfo) ([]operandSlot, error) {
+	var slots []operandSlot
+	for i := 0; i < len(info.Operands); i++ {
+		op := info.Operands[i]
+		exact := func(o Operand) {
+			slots = append(slots, operandSlot{kind: slotExact, exact: o})
+		}
+
+		switch name := op.Name; {
+		case name == "SP" && op.Increment:
+			// LD HL, SP + e8 is described as three operands: HL, SP+, e8
+			if i+1 >= len(info.Operands) || info.Operands[i+1].Name != "e8" {
+				return nil, errors.New("SP+ without offset")
+			}
+			slots = append(slots, operandSlot{kind: slotSPOffset})
+			i++
+
+		case name == "C" && branches[info.Mnemonic]:
+			exact(CY)
+
+		case name == "NZ":
+			exact(NZ)
+
+		case name == "Z":
+			exact(Z)
+
+		case name == "NC":
+			exact(NC)
+
+		case name == "n8":
+			slots = append(slots, operandSlot{kind: slotImm8})
+
+		case name == "n16":
+			slots = append(slots, operandSlot{kind: slotImm16})
+
+		case name == "a16" && op.Immediate:
+			slots = append(slots, operandSlot{kind: slotImm16})
+
+		case name == "a16":
+			slots = append(slots, operandSlot{kind: slotAddr16})
+
+		case name == "a8":
+			slots = append(slots, operandSlot{kind: slotHighAddr})
+
+		case name == "e8" && info.Mnemonic == "JR":
+			slots = append(slots, operandSlot{kind: slotRel8})
+
+		case name == "e8":
+			slots = append(slots, operandSlot{kind: slotImm8})
+
+		case strings.HasPrefix(name, "$"):
+			v, err := strconv.ParseUint(name[1:], 16, 8)
+			if err != nil {
+				return nil, err
+			}
+			exact(Imm8(v))
+
+		case len(name) == 1 && name[0] >= '0' && name[0] <= '7':
+			exact(Bit(name[0] - '0'))
+
+		default:
+			reg, ok := registerOperand(name, op)
+			if !ok {
+				return nil, fmt.Errorf("unknown operand %q", name)
+			}
+			exact(reg)
+		}
+	}
+	return slots, nil
+}
+
+// registerOperand translates a register operand of opcodedata, which may be used as a pointer.
+func registerOperand(name string, op *opcodedata.Operand) (Operand, bool) {
+	var delta Delta
+	switch {
+	case op.Increment:
+		delta = Plus
+	case op.Decrement:
+		delta = Minus
+	}
+
+	for i, s := range registerStrs {
+		if s == name {
+			if !op.Immediate {
+				return Ptr(Reg8(i), delta), true
+			}
+			return Reg8(i), true
+		}
+	}
+
+	var reg Reg16
+	switch name {
+	case "AF":
+		reg = AF
+	case "BC":
+		reg = BC
+	case "DE":
+		reg = DE
+	case "HL":
+		reg = HL
+	case "SP":
+		reg = SP
+	default:
+		return nil, false
+	}
+	if !op.Immediate {
+		return Ptr(reg, delta), true
+	}
+	return reg, true
+}
+
+// NewInstruction constructs any instruction of the Gameboy CPU, from its mnemonic and operands.
+// The operand forms follow the RGBDS documentation, using the [opcodedata] tables to determine the size and timing.
+// Conditional instructions report the number of cycles taken when the branch is taken.
+//
+// Prefer the typed constructors such as [LD] when available. An invalid construction is reported by [Instruction.Err].
+func NewInstruction(mnemonic string, ops ...Operand) *Instruction {
+	mnemonic = strings.ToUpper(mnemonic)
+	o, ok := lookupOpcode(mnemonic, ops)
+	if !ok {
+		return &Instruction{
+			Mnemonic: mnemonic,
+			Operands: ops,
+			err:      errors.New("invalid construction"),
+		}
+	}
+
+	return &Instruction{
+		Mnemonic: mnemonic,
+		Bytes:    o.Info.Bytes,
+		Cycles:   o.Info.Cycles[0],
+		Operands: ops,
+	}
+}
+
+// encode assembles any instruction found in the opcode table.
+func (a *Assembler) encode(i int, instr *Instruction, buf *bytes.Buffer) error {
+	if len(opcodes(instr.Mnemonic)) == 0 {
+		return badInstr(i, instr, "unknown mnemnonic")
+	}
+	o, ok := lookupOpcode(instr.Mnemonic, instr.Operands)
+	if !ok {
+		return illegalOperands(i, instr)
+	}
+	o.encode(instr.Operands, buf)
+	return nil
+}
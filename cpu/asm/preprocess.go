@@ -0,0 +1,645 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Pos is a position in assembly source.
+type Pos struct {
+	File string
+	Line int
+}
+
+// String implements fmt.Stringer
+func (p Pos) String() string {
+	return p.File + ":" + strconv.Itoa(p.Line)
+}
+
+// srcLine is a single line of source, and where it came from.
+type srcLine struct {
+	pos  Pos
+	text string
+}
+
+// readLines reads all the lines of a source file.
+func readLines(name string, r io.Reader) ([]srcLine, error) {
+	var lines []srcLine
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		lines = append(lines, srcLine{pos: Pos{File: name, Line: n}, text: scanner.Text()})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+	return lines, nil
+}
+
+// stripComment removes a trailing ; comment from a line, ignoring semicolons inside of strings.
+func stripComment(s string) string {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// splitLabel splits a leading "label:" or "label::" from a line, returning the label, whether it is exported, and the
+// remainder of the line.
+func splitLabel(s string) (label string, exported bool, rest string) {
+	t := strings.TrimLeft(s, " \t")
+	i := 0
+	for i < len(t) && isIdentChar(t[i]) {
+		i++
+	}
+	if i == 0 || i >= len(t) || t[i] != ':' || !isIdentStart(t[0]) {
+		return "", false, s
+	}
+	label, rest = t[:i], t[i+1:]
+	if strings.HasPrefix(rest, ":") {
+		exported = true
+		rest = rest[1:]
+	}
+	return label, exported, rest
+}
+
+// splitKeyword splits the first word of a line from the rest.
+func splitKeyword(s string) (keyword, rest string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i+1:])
+}
+
+// splitArgs splits a comma separated list, ignoring commas nested inside of strings, parenthesis, and brackets.
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var args []string
+	depth, start, inString := 0, 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && inString:
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// constDef is a parsed constant definition: DEF name EQU value, name = value, and the like.
+type constDef struct {
+	name  string
+	op    string // EQU, =, or SET
+	value string
+	redef bool
+}
+
+// parseConstDef recognizes a line that defines a constant.
+func parseConstDef(s string) (constDef, bool) {
+	keyword, rest := splitKeyword(s)
+	var def constDef
+	switch strings.ToUpper(keyword) {
+	case "DEF":
+	case "REDEF":
+		def.redef = true
+	default:
+		rest = strings.TrimSpace(s)
+	}
+
+	name, rest := splitKeyword(rest)
+	op := ""
+	if i := strings.IndexAny(name, "="); i > 0 {
+		// name=value without spaces
+		name, rest, op = name[:i], name[i+1:]+" "+rest, "="
+	} else {
+		op, rest = splitKeyword(rest)
+		if strings.HasPrefix(op, "=") && len(op) > 1 {
+			op, rest = "=", op[1:]+" "+rest
+		}
+	}
+	switch strings.ToUpper(op) {
+	case "EQU", "SET", "=":
+	default:
+		return constDef{}, false
+	}
+	if name == "" || !isIdentStart(name[0]) || name[0] == '.' {
+		return constDef{}, false
+	}
+	def.name, def.op, def.value = name, strings.ToUpper(op), strings.TrimSpace(rest)
+	return def, true
+}
+
+// macroArgs are the arguments of a macro invocation.
+type macroArgs struct {
+	args  []string
+	shift int
+}
+
+// expansion is the context a line is expanded within: a macro invocation and/or a REPT/FOR iteration.
+type expansion struct {
+	macro  *macroArgs
+	unique int
+}
+
+// maxDepth bounds the nesting of macro invocations and loops, to catch infinite recursion.
+const maxDepth = 64
+
+// preprocessor expands macros, loops and conditional assembly into a flat stream of lines.
+type preprocessor struct {
+	fsys   fs.FS
+	macros map[string][]srcLine
+	// consts holds the numeric constants known so far, which may be used by IF, REPT and FOR.
+	consts symbolMap
+	unique int
+	depth  int
+	out    []srcLine
+}
+
+func newPreprocessor(fsys fs.FS) *preprocessor {
+	return &preprocessor{
+		fsys:   fsys,
+		macros: make(map[string][]srcLine),
+		consts: make(symbolMap),
+	}
+}
+
+// errorf formats an error at a source position.
+func errorf(pos Pos, format string, args ...any) error {
+	return fmt.Errorf("%s: %s", pos, fmt.Sprintf(format, args...))
+}
+
+// substitute expands the macro arguments \1 to \9, \<n>, \#, the unique label suffix \@, and _NARG in a line.
+func (e *expansion) substitute(ln srcLine) (string, error) {
+	if e == nil || !strings.ContainsAny(ln.text, "\\_") {
+		return ln.text, nil
+	}
+
+	var sb strings.Builder
+	s := ln.text
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		arg := func(n int) error {
+			if e.macro == nil {
+				return errorf(ln.pos, "macro argument \\%d used outside of a macro", n)
+			}
+			idx := e.macro.shift + n - 1
+			if idx < 0 || idx >= len(e.macro.args) {
+				return errorf(ln.pos, "macro argument \\%d not defined", n)
+			}
+			sb.WriteString(e.macro.args[idx])
+			return nil
+		}
+
+		switch d := s[i+1]; {
+		case d >= '1' && d <= '9':
+			if err := arg(int(d - '0')); err != nil {
+				return "", err
+			}
+			i++
+
+		case d == '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return "", errorf(ln.pos, "unterminated macro argument")
+			}
+			n, err := strconv.Atoi(s[i+2 : i+end])
+			if err != nil {
+				return "", errorf(ln.pos, "bad macro argument %q", s[i:i+end+1])
+			}
+			if err := arg(n); err != nil {
+				return "", err
+			}
+			i += end
+
+		case d == '@':
+			if e.unique == 0 {
+				return "", errorf(ln.pos, "\\@ used outside of a macro or loop")
+			}
+			sb.WriteString("_u" + strconv.Itoa(e.unique))
+			i++
+
+		case d == '#':
+			if e.macro != nil && e.macro.shift < len(e.macro.args) {
+				sb.WriteString(strings.Join(e.macro.args[e.macro.shift:], ", "))
+			}
+			i++
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	if e.macro == nil {
+		return sb.String(), nil
+	}
+	return replaceIdent(sb.String(), "_NARG", strconv.Itoa(len(e.macro.args)-e.macro.shift)), nil
+}
+
+// replaceIdent replaces whole identifiers named old, outside of strings, with new.
+func replaceIdent(s, old, new string) string {
+	if !strings.Contains(s, old) {
+		return s
+	}
+	var sb strings.Builder
+	inString := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == '"' {
+			inString = !inString
+		}
+		if !inString && strings.HasPrefix(s[i:], old) &&
+			(i == 0 || !isIdentChar(s[i-1])) &&
+			(i+len(old) >= len(s) || !isIdentChar(s[i+len(old)])) {
+			sb.WriteString(new)
+			i += len(old)
+			continue
+		}
+		sb.WriteByte(c)
+		i++
+	}
+	return sb.String()
+}
+
+// blockKind names the block a directive opens or closes.
+func blockKind(keyword string) (kind string, open bool) {
+	switch keyword {
+	case "IF":
+		return "IF", true
+	case "ENDC":
+		return "IF", false
+	case "REPT", "FOR":
+		return "REPT", true
+	case "ENDR":
+		return "REPT", false
+	case "MACRO":
+		return "MACRO", true
+	case "ENDM":
+		return "MACRO", false
+	default:
+		return "", false
+	}
+}
+
+// lineKeyword returns the upper case directive of a raw line, skipping a leading label.
+func lineKeyword(text string) string {
+	_, _, rest := splitLabel(stripComment(text))
+	keyword, _ := splitKeyword(rest)
+	return strings.ToUpper(keyword)
+}
+
+// collect gathers the body of a block starting after lines[start], up until the directive closing it.
+// It returns the body, and the index of the closing line.
+func collect(lines []srcLine, start int, kind string) ([]srcLine, int, error) {
+	depth := 0
+	for i := start + 1; i < len(lines); i++ {
+		k, open := blockKind(lineKeyword(lines[i].text))
+		if k != kind {
+			continue
+		}
+		if open {
+			depth++
+			continue
+		}
+		if depth == 0 {
+			return lines[start+1 : i], i, nil
+		}
+		depth--
+	}
+	return nil, 0, errorf(lines[start].pos, "unterminated %s", kind)
+}
+
+// evalConst evaluates an expression which must be constant at this point of the source.
+func (p *preprocessor) evalConst(pos Pos, s string) (int, error) {
+	x, err := parseExpr(s, "")
+	if err != nil {
+		return 0, errorf(pos, "%v", err)
+	}
+	v, err := eval(x, p.consts)
+	if err != nil {
+		return 0, errorf(pos, "%v", err)
+	}
+	return v, nil
+}
+
+// run expands lines into p.out.
+func (p *preprocessor) run(lines []srcLine, ctx *expansion) error {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		if len(lines) > 0 {
+			return errorf(lines[0].pos, "maximum expansion depth of %d exceeded", maxDepth)
+		}
+		return fmt.Errorf("maximum expansion depth of %d exceeded", maxDepth)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		ln := lines[i]
+		text, err := ctx.substitute(ln)
+		if err != nil {
+			return err
+		}
+
+		label, _, rest := splitLabel(stripComment(text))
+		keyword, args := splitKeyword(rest)
+		switch upper := strings.ToUpper(keyword); upper {
+		case "MACRO":
+			name := args
+			if label != "" {
+				name = label
+			}
+			if name == "" {
+				return errorf(ln.pos, "MACRO without a name")
+			}
+			body, end, err := collect(lines, i, "MACRO")
+			if err != nil {
+				return err
+			}
+			if _, ok := p.macros[name]; ok {
+				return errorf(ln.pos, "macro %q already defined", name)
+			}
+			p.macros[name] = body
+			i = end
+
+		case "REPT":
+			n, err := p.evalConst(ln.pos, args)
+			if err != nil {
+				return err
+			}
+			body, end, err := collect(lines, i, "REPT")
+			if err != nil {
+				return err
+			}
+			for k := 0; k < n; k++ {
+				if err := p.run(body, p.iteration(ctx)); err != nil {
+					return err
+				}
+			}
+			i = end
+
+		case "FOR":
+			if err := p.forLoop(lines, &i, ln.pos, args, ctx); err != nil {
+				return err
+			}
+
+		case "IF":
+			if err := p.conditional(lines, &i, args, ctx); err != nil {
+				return err
+			}
+
+		case "ELIF", "ELSE", "ENDC", "ENDR", "ENDM":
+			return errorf(ln.pos, "%s without a matching block", upper)
+
+		case "SHIFT":
+			if ctx == nil || ctx.macro == nil {
+				return errorf(ln.pos, "SHIFT used outside of a macro")
+			}
+			n := 1
+			if args != "" {
+				if n, err = p.evalConst(ln.pos, args); err != nil {
+					return err
+				}
+			}
+			ctx.macro.shift += n
+			if ctx.macro.shift < 0 || ctx.macro.shift > len(ctx.macro.args) {
+				return errorf(ln.pos, "cannot shift macro arguments past their end")
+			}
+
+		case "INCLUDE":
+			if err := p.include(ln.pos, args); err != nil {
+				return err
+			}
+
+		case "FAIL":
+			return errorf(ln.pos, "%s", strings.Trim(args, `"`))
+
+		default:
+			if body, ok := p.macros[keyword]; ok {
+				if label != "" {
+					p.out = append(p.out, srcLine{pos: ln.pos, text: label + ":"})
+				}
+				p.unique++
+				invocation := &expansion{
+					macro:  &macroArgs{args: splitArgs(args)},
+					unique: p.unique,
+				}
+				if err := p.run(body, invocation); err != nil {
+					return fmt.Errorf("%v\n\tin macro %s invoked at %s", err, keyword, ln.pos)
+				}
+				continue
+			}
+
+			if def, ok := parseConstDef(stripComment(text)); ok {
+				// constants are evaluated here on a best effort basis, so that they can be used by IF and REPT.
+				// The assembler evaluates them again during layout, when labels are known.
+				if x, err := parseExpr(def.value, ""); err == nil {
+					if v, err := eval(x, p.consts); err == nil {
+						p.consts[def.name] = v
+					}
+				}
+			}
+			p.out = append(p.out, srcLine{pos: ln.pos, text: text})
+		}
+	}
+	return nil
+}
+
+// iteration creates the expansion context of a single REPT or FOR iteration.
+func (p *preprocessor) iteration(ctx *expansion) *expansion {
+	p.unique++
+	child := &expansion{unique: p.unique}
+	if ctx != nil {
+		child.macro = ctx.macro
+	}
+	return child
+}
+
+// forLoop expands FOR var, [start,] stop [, step] ... ENDR.
+func (p *preprocessor) forLoop(lines []srcLine, i *int, pos Pos, args string, ctx *expansion) error {
+	parts := splitArgs(args)
+	if len(parts) < 2 || len(parts) > 4 {
+		return errorf(pos, "FOR takes a variable, and 1 to 3 arguments")
+	}
+	name := parts[0]
+	var bounds []int
+	for _, part := range parts[1:] {
+		v, err := p.evalConst(pos, part)
+		if err != nil {
+			return err
+		}
+		bounds = append(bounds, v)
+	}
+	start, stop, step := 0, 0, 1
+	switch len(bounds) {
+	case 1:
+		stop = bounds[0]
+	case 2:
+		start, stop = bounds[0], bounds[1]
+	case 3:
+		start, stop, step = bounds[0], bounds[1], bounds[2]
+	}
+	if step == 0 {
+		return errorf(pos, "FOR cannot have a step of 0")
+	}
+
+	body, end, err := collect(lines, *i, "REPT")
+	if err != nil {
+		return err
+	}
+	for v := start; (step > 0 && v < stop) || (step < 0 && v > stop); v += step {
+		p.consts[name] = v
+		p.out = append(p.out, srcLine{pos: pos, text: fmt.Sprintf("DEF %s = %d", name, v)})
+		if err := p.run(body, p.iteration(ctx)); err != nil {
+			return err
+		}
+	}
+	*i = end
+	return nil
+}
+
+// conditional expands IF cond ... [ELIF cond ...] [ELSE ...] ENDC.
+func (p *preprocessor) conditional(lines []srcLine, i *int, cond string, ctx *expansion) error {
+	start := *i
+	pos := lines[start].pos
+	taken := false
+	var body []srcLine
+
+	// branchStart is the first line of the current branch, and active reports whether it is taken.
+	branchStart := start + 1
+	active, err := p.evalCond(pos, cond)
+	if err != nil {
+		return err
+	}
+	seenElse := false
+
+	depth := 0
+	for j := start + 1; j < len(lines); j++ {
+		keyword := lineKeyword(lines[j].text)
+		if k, open := blockKind(keyword); k == "IF" {
+			if open {
+				depth++
+				continue
+			}
+			if depth > 0 {
+				depth--
+				continue
+			}
+		} else if depth > 0 || (keyword != "ELIF" && keyword != "ELSE") {
+			continue
+		}
+
+		// the end of a branch: ELIF, ELSE or ENDC
+		if active && !taken {
+			body, taken = lines[branchStart:j], true
+		}
+		branchStart = j + 1
+
+		switch keyword {
+		case "ELIF":
+			if seenElse {
+				return errorf(lines[j].pos, "ELIF after ELSE")
+			}
+			active = false
+			if !taken {
+				text, err := ctx.substitute(lines[j])
+				if err != nil {
+					return err
+				}
+				_, _, rest := splitLabel(stripComment(text))
+				_, c := splitKeyword(rest)
+				if active, err = p.evalCond(lines[j].pos, c); err != nil {
+					return err
+				}
+			}
+
+		case "ELSE":
+			if seenElse {
+				return errorf(lines[j].pos, "multiple ELSE")
+			}
+			seenElse = true
+			active = !taken
+
+		default: // ENDC
+			*i = j
+			if taken {
+				return p.run(body, ctx)
+			}
+			return nil
+		}
+	}
+	return errorf(pos, "unterminated IF")
+}
+
+// evalCond evaluates the condition of an IF or ELIF.
+func (p *preprocessor) evalCond(pos Pos, cond string) (bool, error) {
+	if strings.TrimSpace(cond) == "" {
+		return false, errorf(pos, "missing condition")
+	}
+	v, err := p.evalConst(pos, cond)
+	return v != 0, err
+}
+
+// include expands an INCLUDE "file" directive.
+func (p *preprocessor) include(pos Pos, args string) error {
+	name := strings.Trim(strings.TrimSpace(args), `"`)
+	if p.fsys == nil {
+		return errorf(pos, "cannot INCLUDE %q: no file system", name)
+	}
+	f, err := p.fsys.Open(name)
+	if err != nil {
+		return errorf(pos, "%v", err)
+	}
+	defer f.Close()
+	lines, err := readLines(name, f)
+	if err != nil {
+		return err
+	}
+	return p.run(lines, nil)
+}
+
+// preprocess reads a source file and expands it.
+func preprocess(fsys fs.FS, name string, r io.Reader) ([]srcLine, error) {
+	lines, err := readLines(name, r)
+	if err != nil {
+		return nil, err
+	}
+	p := newPreprocessor(fsys)
+	if err := p.run(lines, nil); err != nil {
+		return nil, err
+	}
+	return p.out, nil
+}
@@ -0,0 +1,135 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expand preprocesses src, returning the non-empty lines it expands to.
+func expand(t *testing.T, src string) []string {
+	t.Helper()
+	lines, err := preprocess(nil, "test.asm", strings.NewReader(src))
+	assert.NoError(t, err)
+	var out []string
+	for _, ln := range lines {
+		if text := strings.TrimSpace(ln.text); text != "" {
+			out = append(out, text)
+		}
+	}
+	return out
+}
+
+func TestPreprocessMacro(t *testing.T) {
+	assert.Equal(t, []string{
+		"ld a, 1",
+		"ld [$C000], a",
+		"db 3",
+		"db 3, 4",
+	}, expand(t, `
+MACRO store
+	ld a, \1
+	ld [\2], a
+ENDM
+MACRO count
+	db _NARG
+	SHIFT
+	db \#
+ENDM
+	store 1, $C000
+	count a, 3, 4
+`))
+}
+
+func TestPreprocessOldMacroSyntax(t *testing.T) {
+	assert.Equal(t, []string{"Label:", "nop"}, expand(t, `
+pad: MACRO
+	nop
+ENDM
+Label: pad
+`))
+}
+
+func TestPreprocessUniqueLabels(t *testing.T) {
+	assert.Equal(t, []string{
+		".wait_u1:", "jr .wait_u1",
+		".wait_u2:", "jr .wait_u2",
+	}, expand(t, `
+MACRO spin
+.wait\@:
+	jr .wait\@
+ENDM
+	spin
+	spin
+`))
+}
+
+func TestPreprocessLoops(t *testing.T) {
+	assert.Equal(t, []string{
+		"DEF N EQU 2",
+		"nop", "nop",
+		"DEF x = 0", "db x",
+		"DEF x = 2", "db x",
+		"DEF x = 4", "db x",
+	}, expand(t, `
+DEF N EQU 2
+REPT N
+	nop
+ENDR
+FOR x, 0, 6, N
+	db x
+ENDR
+`))
+}
+
+func TestPreprocessConditionals(t *testing.T) {
+	src := `
+DEF MODE EQU %d
+IF MODE == 0
+	db 0
+ELIF MODE == 1
+	IF 0
+		db 10
+	ELSE
+		db 11
+	ENDC
+ELSE
+	db 2
+ENDC
+`
+	for mode, want := range [][]string{{"db 0"}, {"db 11"}, {"db 2"}} {
+		got := expand(t, strings.Replace(src, "%d", string(rune('0'+mode)), 1))
+		assert.Equal(t, want, got[1:], "mode %d", mode)
+	}
+}
+
+func TestPreprocessConditionalMacroArgs(t *testing.T) {
+	assert.Equal(t, []string{"db 1", "db 1, 2"}, expand(t, `
+MACRO opt
+	IF _NARG == 1
+		db \1
+	ELSE
+		db \1, \2
+	ENDC
+ENDM
+	opt 1
+	opt 1, 2
+`))
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	for _, src := range []string{
+		"REPT 2\nnop",
+		"IF 1\nnop",
+		"ENDC",
+		"MACRO m\nnop",
+		"MACRO m\n\\2\nENDM\nm 1",
+		"MACRO m\nm\nENDM\nm",
+		"SHIFT",
+		"IF UNDEFINED\nENDC",
+	} {
+		_, err := preprocess(nil, "test.asm", strings.NewReader(src))
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,512 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// syntaxKind classifies the written form of an operand, before its expressions are evaluated.
+type syntaxKind int
+
+const (
+	// synFixed is an operand which needs no evaluation, such as a register, condition or register pointer.
+	synFixed syntaxKind = iota
+	// synExpr is an expression: n8, n16, e8, a16, a bit index or an RST vector.
+	synExpr
+	// synPtrExpr is a pointer to an expression: [a16] or [a8]
+	synPtrExpr
+	// synSPExpr is SP plus or minus an expression: SP + e8
+	synSPExpr
+)
+
+// operandSyntax is an operand as written in source.
+type operandSyntax struct {
+	kind    syntaxKind
+	operand Operand
+	x       expr
+}
+
+// match reports whether the written operand can be placed into an opcode slot. Slots which depend on the value of an
+// expression, such as bit indices, match any expression.
+func (s operandSyntax) match(slot operandSlot) bool {
+	switch s.kind {
+	case synFixed:
+		switch slot.kind {
+		case slotExact:
+			// C is either the register or the carry condition
+			return isEq(slot.exact, s.operand) || (slot.exact == CY && isEq(s.operand, C))
+
+		case slotSPOffset:
+			return isEq(s.operand, SP)
+		}
+		return false
+
+	case synExpr:
+		switch slot.kind {
+		case slotImm8, slotImm16, slotRel8:
+			return true
+
+		case slotExact:
+			return is[Bit](slot.exact) || is[Imm8](slot.exact)
+		}
+		return false
+
+	case synPtrExpr:
+		return slot.kind == slotAddr16 || slot.kind == slotHighAddr
+
+	case synSPExpr:
+		return slot.kind == slotSPOffset
+
+	default:
+		return false
+	}
+}
+
+var fixedOperands = map[string]Operand{
+	"a": A, "b": B, "c": C, "d": D, "e": E, "h": H, "l": L,
+	"af": AF, "bc": BC, "de": DE, "hl": HL, "sp": SP,
+	"nz": NZ, "z": Z, "nc": NC,
+	"[bc]": Ptr(BC), "[de]": Ptr(DE), "[hl]": Ptr(HL),
+	"[hl+]": Ptr(HL, Plus), "[hli]": Ptr(HL, Plus),
+	"[hl-]": Ptr(HL, Minus), "[hld]": Ptr(HL, Minus),
+	"[c]": Ptr(C), "[$ff00+c]": Ptr(C), "[0xff00+c]": Ptr(C),
+}
+
+// parseOperand parses a single instruction operand.
+func parseOperand(s string, scope string) (operandSyntax, error) {
+	key := strings.ToLower(strings.Join(strings.Fields(s), ""))
+	if op, ok := fixedOperands[key]; ok {
+		return operandSyntax{kind: synFixed, operand: op}, nil
+	}
+
+	if strings.HasPrefix(key, "sp+") || strings.HasPrefix(key, "sp-") {
+		x, err := parseExpr(strings.TrimSpace(s)[2:], scope)
+		if err != nil {
+			return operandSyntax{}, err
+		}
+		return operandSyntax{kind: synSPExpr, x: x}, nil
+	}
+
+	t := strings.TrimSpace(s)
+	if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+		x, err := parseExpr(t[1:len(t)-1], scope)
+		if err != nil {
+			return operandSyntax{}, err
+		}
+		return operandSyntax{kind: synPtrExpr, x: x}, nil
+	}
+
+	x, err := parseExpr(t, scope)
+	if err != nil {
+		return operandSyntax{}, err
+	}
+	return operandSyntax{kind: synExpr, x: x}, nil
+}
+
+// aluMnemonics may omit their A operand, such as "xor a" for "xor a, a".
+var aluMnemonics = map[string]bool{
+	"ADD": true, "ADC": true, "SUB": true, "SBC": true, "AND": true, "XOR": true, "OR": true, "CP": true,
+}
+
+// normalize rewrites the aliases RGBDS accepts into the forms found in the opcode table.
+func normalize(mnemonic string, ops []operandSyntax) (string, []operandSyntax) {
+	switch {
+	case aluMnemonics[mnemonic] && len(ops) == 1:
+		ops = append([]operandSyntax{{kind: synFixed, operand: A}}, ops...)
+
+	case mnemonic == "JP" && len(ops) == 1 && ops[0].kind == synFixed && isEq(ops[0].operand, Ptr(HL)):
+		ops = []operandSyntax{{kind: synFixed, operand: HL}}
+
+	case mnemonic == "STOP" && len(ops) == 0:
+		ops = []operandSyntax{{kind: synExpr, x: numExpr(0)}}
+
+	case mnemonic == "LDH" && len(ops) == 2:
+		for _, op := range ops {
+			if op.kind == synFixed && isEq(op.operand, Ptr(C)) {
+				mnemonic = ld
+			}
+		}
+
+	case mnemonic == "LDI" || mnemonic == "LDD":
+		delta := Plus
+		if mnemonic == "LDD" {
+			delta = Minus
+		}
+		mnemonic = ld
+		for i, op := range ops {
+			if op.kind == synFixed && isEq(op.operand, Ptr(HL)) {
+				ops[i].operand = Ptr(HL, delta)
+			}
+		}
+	}
+	return mnemonic, ops
+}
+
+// evalRange evaluates an expression, and checks that it fits within [lo, hi].
+func evalRange(x expr, syms symbols, lo, hi int) (int, error) {
+	v, err := eval(x, syms)
+	if err != nil {
+		return 0, err
+	}
+	if v < lo || v > hi {
+		return 0, fmt.Errorf("value $%X out of range", v)
+	}
+	return v, nil
+}
+
+// resolve evaluates an expression which may refer to symbols that are only known once linked.
+// If so, the expression is returned with every known symbol folded, for a relocation.
+func resolve(x expr, syms symbols) (int, expr, error) {
+	v, err := eval(x, syms)
+	var undefined errUndefined
+	if errors.As(err, &undefined) {
+		return 0, fold(x, syms), nil
+	}
+	return v, nil, err
+}
+
+// checkRange checks that a value fits within [lo, hi].
+func checkRange(v, lo, hi int) error {
+	if v < lo || v > hi {
+		return fmt.Errorf("value $%X out of range", v)
+	}
+	return nil
+}
+
+// typedOperands evaluates the operands of an instruction statement into typed [Operand] values.
+// An operand that can only be computed by the linker is left as zero, and returned as a relocation.
+func (s *statement) typedOperands(syms symbols) ([]Operand, *Reloc, error) {
+	slots := s.candidates[0].slots
+	ops := make([]Operand, len(s.operands))
+	var reloc *Reloc
+
+	// relocate resolves x, recording a relocation of the given kind if needed.
+	relocate := func(x expr, kind RelocKind) (v int, relocated bool, err error) {
+		v, rx, err := resolve(x, syms)
+		if err != nil || rx == nil {
+			return v, false, err
+		}
+		if reloc != nil {
+			return 0, false, errors.New("only one operand may refer to a relocatable symbol")
+		}
+		reloc = &Reloc{Kind: kind, Expr: rx.String(), PC: s.addr, Pos: s.pos}
+		return 0, true, nil
+	}
+
+	for i, op := range s.operands {
+		slot := slots[i]
+		if op.kind == synFixed {
+			if slot.kind == slotExact {
+				ops[i] = slot.exact
+			} else {
+				ops[i] = op.operand
+			}
+			continue
+		}
+
+		switch {
+		case slot.kind == slotImm8:
+			v, relocated, err := relocate(op.x, RelocByte)
+			if err == nil && !relocated {
+				err = checkRange(v, -0x80, 0xFF)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = Imm8(v)
+
+		case slot.kind == slotImm16:
+			v, relocated, err := relocate(op.x, RelocWord)
+			if err == nil && !relocated {
+				err = checkRange(v, -0x8000, 0xFFFF)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = Imm16(v)
+
+		case slot.kind == slotRel8:
+			target, relocated, err := relocate(op.x, RelocJR)
+			if err != nil {
+				return nil, nil, err
+			}
+			pc, fixed := syms.lookup("@")
+			if !relocated && !fixed {
+				// a jump to a known address from a floating section still depends on where the section is placed
+				if reloc != nil {
+					return nil, nil, errors.New("only one operand may refer to a relocatable symbol")
+				}
+				reloc = &Reloc{Kind: RelocJR, Expr: op.x.String(), PC: s.addr, Pos: s.pos}
+				relocated = true
+			}
+			offset := target - (pc + 2)
+			if relocated {
+				offset = 0
+			} else if offset < -0x80 || offset > 0x7F {
+				return nil, nil, fmt.Errorf("jump target $%X is out of range", target)
+			}
+			ops[i] = Rel8(offset)
+
+		case slot.kind == slotAddr16:
+			v, relocated, err := relocate(op.x, RelocWord)
+			if err == nil && !relocated {
+				err = checkRange(v, 0, 0xFFFF)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = Ptr(Imm16(v))
+
+		case slot.kind == slotHighAddr:
+			v, relocated, err := relocate(op.x, RelocHigh)
+			if err != nil {
+				return nil, nil, err
+			}
+			if v < 0x100 || relocated {
+				v |= 0xFF00
+			}
+			if v < 0xFF00 || v > 0xFFFF {
+				return nil, nil, fmt.Errorf("address $%X is not within $FF00-$FFFF", v)
+			}
+			ops[i] = Ptr(Imm16(v))
+
+		case slot.kind == slotSPOffset:
+			v, err := evalRange(op.x, syms, -0x80, 0x7F)
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = SP + Reg16(v)
+
+		case is[Bit](slot.exact):
+			v, err := evalRange(op.x, syms, 0, 7)
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = Bit(v)
+
+		default:
+			// RST vectors
+			v, err := evalRange(op.x, syms, 0, 0x38)
+			if err != nil {
+				return nil, nil, err
+			}
+			ops[i] = Imm8(v)
+		}
+	}
+
+	if reloc != nil {
+		reloc.Offset = s.addr + 1
+		if s.candidates[0].Prefixed {
+			reloc.Offset++
+		}
+	}
+	return ops, reloc, nil
+}
+
+// encodeStatement assembles a laid out statement into its section's buf, returning its relocations.
+func (a *Assembler) encodeStatement(s *statement, sec *sectionLayout, syms symbols, buf *bytes.Buffer) ([]*Reloc, error) {
+	if sec.Fixed() {
+		syms = pcSymbols{syms: syms, pc: sec.Org + s.addr}
+	}
+
+	switch s.kind {
+	case stmtInstr:
+		ops, reloc, err := s.typedOperands(syms)
+		if err != nil {
+			return nil, errorf(s.pos, "%v", err)
+		}
+		instr := NewInstruction(s.mnemonic, ops...)
+		code, err := a.Assemble(instr)
+		if err != nil {
+			return nil, errorf(s.pos, "%v", err)
+		}
+		buf.Write(code)
+		if reloc != nil {
+			return []*Reloc{reloc}, nil
+		}
+
+	case stmtData:
+		var relocs []*Reloc
+		for _, item := range s.items {
+			switch item := item.(type) {
+			case []byte:
+				for _, b := range item {
+					buf.WriteByte(b)
+					if s.width == 2 {
+						buf.WriteByte(0)
+					}
+				}
+
+			case expr:
+				kind, lo, hi := RelocByte, -0x80, 0xFF
+				if s.width == 2 {
+					kind, lo, hi = RelocWord, -0x8000, 0xFFFF
+				}
+				v, rx, err := resolve(item, syms)
+				if err == nil && rx == nil {
+					err = checkRange(v, lo, hi)
+				}
+				if err != nil {
+					return nil, errorf(s.pos, "%v", err)
+				}
+				if rx != nil {
+					relocs = append(relocs, &Reloc{Offset: buf.Len(), Kind: kind, Expr: rx.String(), PC: s.addr, Pos: s.pos})
+				}
+				buf.WriteByte(byte(v))
+				if s.width == 2 {
+					buf.WriteByte(byte(v >> 8))
+				}
+			}
+		}
+		return relocs, nil
+
+	case stmtSpace:
+		if !sec.Type.IsROM() {
+			return nil, nil
+		}
+		v, err := evalRange(s.fill, syms, -0x80, 0xFF)
+		if err != nil {
+			return nil, errorf(s.pos, "%v", err)
+		}
+		buf.Write(bytes.Repeat([]byte{byte(v)}, s.size))
+	}
+	return nil, nil
+}
+
+// AssembleObject assembles RGBDS compatible source code, read from r, into a relocatable object.
+// The name of the source is used to report errors.
+//
+// Macros, REPT and FOR loops, and IF conditional assembly are expanded before the source is laid out.
+// INCLUDE directives are read from the Assembler's FS.
+// Code preceding the first SECTION directive is placed in an unnamed ROM0[$0000] section.
+func (a *Assembler) AssembleObject(name string, r io.Reader) (*Object, error) {
+	lines, err := preprocess(a.FS, name, r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLayout()
+	for _, ln := range lines {
+		if err := l.add(ln); err != nil {
+			return nil, err
+		}
+	}
+
+	obj := &Object{}
+	syms := chainSymbols{l.consts, l.labels}
+	for _, sec := range l.sections {
+		var buf bytes.Buffer
+		for _, s := range sec.stmts {
+			if s.kind == stmtInstr {
+				sec.Lines = append(sec.Lines, Line{Offset: s.addr, Pos: s.pos})
+			}
+			relocs, err := a.encodeStatement(s, sec, syms, &buf)
+			if err != nil {
+				return nil, err
+			}
+			sec.Relocs = append(sec.Relocs, relocs...)
+		}
+		sec.Size = sec.pc
+		if sec.Type.IsROM() {
+			sec.Data = buf.Bytes()
+		}
+		obj.Sections = append(obj.Sections, sec.Section)
+	}
+
+	for name, lbl := range l.labels {
+		obj.Symbols = append(obj.Symbols, &Symbol{
+			Name:     name,
+			Section:  lbl.section.index,
+			Value:    lbl.offset,
+			Exported: l.exported[name],
+		})
+	}
+	for name := range l.exported {
+		if _, ok := l.labels[name]; ok {
+			continue
+		}
+		v, ok := l.consts[name]
+		if !ok {
+			return nil, fmt.Errorf("exported symbol %q is not defined", name)
+		}
+		obj.Symbols = append(obj.Symbols, &Symbol{Name: name, Section: -1, Value: v, Exported: true})
+	}
+	sort.Slice(obj.Symbols, func(i, j int) bool {
+		return obj.Symbols[i].Name < obj.Symbols[j].Name
+	})
+	return obj, nil
+}
+
+// AssembleObject assembles RGBDS compatible source code with a default [Assembler].
+func AssembleObject(name string, r io.Reader) (*Object, error) {
+	assm := NewAssembler()
+	return assm.AssembleObject(name, r)
+}
+
+// localResolver resolves the symbols of a single object, whose sections are all at a fixed address.
+type localResolver struct {
+	obj *Object
+}
+
+func (r localResolver) find(name string) (*Symbol, bool) {
+	for _, sym := range r.obj.Symbols {
+		if sym.Name == name {
+			return sym, true
+		}
+	}
+	return nil, false
+}
+
+func (r localResolver) Symbol(name string) (int, bool) {
+	sym, ok := r.find(name)
+	if !ok {
+		return 0, false
+	}
+	if sym.Section < 0 {
+		return sym.Value, true
+	}
+	return r.obj.Sections[sym.Section].Org + sym.Value, true
+}
+
+func (r localResolver) Bank(name string) (int, bool) {
+	sym, ok := r.find(name)
+	if !ok || sym.Section < 0 {
+		return 0, false
+	}
+	return r.obj.Sections[sym.Section].Bank, true
+}
+
+// AssembleSource assembles RGBDS compatible source code, read from r, into binary bytes. The source must consist of
+// a single section at a fixed address, by default ROM0[$0000], and the bytes of that section are returned.
+// Use [Assembler.AssembleObject] and a linker for anything else.
+func (a *Assembler) AssembleSource(name string, r io.Reader) ([]byte, error) {
+	obj, err := a.AssembleObject(name, r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(obj.Sections) == 0:
+		return nil, nil
+
+	case len(obj.Sections) > 1 || !obj.Sections[0].Fixed():
+		return nil, fmt.Errorf("%s: source must consist of a single section at a fixed address, link it instead", name)
+	}
+
+	sec := obj.Sections[0]
+	for _, reloc := range sec.Relocs {
+		if err := reloc.Apply(sec.Data, Placement{Addr: sec.Org, Bank: sec.Bank}, localResolver{obj: obj}); err != nil {
+			return nil, err
+		}
+	}
+	return sec.Data, nil
+}
+
+// AssembleSource assembles RGBDS compatible source code with a default [Assembler].
+func AssembleSource(name string, r io.Reader) ([]byte, error) {
+	assm := NewAssembler()
+	return assm.AssembleSource(name, r)
+}
@@ -0,0 +1,135 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleAssembleSource() {
+	code, err := AssembleSource("example.asm", strings.NewReader(`
+MACRO wait ; wait for \1 iterations of register b
+	ld b, \1
+.loop\@:
+	dec b
+	jr nz, .loop\@
+ENDM
+
+Main:
+	wait 16
+	jp Main
+`))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("% X\n", code)
+	// Output: 06 10 05 20 FD C3 00 00
+}
+
+func TestAssembleSource(t *testing.T) {
+	code, err := AssembleSource("test.asm", strings.NewReader(`
+DEF rLCDC EQU $FF40
+Start:
+	di
+	xor a
+	ldh [rLCDC], a
+	ld hl, Data
+	ld a, [hli]
+	ldi [hl], a
+	bit 7, [hl]
+	res 0, a
+	jr c, .done
+	call nz, Start
+.done
+	rst $38
+	ld [$C000], sp
+	ld hl, sp-2
+	add sp, 4
+	stop
+	jp hl
+Data:
+	db "Hi", 0
+	dw Start, $1234
+	ds 2, $FF
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0xF3,       // di
+		0xAF,       // xor a
+		0xE0, 0x40, // ldh [rLCDC], a
+		0x21, 0x1D, 0x00, // ld hl, Data
+		0x2A,       // ld a, [hli]
+		0x22,       // ldi [hl], a
+		0xCB, 0x7E, // bit 7, [hl]
+		0xCB, 0x87, // res 0, a
+		0x38, 0x03, // jr c, .done
+		0xC4, 0x00, 0x00, // call nz, Start
+		0xFF,             // rst $38
+		0x08, 0x00, 0xC0, // ld [$C000], sp
+		0xF8, 0xFE, // ld hl, sp-2
+		0xE8, 0x04, // add sp, 4
+		0x10, 0x00, // stop
+		0xE9,           // jp hl
+		'H', 'i', 0x00, // db
+		0x00, 0x00, 0x34, 0x12, // dw
+		0xFF, 0xFF, // ds
+	}, code)
+}
+
+func TestAssembleSourceInclude(t *testing.T) {
+	assm := NewAssembler()
+	assm.FS = fstest.MapFS{
+		"hardware.inc": {Data: []byte("DEF rIE EQU $FFFF\n")},
+	}
+	code, err := assm.AssembleSource("main.asm", strings.NewReader(`
+INCLUDE "hardware.inc"
+	ld [rIE], a
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xEA, 0xFF, 0xFF}, code)
+}
+
+func TestAssembleSourceVariables(t *testing.T) {
+	code, err := AssembleSource("test.asm", strings.NewReader(`
+DEF x = 1
+	db x
+x = x + 1
+	db x
+FOR i, 3
+	db i, Later - @
+ENDR
+Later:
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 0, 6, 1, 4, 2, 2}, code)
+}
+
+func TestAssembleSourceFixedAddress(t *testing.T) {
+	code, err := AssembleSource("test.asm", strings.NewReader(`
+SECTION "Main", ROM0[$150]
+Main:
+	jr Main
+	jr @
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x18, 0xFE, 0x18, 0xFE}, code)
+}
+
+func TestAssembleSourceErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"\tld a, Undefined":        `test.asm:1: undefined symbol "Undefined"`,
+		"\tld [bc], b":             "test.asm:1: ld [bc], b: illegal operands",
+		"\tfoo a":                  `test.asm:1: unknown mnemonic or macro "foo"`,
+		"\tld a, $100":             "test.asm:1: value $100 out of range",
+		"\tbit 8, a":               "test.asm:1: value $8 out of range",
+		"\tjr Far\n\tds 200\nFar:": "test.asm:1: jump target $CA is out of range",
+		"L:\nL:":                   `test.asm:2: label "L" already defined`,
+		"DEF X EQU 1\nDEF X EQU 2": `test.asm:2: constant "X" already defined`,
+	} {
+		_, err := AssembleSource("test.asm", strings.NewReader(src))
+		assert.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,208 @@
+// Package sym reads and writes symbol files: the bank:addr name .sym format shared by Gameboy assemblers, debuggers
+// and emulators.
+package sym
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a named address within a bank.
+type Symbol struct {
+	Bank int
+	Addr int
+	Name string
+}
+
+// String implements fmt.Stringer, as a line of a symbol file.
+func (s Symbol) String() string {
+	return fmt.Sprintf("%02x:%04x %s", s.Bank, s.Addr, s.Name)
+}
+
+// regions are the starts of the memory regions a symbol can be relative to. An address is never shown relative to a
+// symbol of another region, such as a WRAM address relative to the last label in ROM.
+var regions = []int{0x0000, 0x4000, 0x8000, 0xA000, 0xC000, 0xD000, 0xE000, 0xFE00, 0xFEA0, 0xFF00, 0xFF80, 0xFFFF}
+
+// region returns the start of the region containing addr.
+func region(addr int) int {
+	i := sort.Search(len(regions), func(i int) bool { return regions[i] > addr })
+	return regions[i-1]
+}
+
+// banked reports whether the bank of an address matters: only the switchable regions have banks.
+func banked(addr int) bool {
+	switch region(addr) {
+	case 0x4000, 0x8000, 0xA000, 0xD000:
+		return true
+	default:
+		return false
+	}
+}
+
+// Table is a set of symbols, sorted by bank and address.
+type Table struct {
+	syms   []Symbol
+	sorted bool
+	byName map[string]int
+}
+
+// NewTable constructs a new, empty [Table].
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Add adds a symbol to the table.
+func (t *Table) Add(bank, addr int, name string) {
+	t.syms = append(t.syms, Symbol{Bank: bank, Addr: addr, Name: name})
+	t.sorted = false
+}
+
+// sort orders the symbols after symbols were added, and indexes them by name.
+func (t *Table) sort() {
+	if t.sorted {
+		return
+	}
+	sort.SliceStable(t.syms, func(i, j int) bool { return less(t.syms[i], t.syms[j]) })
+	t.byName = make(map[string]int, len(t.syms))
+	for i, s := range t.syms {
+		if _, ok := t.byName[s.Name]; !ok {
+			t.byName[s.Name] = i
+		}
+	}
+	t.sorted = true
+}
+
+func less(a, b Symbol) bool {
+	switch {
+	case a.Bank != b.Bank:
+		return a.Bank < b.Bank
+	case a.Addr != b.Addr:
+		return a.Addr < b.Addr
+	default:
+		return a.Name < b.Name
+	}
+}
+
+// Symbols returns every symbol of the table, sorted by bank and address.
+func (t *Table) Symbols() []Symbol {
+	t.sort()
+	return t.syms
+}
+
+// Lookup returns the symbol with the given name. If several symbols share the name, the first one is returned.
+func (t *Table) Lookup(name string) (Symbol, bool) {
+	t.sort()
+	i, ok := t.byName[name]
+	if !ok {
+		return Symbol{}, false
+	}
+	return t.syms[i], true
+}
+
+// At returns the name of the first symbol at an address.
+func (t *Table) At(bank, addr int) (string, bool) {
+	t.sort()
+	if !banked(addr) {
+		bank = 0
+	}
+	i := sort.Search(len(t.syms), func(i int) bool { return !less(t.syms[i], Symbol{Bank: bank, Addr: addr}) })
+	if i < len(t.syms) && t.syms[i].Bank == bank && t.syms[i].Addr == addr {
+		return t.syms[i].Name, true
+	}
+	return "", false
+}
+
+// Nearest returns the closest symbol at or before an address, within the same bank and memory region.
+func (t *Table) Nearest(bank, addr int) (Symbol, bool) {
+	t.sort()
+	if !banked(addr) {
+		bank = 0
+	}
+	// the first symbol after the address
+	i := sort.Search(len(t.syms), func(i int) bool {
+		s := t.syms[i]
+		return s.Bank > bank || s.Bank == bank && s.Addr > addr
+	})
+	for i--; i >= 0; i-- {
+		s := t.syms[i]
+		if s.Bank != bank || region(s.Addr) != region(addr) {
+			return Symbol{}, false
+		}
+		// prefer the first of several symbols at the same address
+		if i > 0 && t.syms[i-1].Bank == s.Bank && t.syms[i-1].Addr == s.Addr {
+			continue
+		}
+		return s, true
+	}
+	return Symbol{}, false
+}
+
+// Format returns an address relative to its nearest symbol, such as Main.loop+3, or in hexadecimal as $0153 if no
+// symbol precedes it.
+func (t *Table) Format(bank, addr int) string {
+	if t != nil {
+		if s, ok := t.Nearest(bank, addr); ok {
+			if addr == s.Addr {
+				return s.Name
+			}
+			return s.Name + "+" + strconv.Itoa(addr-s.Addr)
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// Read reads a symbol file. Comments start with a semicolon, and section headers such as [labels], written by some
+// emulators, are ignored.
+func Read(r io.Reader) (*Table, error) {
+	t := NewTable()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "[") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("sym: line %d: expected bank:addr name", line)
+		}
+
+		bankStr, addrStr, ok := strings.Cut(fields[0], ":")
+		if !ok {
+			return nil, fmt.Errorf("sym: line %d: expected bank:addr name", line)
+		}
+		bank, err := strconv.ParseUint(bankStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("sym: line %d: bad bank %q", line, bankStr)
+		}
+		addr, err := strconv.ParseUint(addrStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("sym: line %d: bad address %q", line, addrStr)
+		}
+		t.Add(int(bank), int(addr), fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// WriteTo implements io.WriterTo, writing the table as a symbol file.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	t.sort()
+	var n int64
+	for _, s := range t.syms {
+		m, err := fmt.Fprintln(w, s)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
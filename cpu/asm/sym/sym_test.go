@@ -0,0 +1,79 @@
+package sym
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleTable_Format() {
+	t, err := Read(strings.NewReader(`
+; File generated by rgblink
+00:0150 Main
+00:0153 Main.loop
+02:4000 Far
+00:c000 wBuffer
+`))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(t.Format(0, 0x0156))
+	fmt.Println(t.Format(2, 0x4010))
+	fmt.Println(t.Format(1, 0x4010))
+	fmt.Println(t.Format(0, 0xC003))
+	fmt.Println(t.Format(0, 0xFF80))
+	// Output:
+	// Main.loop+3
+	// Far+16
+	// $4010
+	// wBuffer+3
+	// $FF80
+}
+
+func TestReadWrite(t *testing.T) {
+	table, err := Read(strings.NewReader(`
+[labels]
+02:4000 Far ; comment
+00:0150 Main
+00:0150 Alias
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Bank: 0, Addr: 0x150, Name: "Alias"},
+		{Bank: 0, Addr: 0x150, Name: "Main"},
+		{Bank: 2, Addr: 0x4000, Name: "Far"},
+	}, table.Symbols())
+
+	s, ok := table.Lookup("Far")
+	assert.True(t, ok)
+	assert.Equal(t, Symbol{Bank: 2, Addr: 0x4000, Name: "Far"}, s)
+	_, ok = table.Lookup("Missing")
+	assert.False(t, ok)
+
+	name, ok := table.At(0, 0x150)
+	assert.True(t, ok)
+	assert.Equal(t, "Alias", name)
+	_, ok = table.At(0, 0x151)
+	assert.False(t, ok)
+	assert.Equal(t, "Alias+1", table.Format(0, 0x151))
+
+	var buf bytes.Buffer
+	_, err = table.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "00:0150 Alias\n00:0150 Main\n02:4000 Far\n", buf.String())
+}
+
+func TestReadErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"00:0150":           "sym: line 1: expected bank:addr name",
+		"0150 Main":         "sym: line 1: expected bank:addr name",
+		"xx:0150 Main":      `sym: line 1: bad bank "xx"`,
+		"\n00:10000 Main\n": `sym: line 2: bad address "10000"`,
+	} {
+		_, err := Read(strings.NewReader(src))
+		assert.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,306 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// CachedCore is a fast [Core], which executes the instructions of a [SimpleCore] from a cache of basic blocks, decoded
+// once and compiled to closures, rather than decoding every instruction it executes. It keeps its state in the
+// SimpleCore, and executes instructions exactly as it does, so that the two produce the same traces and can be
+// swapped at any instruction.
+//
+// Blocks in ROM are keyed by the bank of ROM they were decoded from. Blocks in RAM are dropped when the CPU writes to
+// their bytes, and checked against memory before they are entered, since banking and DMA change RAM without the CPU.
+// The opcode of each instruction is still fetched, and its operands read, through the bus, so that hooks observe the
+// same accesses as with the SimpleCore, and the bytes read are compared with those the instruction was decoded from.
+type CachedCore struct {
+	*SimpleCore
+
+	// Bank, if not nil, returns the bank of ROM mapped at an address of $0000-$7FFF, which keys the blocks cached
+	// there, or -1 if the address is not mapped to ROM, as when a boot ROM is mapped over it. Code at addresses
+	// without a bank is cached like code in RAM.
+	Bank func(addr uint16) int
+
+	blocks map[blockKey]*block
+	// code marks the bytes of RAM at $8000-$FFFF that cached instructions were decoded from.
+	code [0x8000]bool
+	// block is the block being executed, and next the index of its next instruction.
+	block *block
+	next  int
+}
+
+// maxBlockLength is the maximum number of instructions of a block.
+const maxBlockLength = 64
+
+// ramBank is the bank of the keys of blocks cached like code in RAM.
+const ramBank = -1
+
+// blockKey identifies a block by its address, and the bank of ROM it is in.
+type blockKey struct {
+	bank int
+	addr uint16
+}
+
+// block is a sequence of instructions that ends with one that may branch.
+type block struct {
+	ram    bool
+	instrs []*compiled
+}
+
+// compiled is a decoded instruction, compiled to a closure that executes it.
+type compiled struct {
+	addr  uint16
+	bytes []byte
+	exec  func() (int, error)
+}
+
+// NewCachedCore constructs a CachedCore executing the instructions of c, which it shares the state of.
+func NewCachedCore(c *SimpleCore) *CachedCore {
+	cc := &CachedCore{SimpleCore: c, blocks: make(map[blockKey]*block)}
+	c.written = cc.written
+	return cc
+}
+
+var _ Core = (*CachedCore)(nil)
+
+// Flush drops every cached block, as when memory or the banks of ROM are changed behind the CPU's back, such as by
+// loading a save state.
+func (c *CachedCore) Flush() {
+	c.blocks = make(map[blockKey]*block)
+	c.code = [0x8000]bool{}
+	c.block = nil
+}
+
+// flushRAM drops the blocks cached like code in RAM.
+func (c *CachedCore) flushRAM() {
+	for k, b := range c.blocks {
+		if !b.ram {
+			continue
+		}
+		for _, in := range b.instrs {
+			for i := range in.bytes {
+				if a := in.addr + uint16(i); a >= 0x8000 {
+					c.code[a-0x8000] = false
+				}
+			}
+		}
+		delete(c.blocks, k)
+	}
+	c.block = nil
+}
+
+// written observes the writes of the CPU.
+func (c *CachedCore) written(addr uint16) {
+	switch {
+	case addr < 0x8000 || addr >= 0xFF00 && addr < 0xFF80:
+		// the memory bank controller, and I/O registers, may map other code
+		c.block = nil
+	case c.code[addr-0x8000]:
+		c.flushRAM()
+	}
+}
+
+// Step implements Core.
+func (c *CachedCore) Step() (int, error) {
+	if c.Halted || c.Stopped || c.haltBug || c.IME && c.pending() != 0 {
+		c.block = nil
+		return c.SimpleCore.Step()
+	}
+
+	c.ticked = 0
+	pc := uint16(c.PC)
+	c.cycle()
+	op := c.Memory.Fetch(pc)
+	in, err := c.lookup(pc)
+	if err == nil && in.bytes[0] != op {
+		// the bank of ROM was mapped without the CPU
+		c.Flush()
+		in, err = c.lookup(pc)
+	}
+	if err != nil {
+		return 0, err
+	}
+	changed := false
+	for i, v := range in.bytes[1:] {
+		c.cycle()
+		changed = changed || c.Memory.Read(pc+1+uint16(i)) != v
+	}
+	if changed {
+		// an operand was mapped or changed without the CPU, which only checks blocks in RAM when it enters them
+		if c.block.ram {
+			c.flushRAM()
+		} else {
+			c.Flush()
+		}
+		if in, err = c.lookup(pc); err != nil {
+			return 0, err
+		}
+	}
+	c.PC = Register(pc + uint16(len(in.bytes)))
+	c.next++
+
+	enable := c.eiPending
+	cycles, err := in.exec()
+	if enable && c.eiPending {
+		c.IME, c.eiPending = true, false
+	}
+	if cycles -= c.ticked; cycles < 0 {
+		cycles = 0
+	}
+	return cycles, err
+}
+
+// lookup returns the instruction at an address, from the block being executed if it continues there, and otherwise
+// from the block cached there, which it decodes if there is none.
+func (c *CachedCore) lookup(addr uint16) (*compiled, error) {
+	if b := c.block; b != nil && c.next < len(b.instrs) && b.instrs[c.next].addr == addr {
+		return b.instrs[c.next], nil
+	}
+
+	key := blockKey{ramBank, addr}
+	if addr < 0x8000 && c.Bank != nil {
+		key.bank = c.Bank(addr)
+	}
+	b := c.blocks[key]
+	if b != nil && b.ram && !c.valid(b) {
+		b = nil
+	}
+	if b == nil {
+		var err error
+		if b, err = c.compileBlock(addr, key.bank == ramBank); err != nil {
+			return nil, err
+		}
+		c.blocks[key] = b
+	}
+	c.block, c.next = b, 0
+	return b.instrs[0], nil
+}
+
+// valid reports whether the bytes of a block are still in memory.
+func (c *CachedCore) valid(b *block) bool {
+	for _, in := range b.instrs {
+		for i, v := range in.bytes {
+			if c.Memory.Peek(in.addr+uint16(i)) != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compileBlock decodes the block at an address, until an instruction that may branch, or the end of the region of
+// memory it starts in.
+func (c *CachedCore) compileBlock(addr uint16, ram bool) (*block, error) {
+	b := &block{ram: ram}
+	for next := addr; len(b.instrs) < maxBlockLength; {
+		buf := []byte{c.Memory.Peek(next)}
+		instr, err := asm.Decode(buf)
+		for err == io.ErrUnexpectedEOF {
+			buf = append(buf, c.Memory.Peek(next+uint16(len(buf))))
+			instr, err = asm.Decode(buf)
+		}
+		if err != nil {
+			if len(b.instrs) == 0 {
+				return nil, fmt.Errorf("executing $%04X: %w", next, err)
+			}
+			// the illegal opcode is reported if it is reached
+			break
+		}
+
+		b.instrs = append(b.instrs, &compiled{addr: next, bytes: buf, exec: c.compile(instr)})
+		if ram {
+			for i := range buf {
+				if a := next + uint16(i); a >= 0x8000 {
+					c.code[a-0x8000] = true
+				}
+			}
+		}
+		next += uint16(len(buf))
+		if branches(instr) || next&0xC000 != addr&0xC000 {
+			break
+		}
+	}
+	return b, nil
+}
+
+// branches reports whether an instruction may change PC, or stop executing, so that it ends a block.
+func branches(instr *asm.Instruction) bool {
+	switch instr.Mnemonic {
+	case "JP", "JR", "CALL", "RET", "RETI", "RST", "HALT", "STOP":
+		return true
+	}
+	return false
+}
+
+// compile compiles an instruction to a closure executing it. Common instructions on registers have closures of their
+// own, and the others are executed by the SimpleCore.
+func (c *CachedCore) compile(instr *asm.Instruction) func() (int, error) {
+	s := c.SimpleCore
+	cycles := instr.Cycles
+	ops := instr.Operands
+	switch instr.Mnemonic {
+	case "NOP":
+		return func() (int, error) { return cycles, nil }
+
+	case "LD":
+		dst, ok := ops[0].(asm.Reg8)
+		if !ok {
+			break
+		}
+		switch src := ops[1].(type) {
+		case asm.Reg8:
+			return func() (int, error) {
+				s.set8(dst, s.get8(src))
+				return cycles, nil
+			}
+		case asm.Imm8:
+			return func() (int, error) {
+				s.set8(dst, uint8(src))
+				return cycles, nil
+			}
+		}
+
+	case "ADC", "SUB", "SBC", "AND", "XOR", "OR", "CP":
+		mnemonic := instr.Mnemonic
+		switch src := ops[1].(type) {
+		case asm.Reg8:
+			return func() (int, error) {
+				s.alu(mnemonic, s.get8(src))
+				return cycles, nil
+			}
+		case asm.Imm8:
+			return func() (int, error) {
+				s.alu(mnemonic, uint8(src))
+				return cycles, nil
+			}
+		}
+
+	case "JP":
+		if target, ok := ops[0].(asm.Imm16); ok {
+			return func() (int, error) {
+				s.PC = Register(target)
+				return cycles, nil
+			}
+		}
+
+	case "JR":
+		if offset, ok := ops[0].(asm.Rel8); ok {
+			return func() (int, error) {
+				s.PC += Register(int8(offset))
+				return cycles, nil
+			}
+		}
+		cond, offset := ops[0].(asm.Cond), ops[1].(asm.Rel8)
+		return func() (int, error) {
+			if !s.condition(cond) {
+				return cycles - notTakenCycles["JR"], nil
+			}
+			s.PC += Register(int8(offset))
+			return cycles, nil
+		}
+	}
+	return func() (int, error) { return s.execute(instr) }
+}
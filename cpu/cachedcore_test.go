@@ -0,0 +1,240 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedSource copies a routine to RAM and calls it, modifying it between calls, and while it runs.
+const cachedSource = `
+	ld sp, $FFFE
+	ld hl, $C000
+	ld de, Routine
+	ld b, RoutineEnd - Routine
+.copy
+	ld a, [de]
+	ld [hl+], a
+	inc de
+	dec b
+	jr nz, .copy
+
+	ld c, 4
+.loop
+	call $C000
+	dec c
+	jr nz, .loop
+
+	ld a, $3C ; INC A
+	ld [$C001], a
+	call $C000
+	ld a, $2F ; CPL
+	ld [$C001], a
+	call $C000
+	halt
+
+Routine:
+	ld a, 0
+	; the routine overwrites its next instruction with the opcode in A
+	ld [$C005], a
+	nop
+	ld b, a
+	ret
+RoutineEnd:
+`
+
+// traceCore runs c until it halts, and returns the registers and cycles of each step.
+func traceCore(t *testing.T, c Core, regs *Registers, halted *bool) []string {
+	t.Helper()
+	var trace []string
+	for i := 0; !*halted; i++ {
+		require.Less(t, i, 10000, "program did not halt")
+		cycles, err := c.Step()
+		require.NoError(t, err)
+		trace = append(trace, fmt.Sprintf("%04X %04X %04X %04X %04X %04X %d", regs.AF, regs.BC, regs.DE, regs.HL, regs.SP, regs.PC, cycles))
+	}
+	return trace
+}
+
+func TestCachedCore(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(cachedSource))
+	require.NoError(t, err)
+
+	mem := NewMemory()
+	mem.WriteAt(code, 0)
+	s := NewSimpleCore(mem)
+	want := traceCore(t, s, &s.Registers, &s.Halted)
+
+	mem = NewMemory()
+	mem.WriteAt(code, 0)
+	c := NewCachedCore(NewSimpleCore(mem))
+	got := traceCore(t, c, &c.Registers, &c.Halted)
+	assert.Equal(t, want, got)
+	assert.Equal(t, uint8(0x2F), mem.Read(0xC005))
+
+	// memory changed behind the CPU's back is decoded again, when the blocks in it are entered
+	c.Halted = false
+	c.PC = 0xC000
+	mem.Poke(0xC001, 0x42)
+	for c.PC != 0xC005 {
+		_, err := c.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint8(0x42), c.AF.Hi())
+}
+
+func TestCachedCoreBus(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(cachedSource))
+	require.NoError(t, err)
+	// the accesses of the cores, each with the cycles ticked before it
+	bus := func(newCore func(*SimpleCore) Core) []string {
+		mem := NewMemory()
+		mem.WriteAt(code, 0)
+		s := NewSimpleCore(mem)
+		ticked := 0
+		s.Tick = func(cycles int) { ticked += cycles }
+		var accesses []string
+		mem.AddHook(0x0000, 0xFFFF, AccessRead|AccessWrite|AccessExecute, func(addr uint16, v uint8, access Access) {
+			accesses = append(accesses, fmt.Sprintf("%d %v $%04X = $%02X", ticked, access, addr, v))
+		})
+		c := newCore(s)
+		for !s.Halted {
+			_, err := c.Step()
+			require.NoError(t, err)
+		}
+		return accesses
+	}
+	want := bus(func(s *SimpleCore) Core { return s })
+	got := bus(func(s *SimpleCore) Core { return NewCachedCore(s) })
+	assert.Equal(t, want, got)
+}
+
+func TestCachedCoreOperands(t *testing.T) {
+	mem := NewMemory()
+	mem.WriteAt([]byte{
+		0xFA, 0x90, 0xFF, // LD A, [$FF90]
+		0x3C,             // INC A
+		0xEA, 0x90, 0xFF, // LD [$FF90], A
+		0xEA, 0x8B, 0xFF, // LD [$FF8B], A
+		0x06, 0x00, // LD B, 0, with its operand patched by the previous instruction
+		0x76, // HALT
+	}, 0xFF80)
+	c := NewCachedCore(NewSimpleCore(mem))
+	run := func() {
+		c.PC, c.Halted = 0xFF80, false
+		for !c.Halted {
+			_, err := c.Step()
+			require.NoError(t, err)
+		}
+	}
+	run()
+	run()
+	assert.Equal(t, uint8(2), c.BC.Hi())
+
+	// operands changed without the CPU are seen within a block
+	mem.WriteAt([]byte{
+		0x00,       // NOP
+		0x06, 0x01, // LD B, 1
+		0x76, // HALT
+	}, 0xFF80)
+	run()
+	assert.Equal(t, uint8(1), c.BC.Hi())
+	c.PC, c.Halted = 0xFF80, false
+	_, err := c.Step()
+	require.NoError(t, err)
+	mem.Poke(0xFF82, 0x55)
+	_, err = c.Step()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x55), c.BC.Hi())
+}
+
+func TestCachedCoreBanks(t *testing.T) {
+	mem := NewMemory()
+	// LD A, bank; JP $4000, with a different bank at $4000
+	mem.WriteAt([]byte{0xC3, 0x00, 0x40}, 0)
+	mem.WriteAt([]byte{0x3E, 0x01, 0xC3, 0x00, 0x00}, 0x4000)
+	c := NewCachedCore(NewSimpleCore(mem))
+	bank := 1
+	c.Bank = func(addr uint16) int {
+		if addr < 0x4000 {
+			return 0
+		}
+		return bank
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint8(1), c.AF.Hi())
+
+	// blocks of ROM are cached per bank
+	bank = 2
+	mem.WriteAt([]byte{0x3E, 0x02}, 0x4000)
+	for i := 0; i < 3; i++ {
+		_, err := c.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint8(2), c.AF.Hi())
+	assert.Len(t, c.blocks, 3)
+
+	// illegal opcodes are reported when they are reached
+	mem.WriteAt([]byte{0xD3}, 0)
+	c.Flush()
+	c.PC = 0
+	_, err := c.Step()
+	assert.ErrorIs(t, err, asm.ErrIllegalOpcode)
+}
+
+// benchmarkSource spins in a loop of common instructions.
+const benchmarkSource = `
+	ld sp, $FFFE
+.loop
+	ld hl, $C000
+	ld b, 0
+.inner
+	ld a, [hl]
+	add a, b
+	ld [hl+], a
+	xor c
+	ld c, a
+	cp $80
+	jr c, .skip
+	inc d
+.skip
+	push bc
+	pop bc
+	dec b
+	jr nz, .inner
+	call .f
+	jr .loop
+.f
+	ret
+`
+
+func BenchmarkCore(b *testing.B) {
+	code, err := asm.AssembleSource("bench.asm", strings.NewReader(benchmarkSource))
+	require.NoError(b, err)
+	for _, bench := range []struct {
+		name string
+		core func(mem *Memory) Core
+	}{
+		{"Simple", func(mem *Memory) Core { return NewSimpleCore(mem) }},
+		{"Cached", func(mem *Memory) Core { return NewCachedCore(NewSimpleCore(mem)) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			mem := NewMemory()
+			mem.WriteAt(code, 0)
+			c := bench.core(mem)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Step(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,4 +3,32 @@ package cpu
 
 // Core represents an abstract CPU Core implementation
 type Core interface {
+	// Step executes a single instruction, or services an interrupt, and returns the number of clock cycles it took.
+	Step() (cycles int, err error)
+}
+
+// Addresses of the interrupt registers.
+const (
+	// IFAddr is the address of the interrupt flag register, where devices request interrupts.
+	IFAddr = 0xFF0F
+	// IEAddr is the address of the interrupt enable register.
+	IEAddr = 0xFFFF
+)
+
+// Interrupt is a bit of the interrupt flag and interrupt enable registers.
+type Interrupt uint8
+
+// Interrupts, in order of priority.
+const (
+	VBlank Interrupt = 1 << iota
+	STAT
+	Timer
+	Serial
+	Joypad
+)
+
+// RequestInterrupt requests an interrupt, by setting its bit in the interrupt flag register. This is a signal rather
+// than a bus access, so hooks are not called.
+func RequestInterrupt(m *Memory, i Interrupt) {
+	m.Poke(IFAddr, m.Peek(IFAddr)|uint8(i))
 }
@@ -0,0 +1,330 @@
+package cpu
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/opcodedata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fuzzSteps is the number of instructions each input runs.
+const fuzzSteps = 64
+
+// fuzzMemory returns memory filled with pseudo random values from seed, with code at pc.
+func fuzzMemory(seed int64, code []byte, pc uint16) *Memory {
+	mem := NewMemory()
+	rand.New(rand.NewSource(seed)).Read(mem.buffer[:])
+	for i, v := range code {
+		mem.buffer[pc+uint16(i)] = v
+	}
+	return mem
+}
+
+// opcodeInfo returns the opcode table entry of an instruction.
+func opcodeInfo(code []byte) *opcodedata.InstructionInfo {
+	if code[0] == 0xCB {
+		return opcodedata.OpcodeData.CBPrefixed[fmt.Sprintf("0x%02X", code[1])]
+	}
+	return opcodedata.OpcodeData.Unprefixed[fmt.Sprintf("0x%02X", code[0])]
+}
+
+// checkOpcodeInfo checks that an instruction, executed from the registers before, took the cycles and left the flags
+// its opcode table entry lists.
+func checkOpcodeInfo(t *testing.T, code []byte, before Registers, flags Flags, cycles int) {
+	t.Helper()
+	info := opcodeInfo(code)
+	require.NotNil(t, info)
+
+	want := info.Cycles[0]
+	if len(info.Cycles) > 1 {
+		// the second count is for a conditional branch not taken
+		instr, err := asm.Decode(code[:info.Bytes])
+		require.NoError(t, err)
+		if !(&SimpleCore{Registers: before}).condition(instr.Operands[0].(asm.Cond)) {
+			want = info.Cycles[1]
+		}
+	}
+	assert.Equal(t, want, cycles, "cycles of %s at $%04X", info.Mnemonic, before.PC)
+
+	for i, column := range []string{info.Flags.Z, info.Flags.N, info.Flags.H, info.Flags.C} {
+		flag := []Flags{FlagZ, FlagN, FlagH, FlagC}[i]
+		switch column {
+		case "-":
+			assert.Equal(t, before.Flags()&flag, flags&flag, "%s at $%04X changed %c", info.Mnemonic, before.PC, "ZNHC"[i])
+		case "0":
+			assert.Zero(t, flags&flag, "%s at $%04X set %c", info.Mnemonic, before.PC, "ZNHC"[i])
+		case "1":
+			assert.Equal(t, flag, flags&flag, "%s at $%04X cleared %c", info.Mnemonic, before.PC, "ZNHC"[i])
+		}
+	}
+}
+
+// refWrite is a write to memory of an instruction, as the reference computes it.
+type refWrite struct {
+	addr uint16
+	v    uint8
+}
+
+// reference computes, independently of the cores, the registers and the writes to memory after the instructions
+// whose results the opcode table does not give: the 8 bit arithmetic and logic of A with a register, [HL] or an
+// immediate, INC and DEC of 8 and 16 bit operands, ADD HL, ADD SP, e and LD HL, SP+e, the rotates of A, the shifts,
+// rotates and bit operations prefixed by CB, DAA, and PUSH and POP of AF. It returns false for other instructions.
+func reference(code []byte, regs Registers, peek func(uint16) uint8) (Registers, []refWrite, bool) {
+	op := code[0]
+	f := regs.Flags()
+	carry := 0
+	if f.C() {
+		carry = 1
+	}
+	a := int(regs.AF.Hi())
+	flags := func(z, n, h, c bool) Flags {
+		var f Flags
+		for i, set := range []bool{z, n, h, c} {
+			if set {
+				f |= FlagZ >> i
+			}
+		}
+		return f
+	}
+
+	// the 8 bit operands, in the order of the encoding of the opcodes, B, C, D, E, H, L, [HL] and A
+	r8 := []*Register{&regs.BC, &regs.BC, &regs.DE, &regs.DE, &regs.HL, &regs.HL, nil, &regs.AF}
+	hl := regs.HL
+	var writes []refWrite
+	get := func(i int) int {
+		switch {
+		case i == 6:
+			return int(peek(uint16(hl)))
+		case i&1 == 0 || i == 7:
+			return int(r8[i].Hi())
+		default:
+			return int(r8[i].Lo())
+		}
+	}
+	set := func(i, v int) {
+		switch {
+		case i == 6:
+			writes = append(writes, refWrite{uint16(hl), uint8(v)})
+		case i&1 == 0 || i == 7:
+			r8[i].SetHi(uint8(v))
+		default:
+			r8[i].SetLo(uint8(v))
+		}
+	}
+	// the 16 bit operands of INC, DEC and ADD HL, in the order of the encoding of the opcodes
+	r16 := []*Register{&regs.BC, &regs.DE, &regs.HL, &regs.SP}[op>>4&3]
+	// the operand of ADD SP, e and LD HL, SP+e, whose flags are those of adding its unsigned value to the low byte
+	e, sp := int(code[1]), int(regs.SP)
+
+	length := 1
+	switch {
+	case op&0xC7 == 0x04 || op&0xC7 == 0x05:
+		// INC and DEC leave C as it is
+		dst := int(op>>3) & 7
+		x := get(dst)
+		if op&1 == 0 {
+			set(dst, x+1)
+			regs.SetFlags(flags(uint8(x+1) == 0, false, x&0xF == 0xF, false) | f&FlagC)
+		} else {
+			set(dst, x-1)
+			regs.SetFlags(flags(uint8(x-1) == 0, true, x&0xF == 0, false) | f&FlagC)
+		}
+
+	case op >= 0x80 && op < 0xC0 || op >= 0xC6 && op&0xC7 == 0xC6:
+		x := int(code[1])
+		if op < 0xC0 {
+			x = get(int(op & 7))
+		} else {
+			length = 2
+		}
+		r := a
+		switch op >> 3 & 7 {
+		case 0:
+			r = a + x
+			regs.SetFlags(flags(uint8(r) == 0, false, a&0xF+x&0xF > 0xF, r > 0xFF))
+		case 1:
+			r = a + x + carry
+			regs.SetFlags(flags(uint8(r) == 0, false, a&0xF+x&0xF+carry > 0xF, r > 0xFF))
+		case 2:
+			r = a - x
+			regs.SetFlags(flags(uint8(r) == 0, true, a&0xF < x&0xF, a < x))
+		case 3:
+			r = a - x - carry
+			regs.SetFlags(flags(uint8(r) == 0, true, a&0xF-x&0xF-carry < 0, r < 0))
+		case 4:
+			r = a & x
+			regs.SetFlags(flags(r == 0, false, true, false))
+		case 5:
+			r = a ^ x
+			regs.SetFlags(flags(r == 0, false, false, false))
+		case 6:
+			r = a | x
+			regs.SetFlags(flags(r == 0, false, false, false))
+		default:
+			regs.SetFlags(flags(a == x, true, a&0xF < x&0xF, a < x))
+		}
+		regs.AF.SetHi(uint8(r))
+
+	case op&0xCF == 0x03:
+		*r16++
+
+	case op&0xCF == 0x0B:
+		*r16--
+
+	case op&0xCF == 0x09:
+		h, x := int(regs.HL), int(*r16)
+		regs.HL = Register(h + x)
+		regs.SetFlags(f&FlagZ | flags(false, false, h&0xFFF+x&0xFFF > 0xFFF, h+x > 0xFFFF))
+
+	case op == 0xE8 || op == 0xF8:
+		length = 2
+		if op == 0xE8 {
+			regs.SP = Register(sp + int(int8(e)))
+		} else {
+			regs.HL = Register(sp + int(int8(e)))
+		}
+		regs.SetFlags(flags(false, false, sp&0xF+e&0xF > 0xF, sp&0xFF+e > 0xFF))
+
+	case op == 0x07 || op == 0x0F || op == 0x17 || op == 0x1F:
+		// RLCA, RRCA, RLA and RRA rotate as their CB prefixed forms, but always clear Z
+		r, c := rotate(op>>3, a, carry)
+		regs.AF.SetHi(uint8(r))
+		regs.SetFlags(flags(false, false, false, c))
+
+	case op == 0xCB:
+		length = 2
+		cb := code[1]
+		dst, bit := int(cb&7), cb>>3&7
+		x := get(dst)
+		switch cb >> 6 {
+		case 0:
+			r, c := rotate(bit, x, carry)
+			set(dst, r)
+			regs.SetFlags(flags(uint8(r) == 0, false, false, c))
+		case 1:
+			regs.SetFlags(flags(x>>bit&1 == 0, false, true, f.C()))
+		case 2:
+			set(dst, x&^(1<<bit))
+		default:
+			set(dst, x|1<<bit)
+		}
+
+	case op == 0x27:
+		// DAA adjusts A to the binary coded decimal result of the addition or subtraction before it
+		r, c := a, f.C()
+		if !f.N() {
+			if c || r > 0x99 {
+				r, c = r+0x60, true
+			}
+			if f.H() || a&0xF > 9 {
+				r += 6
+			}
+		} else {
+			if c {
+				r -= 0x60
+			}
+			if f.H() {
+				r -= 6
+			}
+		}
+		regs.AF.SetHi(uint8(r))
+		regs.SetFlags(flags(uint8(r) == 0, f.N(), false, c))
+
+	case op == 0xF5:
+		regs.SP -= 2
+		writes = append(writes, refWrite{uint16(regs.SP) + 1, regs.AF.Hi()}, refWrite{uint16(regs.SP), regs.AF.Lo()})
+
+	case op == 0xF1:
+		regs.AF = Register(peek(uint16(regs.SP)+1))<<8 | Register(peek(uint16(regs.SP))&0xF0)
+		regs.SP += 2
+
+	default:
+		return regs, nil, false
+	}
+	regs.PC += Register(length)
+	return regs, writes, true
+}
+
+// rotate computes the CB prefixed rotates and shifts of x by their index in the encoding of the opcodes, RLC, RRC,
+// RL, RR, SLA, SRA, SWAP and SRL, returning the result and the carry out.
+func rotate(index uint8, x, carry int) (int, bool) {
+	switch index {
+	case 0:
+		return x<<1&0xFF | x>>7, x&0x80 != 0
+	case 1:
+		return x>>1 | x<<7&0xFF, x&1 != 0
+	case 2:
+		return x<<1&0xFF | carry, x&0x80 != 0
+	case 3:
+		return x>>1 | carry<<7, x&1 != 0
+	case 4:
+		return x << 1 & 0xFF, x&0x80 != 0
+	case 5:
+		return x>>1 | x&0x80, x&1 != 0
+	case 6:
+		return x>>4 | x<<4&0xF0, false
+	default:
+		return x >> 1, x&1 != 0
+	}
+}
+
+// FuzzCores runs random code with random registers and memory on a SimpleCore and a CachedCore, which must end in the
+// same state having taken the same cycles. The CachedCore compiles only common instructions, and executes the others
+// with the code of the SimpleCore, so comparing them tests its cache and those instructions. Each instruction the
+// SimpleCore executes is checked against two references independent of the cores: the cycles and flags of the opcode
+// table, and the results of reference for the instructions whose results the table does not give.
+func FuzzCores(f *testing.F) {
+	// every opcode, with the same operands
+	for op := 0; op < 0x100; op++ {
+		f.Add([]byte{uint8(op), 0x12, 0x34}, uint16(0x01B0), uint16(0x0013), uint16(0x00D8), uint16(0xC14D),
+			uint16(0xDFFE), uint16(0xC000), false, int64(op))
+		f.Add([]byte{0xCB, uint8(op)}, uint16(0x12F0), uint16(0x3456), uint16(0x789A), uint16(0xD0BC),
+			uint16(0xFFFE), uint16(0x0100), true, int64(op))
+	}
+
+	f.Fuzz(func(t *testing.T, code []byte, af, bc, de, hl, sp, pc uint16, ime bool, seed int64) {
+		regs := Registers{AF: Register(af & 0xFFF0), BC: Register(bc), DE: Register(de), HL: Register(hl),
+			SP: Register(sp), PC: Register(pc)}
+		s := NewSimpleCore(fuzzMemory(seed, code, pc))
+		s.Registers, s.IME = regs, ime
+		cached := NewSimpleCore(fuzzMemory(seed, code, pc))
+		cached.Registers, cached.IME = regs, ime
+		c := NewCachedCore(cached)
+
+		for i := 0; i < fuzzSteps; i++ {
+			before := s.Registers
+			// halted and stopped steps, interrupts and the halt bug are not instructions of the opcode table
+			check := !s.Halted && !s.Stopped && !s.haltBug && !(s.IME && s.pending() != 0)
+			instr := []byte{s.Memory.Peek(uint16(s.PC)), s.Memory.Peek(uint16(s.PC) + 1), s.Memory.Peek(uint16(s.PC) + 2)}
+
+			wantRegs, writes, referenced := reference(instr, before, s.Memory.Peek)
+
+			want, wantErr := s.Step()
+			got, err := c.Step()
+			if wantErr != nil {
+				require.EqualError(t, err, wantErr.Error(), "step %d", i)
+				break
+			}
+			require.NoError(t, err, "step %d", i)
+			require.Equal(t, want, got, "cycles of step %d at $%04X", i, before.PC)
+			require.Equal(t, s.Registers, c.Registers, "registers after step %d at $%04X", i, before.PC)
+			require.Equal(t, []bool{s.IME, s.eiPending, s.Halted, s.Stopped, s.haltBug},
+				[]bool{c.IME, c.eiPending, c.Halted, c.Stopped, c.haltBug}, "state after step %d at $%04X", i, before.PC)
+			if !check {
+				continue
+			}
+			checkOpcodeInfo(t, instr, before, s.Flags(), want)
+			if referenced {
+				assert.Equal(t, wantRegs, s.Registers, "registers after % X at $%04X", instr, before.PC)
+				for _, w := range writes {
+					assert.Equal(t, w.v, s.Memory.Peek(w.addr), "$%04X after % X at $%04X", w.addr, instr, before.PC)
+				}
+			}
+		}
+		require.True(t, s.Memory.buffer == c.Memory.buffer, "memory differs")
+	})
+}
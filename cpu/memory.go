@@ -10,11 +10,151 @@ import (
 type Memory struct {
 	// Maximum memory cells a 16-bit bus can can address.
 	buffer [0x10000]byte
+
+	// devices maps each address to the index of a device in mapped, or zero if the address is backed by buffer.
+	devices [0x10000]uint8
+	mapped  []Device
+
+	// hooks observe accesses. The slice is replaced rather than modified, so hooks may add or remove hooks.
+	hooks []*hook
+}
+
+// Access is a kind of memory access.
+type Access uint8
+
+// Kinds of memory access.
+const (
+	// AccessRead is a read by an instruction.
+	AccessRead Access = 1 << iota
+	// AccessWrite is a write by an instruction.
+	AccessWrite
+	// AccessExecute is the fetch of an opcode to execute it.
+	AccessExecute
+)
+
+// String implements fmt.Stringer, such as "rw" for a read or write access.
+func (a Access) String() string {
+	var s []byte
+	for i, c := range "rwx" {
+		if a&(1<<i) != 0 {
+			s = append(s, byte(c))
+		}
+	}
+	return string(s)
+}
+
+// Hook is called with each access to the addresses it observes, and the value read or written.
+type Hook func(addr uint16, v uint8, access Access)
+
+type hook struct {
+	start, end uint16
+	access     Access
+	fn         Hook
+}
+
+// Device is hardware mapped into the address space, such as a cartridge or an I/O register.
+type Device interface {
+	// Read returns the value at an address mapped to the device.
+	Read(addr uint16) uint8
+	// Write stores a value at an address mapped to the device.
+	Write(addr uint16, v uint8)
 }
 
 // NewMemory constructs a new Memory object
 func NewMemory() *Memory {
-	return &Memory{}
+	return &Memory{mapped: []Device{nil}}
+}
+
+// Map maps the addresses from start to end inclusive to a device, replacing any device previously mapped there.
+func (m *Memory) Map(start, end uint16, d Device) {
+	if len(m.mapped) == 0 {
+		m.mapped = []Device{nil}
+	}
+	index := -1
+	for i, mapped := range m.mapped {
+		if i > 0 && mapped == d {
+			index = i
+		}
+	}
+	if index < 0 {
+		if len(m.mapped) > 0xFF {
+			panic("too many devices")
+		}
+		index = len(m.mapped)
+		m.mapped = append(m.mapped, d)
+	}
+	for addr := int(start); addr <= int(end); addr++ {
+		m.devices[addr] = uint8(index)
+	}
+}
+
+// AddHook calls fn with each access of the given kinds to the addresses from start to end inclusive, until the
+// returned function is called to remove it. Hooks are called in the order they were added.
+func (m *Memory) AddHook(start, end uint16, access Access, fn Hook) (remove func()) {
+	h := &hook{start: start, end: end, access: access, fn: fn}
+	m.hooks = append(m.hooks[:len(m.hooks):len(m.hooks)], h)
+	return func() {
+		for i, other := range m.hooks {
+			if other == h {
+				hooks := make([]*hook, 0, len(m.hooks)-1)
+				m.hooks = append(append(hooks, m.hooks[:i]...), m.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// notify calls the hooks observing an access.
+func (m *Memory) notify(addr uint16, v uint8, access Access) {
+	for _, h := range m.hooks {
+		if h.access&access != 0 && addr >= h.start && addr <= h.end {
+			h.fn(addr, v, access)
+		}
+	}
+}
+
+// Read returns the value at an address, from the device mapped there if any.
+func (m *Memory) Read(addr uint16) uint8 {
+	v := m.Peek(addr)
+	if len(m.hooks) != 0 {
+		m.notify(addr, v, AccessRead)
+	}
+	return v
+}
+
+// Fetch returns the opcode at an address, as the CPU fetches it to execute it.
+func (m *Memory) Fetch(addr uint16) uint8 {
+	v := m.Peek(addr)
+	if len(m.hooks) != 0 {
+		m.notify(addr, v, AccessExecute)
+	}
+	return v
+}
+
+// Write stores a value at an address, into the device mapped there if any.
+func (m *Memory) Write(addr uint16, v uint8) {
+	if len(m.hooks) != 0 {
+		m.notify(addr, v, AccessWrite)
+	}
+	m.Poke(addr, v)
+}
+
+// Peek returns the value at an address like [Memory.Read], without calling hooks. It is used by debuggers, and by
+// hardware that accesses its own registers without going through the bus.
+func (m *Memory) Peek(addr uint16) uint8 {
+	if i := m.devices[addr]; i != 0 {
+		return m.mapped[i].Read(addr)
+	}
+	return m.buffer[addr]
+}
+
+// Poke stores a value at an address like [Memory.Write], without calling hooks.
+func (m *Memory) Poke(addr uint16, v uint8) {
+	if i := m.devices[addr]; i != 0 {
+		m.mapped[i].Write(addr, v)
+		return
+	}
+	m.buffer[addr] = v
 }
 
 // WriteAt implements memoryImplements.
@@ -24,7 +164,9 @@ func (m *Memory) WriteAt(p []byte, off int64) (n int, err error) {
 	if start < 0 || end > int64(len(m.buffer)) {
 		return 0, fmt.Errorf("range [%d:%d] out of bounds", start, end)
 	}
-	copy(m.buffer[start:end], p)
+	for i, b := range p {
+		m.Write(uint16(start)+uint16(i), b)
+	}
 	return len(p), nil
 }
 
@@ -58,7 +200,9 @@ func (m *Memory) ReadAt(p []byte, off int64) (n int, err error) {
 	if start < 0 || end > int64(len(m.buffer)) {
 		return 0, fmt.Errorf("range [%d:%d] out of bounds", start, end)
 	}
-	copy(p, m.buffer[start:end])
+	for i := range p {
+		p[i] = m.Read(uint16(start) + uint16(i))
+	}
 	return len(p), nil
 }
 
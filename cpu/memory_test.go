@@ -0,0 +1,67 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryHooks(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(`
+	ld a, $80
+	ldh [$FF26], a
+	ld a, $77
+	ldh [$FF24], a
+	ldh a, [$FF26]
+	ld hl, $FF25
+	ld [hl], $F3
+	halt
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory()
+	if _, err := mem.WriteAt(code, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var io, fetched []string
+	mem.AddHook(0xFF00, 0xFF7F, AccessRead|AccessWrite, func(addr uint16, v uint8, access Access) {
+		io = append(io, fmt.Sprintf("%s $%04X $%02X", access, addr, v))
+	})
+	var remove func()
+	remove = mem.AddHook(0x0000, 0x00FF, AccessExecute, func(addr uint16, v uint8, access Access) {
+		fetched = append(fetched, fmt.Sprintf("$%04X", addr))
+		if len(fetched) == 3 {
+			// hooks may remove themselves
+			remove()
+		}
+	})
+
+	c := NewSimpleCore(mem)
+	for !c.Halted {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, []string{
+		"w $FF26 $80",
+		"w $FF24 $77",
+		"r $FF26 $80",
+		"w $FF25 $F3",
+	}, io)
+	assert.Equal(t, []string{"$0000", "$0002", "$0004"}, fetched)
+
+	// debuggers peek and poke without calling hooks
+	mem.Poke(0xFF40, 0x91)
+	assert.Equal(t, uint8(0x91), mem.Peek(0xFF40))
+	assert.Len(t, io, 4)
+}
+
+func TestAccess(t *testing.T) {
+	assert.Equal(t, "r", AccessRead.String())
+	assert.Equal(t, "rwx", (AccessRead | AccessWrite | AccessExecute).String())
+}
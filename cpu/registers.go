@@ -44,9 +44,34 @@ func (r *Registers) Flags() Flags {
 	return Flags(r.AF.Lo())
 }
 
+// SetFlags stores the Flags register into the Lo bits of the AF register. The low 4 bits of the Flags register
+// always read as zero.
+func (r *Registers) SetFlags(f Flags) {
+	r.AF.SetLo(uint8(f) & 0xF0)
+}
+
 // Flags represents an 8-bit flags register, found in the lower 8-bits of the AF register.
 type Flags uint8
 
+// Bits of the Flags register.
+const (
+	FlagZ Flags = 1 << 7
+	FlagN Flags = 1 << 6
+	FlagH Flags = 1 << 5
+	FlagC Flags = 1 << 4
+)
+
+// String implements fmt.Stringer, showing the letter of each set flag, and a dash for each clear flag: Z-H-
+func (f Flags) String() string {
+	b := []byte("----")
+	for i, flag := range []Flags{FlagZ, FlagN, FlagH, FlagC} {
+		if f&flag != 0 {
+			b[i] = "ZNHC"[i]
+		}
+	}
+	return string(b)
+}
+
 // Z is the zero flag of the Flags register, at bit 7.
 // Returns true if Z == 1
 func (f Flags) Z() bool {
@@ -1,34 +1,628 @@
 package cpu
 
+import (
+	"fmt"
+	"io"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// SimpleCore is a straightforward [Core], which decodes every instruction it executes with package asm, and
+// executes the typed operands of the decoded instruction.
 type SimpleCore struct {
 	Registers
 	Memory *Memory
+
+	// IME is the interrupt master enable flag.
+	IME bool
+	// Halted is set by HALT, until an interrupt is requested.
+	Halted bool
+	// Stopped is set by STOP, until a joypad interrupt is requested.
+	Stopped bool
+
+	// eiPending is set by EI, which enables interrupts after the instruction that follows it.
+	eiPending bool
+	// haltBug is set when HALT is executed while an interrupt is pending with IME disabled: the CPU then fails to
+	// increment PC after fetching the next opcode.
+	haltBug bool
+
+	// IDU, if not nil, is called with the values of 16 bit registers the increment and decrement unit increments or
+	// decrements, which it puts on the address bus, as INC and DEC, [HL+] and [HL-], PUSH and POP do. The access is a
+	// write for INC, DEC, PUSH and stores, and a read otherwise. The DMG corrupts OAM when the value is in it.
+	IDU func(v uint16, access Access)
+
+	// Tick, if not nil, selects the cycle accurate mode, where each memory access of an instruction happens on its own
+	// M-cycle, rather than all of them at once: Tick is called with the 4 clock cycles of each M-cycle before the
+	// access that ends it, and of the internal M-cycles before accesses, so that the other devices can advance between
+	// accesses. Step then returns the cycles left after the last access.
+	Tick func(cycles int)
+	// ticked counts the cycles passed to Tick by the current step.
+	ticked int
+	// written, if not nil, is called with the address of each write, as the CachedCore observes them.
+	written func(addr uint16)
+}
+
+// NewSimpleCore constructs a new [SimpleCore] executing code from mem.
+func NewSimpleCore(mem *Memory) *SimpleCore {
+	return &SimpleCore{Memory: mem}
+}
+
+var _ Core = (*SimpleCore)(nil)
+
+// interruptCycles is the number of cycles taken to dispatch an interrupt.
+const interruptCycles = 20
+
+// pending returns the interrupts that are both requested and enabled.
+func (c *SimpleCore) pending() uint8 {
+	return c.Memory.Peek(IEAddr) & c.Memory.Peek(IFAddr) & 0x1F
+}
+
+// Step implements Core.
+func (c *SimpleCore) Step() (int, error) {
+	c.ticked = 0
+	cycles, err := c.step()
+	// STOP reads its operand in the M-cycle the opcode table counts for it
+	if cycles -= c.ticked; cycles < 0 {
+		cycles = 0
+	}
+	return cycles, err
+}
+
+// step executes an instruction, or services an interrupt, returning its cycles including those passed to Tick.
+func (c *SimpleCore) step() (int, error) {
+	pending := c.pending()
+	switch {
+	case c.Stopped && pending&uint8(Joypad) == 0:
+		return 4, nil
+
+	case c.Halted && pending == 0:
+		return 4, nil
+	}
+	c.Stopped, c.Halted = false, false
+
+	if c.IME && pending != 0 {
+		return c.interrupt(pending), nil
+	}
+
+	instr, err := c.decode()
+	if err != nil {
+		return 0, err
+	}
+
+	enable := c.eiPending
+	cycles, err := c.execute(instr)
+	if enable && c.eiPending {
+		c.IME, c.eiPending = true, false
+	}
+	return cycles, err
+}
+
+// interrupt dispatches the highest priority pending interrupt.
+func (c *SimpleCore) interrupt(pending uint8) int {
+	for bit := 0; bit < 5; bit++ {
+		if pending&(1<<bit) == 0 {
+			continue
+		}
+		c.IME, c.eiPending = false, false
+		c.Memory.Poke(IFAddr, c.Memory.Peek(IFAddr)&^(1<<bit))
+		c.cycle()
+		c.push(uint16(c.PC))
+		c.PC = Register(0x40 + 8*bit)
+		break
+	}
+	return interruptCycles
+}
+
+// decode fetches and decodes the instruction at PC, advancing PC past it. The opcode is fetched, and its operands
+// read, one byte at a time, so that only the bytes of the instruction are accessed.
+func (c *SimpleCore) decode() (*asm.Instruction, error) {
+	pc := uint16(c.PC)
+	buf := make([]byte, 1, 3)
+	c.cycle()
+	buf[0] = c.Memory.Fetch(pc)
+	next := pc + 1
+	if c.haltBug {
+		// the opcode is read again as the next byte
+		next = pc
+	}
+
+	for {
+		instr, err := asm.Decode(buf)
+		if err == io.ErrUnexpectedEOF {
+			buf = append(buf, c.read(next))
+			next++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("executing $%04X: %w", pc, err)
+		}
+		c.PC = Register(next)
+		c.haltBug = false
+		return instr, nil
+	}
+}
+
+// notTakenCycles is how many fewer cycles conditional instructions take when their branch is not taken.
+var notTakenCycles = map[string]int{"JR": 4, "JP": 4, "CALL": 12, "RET": 12}
+
+// execute executes a decoded instruction, returning the number of cycles taken.
+func (c *SimpleCore) execute(instr *asm.Instruction) (int, error) {
+	ops := instr.Operands
+	// a conditional branch has its condition as the first operand
+	if len(ops) > 0 {
+		if cond, ok := ops[0].(asm.Cond); ok {
+			if instr.Mnemonic == "RET" {
+				// the condition is checked in an M-cycle of its own
+				c.cycle()
+			}
+			if !c.condition(cond) {
+				return instr.Cycles - notTakenCycles[instr.Mnemonic], nil
+			}
+			ops = ops[1:]
+		}
+	}
+
+	switch instr.Mnemonic {
+	case "NOP":
+
+	case "LD", "LDH":
+		c.ld(ops[0], ops[1])
+
+	case "INC", "DEC":
+		c.incDec(instr.Mnemonic == "INC", ops[0])
+
+	case "ADD":
+		c.add(ops[0], ops[1])
+
+	case "ADC", "SUB", "SBC", "AND", "XOR", "OR", "CP":
+		c.alu(instr.Mnemonic, c.read8(ops[1]))
+
+	case "RLCA", "RRCA", "RLA", "RRA":
+		c.AF.SetHi(c.rotate(instr.Mnemonic[:len(instr.Mnemonic)-1], c.AF.Hi()))
+		c.SetFlags(c.Flags() &^ FlagZ)
+
+	case "RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL":
+		c.write8(ops[0], c.rotate(instr.Mnemonic, c.read8(ops[0])))
+
+	case "BIT":
+		v := c.read8(ops[1])
+		c.setFlags(v&(1<<ops[0].(asm.Bit)) == 0, false, true, c.Flags().C())
+
+	case "RES":
+		c.write8(ops[1], c.read8(ops[1])&^(1<<ops[0].(asm.Bit)))
+
+	case "SET":
+		c.write8(ops[1], c.read8(ops[1])|(1<<ops[0].(asm.Bit)))
+
+	case "DAA":
+		c.daa()
+
+	case "CPL":
+		c.AF.SetHi(^c.AF.Hi())
+		c.SetFlags(c.Flags() | FlagN | FlagH)
+
+	case "SCF":
+		c.setFlags(c.Flags().Z(), false, false, true)
+
+	case "CCF":
+		c.setFlags(c.Flags().Z(), false, false, !c.Flags().C())
+
+	case "JP":
+		if r, ok := ops[0].(asm.Reg16); ok {
+			c.PC = Register(c.get16(r))
+		} else {
+			c.PC = Register(ops[0].(asm.Imm16))
+		}
+
+	case "JR":
+		c.PC += Register(int8(ops[0].(asm.Rel8)))
+
+	case "CALL":
+		c.push(uint16(c.PC))
+		c.PC = Register(ops[0].(asm.Imm16))
+
+	case "RET":
+		c.PC = Register(c.pop())
+
+	case "RETI":
+		c.PC = Register(c.pop())
+		c.IME = true
+
+	case "RST":
+		c.push(uint16(c.PC))
+		c.PC = Register(ops[0].(asm.Imm8))
+
+	case "PUSH":
+		c.push(c.get16(ops[0].(asm.Reg16)))
+
+	case "POP":
+		c.set16(ops[0].(asm.Reg16), c.pop())
+
+	case "DI":
+		c.IME, c.eiPending = false, false
+
+	case "EI":
+		if !c.IME {
+			c.eiPending = true
+		}
+
+	case "HALT":
+		if !c.IME && c.pending() != 0 {
+			c.haltBug = true
+		} else {
+			c.Halted = true
+		}
+
+	case "STOP":
+		c.Stopped = true
+
+	default:
+		return 0, fmt.Errorf("executing %s: not implemented", instr)
+	}
+	return instr.Cycles, nil
 }
 
-// func (c *SimpleCore) fetch() (op uint8, err error) {
-// 	op, err = c.Memory.ReadUint8At(int64(c.PC))
-// 	if err != nil {
-// 		err = fmt.Errorf("fetching instruction @ $%X: %v", c.PC, err)
-// 	}
-// 	return
-// }
-
-// func (c *SimpleCore) decode(op uint8) (*asm.Instruction, error) {
-// 	// prefixed
-// 	var instructionMap opcodedata.InstructionMap
-// 	if op == 0xCB {
-// 		instructionMap = opcodedata.OpcodeData.Unprefixed
-// 	} else {
-// 		instructionMap = opcodedata.OpcodeData.Unprefixed
-// 	}
-// 	instruction, ok := instructionMap[fmt.Sprintf("0x%02X", op)]
-// 	if !ok {
-// 		return nil, fmt.Errorf("invalid opcode: %02X", op)
-// 	}
-// 	fmt.Println(instruction)
-// 	panic("not finished")
-// }
-
-// func (c *SimpleCore) execute() {
-// 	panic("not finished")
-// }
+func (c *SimpleCore) condition(cond asm.Cond) bool {
+	f := c.Flags()
+	switch cond {
+	case asm.NZ:
+		return !f.Z()
+	case asm.Z:
+		return f.Z()
+	case asm.NC:
+		return !f.C()
+	default:
+		return f.C()
+	}
+}
+
+func (c *SimpleCore) setFlags(z, n, h, cy bool) {
+	var f Flags
+	if z {
+		f |= FlagZ
+	}
+	if n {
+		f |= FlagN
+	}
+	if h {
+		f |= FlagH
+	}
+	if cy {
+		f |= FlagC
+	}
+	c.SetFlags(f)
+}
+
+// get8 returns the value of an 8 bit register.
+func (c *SimpleCore) get8(r asm.Reg8) uint8 {
+	switch r {
+	case asm.A:
+		return c.AF.Hi()
+	case asm.F:
+		return c.AF.Lo()
+	case asm.B:
+		return c.BC.Hi()
+	case asm.C:
+		return c.BC.Lo()
+	case asm.D:
+		return c.DE.Hi()
+	case asm.E:
+		return c.DE.Lo()
+	case asm.H:
+		return c.HL.Hi()
+	default:
+		return c.HL.Lo()
+	}
+}
+
+// set8 sets the value of an 8 bit register.
+func (c *SimpleCore) set8(r asm.Reg8, v uint8) {
+	switch r {
+	case asm.A:
+		c.AF.SetHi(v)
+	case asm.F:
+		c.SetFlags(Flags(v))
+	case asm.B:
+		c.BC.SetHi(v)
+	case asm.C:
+		c.BC.SetLo(v)
+	case asm.D:
+		c.DE.SetHi(v)
+	case asm.E:
+		c.DE.SetLo(v)
+	case asm.H:
+		c.HL.SetHi(v)
+	default:
+		c.HL.SetLo(v)
+	}
+}
+
+// reg16 returns a 16 bit register.
+func (c *SimpleCore) reg16(r asm.Reg16) *Register {
+	switch r {
+	case asm.AF:
+		return &c.AF
+	case asm.BC:
+		return &c.BC
+	case asm.DE:
+		return &c.DE
+	case asm.HL:
+		return &c.HL
+	case asm.PC:
+		return &c.PC
+	default:
+		return &c.SP
+	}
+}
+
+func (c *SimpleCore) get16(r asm.Reg16) uint16 {
+	return uint16(*c.reg16(r))
+}
+
+func (c *SimpleCore) set16(r asm.Reg16, v uint16) {
+	if r == asm.AF {
+		v &= 0xFFF0
+	}
+	*c.reg16(r) = Register(v)
+}
+
+// cycle completes an M-cycle in the cycle accurate mode, calling Tick.
+func (c *SimpleCore) cycle() {
+	if c.Tick != nil {
+		c.Tick(4)
+		c.ticked += 4
+	}
+}
+
+// read reads memory in an M-cycle of its own.
+func (c *SimpleCore) read(addr uint16) uint8 {
+	c.cycle()
+	return c.Memory.Read(addr)
+}
+
+// write writes memory in an M-cycle of its own.
+func (c *SimpleCore) write(addr uint16, v uint8) {
+	c.cycle()
+	c.Memory.Write(addr, v)
+	if c.written != nil {
+		c.written(addr)
+	}
+}
+
+// idu calls the IDU hook, if any.
+func (c *SimpleCore) idu(v uint16, access Access) {
+	if c.IDU != nil {
+		c.IDU(v, access)
+	}
+}
+
+// address returns the address a pointer operand refers to for an access, applying the increment or decrement of
+// [HL+] and [HL-].
+func (c *SimpleCore) address(op asm.Operand, access Access) uint16 {
+	switch p := op.(type) {
+	case asm.Pointer[asm.Reg16]:
+		addr := c.get16(p.Ref)
+		if p.Delta != 0 {
+			c.idu(addr, access)
+			c.set16(p.Ref, addr+uint16(int16(p.Delta)))
+		}
+		return addr
+
+	case asm.Pointer[asm.Reg8]:
+		return 0xFF00 | uint16(c.get8(p.Ref))
+
+	case asm.Pointer[asm.Imm16]:
+		return uint16(p.Ref)
+
+	default:
+		panic(fmt.Sprintf("%v is not a pointer", op))
+	}
+}
+
+// read8 returns the value of an 8 bit operand.
+func (c *SimpleCore) read8(op asm.Operand) uint8 {
+	switch op := op.(type) {
+	case asm.Reg8:
+		return c.get8(op)
+	case asm.Imm8:
+		return uint8(op)
+	default:
+		return c.read(c.address(op, AccessRead))
+	}
+}
+
+// write8 stores the value of an 8 bit operand.
+func (c *SimpleCore) write8(op asm.Operand, v uint8) {
+	if r, ok := op.(asm.Reg8); ok {
+		c.set8(r, v)
+		return
+	}
+	c.write(c.address(op, AccessWrite), v)
+}
+
+// push pushes a value on the stack, after an internal M-cycle.
+func (c *SimpleCore) push(v uint16) {
+	c.cycle()
+	c.idu(uint16(c.SP), AccessWrite)
+	c.SP -= 2
+	c.write(uint16(c.SP)+1, uint8(v>>8))
+	c.write(uint16(c.SP), uint8(v))
+}
+
+func (c *SimpleCore) pop() uint16 {
+	c.idu(uint16(c.SP), AccessRead)
+	lo := c.read(uint16(c.SP))
+	hi := c.read(uint16(c.SP) + 1)
+	c.SP += 2
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// isSPOffset reports whether a 16 bit register operand is the SP + e8 operand of LD HL, SP + e8
+func isSPOffset(r asm.Reg16) bool {
+	return r != asm.SP && r >= asm.SP-0x80 && r <= asm.SP+0x7F
+}
+
+// addSP returns SP plus a signed offset, setting the flags as ADD SP, e8 and LD HL, SP + e8 do.
+func (c *SimpleCore) addSP(offset int8) uint16 {
+	sp, e := uint16(c.SP), uint16(uint8(offset))
+	c.setFlags(false, false, sp&0xF+e&0xF > 0xF, sp&0xFF+e&0xFF > 0xFF)
+	return sp + uint16(offset)
+}
+
+func (c *SimpleCore) ld(dst, src asm.Operand) {
+	switch dst := dst.(type) {
+	case asm.Reg16:
+		switch src := src.(type) {
+		case asm.Imm16:
+			c.set16(dst, uint16(src))
+		case asm.Reg16:
+			if dst == asm.HL && (src == asm.SP || isSPOffset(src)) {
+				c.set16(dst, c.addSP(int8(src-asm.SP)))
+			} else {
+				c.set16(dst, c.get16(src))
+			}
+		}
+		return
+
+	case asm.Pointer[asm.Imm16]:
+		if r, ok := src.(asm.Reg16); ok {
+			addr := uint16(dst.Ref)
+			v := c.get16(r)
+			c.write(addr, uint8(v))
+			c.write(addr+1, uint8(v>>8))
+			return
+		}
+	}
+	c.write8(dst, c.read8(src))
+}
+
+func (c *SimpleCore) incDec(inc bool, op asm.Operand) {
+	if r, ok := op.(asm.Reg16); ok {
+		c.idu(c.get16(r), AccessWrite)
+		if inc {
+			c.set16(r, c.get16(r)+1)
+		} else {
+			c.set16(r, c.get16(r)-1)
+		}
+		return
+	}
+
+	// the pointer is only evaluated once, to read and write the same address
+	if _, ok := op.(asm.Reg8); !ok {
+		op = asm.Ptr(asm.Imm16(c.address(op, AccessRead)))
+	}
+	v := c.read8(op)
+	if inc {
+		c.write8(op, v+1)
+		c.setFlags(v+1 == 0, false, v&0xF == 0xF, c.Flags().C())
+	} else {
+		c.write8(op, v-1)
+		c.setFlags(v-1 == 0, true, v&0xF == 0, c.Flags().C())
+	}
+}
+
+func (c *SimpleCore) add(dst, src asm.Operand) {
+	switch dst {
+	case asm.HL:
+		hl, v := c.get16(asm.HL), c.get16(src.(asm.Reg16))
+		c.setFlags(c.Flags().Z(), false, hl&0xFFF+v&0xFFF > 0xFFF, uint32(hl)+uint32(v) > 0xFFFF)
+		c.set16(asm.HL, hl+v)
+
+	case asm.SP:
+		c.SP = Register(c.addSP(int8(src.(asm.Imm8))))
+
+	default:
+		c.alu("ADD", c.read8(src))
+	}
+}
+
+// alu executes the 8 bit arithmetic and logic operations on A.
+func (c *SimpleCore) alu(mnemonic string, v uint8) {
+	a := c.AF.Hi()
+	var carry uint8
+	if (mnemonic == "ADC" || mnemonic == "SBC") && c.Flags().C() {
+		carry = 1
+	}
+
+	switch mnemonic {
+	case "ADD", "ADC":
+		r := uint16(a) + uint16(v) + uint16(carry)
+		c.AF.SetHi(uint8(r))
+		c.setFlags(uint8(r) == 0, false, a&0xF+v&0xF+carry > 0xF, r > 0xFF)
+
+	case "SUB", "SBC", "CP":
+		r := int(a) - int(v) - int(carry)
+		if mnemonic != "CP" {
+			c.AF.SetHi(uint8(r))
+		}
+		c.setFlags(uint8(r) == 0, true, int(a&0xF)-int(v&0xF)-int(carry) < 0, r < 0)
+
+	case "AND":
+		c.AF.SetHi(a & v)
+		c.setFlags(a&v == 0, false, true, false)
+
+	case "XOR":
+		c.AF.SetHi(a ^ v)
+		c.setFlags(a^v == 0, false, false, false)
+
+	case "OR":
+		c.AF.SetHi(a | v)
+		c.setFlags(a|v == 0, false, false, false)
+	}
+}
+
+// rotate executes the rotate and shift operations, setting the flags as the CB prefixed instructions do.
+func (c *SimpleCore) rotate(mnemonic string, v uint8) uint8 {
+	var carry uint8
+	if c.Flags().C() {
+		carry = 1
+	}
+
+	var r uint8
+	out := v&0x80 != 0
+	switch mnemonic {
+	case "RLC":
+		r = v<<1 | v>>7
+	case "RL":
+		r = v<<1 | carry
+	case "SLA":
+		r = v << 1
+	case "RRC":
+		r, out = v>>1|v<<7, v&1 != 0
+	case "RR":
+		r, out = v>>1|carry<<7, v&1 != 0
+	case "SRA":
+		r, out = v>>1|v&0x80, v&1 != 0
+	case "SRL":
+		r, out = v>>1, v&1 != 0
+	case "SWAP":
+		r, out = v<<4|v>>4, false
+	}
+	c.setFlags(r == 0, false, false, out)
+	return r
+}
+
+// daa adjusts A to be a binary coded decimal, after an addition or subtraction of binary coded decimals.
+func (c *SimpleCore) daa() {
+	a := c.AF.Hi()
+	f := c.Flags()
+	carry := f.C()
+	if !f.N() {
+		if carry || a > 0x99 {
+			a += 0x60
+			carry = true
+		}
+		if f.H() || a&0xF > 0x9 {
+			a += 0x06
+		}
+	} else {
+		if carry {
+			a -= 0x60
+		}
+		if f.H() {
+			a -= 0x06
+		}
+	}
+	c.AF.SetHi(a)
+	c.setFlags(a == 0, f.N(), false, carry)
+}
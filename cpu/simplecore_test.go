@@ -0,0 +1,273 @@
+package cpu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/stretchr/testify/assert"
+)
+
+// runProgram assembles src at $0000, and executes it until it halts.
+func runProgram(t *testing.T, src string) *SimpleCore {
+	t.Helper()
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory()
+	if _, err := mem.WriteAt(code, 0); err != nil {
+		t.Fatal(err)
+	}
+	c := NewSimpleCore(mem)
+	c.SP = 0xFFFE
+	for i := 0; !c.Halted; i++ {
+		if i > 10000 {
+			t.Fatal("program did not halt")
+		}
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestSimpleCore(t *testing.T) {
+	for name, tc := range map[string]struct {
+		src  string
+		want Registers
+	}{
+		"loads": {
+			src: `
+	ld bc, $1234
+	ld d, b
+	ld e, $56
+	ld hl, $C000
+	ld [hl+], a
+	ld [hl], e
+	ld a, [$C001]
+	halt`,
+			want: Registers{AF: 0x5600, BC: 0x1234, DE: 0x1256, HL: 0xC001, SP: 0xFFFE, PC: 0x000F},
+		},
+		"arithmetic": {
+			src: `
+	ld a, $0F
+	add a, $01
+	ld b, a
+	sub a, $11
+	halt`,
+			want: Registers{AF: 0xFF70, BC: 0x1000, SP: 0xFFFE, PC: 0x0008},
+		},
+		"decimal adjust": {
+			src: `
+	ld a, $19
+	add a, $28
+	daa
+	ld b, a
+	sub a, $48
+	daa
+	halt`,
+			want: Registers{AF: 0x9950, BC: 0x4700, SP: 0xFFFE, PC: 0x000A},
+		},
+		"loops": {
+			src: `
+	ld b, 10
+	xor a, a
+.loop
+	add a, b
+	dec b
+	jr nz, .loop
+	halt`,
+			want: Registers{AF: 0x37C0, SP: 0xFFFE, PC: 0x0008},
+		},
+		"calls": {
+			src: `
+	ld sp, $D000
+	call Double
+	call Double
+	halt
+Double:
+	push af
+	pop hl
+	add hl, hl
+	ld a, h
+	inc a
+	ret`,
+			want: Registers{AF: 0x0300, HL: 0x0200, SP: 0xD000, PC: 0x000A},
+		},
+		"stack pointer offsets": {
+			src: `
+	ld sp, $FFF8
+	ld hl, sp + 2
+	add sp, -8
+	ld [$C000], sp
+	ld a, [$C000]
+	halt`,
+			want: Registers{AF: 0xF030, HL: 0xFFFA, SP: 0xFFF0, PC: 0x000E},
+		},
+		"bit operations": {
+			src: `
+	ld a, $81
+	rlca
+	ld b, a
+	swap a
+	set 7, a
+	res 0, b
+	bit 1, b
+	halt`,
+			want: Registers{AF: 0xB020, BC: 0x0200, SP: 0xFFFE, PC: 0x000D},
+		},
+	} {
+		c := runProgram(t, tc.src)
+		assert.Equal(t, tc.want, c.Registers, name)
+	}
+}
+
+func TestSimpleCoreInterrupts(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(`
+	ei
+	nop
+	halt
+	ld b, a
+	halt
+	ds $50 - @
+Timer:
+	ld a, $42
+	reti
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory()
+	mem.WriteAt(code, 0)
+	mem.Write(IEAddr, uint8(Timer))
+	c := NewSimpleCore(mem)
+	c.SP = 0xFFFE
+
+	step := func() int {
+		cycles, err := c.Step()
+		assert.NoError(t, err)
+		return cycles
+	}
+	step() // ei
+	assert.False(t, c.IME)
+	step() // nop
+	assert.True(t, c.IME)
+	step() // halt
+	assert.True(t, c.Halted)
+	assert.Equal(t, 4, step())
+
+	RequestInterrupt(mem, Timer)
+	assert.Equal(t, 20, step())
+	assert.Equal(t, Register(0x50), c.PC)
+	assert.False(t, c.IME)
+	assert.Zero(t, mem.Read(IFAddr))
+	step() // ld a, $42
+	step() // reti
+	assert.True(t, c.IME)
+	step() // ld b, a
+	assert.Equal(t, uint8(0x42), c.BC.Hi())
+}
+
+func TestSimpleCoreCycles(t *testing.T) {
+	mem := NewMemory()
+	mem.WriteAt([]byte{0x20, 0x00, 0x28, 0x00, 0xC4, 0x00, 0x00, 0xCB, 0x46}, 0)
+	c := NewSimpleCore(mem)
+	c.SetFlags(0)
+
+	for _, want := range []int{12, 8, 24, 0} {
+		if want == 0 {
+			break
+		}
+		cycles, err := c.Step()
+		assert.NoError(t, err)
+		assert.Equal(t, want, cycles)
+	}
+	c.PC = 7
+	cycles, _ := c.Step()
+	assert.Equal(t, 12, cycles)
+
+	mem.Write(9, 0xD3)
+	_, err := c.Step()
+	assert.ErrorIs(t, err, asm.ErrIllegalOpcode)
+}
+
+func TestSimpleCoreIDU(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(`
+	ld hl, $FE10
+	ld sp, $FE20
+	inc hl
+	ld a, [hl+]
+	ld [hl-], a
+	push bc
+	pop bc
+	ld a, [hl]
+	halt
+`))
+	assert.NoError(t, err)
+	mem := NewMemory()
+	mem.WriteAt(code, 0)
+	c := NewSimpleCore(mem)
+
+	type call struct {
+		v      uint16
+		access Access
+	}
+	var got []call
+	c.IDU = func(v uint16, access Access) { got = append(got, call{v, access}) }
+	for !c.Halted {
+		_, err := c.Step()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []call{
+		{0xFE10, AccessWrite},
+		{0xFE11, AccessRead},
+		{0xFE12, AccessWrite},
+		{0xFE20, AccessWrite},
+		{0xFE1E, AccessRead},
+	}, got)
+}
+
+func TestSimpleCoreTick(t *testing.T) {
+	code, err := asm.AssembleSource("test.asm", strings.NewReader(`
+	ld a, [$C000]
+	push bc
+	inc [hl]
+	call .f
+	halt
+.f
+	scf
+	ret c
+`))
+	assert.NoError(t, err)
+	mem := NewMemory()
+	mem.WriteAt(code, 0)
+	c := NewSimpleCore(mem)
+	c.SP, c.HL = 0xD000, 0xC100
+
+	// each access records the cycles ticked by the step before it
+	ticked := 0
+	c.Tick = func(cycles int) { ticked += cycles }
+	var accesses [][]int
+	mem.AddHook(0x0000, 0xFFFF, AccessRead|AccessWrite|AccessExecute, func(uint16, uint8, Access) {
+		accesses[len(accesses)-1] = append(accesses[len(accesses)-1], ticked)
+	})
+	var left []int
+	for !c.Halted {
+		ticked = 0
+		accesses = append(accesses, nil)
+		cycles, err := c.Step()
+		assert.NoError(t, err)
+		left = append(left, cycles)
+	}
+	assert.Equal(t, [][]int{
+		{4, 8, 12, 16},     // LD A, [$C000]
+		{4, 12, 16},        // PUSH BC, after an internal M-cycle
+		{4, 8, 12},         // INC [HL]
+		{4, 8, 12, 20, 24}, // CALL
+		{4},                // SCF
+		{4, 12, 16},        // RET C, with an M-cycle to check the condition
+		{4},                // HALT
+	}, accesses)
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 4, 0}, left)
+}
@@ -0,0 +1,262 @@
+// Package sm83test runs the single step tests of the SM83, the CPU of the Gameboy: JSON test vectors, one file per
+// opcode such as "3e.json" or "cb 37.json", each giving the state of the CPU and of the memory it accesses before
+// and after executing an instruction, and the bus activity of each of its M-cycles.
+//
+// The tests model the overlap of the fetch of each opcode with the execution of the previous instruction: PC starts
+// past the opcode, which the CPU already fetched, and each test ends with the fetch of the next opcode. The runner
+// executes the instruction from its opcode with a SimpleCore in the cycle accurate mode, leaves its fetch out of the
+// bus activity, and then fetches the next opcode as the CPU would.
+//
+// The tests of this package run the vectors of testdata/sm83, or of the directory named by the -sm83 flag, and are
+// skipped if it does not exist:
+//
+//	go test ./cpu/sm83test -sm83 ~/sm83/v1
+package sm83test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// Test is a test vector.
+type Test struct {
+	Name    string  `json:"name"`
+	Initial State   `json:"initial"`
+	Final   State   `json:"final"`
+	Cycles  []Cycle `json:"cycles"`
+}
+
+// State is the state of the CPU and of the memory accessed by a test.
+type State struct {
+	PC uint16 `json:"pc"`
+	SP uint16 `json:"sp"`
+	A  uint8  `json:"a"`
+	B  uint8  `json:"b"`
+	C  uint8  `json:"c"`
+	D  uint8  `json:"d"`
+	E  uint8  `json:"e"`
+	F  uint8  `json:"f"`
+	H  uint8  `json:"h"`
+	L  uint8  `json:"l"`
+	// IME and IE, the interrupt master enable flag and the interrupt enable register, are not checked if nil.
+	IME *uint8 `json:"ime,omitempty"`
+	IE  *uint8 `json:"ie,omitempty"`
+	// RAM holds pairs of an address and its value.
+	RAM [][2]uint16 `json:"ram"`
+}
+
+// Cycle is the bus activity of an M-cycle.
+type Cycle struct {
+	// Addr and Value are the address and the data on the bus, or nil if they are not given.
+	Addr  *uint16
+	Value *uint8
+	// Pins are the read, write and memory request pins: "r-m" for a read, "-wm" for a write, and "---" for an
+	// internal M-cycle.
+	Pins string
+}
+
+// Pins of the bus.
+const (
+	pinsRead     = "r-m"
+	pinsWrite    = "-wm"
+	pinsInternal = "---"
+)
+
+// UnmarshalJSON implements json.Unmarshaler, decoding an array of the address, the value and the pins, or null for
+// an internal M-cycle.
+func (c *Cycle) UnmarshalJSON(b []byte) error {
+	var v []*json.RawMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = Cycle{Pins: pinsInternal}
+	if v == nil {
+		return nil
+	}
+	if len(v) != 3 {
+		return fmt.Errorf("sm83test: cycle %s is not an address, a value and pins", b)
+	}
+	for i, dst := range []interface{}{&c.Addr, &c.Value, &c.Pins} {
+		if v[i] == nil {
+			continue
+		}
+		if err := json.Unmarshal(*v[i], dst); err != nil {
+			return fmt.Errorf("sm83test: cycle %s: %w", b, err)
+		}
+	}
+	return nil
+}
+
+// String implements fmt.Stringer, such as "r-m $C000 = $3E" for a read.
+func (c Cycle) String() string {
+	s := c.Pins
+	if c.Addr != nil {
+		s += fmt.Sprintf(" $%04X", *c.Addr)
+	}
+	if c.Value != nil {
+		s += fmt.Sprintf(" = $%02X", *c.Value)
+	}
+	return s
+}
+
+// Load reads the test vectors of a file.
+func Load(path string) ([]Test, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tests []Test
+	if err := json.Unmarshal(b, &tests); err != nil {
+		return nil, fmt.Errorf("sm83test: %s: %w", path, err)
+	}
+	return tests, nil
+}
+
+// Run runs a test vector with a SimpleCore, and returns how its results differ from the final state and the bus
+// activity of the test, if they do.
+func Run(test Test) []string {
+	mem := cpu.NewMemory()
+	c := cpu.NewSimpleCore(mem)
+	initial := test.Initial
+	for _, ram := range initial.RAM {
+		mem.Poke(ram[0], uint8(ram[1]))
+	}
+	if initial.IE != nil {
+		mem.Poke(cpu.IEAddr, *initial.IE)
+	}
+	c.AF = cpu.Register(initial.A)<<8 | cpu.Register(initial.F&0xF0)
+	c.BC = cpu.Register(initial.B)<<8 | cpu.Register(initial.C)
+	c.DE = cpu.Register(initial.D)<<8 | cpu.Register(initial.E)
+	c.HL = cpu.Register(initial.H)<<8 | cpu.Register(initial.L)
+	c.SP, c.PC = cpu.Register(initial.SP), cpu.Register(initial.PC-1)
+	// the CPU checked for interrupts before the test, as it fetched the opcode, so IME is only set once the opcode is
+	// fetched again: an interrupt pending in IE and IF is not dispatched in place of the instruction
+	ime := initial.IME != nil && *initial.IME != 0
+	fetched := false
+
+	var bus []Cycle
+	c.Tick = func(int) { bus = append(bus, Cycle{Pins: pinsInternal}) }
+	mem.AddHook(0x0000, 0xFFFF, cpu.AccessRead|cpu.AccessWrite|cpu.AccessExecute, func(addr uint16, v uint8, access cpu.Access) {
+		if access == cpu.AccessExecute && !fetched {
+			c.IME, fetched = ime, true
+		}
+		cycle := &bus[len(bus)-1]
+		cycle.Addr, cycle.Value, cycle.Pins = &addr, &v, pinsRead
+		if access == cpu.AccessWrite {
+			cycle.Pins = pinsWrite
+		}
+	})
+
+	cycles, err := c.Step()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for ; cycles > 0; cycles -= 4 {
+		bus = append(bus, Cycle{Pins: pinsInternal})
+	}
+	c.Tick(4)
+	mem.Fetch(uint16(c.PC))
+	c.PC++
+	// the opcode was fetched before the test
+	bus = bus[1:]
+
+	var diffs []string
+	diff := func(name string, got, want interface{}) {
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("%s = %v, want %v", name, got, want))
+		}
+	}
+	final := test.Final
+	hex8 := func(v uint8) string { return fmt.Sprintf("$%02X", v) }
+	hex16 := func(v uint16) string { return fmt.Sprintf("$%04X", v) }
+	for _, r := range []struct {
+		name      string
+		got, want uint8
+	}{
+		{"A", c.AF.Hi(), final.A}, {"F", c.AF.Lo(), final.F},
+		{"B", c.BC.Hi(), final.B}, {"C", c.BC.Lo(), final.C},
+		{"D", c.DE.Hi(), final.D}, {"E", c.DE.Lo(), final.E},
+		{"H", c.HL.Hi(), final.H}, {"L", c.HL.Lo(), final.L},
+	} {
+		diff(r.name, hex8(r.got), hex8(r.want))
+	}
+	diff("SP", hex16(uint16(c.SP)), hex16(final.SP))
+	diff("PC", hex16(uint16(c.PC)), hex16(final.PC))
+	if final.IME != nil {
+		diff("IME", c.IME, *final.IME != 0)
+	}
+	if final.IE != nil {
+		diff("IE", hex8(mem.Peek(cpu.IEAddr)), hex8(*final.IE))
+	}
+	for _, ram := range final.RAM {
+		diff("["+hex16(ram[0])+"]", hex8(mem.Peek(ram[0])), hex8(uint8(ram[1])))
+	}
+
+	diff("M-cycles", len(bus), len(test.Cycles))
+	for i := 0; i < len(bus) && i < len(test.Cycles); i++ {
+		if !matches(bus[i], test.Cycles[i]) {
+			diffs = append(diffs, fmt.Sprintf("M-cycle %d = %v, want %v", i, bus[i], test.Cycles[i]))
+		}
+	}
+	return diffs
+}
+
+// matches reports whether the bus activity of an M-cycle matches that of a test. The address bus of internal
+// M-cycles is not emulated.
+func matches(got, want Cycle) bool {
+	if got.Pins != want.Pins {
+		return false
+	}
+	if want.Pins == pinsInternal {
+		return true
+	}
+	return (want.Addr == nil || *got.Addr == *want.Addr) && (want.Value == nil || *got.Value == *want.Value)
+}
+
+// maxReported is the number of failing tests reported for each opcode.
+const maxReported = 10
+
+// RunDir runs the test vectors of each JSON file in dir as a subtest of t named by the file, such as "cb 37", which
+// reports the tests of the opcode that fail by their names. t is skipped if dir does not exist.
+func RunDir(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := os.Stat(dir); err != nil {
+		t.Skipf("no test vectors: %v", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			t.Parallel()
+			tests, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := 0
+			for _, test := range tests {
+				diffs := Run(test)
+				if len(diffs) == 0 {
+					continue
+				}
+				if failed++; failed <= maxReported {
+					t.Errorf("%s: %s", test.Name, strings.Join(diffs, ", "))
+				}
+			}
+			if failed > maxReported {
+				t.Errorf("%d of %d tests failed", failed, len(tests))
+			}
+		})
+	}
+}
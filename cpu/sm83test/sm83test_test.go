@@ -0,0 +1,96 @@
+package sm83test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var vectorDir = flag.String("sm83", "testdata/sm83", "run the test vectors under `dir`")
+
+func TestSM83(t *testing.T) {
+	RunDir(t, *vectorDir)
+}
+
+// vectors are test vectors of a few opcodes, in the format of the suite.
+var vectors = map[string]string{
+	// LD [BC], A
+	"02": `[{
+		"name": "02 0000",
+		"initial": {"pc": 49153, "sp": 65534, "a": 66, "b": 208, "c": 0, "d": 0, "e": 0, "f": 176, "h": 0, "l": 0,
+			"ime": 0, "ie": 0, "ram": [[49152, 2], [49153, 0], [53248, 0]]},
+		"final": {"pc": 49154, "sp": 65534, "a": 66, "b": 208, "c": 0, "d": 0, "e": 0, "f": 176, "h": 0, "l": 0,
+			"ime": 0, "ie": 0, "ram": [[49152, 2], [49153, 0], [53248, 66]]},
+		"cycles": [[53248, 66, "-wm"], [49153, 0, "r-m"]]
+	}]`,
+	// INC BC
+	"03": `[{
+		"name": "03 0000",
+		"initial": {"pc": 257, "sp": 65534, "a": 1, "b": 0, "c": 255, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0,
+			"ime": 1, "ie": 0, "ram": [[256, 3], [257, 0]]},
+		"final": {"pc": 258, "sp": 65534, "a": 1, "b": 1, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0,
+			"ime": 1, "ie": 0, "ram": [[256, 3], [257, 0]]},
+		"cycles": [[0, null, "---"], [257, 0, "r-m"]]
+	}]`,
+	// INC B, with an interrupt pending, which the CPU checked for before the test
+	"04": `[{
+		"name": "04 0000",
+		"initial": {"pc": 257, "sp": 65534, "a": 1, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0,
+			"ime": 1, "ie": 1, "ram": [[256, 4], [257, 0], [65295, 1]]},
+		"final": {"pc": 258, "sp": 65534, "a": 1, "b": 1, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0,
+			"ime": 1, "ie": 1, "ram": [[256, 4], [257, 0], [65295, 1]]},
+		"cycles": [[257, 0, "r-m"]]
+	}]`,
+	// SWAP A
+	"cb 37": `[{
+		"name": "CB 37 0000",
+		"initial": {"pc": 257, "sp": 65534, "a": 18, "b": 0, "c": 0, "d": 0, "e": 0, "f": 240, "h": 0, "l": 0,
+			"ram": [[256, 203], [257, 55], [258, 0]]},
+		"final": {"pc": 259, "sp": 65534, "a": 33, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0,
+			"ram": [[256, 203], [257, 55], [258, 0]]},
+		"cycles": [[257, 55, "r-m"], [258, 0, "r-m"]]
+	}]`,
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	for name, v := range vectors {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".json"), []byte(v), 0o666))
+	}
+
+	tests, err := Load(filepath.Join(dir, "03.json"))
+	require.NoError(t, err)
+	require.Len(t, tests, 1)
+	assert.Equal(t, "03 0000", tests[0].Name)
+	assert.Equal(t, "--- $0000", tests[0].Cycles[0].String())
+	assert.Equal(t, "r-m $0101 = $00", tests[0].Cycles[1].String())
+
+	// every opcode passes
+	RunDir(t, dir)
+
+	// failures describe the differences
+	var failing []Test
+	require.NoError(t, json.Unmarshal([]byte(vectors["02"]), &failing))
+	test := failing[0]
+	test.Final.B = 0xD1
+	test.Cycles = append(test.Cycles[:1], Cycle{Pins: pinsInternal}, test.Cycles[1])
+	assert.Equal(t, []string{
+		"B = $D0, want $D1",
+		"M-cycles = 2, want 3",
+		"M-cycle 1 = r-m $C001 = $00, want ---",
+	}, Run(test))
+
+	// null M-cycles are internal
+	var c Cycle
+	require.NoError(t, json.Unmarshal([]byte("null"), &c))
+	assert.Equal(t, Cycle{Pins: pinsInternal}, c)
+	assert.Error(t, json.Unmarshal([]byte("[1, 2]"), &c))
+
+	_, err = Load(filepath.Join(dir, "ff.json"))
+	assert.Error(t, err)
+}
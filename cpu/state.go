@@ -0,0 +1,48 @@
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of the CPU and memory in save states.
+const StateVersion = 1
+
+// SaveState encodes the registers and the interrupt and halt state of the CPU.
+func (c *SimpleCore) SaveState(e *state.Encoder) {
+	for _, r := range []Register{c.AF, c.BC, c.DE, c.HL, c.SP, c.PC} {
+		e.Uint16(uint16(r))
+	}
+	for _, b := range []bool{c.IME, c.Halted, c.Stopped, c.eiPending, c.haltBug} {
+		e.Bool(b)
+	}
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (c *SimpleCore) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("cpu: unsupported state version %d", version)
+	}
+	for _, r := range []*Register{&c.AF, &c.BC, &c.DE, &c.HL, &c.SP, &c.PC} {
+		*r = Register(d.Uint16())
+	}
+	for _, b := range []*bool{&c.IME, &c.Halted, &c.Stopped, &c.eiPending, &c.haltBug} {
+		*b = d.Bool()
+	}
+	return d.Err()
+}
+
+// SaveState encodes the memory that no device is mapped to. Devices save their own state.
+func (m *Memory) SaveState(e *state.Encoder) {
+	e.Slice(m.buffer[:])
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (m *Memory) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("cpu: unsupported memory state version %d", version)
+	}
+	d.Slice(m.buffer[:])
+	return d.Err()
+}
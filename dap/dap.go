@@ -0,0 +1,638 @@
+// Package dap serves the Debug Adapter Protocol, so that editors can debug ROMs at the level of their assembly source.
+//
+// A launch request either assembles and links the .asm files named by its sources, recording the address of every
+// source line, or loads a prebuilt ROM named by its program. Breakpoints are set on source lines, and the registers
+// and memory regions are shown as variables.
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/sym"
+	"github.com/gopherpocket/gopherpocket/debugger"
+	"github.com/gopherpocket/gopherpocket/machine"
+)
+
+// threadID is the ID of the only thread, the CPU.
+const threadID = 1
+
+// References of the variables of each scope. Memory regions are numbered from regionsReference + 1.
+const (
+	registersReference = 1
+	regionsReference   = 100
+)
+
+// bytesPerRow is the number of bytes shown by each variable of a memory region.
+const bytesPerRow = 16
+
+// region is a region of the address space shown as variables.
+type region struct {
+	name       string
+	start, end int
+}
+
+var regions = []region{
+	{"ROM0", 0x0000, 0x3FFF},
+	{"ROMX", 0x4000, 0x7FFF},
+	{"VRAM", 0x8000, 0x9FFF},
+	{"SRAM", 0xA000, 0xBFFF},
+	{"WRAM", 0xC000, 0xDFFF},
+	{"OAM", 0xFE00, 0xFE9F},
+	{"IO", 0xFF00, 0xFF7F},
+	{"HRAM", 0xFF80, 0xFFFE},
+	{"IE", 0xFFFF, 0xFFFF},
+}
+
+// registers are the registers and flags shown as variables, by their debugger names.
+var registers = []string{"AF", "BC", "DE", "HL", "SP", "PC", "A", "F", "B", "C", "D", "E", "H", "L", "ZF", "NF", "HF",
+	"CF", "IME", "ROMBANK"}
+
+// launchArguments are the arguments of the launch request.
+type launchArguments struct {
+	// Sources are assembled and linked into the ROM to debug.
+	Sources []string `json:"sources"`
+	// Title and CartridgeType are written to the header of a ROM linked from sources. The type defaults to MBC5.
+	Title         string `json:"title"`
+	CartridgeType *int   `json:"cartridgeType"`
+
+	// Program is a prebuilt ROM to debug without source when there are no sources, with the symbols of Symbols.
+	Program string `json:"program"`
+	Symbols string `json:"symbols"`
+
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+// errRunning is the error of requests that need the target to be stopped.
+var errRunning = errors.New("the target is running")
+
+// session is a debugging session with a single editor.
+type session struct {
+	c *conn
+	d *debugger.Debugger
+	// lines are the positions of the instructions, with absolute paths.
+	lines       []link.Line
+	breakpoints map[string][]int
+	stopOnEntry bool
+
+	mu      sync.Mutex
+	running bool
+	stopped chan struct{}
+	// run and reason are the function the target runs and its stop reason, to run again when the target is
+	// interrupted to change its breakpoints, which carries on to the stop it was started for.
+	run    func() (debugger.Stop, error)
+	reason string
+	// quiet is set while the target is interrupted to change its breakpoints, and interrupted reports whether it
+	// stopped for that rather than for a breakpoint of its own, which is then not reported to the editor.
+	quiet, interrupted bool
+}
+
+// Serve serves a single session, reading requests from r and writing responses and events to w, until the editor
+// disconnects.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &session{c: newConn(r, w), breakpoints: make(map[string][]int)}
+	defer s.stop()
+	for {
+		m, err := s.c.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Type != "request" {
+			continue
+		}
+		done, err := s.handle(m)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// ListenAndServe listens on the TCP address addr, and serves one session at a time until the listener fails.
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = Serve(c, c)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request, reporting whether the session is over.
+func (s *session) handle(m *message) (bool, error) {
+	var body any
+	var err error
+	switch m.Command {
+	case "initialize":
+		body = map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}
+
+	case "launch":
+		if err = s.launch(m.Arguments); err == nil {
+			if err := s.c.respond(m, nil, nil); err != nil {
+				return true, err
+			}
+			return false, s.c.event("initialized", nil)
+		}
+
+	case "disconnect", "terminate":
+		s.stop()
+		if err := s.c.respond(m, nil, nil); err != nil {
+			return true, err
+		}
+		return true, s.c.event("terminated", nil)
+
+	case "configurationDone":
+		if s.d == nil {
+			return false, s.c.respond(m, nil, errors.New("no program was launched"))
+		}
+		if err := s.c.respond(m, nil, nil); err != nil {
+			return true, err
+		}
+		if s.stopOnEntry {
+			return false, s.stoppedEvent("entry", debugger.Stop{}, nil)
+		}
+		return false, s.start(s.d.Continue, "")
+
+	case "threads":
+		body = map[string]any{"threads": []map[string]any{{"id": threadID, "name": "SM83"}}}
+
+	case "pause":
+		if s.d != nil {
+			s.d.Interrupt()
+		}
+
+	case "continue", "next", "stepIn", "stepOut":
+		return false, s.resume(m)
+
+	case "setBreakpoints":
+		return false, s.setBreakpointsRunning(m)
+
+	default:
+		body, err = s.inspect(m)
+	}
+	return false, s.c.respond(m, body, err)
+}
+
+// inspect handles the requests that inspect or modify a stopped target.
+func (s *session) inspect(m *message) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d == nil {
+		return nil, errors.New("no program was launched")
+	}
+	if s.running {
+		return nil, errRunning
+	}
+
+	switch m.Command {
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(m.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]any{"breakpoints": s.setBreakpoints(args)}, nil
+
+	case "stackTrace":
+		return map[string]any{"stackFrames": []stackFrame{s.frame()}, "totalFrames": 1}, nil
+
+	case "scopes":
+		return map[string]any{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "Memory", VariablesReference: regionsReference, Expensive: true},
+		}}, nil
+
+	case "variables":
+		var args variablesArguments
+		if err := json.Unmarshal(m.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]any{"variables": s.variables(args)}, nil
+
+	case "setVariable":
+		var args setVariableArguments
+		if err := json.Unmarshal(m.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.VariablesReference != registersReference {
+			return nil, errors.New("only registers can be set")
+		}
+		v, err := s.d.Eval(args.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.d.SetRegister(args.Name, v); err != nil {
+			return nil, err
+		}
+		return map[string]string{"value": s.register(args.Name)}, nil
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := json.Unmarshal(m.Arguments, &args); err != nil {
+			return nil, err
+		}
+		v, err := s.d.Eval(args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"result": fmt.Sprintf("%d ($%X)", v, v), "variablesReference": 0}, nil
+
+	case "readMemory":
+		var args readMemoryArguments
+		if err := json.Unmarshal(m.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.readMemory(args)
+
+	default:
+		return nil, fmt.Errorf("unsupported request %q", m.Command)
+	}
+}
+
+// launch builds or loads the ROM to debug.
+func (s *session) launch(raw json.RawMessage) error {
+	var args launchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	s.stopOnEntry = args.StopOnEntry
+
+	var rom []byte
+	var symbols *sym.Table
+	switch {
+	case len(args.Sources) > 0:
+		img, err := build(args)
+		if err != nil {
+			return err
+		}
+		rom, symbols, s.lines = img.ROM, img.SymbolTable(), img.Lines
+
+	case args.Program != "":
+		var err error
+		if rom, err = os.ReadFile(args.Program); err != nil {
+			return err
+		}
+		if args.Symbols != "" {
+			f, err := os.Open(args.Symbols)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if symbols, err = sym.Read(f); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.New("launch needs sources or a program")
+	}
+
+	m, err := machine.NewWithOptions(rom, machine.Options{Model: machine.Auto})
+	if err != nil {
+		return err
+	}
+	s.d = debugger.New(m)
+	s.d.Symbols = symbols
+	return nil
+}
+
+// build assembles and links the sources of a launch request. The positions of the lines are made absolute, as
+// editors name sources by their absolute paths.
+func build(args launchArguments) (*link.Image, error) {
+	var objs []*asm.Object
+	for _, src := range args.Sources {
+		path, err := filepath.Abs(src)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		// INCLUDE directives are relative to the directory of the source
+		dir := filepath.Dir(path)
+		assm := asm.NewAssembler()
+		assm.FS = os.DirFS(dir)
+		obj, err := assm.AssembleObject(filepath.Base(path), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, sec := range obj.Sections {
+			for i, line := range sec.Lines {
+				sec.Lines[i].Pos.File = filepath.Join(dir, filepath.FromSlash(line.Pos.File))
+			}
+		}
+		objs = append(objs, obj)
+	}
+
+	opts := link.Options{Fix: true, Title: args.Title, Type: cartridge.MBC5}
+	if args.CartridgeType != nil {
+		opts.Type = cartridge.Type(*args.CartridgeType)
+	}
+	return link.Link(opts, objs...)
+}
+
+// setBreakpoints replaces the breakpoints of a source. Each breakpoint is placed at the first instruction at or after
+// its line.
+func (s *session) setBreakpoints(args setBreakpointsArguments) []breakpoint {
+	path := filepath.Clean(args.Source.Path)
+	for _, id := range s.breakpoints[path] {
+		_ = s.d.RemoveBreakpoint(id)
+	}
+	s.breakpoints[path] = nil
+
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, want := range args.Breakpoints {
+		line, ok := s.lineAtOrAfter(path, want.Line)
+		if !ok {
+			result = append(result, breakpoint{Verified: false, Message: "no instruction at or after this line"})
+			continue
+		}
+
+		var cond *asm.Expr
+		if want.Condition != "" {
+			var err error
+			if cond, err = debugger.ParseCondition(want.Condition); err != nil {
+				result = append(result, breakpoint{Verified: false, Message: err.Error()})
+				continue
+			}
+		}
+		bank := debugger.AnyBank
+		if line.Addr >= 0x4000 && line.Addr < 0x8000 {
+			bank = line.Bank
+		}
+		bp := s.d.AddBreakpoint(bank, uint16(line.Addr), cond)
+		s.breakpoints[path] = append(s.breakpoints[path], bp.ID)
+		result = append(result, breakpoint{ID: bp.ID, Verified: true, Source: &args.Source, Line: line.Pos.Line})
+	}
+	return result
+}
+
+// lineAtOrAfter returns the first instruction of a source at or after a line.
+func (s *session) lineAtOrAfter(path string, n int) (link.Line, bool) {
+	var best link.Line
+	found := false
+	for _, line := range s.lines {
+		if line.Pos.File != path || line.Pos.Line < n {
+			continue
+		}
+		if !found || line.Pos.Line < best.Pos.Line {
+			best, found = line, true
+		}
+	}
+	return best, found
+}
+
+// lineAt returns the source line of the instruction at PC.
+func (s *session) lineAt(pc uint16) (link.Line, bool) {
+	bank := 0
+	if pc >= 0x4000 && pc < 0x8000 {
+		bank = s.d.Machine.ROMBank()
+	}
+	for _, line := range s.lines {
+		if line.Bank == bank && line.Addr == int(pc) {
+			return line, true
+		}
+	}
+	return link.Line{}, false
+}
+
+// frame returns the stack frame of the current instruction.
+func (s *session) frame() stackFrame {
+	pc := uint16(s.d.Machine.CPU.PC)
+	bank := 0
+	if pc >= 0x4000 && pc < 0x8000 {
+		bank = s.d.Machine.ROMBank()
+	}
+	f := stackFrame{
+		ID:                          1,
+		Name:                        s.d.Format(pc),
+		InstructionPointerReference: fmt.Sprintf("%02X:%04X", bank, pc),
+	}
+	if line, ok := s.lineAt(pc); ok {
+		f.Source = &source{Name: filepath.Base(line.Pos.File), Path: line.Pos.File}
+		f.Line, f.Column = line.Pos.Line, 1
+	}
+	return f
+}
+
+// register formats the value of a register or flag.
+func (s *session) register(name string) string {
+	v, _ := s.d.Eval(name)
+	switch name {
+	case "AF", "BC", "DE", "HL", "SP", "PC":
+		return fmt.Sprintf("$%04X", v)
+	case "A", "F", "B", "C", "D", "E", "H", "L":
+		return fmt.Sprintf("$%02X", v)
+	default:
+		return strconv.Itoa(v)
+	}
+}
+
+// variables returns the variables of a reference: the registers, the memory regions, or the rows of a region.
+func (s *session) variables(args variablesArguments) []variable {
+	vars := []variable{}
+	switch ref := args.VariablesReference; {
+	case ref == registersReference:
+		for _, name := range registers {
+			vars = append(vars, variable{Name: name, Value: s.register(name)})
+		}
+
+	case ref == regionsReference:
+		for i, r := range regions {
+			vars = append(vars, variable{
+				Name:               r.name,
+				Value:              fmt.Sprintf("$%04X-$%04X", r.start, r.end),
+				VariablesReference: regionsReference + 1 + i,
+				IndexedVariables:   (r.end - r.start + bytesPerRow) / bytesPerRow,
+				MemoryReference:    fmt.Sprintf("0x%04X", r.start),
+			})
+		}
+
+	case ref > regionsReference && ref <= regionsReference+len(regions):
+		r := regions[ref-regionsReference-1]
+		rows := (r.end - r.start + bytesPerRow) / bytesPerRow
+		end := rows
+		if args.Count > 0 && args.Start+args.Count < rows {
+			end = args.Start + args.Count
+		}
+		for row := args.Start; row < end; row++ {
+			addr := r.start + row*bytesPerRow
+			var hex []string
+			for a := addr; a < addr+bytesPerRow && a <= r.end; a++ {
+				hex = append(hex, fmt.Sprintf("%02X", s.d.Machine.Memory.Peek(uint16(a))))
+			}
+			vars = append(vars, variable{Name: fmt.Sprintf("$%04X", addr), Value: strings.Join(hex, " ")})
+		}
+	}
+	return vars
+}
+
+// readMemory reads the memory of a readMemory request, addressed as 0xC000 or $C000.
+func (s *session) readMemory(args readMemoryArguments) (any, error) {
+	ref := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(args.MemoryReference), "0x"), "$")
+	base, err := strconv.ParseUint(ref, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad memory reference %q", args.MemoryReference)
+	}
+	start := int(base) + args.Offset
+	if start < 0 || start > 0xFFFF || args.Count < 0 {
+		return nil, errors.New("address out of range")
+	}
+	n := args.Count
+	if start+n > 0x10000 {
+		n = 0x10000 - start
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = s.d.Machine.Memory.Peek(uint16(start + i))
+	}
+	return map[string]any{
+		"address": fmt.Sprintf("0x%04X", start),
+		"data":    base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// resume handles the requests that run the target, responding before the target stops.
+func (s *session) resume(m *message) error {
+	if s.d == nil {
+		return s.c.respond(m, nil, errors.New("no program was launched"))
+	}
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		return s.c.respond(m, nil, errRunning)
+	}
+
+	// the functions of next and stepOut keep their stop from where they start, in case they are resumed
+	var body any
+	fn, reason := s.d.Continue, ""
+	switch m.Command {
+	case "continue":
+		body = map[string]bool{"allThreadsContinued": true}
+	case "next":
+		fn, reason = s.d.NextFunc(), "step"
+	case "stepIn":
+		fn, reason = s.d.Step, "step"
+	case "stepOut":
+		fn, reason = s.d.StepOutFunc(), "step"
+	}
+	if err := s.c.respond(m, body, nil); err != nil {
+		return err
+	}
+	return s.start(fn, reason)
+}
+
+// start runs fn in the background, and sends a stopped event when it stops. reason is the reason reported when fn
+// stops without hitting a breakpoint, or "" if fn only stops on breakpoints.
+func (s *session) start(fn func() (debugger.Stop, error), reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+	s.stopped = make(chan struct{})
+	s.run, s.reason = fn, reason
+
+	go func() {
+		stop, err := fn()
+		s.mu.Lock()
+		s.running = false
+		s.interrupted = s.quiet && stop.Interrupted && err == nil
+		quiet := s.interrupted
+		close(s.stopped)
+		s.mu.Unlock()
+		if !quiet {
+			_ = s.stoppedEvent(reason, stop, err)
+		}
+	}()
+	return nil
+}
+
+// setBreakpointsRunning handles setBreakpoints, which editors send whenever breakpoints are toggled, even while the
+// target runs: the target is then interrupted to change its breakpoints, and resumed.
+func (s *session) setBreakpointsRunning(m *message) error {
+	s.mu.Lock()
+	running, stopped, fn, reason := s.running, s.stopped, s.run, s.reason
+	s.quiet = running
+	s.mu.Unlock()
+	if running {
+		s.d.Interrupt()
+		<-stopped
+	}
+
+	body, err := s.inspect(m)
+	s.mu.Lock()
+	resume := running && s.interrupted
+	s.quiet, s.interrupted = false, false
+	s.mu.Unlock()
+	if err := s.c.respond(m, body, err); err != nil {
+		return err
+	}
+	if resume {
+		return s.start(fn, reason)
+	}
+	return nil
+}
+
+// stop stops the target if it is running, and waits for it to stop.
+func (s *session) stop() {
+	s.mu.Lock()
+	running, stopped := s.running, s.stopped
+	s.mu.Unlock()
+	if running {
+		s.d.Interrupt()
+		<-stopped
+	}
+}
+
+// stoppedEvent reports why the target stopped.
+func (s *session) stoppedEvent(reason string, stop debugger.Stop, err error) error {
+	body := map[string]any{"threadId": threadID, "allThreadsStopped": true}
+	switch {
+	case stop.Breakpoint != nil:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+	case stop.Watchpoint != nil:
+		body["reason"] = "data breakpoint"
+		body["description"] = stop.Watchpoint.String()
+	case err != nil:
+		body["reason"] = "exception"
+		body["text"] = err.Error()
+	case stop.Interrupted:
+		body["reason"] = "pause"
+	default:
+		body["reason"] = reason
+	}
+	return s.c.event("stopped", body)
+}
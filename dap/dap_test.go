@@ -0,0 +1,281 @@
+package dap
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = `SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, BANK(Far)
+	ld [$2000], a
+	ld b, 0
+.loop
+	inc b
+	call Far
+	jr .loop
+
+SECTION "Far", ROMX[$4000], BANK[2]
+Far:
+	ld a, b
+	ld [wCount], a
+	ret
+
+SECTION "Variables", WRAM0[$C000]
+wCount:
+	ds 1
+`
+
+// client is the editor side of a session.
+type client struct {
+	t      *testing.T
+	c      *conn
+	events []*message
+}
+
+// request sends a request and returns its response, queueing the events received before it.
+func (c *client) request(command string, args any) *message {
+	c.t.Helper()
+	raw, err := json.Marshal(args)
+	require.NoError(c.t, err)
+	req := &message{Type: "request", Command: command, Arguments: raw}
+	require.NoError(c.t, c.c.write(req))
+	for {
+		m, err := c.c.read()
+		require.NoError(c.t, err)
+		if m.Type == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		require.Equal(c.t, req.Seq, m.RequestSeq)
+		return m
+	}
+}
+
+// ok sends a request that must succeed, and decodes the body of its response.
+func (c *client) ok(command string, args any) map[string]any {
+	c.t.Helper()
+	m := c.request(command, args)
+	require.True(c.t, *m.Success, m.Message)
+	body, _ := json.Marshal(m.Body)
+	var v map[string]any
+	_ = json.Unmarshal(body, &v)
+	return v
+}
+
+// event waits for an event and decodes its body.
+func (c *client) event(name string) map[string]any {
+	c.t.Helper()
+	var m *message
+	if len(c.events) > 0 {
+		m, c.events = c.events[0], c.events[1:]
+	} else {
+		var err error
+		m, err = c.c.read()
+		require.NoError(c.t, err)
+	}
+	require.Equal(c.t, "event", m.Type)
+	require.Equal(c.t, name, m.Event)
+	body, _ := json.Marshal(m.Body)
+	var v map[string]any
+	_ = json.Unmarshal(body, &v)
+	return v
+}
+
+// line returns the source line of the current frame.
+func (c *client) line() float64 {
+	c.t.Helper()
+	frames := c.ok("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]any)
+	require.Len(c.t, frames, 1)
+	return frames[0].(map[string]any)["line"].(float64)
+}
+
+func TestServe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.asm")
+	require.NoError(t, os.WriteFile(path, []byte(testSource), 0o644))
+
+	requests, serverIn := io.Pipe()
+	serverOut, responses := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(requests, responses)
+		responses.Close()
+	}()
+	c := &client{t: t, c: newConn(serverOut, serverIn)}
+
+	caps := c.ok("initialize", map[string]string{"adapterID": "gopherpocket"})
+	assert.Equal(t, true, caps["supportsConfigurationDoneRequest"])
+	assert.False(t, *c.request("stackTrace", nil).Success)
+
+	c.ok("launch", map[string]any{"sources": []string{path}, "title": "DAP"})
+	c.event("initialized")
+
+	// breakpoints move to the next instruction, and only lines with instructions after them are verified
+	bps := c.ok("setBreakpoints", map[string]any{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]any{{"line": 10}, {"line": 16, "condition": "B == 2"}, {"line": 30}},
+	})["breakpoints"].([]any)
+	require.Len(t, bps, 3)
+	assert.Equal(t, 11.0, bps[0].(map[string]any)["line"])
+	assert.Equal(t, 17.0, bps[1].(map[string]any)["line"])
+	assert.Equal(t, false, bps[2].(map[string]any)["verified"])
+
+	c.ok("configurationDone", nil)
+	stopped := c.event("stopped")
+	assert.Equal(t, "breakpoint", stopped["reason"])
+	assert.Equal(t, 11.0, c.line())
+	frame := c.ok("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]any)[0].(map[string]any)
+	assert.Equal(t, "Main.loop", frame["name"])
+	assert.Equal(t, path, frame["source"].(map[string]any)["path"])
+
+	// replacing the breakpoints of the source removes the first one
+	c.ok("setBreakpoints", map[string]any{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]any{{"line": 16, "condition": "B == 2"}},
+	})
+	c.ok("continue", map[string]int{"threadId": threadID})
+	assert.Equal(t, "breakpoint", c.event("stopped")["reason"])
+	assert.Equal(t, 17.0, c.line())
+	assert.Equal(t, "2 ($2)", c.ok("evaluate", map[string]string{"expression": "B"})["result"])
+
+	c.ok("next", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.event("stopped")["reason"])
+	assert.Equal(t, 18.0, c.line())
+	c.ok("stepIn", map[string]int{"threadId": threadID})
+	c.event("stopped")
+	assert.Equal(t, 19.0, c.line())
+	c.ok("stepOut", map[string]int{"threadId": threadID})
+	c.event("stopped")
+	assert.Equal(t, 13.0, c.line())
+
+	scopes := c.ok("scopes", map[string]int{"frameId": 1})["scopes"].([]any)
+	require.Len(t, scopes, 2)
+	vars := c.ok("variables", map[string]int{"variablesReference": registersReference})["variables"].([]any)
+	assert.Contains(t, vars, map[string]any{"name": "B", "value": "$02", "variablesReference": 0.0})
+	assert.Contains(t, vars, map[string]any{"name": "ROMBANK", "value": "2", "variablesReference": 0.0})
+	set := c.ok("setVariable", map[string]any{"variablesReference": registersReference, "name": "B", "value": "$10"})
+	assert.Equal(t, "$10", set["value"])
+	assert.False(t, *c.request("setVariable", map[string]any{"variablesReference": 1, "name": "X", "value": "1"}).Success)
+
+	vars = c.ok("variables", map[string]int{"variablesReference": regionsReference})["variables"].([]any)
+	wram := vars[4].(map[string]any)
+	assert.Equal(t, "WRAM", wram["name"])
+	assert.Equal(t, 512.0, wram["indexedVariables"])
+	rows := c.ok("variables", map[string]any{
+		"variablesReference": wram["variablesReference"], "start": 1, "count": 2,
+	})["variables"].([]any)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "$C010", rows[0].(map[string]any)["name"])
+	rows = c.ok("variables", map[string]any{"variablesReference": wram["variablesReference"], "count": 1})["variables"].([]any)
+	assert.Equal(t, "02 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00", rows[0].(map[string]any)["value"])
+
+	mem := c.ok("readMemory", map[string]any{"memoryReference": "0xC000", "count": 2})
+	assert.Equal(t, "0xC000", mem["address"])
+	assert.Equal(t, "AgA=", mem["data"])
+
+	// breakpoints set while running are hit
+	c.ok("setBreakpoints", map[string]any{"source": map[string]string{"path": path}, "breakpoints": []any{}})
+	c.ok("continue", map[string]int{"threadId": threadID})
+	bps = c.ok("setBreakpoints", map[string]any{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]any{{"line": 16}},
+	})["breakpoints"].([]any)
+	assert.Equal(t, true, bps[0].(map[string]any)["verified"])
+	assert.Equal(t, "breakpoint", c.event("stopped")["reason"])
+	assert.Equal(t, 17.0, c.line())
+
+	// running until paused
+	c.ok("setBreakpoints", map[string]any{"source": map[string]string{"path": path}, "breakpoints": []any{}})
+	c.ok("continue", map[string]int{"threadId": threadID})
+	c.ok("pause", map[string]int{"threadId": threadID})
+	assert.Equal(t, "pause", c.event("stopped")["reason"])
+
+	c.ok("disconnect", nil)
+	c.event("terminated")
+	require.NoError(t, <-served)
+}
+
+// waitSource calls a function that takes a while to return.
+const waitSource = `SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	call Wait
+	ld a, 1
+.spin
+	jr .spin
+
+Wait:
+	ld bc, 0
+.loop
+	dec bc
+	ld a, b
+	or c
+	jr nz, .loop
+	ret
+`
+
+func TestBreakpointsWhileStepping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wait.asm")
+	require.NoError(t, os.WriteFile(path, []byte(waitSource), 0o644))
+	requests, serverIn := io.Pipe()
+	serverOut, responses := io.Pipe()
+	go func() {
+		_ = Serve(requests, responses)
+		responses.Close()
+	}()
+	c := &client{t: t, c: newConn(serverOut, serverIn)}
+	c.ok("initialize", map[string]string{"adapterID": "gopherpocket"})
+	c.ok("launch", map[string]any{"sources": []string{path}, "stopOnEntry": true})
+	c.event("initialized")
+	c.ok("configurationDone", nil)
+	assert.Equal(t, "entry", c.event("stopped")["reason"])
+	for i := 0; i < 2; i++ {
+		c.ok("stepIn", map[string]int{"threadId": threadID})
+		c.event("stopped")
+	}
+	assert.Equal(t, 7.0, c.line())
+
+	// stepping over the call still stops after it when breakpoints are set within it
+	c.ok("next", map[string]int{"threadId": threadID})
+	// Wait takes tens of milliseconds to return
+	time.Sleep(10 * time.Millisecond)
+	c.ok("setBreakpoints", map[string]any{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]any{{"line": 10}},
+	})
+	assert.Equal(t, "step", c.event("stopped")["reason"])
+	assert.Equal(t, 8.0, c.line())
+
+	c.ok("disconnect", nil)
+	c.event("terminated")
+}
+
+func TestLaunchErrors(t *testing.T) {
+	requests, serverIn := io.Pipe()
+	serverOut, responses := io.Pipe()
+	go func() {
+		_ = Serve(requests, responses)
+		responses.Close()
+	}()
+	c := &client{t: t, c: newConn(serverOut, serverIn)}
+
+	assert.False(t, *c.request("launch", map[string]any{}).Success)
+	assert.False(t, *c.request("launch", map[string]any{"sources": []string{"missing.asm"}}).Success)
+	assert.False(t, *c.request("configurationDone", nil).Success)
+	c.ok("disconnect", nil)
+	c.event("terminated")
+}
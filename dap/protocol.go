@@ -0,0 +1,170 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// message is the envelope of every message of the protocol: requests from the editor, and responses and events from
+// the server.
+type message struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+
+	// requests
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// responses
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	Message    string `json:"message,omitempty"`
+
+	// events
+	Event string `json:"event,omitempty"`
+
+	Body any `json:"body,omitempty"`
+}
+
+// conn reads and writes messages framed by a Content-Length header.
+type conn struct {
+	r *bufio.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read reads the next message.
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("dap: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("dap: %v", err)
+	}
+	return &m, nil
+}
+
+// write numbers and writes a message. It may be called from several goroutines.
+func (c *conn) write(m *message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	m.Seq = c.seq
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+// respond replies to a request, with an error message if err is not nil.
+func (c *conn) respond(req *message, body any, err error) error {
+	success := err == nil
+	m := &message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Body: body}
+	if err != nil {
+		m.Message = err.Error()
+		m.Body = map[string]any{"error": map[string]any{"id": 1, "format": err.Error()}}
+	}
+	return c.write(m)
+}
+
+// event sends an event.
+func (c *conn) event(event string, body any) error {
+	return c.write(&message{Type: "event", Event: event, Body: body})
+}
+
+// The bodies and arguments of the messages the server handles, named as in the protocol specification.
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition,omitempty"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	ID       int     `json:"id,omitempty"`
+	Verified bool    `json:"verified"`
+	Message  string  `json:"message,omitempty"`
+	Source   *source `json:"source,omitempty"`
+	Line     int     `json:"line,omitempty"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+	// InstructionPointerReference is the address of the frame, as bank:addr.
+	InstructionPointerReference string `json:"instructionPointerReference,omitempty"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+	Start              int `json:"start"`
+	Count              int `json:"count"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	IndexedVariables   int    `json:"indexedVariables,omitempty"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type setVariableArguments struct {
+	VariablesReference int    `json:"variablesReference"`
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+}
+
+type readMemoryArguments struct {
+	MemoryReference string `json:"memoryReference"`
+	Offset          int    `json:"offset"`
+	Count           int    `json:"count"`
+}
@@ -0,0 +1,563 @@
+// Package debugger implements run control for a [machine.Machine]: stepping, breakpoints and expressions over the
+// registers, memory and symbols of the machine. An interactive command line is built on top of it.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/sym"
+	"github.com/gopherpocket/gopherpocket/machine"
+)
+
+// AnyBank is the bank of a breakpoint that applies whichever ROM bank is mapped.
+const AnyBank = -1
+
+// Breakpoint stops execution when the CPU reaches an address.
+type Breakpoint struct {
+	ID int
+	// Bank is the ROM bank a breakpoint within $4000-$7FFF applies to, or AnyBank.
+	Bank int
+	Addr uint16
+	// Cond is evaluated when the breakpoint is reached, and execution only stops if it is non-zero. It may be nil.
+	Cond *asm.Expr
+	// Hits counts how many times the breakpoint stopped execution.
+	Hits int
+}
+
+// String implements fmt.Stringer
+func (b *Breakpoint) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "#%d at ", b.ID)
+	if b.Bank == AnyBank {
+		fmt.Fprintf(&s, "$%04X", b.Addr)
+	} else {
+		fmt.Fprintf(&s, "%02X:%04X", b.Bank, b.Addr)
+	}
+	if b.Cond != nil {
+		fmt.Fprintf(&s, " if %s", b.Cond)
+	}
+	return s.String()
+}
+
+// Stop describes why execution stopped.
+type Stop struct {
+	// Breakpoint is the breakpoint that was hit, if any.
+	Breakpoint *Breakpoint
+	// Watchpoint is the watchpoint that was hit, if any, by an Access to Addr with Value.
+	Watchpoint *Watchpoint
+	Access     cpu.Access
+	Addr       uint16
+	Value      uint8
+	// Interrupted is set when execution was stopped by [Debugger.Interrupt].
+	Interrupted bool
+}
+
+// Debugger controls the execution of a machine.
+type Debugger struct {
+	Machine *machine.Machine
+	// Symbols name the addresses of the ROM. It may be nil.
+	Symbols *sym.Table
+
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+	interrupted atomic.Bool
+
+	// watched is the watchpoint hit by the instruction being executed.
+	watched  Stop
+	watchErr error
+}
+
+// New constructs a new Debugger of m.
+func New(m *machine.Machine) *Debugger {
+	return &Debugger{Machine: m, nextID: 1}
+}
+
+// Interrupt stops a running [Debugger.Continue], [Debugger.Next] or [Debugger.RunTo], or the next one to run if none
+// is running. It may be called from another goroutine.
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// Breakpoints returns the breakpoints, ordered by ID.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// AddBreakpoint adds a breakpoint at bank:addr, stopping when cond is non-zero. cond may be nil.
+func (d *Debugger) AddBreakpoint(bank int, addr uint16, cond *asm.Expr) *Breakpoint {
+	bp := &Breakpoint{ID: d.nextID, Bank: bank, Addr: addr, Cond: cond}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID.
+func (d *Debugger) RemoveBreakpoint(id int) error {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no breakpoint #%d", id)
+}
+
+// inBank reports whether bank:addr refers to pc, given the ROM bank that is mapped.
+func (d *Debugger) inBank(bank int, addr, pc uint16) bool {
+	if addr != pc {
+		return false
+	}
+	return bank == AnyBank || pc < 0x4000 || pc >= 0x8000 || bank == d.Machine.ROMBank()
+}
+
+// hit returns the breakpoint at PC whose condition holds, if any.
+func (d *Debugger) hit() (*Breakpoint, error) {
+	pc := uint16(d.Machine.CPU.PC)
+	for _, bp := range d.breakpoints {
+		if !d.inBank(bp.Bank, bp.Addr, pc) {
+			continue
+		}
+		if bp.Cond != nil {
+			v, err := bp.Cond.Eval(d.env())
+			if err != nil {
+				return bp, fmt.Errorf("breakpoint #%d: %v", bp.ID, err)
+			}
+			if v == 0 {
+				continue
+			}
+		}
+		bp.Hits++
+		return bp, nil
+	}
+	return nil, nil
+}
+
+// Step executes a single instruction, reporting a watchpoint it hit.
+func (d *Debugger) Step() (Stop, error) {
+	if _, err := d.Machine.Step(); err != nil {
+		d.takeWatched()
+		return Stop{}, err
+	}
+	if stop, err := d.takeWatched(); stop.Watchpoint != nil || err != nil {
+		return stop, err
+	}
+	return d.executed()
+}
+
+// Continue executes until a breakpoint or watchpoint is hit, or execution is interrupted.
+func (d *Debugger) Continue() (Stop, error) {
+	return d.run(func() bool { return false })
+}
+
+// RunTo executes until PC reaches bank:addr, a breakpoint or watchpoint is hit, or execution is interrupted.
+func (d *Debugger) RunTo(bank int, addr uint16) (Stop, error) {
+	return d.run(func() bool {
+		return d.inBank(bank, addr, uint16(d.Machine.CPU.PC))
+	})
+}
+
+// Next executes a single instruction, stepping over calls: a CALL or RST executes until it returns.
+func (d *Debugger) Next() (Stop, error) {
+	return d.NextFunc()()
+}
+
+// NextFunc returns a function executing as Next from the current instruction. Called again after it was
+// interrupted, it carries on to where Next stops, rather than stepping over the instruction it was interrupted at.
+func (d *Debugger) NextFunc() func() (Stop, error) {
+	c := d.Machine.CPU
+	instr, err := d.decode(uint16(c.PC))
+	if err != nil || (instr.Mnemonic != "CALL" && instr.Mnemonic != "RST") {
+		return d.Step
+	}
+
+	ret, sp := c.PC+cpu.Register(instr.Bytes), c.SP
+	return func() (Stop, error) {
+		return d.run(func() bool {
+			// the stack must have unwound too, in case of recursion
+			return c.PC == ret && c.SP >= sp
+		})
+	}
+}
+
+// StepOut executes until the current function returns: until a RET or RETI unwinds the stack above its current
+// depth.
+func (d *Debugger) StepOut() (Stop, error) {
+	return d.StepOutFunc()()
+}
+
+// StepOutFunc returns a function executing as StepOut from the current function. Called again after it was
+// interrupted, it carries on until that function returns, rather than the one it was interrupted in.
+func (d *Debugger) StepOutFunc() func() (Stop, error) {
+	c := d.Machine.CPU
+	sp := c.SP
+	returning := d.returning()
+	return func() (Stop, error) {
+		return d.run(func() bool {
+			if returning && c.SP > sp {
+				return true
+			}
+			returning = d.returning()
+			return false
+		})
+	}
+}
+
+// returning reports whether the instruction at PC returns from a function.
+func (d *Debugger) returning() bool {
+	instr, err := d.decode(uint16(d.Machine.CPU.PC))
+	return err == nil && (instr.Mnemonic == "RET" || instr.Mnemonic == "RETI")
+}
+
+// run executes instructions until done returns true, a breakpoint or watchpoint is hit, or execution is interrupted.
+func (d *Debugger) run(done func() bool) (Stop, error) {
+	for {
+		if d.interrupted.Swap(false) {
+			return Stop{Interrupted: true}, nil
+		}
+		if stop, err := d.Step(); stop.Watchpoint != nil || err != nil {
+			return stop, err
+		}
+		if bp, err := d.hit(); bp != nil || err != nil {
+			return Stop{Breakpoint: bp}, err
+		}
+		if done() {
+			return Stop{}, nil
+		}
+	}
+}
+
+// decode decodes the instruction at addr.
+func (d *Debugger) decode(addr uint16) (*asm.Instruction, error) {
+	var buf [3]byte
+	for i := range buf {
+		buf[i] = d.Machine.Memory.Peek(addr + uint16(i))
+	}
+	return asm.Decode(buf[:])
+}
+
+// funcs are the functions expressions may call: PEEK(addr) reads a byte, and PEEK16(addr) a little endian word.
+var funcs = []string{"PEEK", "PEEK16"}
+
+// env returns the values expressions are evaluated with: the registers, the flags as ZF, NF, HF and CF, the interrupt
+// master enable as IME, the mapped ROM bank as ROMBANK, and the symbols.
+func (d *Debugger) env() asm.Env {
+	c := d.Machine.CPU
+	mem := d.Machine.Memory
+	return asm.Env{
+		Symbol: func(name string) (int, bool) {
+			if v, ok := d.register(name); ok {
+				return v, true
+			}
+			switch strings.ToUpper(name) {
+			case "IME":
+				return boolInt(c.IME), true
+			case "ROMBANK":
+				return d.Machine.ROMBank(), true
+			}
+			if d.Symbols == nil {
+				return 0, false
+			}
+			if strings.HasPrefix(name, "BANK(") {
+				s, ok := d.Symbols.Lookup(strings.TrimSuffix(strings.TrimPrefix(name, "BANK("), ")"))
+				return s.Bank, ok
+			}
+			s, ok := d.Symbols.Lookup(name)
+			return s.Addr, ok
+		},
+		Funcs: map[string]func(args ...int) (int, error){
+			"PEEK": func(args ...int) (int, error) {
+				if len(args) != 1 {
+					return 0, errors.New("PEEK takes 1 argument")
+				}
+				return int(mem.Peek(uint16(args[0]))), nil
+			},
+			"PEEK16": func(args ...int) (int, error) {
+				if len(args) != 1 {
+					return 0, errors.New("PEEK16 takes 1 argument")
+				}
+				addr := uint16(args[0])
+				return int(mem.Peek(addr)) | int(mem.Peek(addr+1))<<8, nil
+			},
+		},
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// register returns the value of a register or flag, by its case insensitive name.
+func (d *Debugger) register(name string) (int, bool) {
+	c := d.Machine.CPU
+	f := c.Flags()
+	switch strings.ToUpper(name) {
+	case "A":
+		return int(c.AF.Hi()), true
+	case "F":
+		return int(c.AF.Lo()), true
+	case "B":
+		return int(c.BC.Hi()), true
+	case "C":
+		return int(c.BC.Lo()), true
+	case "D":
+		return int(c.DE.Hi()), true
+	case "E":
+		return int(c.DE.Lo()), true
+	case "H":
+		return int(c.HL.Hi()), true
+	case "L":
+		return int(c.HL.Lo()), true
+	case "AF":
+		return int(c.AF), true
+	case "BC":
+		return int(c.BC), true
+	case "DE":
+		return int(c.DE), true
+	case "HL":
+		return int(c.HL), true
+	case "SP":
+		return int(c.SP), true
+	case "PC":
+		return int(c.PC), true
+	case "ZF":
+		return boolInt(f.Z()), true
+	case "NF":
+		return boolInt(f.N()), true
+	case "HF":
+		return boolInt(f.H()), true
+	case "CF":
+		return boolInt(f.C()), true
+	default:
+		return 0, false
+	}
+}
+
+// SetRegister sets a register or flag by its case insensitive name, as named in expressions.
+func (d *Debugger) SetRegister(name string, v int) error {
+	c := d.Machine.CPU
+	flag := func(bit cpu.Flags) {
+		if v != 0 {
+			c.SetFlags(c.Flags() | bit)
+		} else {
+			c.SetFlags(c.Flags() &^ bit)
+		}
+	}
+	switch strings.ToUpper(name) {
+	case "A":
+		c.AF.SetHi(uint8(v))
+	case "F":
+		c.SetFlags(cpu.Flags(v))
+	case "B":
+		c.BC.SetHi(uint8(v))
+	case "C":
+		c.BC.SetLo(uint8(v))
+	case "D":
+		c.DE.SetHi(uint8(v))
+	case "E":
+		c.DE.SetLo(uint8(v))
+	case "H":
+		c.HL.SetHi(uint8(v))
+	case "L":
+		c.HL.SetLo(uint8(v))
+	case "AF":
+		c.AF = cpu.Register(v) &^ 0x000F
+	case "BC":
+		c.BC = cpu.Register(v)
+	case "DE":
+		c.DE = cpu.Register(v)
+	case "HL":
+		c.HL = cpu.Register(v)
+	case "SP":
+		c.SP = cpu.Register(v)
+	case "PC":
+		c.PC = cpu.Register(v)
+	case "ZF":
+		flag(cpu.FlagZ)
+	case "NF":
+		flag(cpu.FlagN)
+	case "HF":
+		flag(cpu.FlagH)
+	case "CF":
+		flag(cpu.FlagC)
+	case "IME":
+		c.IME = v != 0
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
+// Eval evaluates an expression in RGBDS syntax over the registers, memory and symbols, such as
+// PEEK(HL) + 1 or Main.loop. See [Debugger.env] for the names available.
+func (d *Debugger) Eval(s string) (int, error) {
+	x, err := asm.ParseExpr(s, funcs...)
+	if err != nil {
+		return 0, err
+	}
+	return x.Eval(d.env())
+}
+
+// ParseCondition parses the condition of a breakpoint.
+func ParseCondition(s string) (*asm.Expr, error) {
+	return asm.ParseExpr(s, funcs...)
+}
+
+var bankAddr = regexp.MustCompile(`^([0-9A-Fa-f]{1,3}):([0-9A-Fa-f]{1,4})$`)
+
+// Location parses a location: bank:addr in hexadecimal as in symbol files, or an expression such as Main.loop + 3.
+// The bank of a symbol is used when the expression starts with a symbol within $4000-$7FFF, and AnyBank otherwise.
+func (d *Debugger) Location(s string) (bank int, addr uint16, err error) {
+	s = strings.TrimSpace(s)
+	if m := bankAddr.FindStringSubmatch(s); m != nil {
+		b, _ := strconv.ParseUint(m[1], 16, 16)
+		a, _ := strconv.ParseUint(m[2], 16, 16)
+		return int(b), uint16(a), nil
+	}
+
+	v, err := d.Eval(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if v < 0 || v > 0xFFFF {
+		return 0, 0, fmt.Errorf("address $%X out of range", v)
+	}
+	bank = AnyBank
+	if d.Symbols != nil && v >= 0x4000 && v < 0x8000 {
+		name := s
+		if i := strings.IndexAny(s, " +-"); i > 0 {
+			name = s[:i]
+		}
+		if sym, ok := d.Symbols.Lookup(name); ok {
+			bank = sym.Bank
+		}
+	}
+	return bank, uint16(v), nil
+}
+
+// Format returns an address relative to its nearest symbol, in the currently mapped bank.
+func (d *Debugger) Format(addr uint16) string {
+	return d.Symbols.Format(d.bankOf(addr), int(addr))
+}
+
+// bankOf returns the bank mapped at addr.
+func (d *Debugger) bankOf(addr uint16) int {
+	if addr >= 0x4000 && addr < 0x8000 {
+		return d.Machine.ROMBank()
+	}
+	return 0
+}
+
+// Line is a disassembled instruction.
+type Line struct {
+	Bank  int
+	Addr  uint16
+	Bytes []byte
+	Instr *asm.Instruction
+}
+
+// Disassemble decodes n instructions from addr. Bytes that are not a valid instruction are returned as single
+// bytes without an instruction.
+func (d *Debugger) Disassemble(addr uint16, n int) []Line {
+	lines := make([]Line, 0, n)
+	for len(lines) < n {
+		line := Line{Bank: d.bankOf(addr), Addr: addr}
+		size := 1
+		if instr, err := d.decode(addr); err == nil {
+			line.Instr, size = instr, instr.Bytes
+		}
+		for i := 0; i < size; i++ {
+			line.Bytes = append(line.Bytes, d.Machine.Memory.Peek(addr+uint16(i)))
+		}
+		lines = append(lines, line)
+		addr += uint16(size)
+	}
+	return lines
+}
+
+// DisassembleAround decodes up to before instructions preceding addr, and after instructions from addr.
+// As instructions have different sizes, the preceding instructions are found by decoding from the furthest address
+// whose instructions end exactly at addr.
+func (d *Debugger) DisassembleAround(addr uint16, before, after int) []Line {
+	var prev []Line
+	for back := 3 * before; back > 0 && len(prev) == 0; back-- {
+		if int(addr)-back < 0 {
+			continue
+		}
+		start := addr - uint16(back)
+		var lines []Line
+		for a := start; a < addr; {
+			line := d.Disassemble(a, 1)[0]
+			if line.Instr == nil {
+				break
+			}
+			lines = append(lines, line)
+			a += uint16(len(line.Bytes))
+			if a == addr {
+				prev = lines
+			}
+		}
+	}
+	if len(prev) > before {
+		prev = prev[len(prev)-before:]
+	}
+	return append(prev, d.Disassemble(addr, after)...)
+}
+
+// FormatLine formats a line of disassembly, naming its address and any branch target with the symbols.
+func (d *Debugger) FormatLine(line Line) string {
+	var hex []string
+	for _, b := range line.Bytes {
+		hex = append(hex, fmt.Sprintf("%02X", b))
+	}
+	text := fmt.Sprintf("db $%02X", line.Bytes[0])
+	if line.Instr != nil {
+		text = line.Instr.String()
+		if name, ok := d.reference(line); ok {
+			text += " ; " + name
+		}
+	}
+
+	label := ""
+	if d.Symbols != nil {
+		if name := d.Symbols.Format(line.Bank, int(line.Addr)); !strings.HasPrefix(name, "$") {
+			label = " <" + name + ">"
+		}
+	}
+	return fmt.Sprintf("%02X:%04X%s  %-9s %s", line.Bank, line.Addr, label, strings.Join(hex, " "), text)
+}
+
+// reference names the address an instruction branches to, relative to its nearest symbol, or the address it
+// accesses, if a symbol is at that address.
+func (d *Debugger) reference(line Line) (string, bool) {
+	if d.Symbols == nil {
+		return "", false
+	}
+	branch := func(target uint16) (string, bool) {
+		name := d.Format(target)
+		return name, !strings.HasPrefix(name, "$")
+	}
+	for _, op := range line.Instr.Operands {
+		switch op := op.(type) {
+		case asm.Imm16:
+			if line.Instr.Mnemonic == "JP" || line.Instr.Mnemonic == "CALL" {
+				return branch(uint16(op))
+			}
+		case asm.Rel8:
+			return branch(line.Addr + uint16(len(line.Bytes)) + uint16(int8(op)))
+		case asm.Pointer[asm.Imm16]:
+			return d.Symbols.At(d.bankOf(uint16(op.Ref)), int(op.Ref))
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,254 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, BANK(Far)
+	ld [$2000], a
+	ld b, 0
+.loop
+	inc b
+	call Far
+	jr .loop
+
+SECTION "Far", ROMX[$4000], BANK[2]
+Far:
+	ld a, b
+	ld [wCount], a
+	ret
+
+SECTION "Other", ROMX[$4000], BANK[3]
+Other:
+	ret
+
+SECTION "Variables", WRAM0[$C000]
+wCount:
+	ds 1
+`
+
+func newDebugger(t *testing.T) *Debugger {
+	t.Helper()
+	img := testrig.ROM(t, testSource, link.Options{Title: "DEBUG", Type: cartridge.MBC1})
+	m, err := machine.New(img.ROM)
+	require.NoError(t, err)
+
+	d := New(m)
+	d.Symbols = img.SymbolTable()
+	return d
+}
+
+func TestBreakpoints(t *testing.T) {
+	d := newDebugger(t)
+
+	bank, addr, err := d.Location("Far")
+	require.NoError(t, err)
+	assert.Equal(t, 2, bank)
+	assert.Equal(t, uint16(0x4000), addr)
+
+	// a breakpoint in another bank at the same address is never hit
+	d.AddBreakpoint(3, 0x4000, nil)
+	cond, err := ParseCondition("B == 3 && PEEK(wCount) == 2")
+	require.NoError(t, err)
+	bp := d.AddBreakpoint(bank, addr, cond)
+
+	stop, err := d.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, bp, stop.Breakpoint)
+	assert.Equal(t, 1, bp.Hits)
+	assert.Equal(t, "Far", d.Format(uint16(d.Machine.CPU.PC)))
+	assert.Equal(t, uint8(3), d.Machine.CPU.BC.Hi())
+
+	// step out of Far, and over the next call
+	_, addr, err = d.Location("Main.loop")
+	require.NoError(t, err)
+	assert.NoError(t, d.RemoveBreakpoint(bp.ID))
+	stop, err = d.RunTo(AnyBank, addr)
+	require.NoError(t, err)
+	assert.Nil(t, stop.Breakpoint)
+	_, err = d.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, "Main.loop+1", d.Format(uint16(d.Machine.CPU.PC)))
+	_, err = d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "Main.loop+4", d.Format(uint16(d.Machine.CPU.PC)))
+	assert.Equal(t, uint8(4), d.Machine.Memory.Peek(0xC000))
+
+	// step into Far, and back out
+	for i := 0; i < 3; i++ {
+		_, err = d.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "Far", d.Format(uint16(d.Machine.CPU.PC)))
+	_, err = d.StepOut()
+	require.NoError(t, err)
+	assert.Equal(t, "Main.loop+4", d.Format(uint16(d.Machine.CPU.PC)))
+
+	assert.EqualError(t, d.RemoveBreakpoint(bp.ID), "no breakpoint #2")
+}
+
+func TestWatchpoints(t *testing.T) {
+	d := newDebugger(t)
+
+	cond, err := ParseCondition("VALUE == 3")
+	require.NoError(t, err)
+	w := d.AddWatchpoint(0xC000, 0xC000, cpu.AccessWrite, cond)
+	stop, err := d.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, Stop{Watchpoint: w, Access: cpu.AccessWrite, Addr: 0xC000, Value: 3}, stop)
+	// execution stops after the write
+	assert.Equal(t, "Far+4", d.Format(uint16(d.Machine.CPU.PC)))
+	assert.Equal(t, uint8(3), d.Machine.Memory.Peek(0xC000))
+
+	// stepping reports watchpoints too
+	x := d.AddWatchpoint(0xFF80, 0xFFFE, cpu.AccessRead, nil)
+	stop, err = d.Step()
+	require.NoError(t, err)
+	assert.Equal(t, Stop{Watchpoint: x, Access: cpu.AccessRead, Addr: 0xFFFC, Value: 0x5B}, stop)
+	assert.Equal(t, "Main.loop+4", d.Format(uint16(d.Machine.CPU.PC)))
+	assert.NoError(t, d.RemoveWatchpoint(x.ID))
+
+	// execute watchpoints stop before the instruction executes
+	x = d.AddWatchpoint(0x4000, 0x4000, cpu.AccessExecute, nil)
+	stop, err = d.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, Stop{Watchpoint: x, Access: cpu.AccessExecute, Addr: 0x4000, Value: 0x78}, stop)
+	assert.Equal(t, uint8(3), d.Machine.CPU.AF.Hi())
+	assert.Equal(t, 1, w.Hits)
+	assert.Equal(t, 1, x.Hits)
+
+	assert.NoError(t, d.RemoveWatchpoint(w.ID))
+	assert.EqualError(t, d.RemoveWatchpoint(w.ID), "no watchpoint #1")
+}
+
+func TestInterrupt(t *testing.T) {
+	d := newDebugger(t)
+	d.Interrupt()
+	stop, err := d.Continue()
+	assert.NoError(t, err)
+	assert.True(t, stop.Interrupted)
+
+	// stepping over a call, and out of a function, carry on to the same stop after being interrupted in a call
+	_, loop, err := d.Location("Main.loop")
+	require.NoError(t, err)
+	_, err = d.RunTo(AnyBank, loop+1)
+	require.NoError(t, err)
+	interrupt := true
+	d.Machine.Memory.AddHook(0x4000, 0x4000, cpu.AccessExecute, func(uint16, uint8, cpu.Access) {
+		if interrupt {
+			interrupt = false
+			d.Interrupt()
+		}
+	})
+	next := d.NextFunc()
+	stop, err = next()
+	require.NoError(t, err)
+	assert.True(t, stop.Interrupted)
+	assert.Equal(t, "Far+1", d.Format(uint16(d.Machine.CPU.PC)))
+	stop, err = next()
+	require.NoError(t, err)
+	assert.Equal(t, Stop{}, stop)
+	assert.Equal(t, "Main.loop+4", d.Format(uint16(d.Machine.CPU.PC)))
+
+	// Main never returns, so stepping out of it runs until a breakpoint
+	cond, err := ParseCondition("B == 10")
+	require.NoError(t, err)
+	bp := d.AddBreakpoint(AnyBank, loop, cond)
+	interrupt = true
+	out := d.StepOutFunc()
+	stop, err = out()
+	require.NoError(t, err)
+	assert.True(t, stop.Interrupted)
+	assert.Equal(t, "Far+1", d.Format(uint16(d.Machine.CPU.PC)))
+	stop, err = out()
+	require.NoError(t, err)
+	assert.Equal(t, bp, stop.Breakpoint)
+}
+
+func TestRegisters(t *testing.T) {
+	d := newDebugger(t)
+	assert.NoError(t, d.SetRegister("a", 0x12))
+	assert.NoError(t, d.SetRegister("CF", 0))
+	assert.NoError(t, d.SetRegister("NF", 1))
+	assert.NoError(t, d.SetRegister("de", 0xBEEF))
+	assert.EqualError(t, d.SetRegister("IX", 0), `unknown register "IX"`)
+
+	c := d.Machine.CPU
+	assert.Equal(t, uint16(0x12E0), uint16(c.AF))
+	assert.Equal(t, uint16(0xBEEF), uint16(c.DE))
+
+	v, err := d.Eval("A + ZF + NF * 2 + CF")
+	assert.NoError(t, err)
+	assert.Equal(t, 0x15, v)
+}
+
+func TestREPL(t *testing.T) {
+	d := newDebugger(t)
+	script := `break Far if B % 2 == 0
+continue
+
+c
+bl
+regs
+set hl $C000
+poke hl 1 2 3
+x HL 4
+next
+disasm Main 3
+print BANK(Far) + ROMBANK
+delete 1
+watch w wCount if VALUE == 8
+c
+watch rx $4000..$4001
+bl
+delete 2
+delete 2
+frobnicate
+quit
+`
+	var out bytes.Buffer
+	require.NoError(t, d.REPL(strings.NewReader(script), &out))
+	assert.Equal(t, `=> 00:0100  00        NOP
+(gpdb) breakpoint #1 at 02:4000 if ((B % 2) == 0)
+(gpdb) breakpoint #1 at 02:4000 if ((B % 2) == 0)
+=> 02:4000 <Far>  78        LD A, B
+(gpdb) breakpoint #1 at 02:4000 if ((B % 2) == 0)
+=> 02:4000 <Far>  78        LD A, B
+(gpdb) breakpoint #1 at 02:4000 if ((B % 2) == 0)
+=> 02:4000 <Far>  78        LD A, B
+(gpdb) breakpoint #1 at 02:4000 if ((B % 2) == 0), hit 3 times
+(gpdb) AF=0510 BC=0613 DE=00D8 HL=014D SP=FFFC PC=4000
+F=---C IME=0 ROMBANK=2
+(gpdb) (gpdb) (gpdb) C000  01 02 03 00                                      ....
+(gpdb) => 02:4001 <Far+1>  EA 00 C0  LD [$C000], A ; wCount
+(gpdb)    00:0150 <Main>  3E 02     LD A, $2
+   00:0152 <Main+2>  EA 00 20  LD [$2000], A
+   00:0155 <Main+5>  06 00     LD B, $0
+(gpdb) 4 $4
+(gpdb) (gpdb) watchpoint #2 w $C000 if (VALUE == 8)
+(gpdb) watchpoint #2 w $C000 if (VALUE == 8): write $C000 = $08
+=> 02:4004 <Far+4>  C9        RET
+(gpdb) watchpoint #3 rx $4000..$4001
+(gpdb) watchpoint #2 w $C000 if (VALUE == 8), hit 1 times
+watchpoint #3 rx $4000..$4001, hit 0 times
+(gpdb) (gpdb) error: no breakpoint or watchpoint #2
+(gpdb) error: unknown command "frobnicate", try help
+(gpdb) `, out.String())
+}
@@ -0,0 +1,389 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// Prompt is shown by the REPL when it waits for a command.
+const Prompt = "(gpdb) "
+
+// errQuit is returned by the quit command to end the REPL.
+var errQuit = errors.New("quit")
+
+// command is a command of the REPL.
+type command struct {
+	names []string
+	usage string
+	help  string
+	run   func(d *Debugger, out io.Writer, args string) error
+}
+
+// commands are the commands of the REPL, in the order they are listed by help.
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"step", "s"}, "step [N]", "execute N instructions, 1 by default", (*Debugger).cmdStep},
+		{[]string{"next", "n"}, "next", "execute an instruction, stepping over calls", (*Debugger).cmdNext},
+		{[]string{"continue", "c"}, "continue", "run until a breakpoint is hit", (*Debugger).cmdContinue},
+		{[]string{"until", "u"}, "until LOC", "run until PC reaches LOC", (*Debugger).cmdUntil},
+		{[]string{"break", "b"}, "break LOC [if COND]", "stop at LOC when COND is non-zero", (*Debugger).cmdBreak},
+		{[]string{"watch", "w"}, "watch r|w|x ADDR[..END] [if COND]", "stop on accesses to memory", (*Debugger).cmdWatch},
+		{[]string{"delete", "d"}, "delete ID", "delete a breakpoint or watchpoint", (*Debugger).cmdDelete},
+		{[]string{"breakpoints", "bl"}, "breakpoints", "list the breakpoints and watchpoints", (*Debugger).cmdBreakpoints},
+		{[]string{"regs", "r"}, "regs", "show the registers and flags", (*Debugger).cmdRegs},
+		{[]string{"set"}, "set REG VALUE", "set a register, or a flag ZF, NF, HF or CF", (*Debugger).cmdSet},
+		{[]string{"x"}, "x ADDR [N]", "dump N bytes of memory, 64 by default", (*Debugger).cmdDump},
+		{[]string{"poke"}, "poke ADDR VALUE...", "write bytes to memory", (*Debugger).cmdPoke},
+		{[]string{"disasm", "dis"}, "disasm [LOC] [N]", "disassemble N instructions around PC, or from LOC", (*Debugger).cmdDisasm},
+		{[]string{"print", "p"}, "print EXPR", "evaluate an expression", (*Debugger).cmdPrint},
+		{[]string{"help", "h", "?"}, "help", "list the commands", (*Debugger).cmdHelp},
+		{[]string{"quit", "q"}, "quit", "exit the debugger", (*Debugger).cmdQuit},
+	}
+}
+
+// REPL reads commands from in until it ends or the quit command, writing their output and prompts to out.
+// An empty line repeats the previous command. Locations are written as bank:addr in hexadecimal, or as expressions in
+// RGBDS syntax over the registers and symbols, such as Main.loop or HL + 2.
+func (d *Debugger) REPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	d.showPC(out)
+	last := ""
+	for {
+		fmt.Fprint(out, Prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		if line == "" {
+			continue
+		}
+		last = line
+
+		err := d.Exec(out, line)
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+// Exec executes a single command line, writing its output to out.
+func (d *Debugger) Exec(out io.Writer, line string) error {
+	name, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	args = strings.TrimSpace(args)
+	for _, cmd := range commands {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd.run(d, out, args)
+			}
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", name)
+}
+
+// showPC shows the instruction at PC.
+func (d *Debugger) showPC(out io.Writer) {
+	line := d.Disassemble(uint16(d.Machine.CPU.PC), 1)[0]
+	fmt.Fprintf(out, "=> %s\n", d.FormatLine(line))
+}
+
+// stopped reports why execution stopped, and where.
+func (d *Debugger) stopped(out io.Writer, stop Stop, err error) error {
+	switch {
+	case err != nil && stop.Breakpoint == nil && stop.Watchpoint == nil:
+		return err
+	case stop.Breakpoint != nil:
+		fmt.Fprintf(out, "breakpoint %s\n", stop.Breakpoint)
+	case stop.Watchpoint != nil:
+		fmt.Fprintf(out, "watchpoint %s: %s $%04X = $%02X\n",
+			stop.Watchpoint, accessNames[stop.Access], stop.Addr, stop.Value)
+	case stop.Interrupted:
+		fmt.Fprintln(out, "interrupted")
+	}
+	d.showPC(out)
+	return err
+}
+
+func (d *Debugger) cmdStep(out io.Writer, args string) error {
+	n := 1
+	if args != "" {
+		v, err := d.Eval(args)
+		if err != nil {
+			return err
+		}
+		n = v
+	}
+	for i := 0; i < n; i++ {
+		if stop, err := d.Step(); stop.Watchpoint != nil || err != nil {
+			return d.stopped(out, stop, err)
+		}
+	}
+	d.showPC(out)
+	return nil
+}
+
+func (d *Debugger) cmdNext(out io.Writer, _ string) error {
+	stop, err := d.Next()
+	return d.stopped(out, stop, err)
+}
+
+func (d *Debugger) cmdContinue(out io.Writer, _ string) error {
+	stop, err := d.Continue()
+	return d.stopped(out, stop, err)
+}
+
+func (d *Debugger) cmdUntil(out io.Writer, args string) error {
+	bank, addr, err := d.Location(args)
+	if err != nil {
+		return err
+	}
+	stop, err := d.RunTo(bank, addr)
+	return d.stopped(out, stop, err)
+}
+
+func (d *Debugger) cmdBreak(out io.Writer, args string) error {
+	loc, cond, hasCond := strings.Cut(args, " if ")
+	if loc == "" {
+		loc = "PC"
+	}
+	bank, addr, err := d.Location(loc)
+	if err != nil {
+		return err
+	}
+	bp := d.AddBreakpoint(bank, addr, nil)
+	if hasCond {
+		if bp.Cond, err = ParseCondition(cond); err != nil {
+			_ = d.RemoveBreakpoint(bp.ID)
+			return err
+		}
+	}
+	fmt.Fprintf(out, "breakpoint %s\n", bp)
+	return nil
+}
+
+func (d *Debugger) cmdDelete(_ io.Writer, args string) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(args, "#"))
+	if err != nil {
+		return fmt.Errorf("bad breakpoint %q", args)
+	}
+	if d.RemoveBreakpoint(id) != nil && d.RemoveWatchpoint(id) != nil {
+		return fmt.Errorf("no breakpoint or watchpoint #%d", id)
+	}
+	return nil
+}
+
+// accessNames name the kinds of access a watchpoint stops on.
+var accessNames = map[cpu.Access]string{
+	cpu.AccessRead:    "read",
+	cpu.AccessWrite:   "write",
+	cpu.AccessExecute: "execute",
+}
+
+func (d *Debugger) cmdWatch(out io.Writer, args string) error {
+	kinds, args, _ := strings.Cut(args, " ")
+	var access cpu.Access
+	for _, c := range kinds {
+		switch c {
+		case 'r':
+			access |= cpu.AccessRead
+		case 'w':
+			access |= cpu.AccessWrite
+		case 'x':
+			access |= cpu.AccessExecute
+		default:
+			return fmt.Errorf("bad access %q, expected a combination of r, w and x", kinds)
+		}
+	}
+
+	loc, cond, hasCond := strings.Cut(args, " if ")
+	if strings.TrimSpace(loc) == "" {
+		return errors.New("usage: watch r|w|x ADDR[..END] [if COND]")
+	}
+	from, to, isRange := strings.Cut(loc, "..")
+	start, err := d.Eval(from)
+	if err != nil {
+		return err
+	}
+	end := start
+	if isRange {
+		if end, err = d.Eval(to); err != nil {
+			return err
+		}
+	}
+	if start < 0 || end > 0xFFFF || end < start {
+		return fmt.Errorf("bad range $%X..$%X", start, end)
+	}
+
+	var x *asm.Expr
+	if hasCond {
+		if x, err = ParseCondition(cond); err != nil {
+			return err
+		}
+	}
+	w := d.AddWatchpoint(uint16(start), uint16(end), access, x)
+	fmt.Fprintf(out, "watchpoint %s\n", w)
+	return nil
+}
+
+func (d *Debugger) cmdBreakpoints(out io.Writer, _ string) error {
+	if len(d.breakpoints) == 0 && len(d.watchpoints) == 0 {
+		fmt.Fprintln(out, "no breakpoints")
+	}
+	for _, bp := range d.breakpoints {
+		fmt.Fprintf(out, "breakpoint %s, hit %d times\n", bp, bp.Hits)
+	}
+	for _, w := range d.watchpoints {
+		fmt.Fprintf(out, "watchpoint %s, hit %d times\n", w, w.Hits)
+	}
+	return nil
+}
+
+func (d *Debugger) cmdRegs(out io.Writer, _ string) error {
+	c := d.Machine.CPU
+	fmt.Fprintf(out, "AF=%04X BC=%04X DE=%04X HL=%04X SP=%04X PC=%04X\n",
+		uint16(c.AF), uint16(c.BC), uint16(c.DE), uint16(c.HL), uint16(c.SP), uint16(c.PC))
+	fmt.Fprintf(out, "F=%s IME=%d ROMBANK=%d\n", c.Flags(), boolInt(c.IME), d.Machine.ROMBank())
+	return nil
+}
+
+func (d *Debugger) cmdSet(_ io.Writer, args string) error {
+	reg, value, ok := strings.Cut(args, " ")
+	if !ok {
+		return errors.New("usage: set REG VALUE")
+	}
+	v, err := d.Eval(value)
+	if err != nil {
+		return err
+	}
+	return d.SetRegister(reg, v)
+}
+
+// splitArgs splits the last argument from an argument list, if it is a plain number. Expressions may contain spaces,
+// so the count of a command such as x is only recognized after the last space.
+func splitArgs(args string) (string, string) {
+	i := strings.LastIndexByte(args, ' ')
+	if i < 0 {
+		return args, ""
+	}
+	if _, err := strconv.Atoi(args[i+1:]); err != nil {
+		return args, ""
+	}
+	return strings.TrimSpace(args[:i]), args[i+1:]
+}
+
+func (d *Debugger) cmdDump(out io.Writer, args string) error {
+	loc, count := splitArgs(args)
+	if loc == "" {
+		return errors.New("usage: x ADDR [N]")
+	}
+	addr, err := d.Eval(loc)
+	if err != nil {
+		return err
+	}
+	n := 64
+	if count != "" {
+		n, _ = strconv.Atoi(count)
+	}
+
+	mem := d.Machine.Memory
+	for row := 0; row < n; row += 16 {
+		start := uint16(addr + row)
+		var hex, text strings.Builder
+		for i := 0; i < 16 && row+i < n; i++ {
+			b := mem.Peek(start + uint16(i))
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 0x20 && b < 0x7F {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(out, "%04X  %-48s %s\n", start, hex.String(), text.String())
+	}
+	return nil
+}
+
+func (d *Debugger) cmdPoke(_ io.Writer, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return errors.New("usage: poke ADDR VALUE...")
+	}
+	addr, err := d.Eval(fields[0])
+	if err != nil {
+		return err
+	}
+	for i, f := range fields[1:] {
+		v, err := d.Eval(f)
+		if err != nil {
+			return err
+		}
+		d.Machine.Memory.Poke(uint16(addr+i), uint8(v))
+	}
+	return nil
+}
+
+func (d *Debugger) cmdDisasm(out io.Writer, args string) error {
+	loc, count := splitArgs(args)
+	n := 10
+	if count != "" {
+		n, _ = strconv.Atoi(count)
+	}
+
+	pc := uint16(d.Machine.CPU.PC)
+	var lines []Line
+	if loc == "" {
+		lines = d.DisassembleAround(pc, n/2, n-n/2)
+	} else {
+		_, addr, err := d.Location(loc)
+		if err != nil {
+			return err
+		}
+		lines = d.Disassemble(addr, n)
+	}
+	for _, line := range lines {
+		marker := "  "
+		if line.Addr == pc {
+			marker = "=>"
+		}
+		fmt.Fprintf(out, "%s %s\n", marker, d.FormatLine(line))
+	}
+	return nil
+}
+
+func (d *Debugger) cmdPrint(out io.Writer, args string) error {
+	v, err := d.Eval(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d $%X\n", v, v)
+	return nil
+}
+
+func (d *Debugger) cmdHelp(out io.Writer, _ string) error {
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-22s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintln(out, "Locations are bank:addr in hexadecimal, or expressions such as Main.loop + 3.")
+	fmt.Fprintln(out, "Expressions may use registers, flags ZF NF HF CF, IME, ROMBANK, PEEK(addr) and PEEK16(addr).")
+	fmt.Fprintln(out, "Watchpoint conditions may also use VALUE and ADDR, the value and address accessed.")
+	return nil
+}
+
+func (d *Debugger) cmdQuit(io.Writer, string) error {
+	return errQuit
+}
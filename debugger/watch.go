@@ -0,0 +1,126 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// Watchpoint stops execution when memory from Start to End inclusive is accessed.
+type Watchpoint struct {
+	ID         int
+	Start, End uint16
+	// Access are the kinds of access that are watched. Reads and writes stop execution after the instruction
+	// accessing memory, and execution before the instruction is executed, like a breakpoint.
+	Access cpu.Access
+	// Cond is evaluated on each access, with VALUE the value read, written or executed, and ADDR its address.
+	// Execution only stops if it is non-zero. It may be nil.
+	Cond *asm.Expr
+	// Hits counts how many times the watchpoint stopped execution.
+	Hits int
+
+	remove func()
+}
+
+// String implements fmt.Stringer
+func (w *Watchpoint) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "#%d %s $%04X", w.ID, w.Access, w.Start)
+	if w.End != w.Start {
+		fmt.Fprintf(&s, "..$%04X", w.End)
+	}
+	if w.Cond != nil {
+		fmt.Fprintf(&s, " if %s", w.Cond)
+	}
+	return s.String()
+}
+
+// Watchpoints returns the watchpoints, ordered by ID.
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return d.watchpoints
+}
+
+// AddWatchpoint adds a watchpoint on accesses from start to end inclusive, stopping when cond is non-zero. cond may
+// be nil.
+func (d *Debugger) AddWatchpoint(start, end uint16, access cpu.Access, cond *asm.Expr) *Watchpoint {
+	w := &Watchpoint{ID: d.nextID, Start: start, End: end, Access: access, Cond: cond}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, w)
+
+	// execution is checked before each instruction rather than as the opcode is fetched
+	if access &^= cpu.AccessExecute; access != 0 {
+		w.remove = d.Machine.Memory.AddHook(start, end, access, func(addr uint16, v uint8, access cpu.Access) {
+			if d.watched.Watchpoint == nil && d.watchErr == nil {
+				d.watch(w, addr, v, access)
+			}
+		})
+	}
+	return w
+}
+
+// RemoveWatchpoint removes the watchpoint with the given ID.
+func (d *Debugger) RemoveWatchpoint(id int) error {
+	for i, w := range d.watchpoints {
+		if w.ID == id {
+			if w.remove != nil {
+				w.remove()
+			}
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no watchpoint #%d", id)
+}
+
+// watch records an access to a watchpoint if its condition holds, to stop execution once the instruction completes.
+func (d *Debugger) watch(w *Watchpoint, addr uint16, v uint8, access cpu.Access) bool {
+	if w.Cond != nil {
+		env := d.env()
+		symbol := env.Symbol
+		env.Symbol = func(name string) (int, bool) {
+			switch strings.ToUpper(name) {
+			case "VALUE":
+				return int(v), true
+			case "ADDR":
+				return int(addr), true
+			}
+			return symbol(name)
+		}
+		c, err := w.Cond.Eval(env)
+		if err != nil {
+			d.watchErr = fmt.Errorf("watchpoint #%d: %v", w.ID, err)
+			return false
+		}
+		if c == 0 {
+			return false
+		}
+	}
+	w.Hits++
+	d.watched = Stop{Watchpoint: w, Access: access, Addr: addr, Value: v}
+	return true
+}
+
+// executed returns the execute watchpoint at PC whose condition holds, if any.
+func (d *Debugger) executed() (Stop, error) {
+	pc := uint16(d.Machine.CPU.PC)
+	for _, w := range d.watchpoints {
+		if w.Access&cpu.AccessExecute != 0 && pc >= w.Start && pc <= w.End {
+			if d.watch(w, pc, d.Machine.Memory.Peek(pc), cpu.AccessExecute) {
+				return d.takeWatched()
+			}
+			if d.watchErr != nil {
+				return d.takeWatched()
+			}
+		}
+	}
+	return Stop{}, nil
+}
+
+// takeWatched returns and clears the watchpoint hit by the last instruction.
+func (d *Debugger) takeWatched() (Stop, error) {
+	stop, err := d.watched, d.watchErr
+	d.watched, d.watchErr = Stop{}, nil
+	return stop, err
+}
@@ -0,0 +1,92 @@
+//go:build (linux || freebsd || netbsd || openbsd) && !nox11
+
+package frontend
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// audioRate is the sample rate of the audio output of the x11 backend.
+const audioRate = 48000
+
+// audioCommands are the commands of the sound systems that play raw samples from their standard input, in the order
+// they are tried: pacat of PulseAudio, which PipeWire provides too, and aplay of ALSA. Their buffers hold about as
+// many samples as the frontend keeps queued.
+var audioCommands = [][]string{
+	{"pacat", "--playback", "--raw", "--format=s16le", "--channels=2", "--rate=48000", "--latency-msec=60"},
+	{"aplay", "-q", "-t", "raw", "-f", "S16_LE", "-c", "2", "-r", "48000", "--buffer-time=60000"},
+}
+
+// commandAudio is an audio output playing through a command of the sound system. Writes to the command block once
+// its buffer is full, which holds the frontend to the clock of the sound card; the samples still queued in it are
+// estimated from the time since the output began playing.
+type commandAudio struct {
+	cmd   *exec.Cmd
+	w     io.WriteCloser
+	rate  int
+	buf   []byte
+	now   func() time.Time
+	start time.Time
+	// written is the number of samples written since start.
+	written int
+}
+
+// openCommandAudio starts the first of the commands that is installed, playing samples at a rate.
+func openCommandAudio(commands [][]string, rate int) (*commandAudio, error) {
+	for _, args := range commands {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		w, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &commandAudio{cmd: cmd, w: w, rate: rate, now: time.Now}, nil
+	}
+	return nil, errors.New("frontend: no command to play audio is installed")
+}
+
+// SampleRate implements Audio.
+func (a *commandAudio) SampleRate() int { return a.rate }
+
+// Queue implements Audio.
+func (a *commandAudio) Queue(samples []int16) error {
+	a.buf = a.buf[:0]
+	for _, s := range samples {
+		a.buf = binary.LittleEndian.AppendUint16(a.buf, uint16(s))
+	}
+	if _, err := a.w.Write(a.buf); err != nil {
+		return err
+	}
+	if a.start.IsZero() {
+		a.start = a.now()
+	}
+	a.written += len(samples) / 2
+	return nil
+}
+
+// Queued implements Audio.
+func (a *commandAudio) Queued() int {
+	played := int(a.now().Sub(a.start) * time.Duration(a.rate) / time.Second)
+	if played > a.written {
+		// the output ran dry, and starts again from the samples queued next
+		a.start, a.written = time.Time{}, 0
+		return 0
+	}
+	return a.written - played
+}
+
+// Close stops playing.
+func (a *commandAudio) Close() error {
+	a.w.Close()
+	return a.cmd.Wait()
+}
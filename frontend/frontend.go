@@ -0,0 +1,245 @@
+// Package frontend runs a machine in real time, presenting its frames, playing its audio and reading its input through
+// a [Backend]. Backends hold the dependencies on the platform, and are registered by name with [Register], so that
+// the emulator itself has none: a backend for a desktop registers itself from a file of its own, built with the build
+// constraints that select it. The null backend, always registered, presents nothing and reads no input, for running
+// headless, such as in CI.
+//
+// The x11 backend presents the frames in a window of an X server on Linux and the BSDs, and plays the audio through
+// pacat or aplay if either is installed. It speaks the X11 protocol itself, needing no C library, and is left out by
+// the nox11 build tag. Where no desktop backend is built, [DefaultBackend] returns "".
+//
+// The frontend paces the machine by the clock of the audio output of the backend, if it has one: it queues the audio
+// of each frame, and waits for the output to play the audio queued beyond a few frames, so that the machine runs at
+// the rate the output plays samples, without the audio running dry or lagging. The machine has no APU yet, so the
+// audio queued is silence, which paces it all the same. Without an audio output, it paces the frames with a timer
+// instead.
+package frontend
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/screenshot"
+)
+
+// CyclesPerFrame is the number of clock cycles of a frame of the PPU.
+const CyclesPerFrame = 70224
+
+// FrameRate is the number of frames per second the Gameboy displays, about 59.73.
+const FrameRate = float64(machine.ClockRate) / CyclesPerFrame
+
+// Backend presents the video, plays the audio and reads the input of a frontend.
+type Backend interface {
+	// Present shows a frame.
+	Present(frame *image.RGBA) error
+	// Audio returns the audio output, or nil if the backend has none.
+	Audio() Audio
+	// Poll returns the buttons pressed, and whether the user asked to quit.
+	Poll() (buttons joypad.Button, quit bool)
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// Audio is an audio output playing stereo samples.
+type Audio interface {
+	// SampleRate returns the number of samples per second the output plays.
+	SampleRate() int
+	// Queue queues samples to play, left and right interleaved.
+	Queue(samples []int16) error
+	// Queued returns the number of samples queued that the output has not played yet, counting a left and right
+	// sample as one.
+	Queued() int
+}
+
+// backends holds the constructors of the registered backends by name.
+var backends = map[string]func() (Backend, error){
+	"null": func() (Backend, error) { return &Null{}, nil },
+}
+
+// Register registers the constructor of a backend by name, replacing any backend registered with that name. It is
+// meant to be called from the init function of a backend.
+func Register(name string, open func() (Backend, error)) {
+	backends[name] = open
+}
+
+// Backends returns the names of the registered backends, sorted.
+func Backends() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultBackend returns the name of the backend to open when none is chosen: the first of the backends registered
+// besides the null backend, or "" if none is, since the null backend shows nothing.
+func DefaultBackend() string {
+	for _, name := range Backends() {
+		if name != "null" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Open opens the backend registered by name.
+func Open(name string) (Backend, error) {
+	open, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("frontend: unknown backend %q, not one of %v", name, Backends())
+	}
+	return open()
+}
+
+// Null is a backend that presents nothing, has no audio output, and reads no input.
+type Null struct {
+	// Frames counts the frames presented, and Last is the last one.
+	Frames int
+	Last   *image.RGBA
+}
+
+// Present implements Backend.
+func (n *Null) Present(frame *image.RGBA) error {
+	n.Frames++
+	n.Last = frame
+	return nil
+}
+
+// Audio implements Backend.
+func (n *Null) Audio() Audio { return nil }
+
+// Poll implements Backend.
+func (n *Null) Poll() (joypad.Button, bool) { return 0, false }
+
+// Close implements Backend.
+func (n *Null) Close() error { return nil }
+
+// latencyFrames is the number of frames of audio the frontend keeps queued ahead of the output.
+const latencyFrames = 3
+
+// maxLag is how far behind the timer the frontend may fall, as when the machine runs slower than real time, before it
+// gives up catching up.
+const maxLag = 100 * time.Millisecond
+
+// Frontend runs a machine through a backend.
+type Frontend struct {
+	Machine *machine.Machine
+	Backend Backend
+
+	// Palette renders the frames of a DMG.
+	Palette screenshot.Palette
+	// Frames, if not 0, is the number of frames Run runs for.
+	Frames int
+	// Unthrottled runs the machine as fast as it goes, rather than in real time, without audio.
+	Unthrottled bool
+
+	// now and sleep are the clock of the frontend, replaced by tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+	// deadline is the time the frame being run is due, when the frontend paces itself with a timer.
+	deadline time.Time
+	// sampleCycles carries the clock cycles times the sample rate that have not made up a whole sample yet.
+	sampleCycles uint64
+	// silence is the audio queued for a frame, as the machine has no APU to generate it.
+	silence []int16
+	stopped atomic.Bool
+}
+
+// New constructs a Frontend running m through b, rendering DMG frames in the green of its screen.
+func New(m *machine.Machine, b Backend) *Frontend {
+	return &Frontend{Machine: m, Backend: b, Palette: screenshot.Green, now: time.Now, sleep: time.Sleep}
+}
+
+// Stop stops Run after the frame it is running. It may be called from any goroutine.
+func (f *Frontend) Stop() {
+	f.stopped.Store(true)
+}
+
+// Run runs the machine until the user quits, Stop is called, or it ran for Frames frames.
+func (f *Frontend) Run() error {
+	f.deadline = f.now()
+	for i := 0; f.Frames == 0 || i < f.Frames; i++ {
+		quit, err := f.RunFrame()
+		if err != nil {
+			return err
+		}
+		if quit || f.stopped.Load() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// RunFrame runs the machine for a frame with the buttons the backend reads pressed, presents it, and waits for its
+// time to pass, returning whether the user asked to quit.
+func (f *Frontend) RunFrame() (quit bool, err error) {
+	m := f.Machine
+	buttons, quit := f.Backend.Poll()
+	if quit {
+		return true, nil
+	}
+	m.Joypad.Press(buttons)
+
+	start := m.Cycles
+	if err := m.RunFrame(); err != nil {
+		return false, err
+	}
+	if err := f.Backend.Present(f.render()); err != nil {
+		return false, err
+	}
+	return false, f.sync(m.Cycles - start)
+}
+
+// render renders the last frame of the machine.
+func (f *Frontend) render() *image.RGBA {
+	if f.Machine.Model.Color() {
+		return screenshot.ColorImage(f.Machine.PPU)
+	}
+	return screenshot.Image(f.Machine.PPU.Frame(), f.Palette)
+}
+
+// sync waits for a number of clock cycles the machine ran to pass in real time.
+func (f *Frontend) sync(cycles uint64) error {
+	if f.Unthrottled {
+		return nil
+	}
+
+	a := f.Backend.Audio()
+	if a == nil {
+		f.deadline = f.deadline.Add(time.Duration(cycles) * time.Second / machine.ClockRate)
+		switch d := f.deadline.Sub(f.now()); {
+		case d > 0:
+			f.sleep(d)
+		case d < -maxLag:
+			f.deadline = f.now()
+		}
+		return nil
+	}
+
+	rate := a.SampleRate()
+	if rate <= 0 {
+		return errors.New("frontend: the audio output has no sample rate")
+	}
+	f.sampleCycles += cycles * uint64(rate)
+	n := int(f.sampleCycles / machine.ClockRate)
+	f.sampleCycles %= machine.ClockRate
+	if cap(f.silence) < 2*n {
+		f.silence = make([]int16, 2*n)
+	}
+	if err := a.Queue(f.silence[:2*n]); err != nil {
+		return err
+	}
+	// the machine waits for the output to play its samples, rather than the output for the machine
+	latency := int(latencyFrames * float64(rate) / FrameRate)
+	for a.Queued() > latency && !f.stopped.Load() {
+		f.sleep(time.Millisecond)
+	}
+	return nil
+}
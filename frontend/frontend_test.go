@@ -0,0 +1,161 @@
+package frontend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSource spins with the screen on.
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	jr Main
+`
+
+// fakeClock is a clock that only advances when the frontend sleeps.
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+	// played, if not nil, is called with the time slept.
+	played func(time.Duration)
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	c.slept += d
+	if c.played != nil {
+		c.played(d)
+	}
+}
+
+// newTestFrontend constructs a frontend with a fake clock.
+func newTestFrontend(t *testing.T, b Backend) (*Frontend, *fakeClock) {
+	f := New(testrig.Machine(t, testSource, link.Options{Title: "FRONTEND"}), b)
+	c := &fakeClock{t: time.Unix(0, 0)}
+	f.now, f.sleep = c.now, c.sleep
+	return f, c
+}
+
+func TestNull(t *testing.T) {
+	assert.Contains(t, Backends(), "null")
+	assert.NotEqual(t, "null", DefaultBackend())
+	b, err := Open("null")
+	require.NoError(t, err)
+	_, err = Open("nope")
+	assert.EqualError(t, err, fmt.Sprintf(`frontend: unknown backend "nope", not one of %v`, Backends()))
+
+	f, c := newTestFrontend(t, b)
+	f.Frames, f.Unthrottled = 3, true
+	require.NoError(t, f.Run())
+	null := b.(*Null)
+	assert.Equal(t, 3, null.Frames)
+	assert.Equal(t, uint64(3), f.Machine.PPU.Frames)
+	assert.Equal(t, ppu.Width, null.Last.Bounds().Dx())
+	assert.Zero(t, c.slept)
+	assert.NoError(t, b.Close())
+}
+
+func TestTimer(t *testing.T) {
+	f, c := newTestFrontend(t, &Null{})
+	f.Frames = 60
+	require.NoError(t, f.Run())
+	// each frame waits for its cycles to pass
+	assert.InDelta(t, float64(f.Machine.Cycles)/machine.ClockRate, c.slept.Seconds(), 1e-6)
+	assert.InDelta(t, 60/FrameRate, c.slept.Seconds(), 0.02)
+
+	// a machine running slower than real time does not try to catch up
+	c.t = c.t.Add(time.Second)
+	slept := c.slept
+	_, err := f.RunFrame()
+	require.NoError(t, err)
+	assert.Equal(t, slept, c.slept)
+	_, err = f.RunFrame()
+	require.NoError(t, err)
+	assert.InDelta(t, 1/FrameRate, (c.slept - slept).Seconds(), 0.001)
+}
+
+// fakeAudio is an audio output played by a fake clock.
+type fakeAudio struct {
+	queued, total int
+}
+
+func (a *fakeAudio) SampleRate() int { return 48000 }
+
+func (a *fakeAudio) Queue(samples []int16) error {
+	a.queued += len(samples) / 2
+	a.total += len(samples) / 2
+	return nil
+}
+
+func (a *fakeAudio) Queued() int { return a.queued }
+
+// audioBackend is a backend with an audio output, pressing buttons until it quits.
+type audioBackend struct {
+	Null
+	audio   *fakeAudio
+	buttons joypad.Button
+	quit    bool
+}
+
+func (b *audioBackend) Audio() Audio { return b.audio }
+
+func (b *audioBackend) Poll() (joypad.Button, bool) { return b.buttons, b.quit }
+
+func TestAudioSync(t *testing.T) {
+	b := &audioBackend{audio: &fakeAudio{}, buttons: joypad.Start}
+	f, c := newTestFrontend(t, b)
+	c.played = func(d time.Duration) {
+		b.audio.queued -= int(d * 48000 / time.Second)
+		if b.audio.queued < 0 {
+			b.audio.queued = 0
+		}
+	}
+	f.Frames = 120
+	require.NoError(t, f.Run())
+
+	// the audio of every cycle is queued, and the frontend waits for all but a few frames of it to play
+	assert.Equal(t, int(f.Machine.Cycles*48000/machine.ClockRate), b.audio.total)
+	assert.LessOrEqual(t, float64(b.audio.queued), latencyFrames*48000/FrameRate)
+	assert.InDelta(t, 120/FrameRate-latencyFrames/FrameRate, c.slept.Seconds(), 0.02)
+	assert.Equal(t, joypad.Start, f.Machine.Joypad.Pressed())
+
+	// quitting stops before the next frame
+	b.quit = true
+	f.Frames = 0
+	require.NoError(t, f.Run())
+	assert.Equal(t, 120, b.Frames)
+
+	// as does Stop
+	b.quit = false
+	f.Stop()
+	require.NoError(t, f.Run())
+	assert.Equal(t, 121, b.Frames)
+}
+
+func TestAudioStall(t *testing.T) {
+	// Stop takes effect while an output that stopped playing is awaited
+	b := &audioBackend{audio: &fakeAudio{}}
+	f, c := newTestFrontend(t, b)
+	c.played = func(time.Duration) {
+		if c.slept >= time.Second {
+			f.Stop()
+		}
+	}
+	require.NoError(t, f.Run())
+	assert.Equal(t, time.Second, c.slept)
+}
@@ -0,0 +1,414 @@
+//go:build (linux || freebsd || netbsd || openbsd) && !nox11
+
+package frontend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/ppu"
+)
+
+func init() {
+	Register("x11", func() (Backend, error) {
+		b, err := openX11(os.Getenv("DISPLAY"))
+		if err != nil {
+			return nil, err
+		}
+		// without a command of the sound system, the frames are paced with a timer
+		if a, err := openCommandAudio(audioCommands, audioRate); err == nil {
+			b.audio = a
+		}
+		return b, nil
+	})
+}
+
+// x11Scale is the number of pixels of the window for each pixel of the screen, in each direction.
+const x11Scale = 3
+
+// x11Keys maps the keysyms of keys to the buttons they press.
+var x11Keys = map[uint32]joypad.Button{
+	'x':    joypad.A,
+	'z':    joypad.B,
+	0xFF08: joypad.Select, // BackSpace
+	0xFF0D: joypad.Start,  // Return
+	0xFF53: joypad.Right,
+	0xFF51: joypad.Left,
+	0xFF52: joypad.Up,
+	0xFF54: joypad.Down,
+}
+
+// x11Quit is the keysym of Escape, which quits.
+const x11Quit = 0xFF1B
+
+// Requests, events and atoms of the X11 protocol.
+const (
+	x11CreateWindow       = 1
+	x11MapWindow          = 8
+	x11InternAtom         = 16
+	x11ChangeProperty     = 18
+	x11CreateGC           = 55
+	x11PutImage           = 72
+	x11GetKeyboardMapping = 101
+
+	x11Error         = 0
+	x11Reply         = 1
+	x11KeyPress      = 2
+	x11KeyRelease    = 3
+	x11FocusOut      = 10
+	x11DestroyNotify = 17
+	x11ClientMessage = 33
+
+	x11AtomAtom        = 4
+	x11AtomString      = 31
+	x11AtomWMName      = 39
+	x11AtomNormalHints = 40
+	x11AtomSizeHints   = 41
+)
+
+// x11 is a backend presenting the frames in a window of an X server, and reading the keyboard: the arrow keys, X
+// for A, Z for B, Return for Start and BackSpace for Select, with Escape to quit. It speaks the X11 protocol itself,
+// so that it needs no C library, and requires a screen of 24 bit true color, as all modern servers have.
+type x11 struct {
+	conn  net.Conn
+	audio Audio
+
+	window, gc uint32
+	depth      uint8
+	// msbFirst is whether the server takes the bytes of pixels most significant first.
+	msbFirst bool
+	// maxRequest is the maximum length of a request in bytes.
+	maxRequest int
+	// buttons and quits hold the buttons each keycode presses, and whether it quits.
+	buttons [256]joypad.Button
+	quits   [256]bool
+	// protocols and deleteWindow are the atoms of the message the window manager sends to close the window.
+	protocols, deleteWindow uint32
+	image                   []byte
+
+	// mu guards the state the events update.
+	mu   sync.Mutex
+	held [256]bool
+	quit bool
+	err  error
+}
+
+// openX11 connects to the X server of a display, such as ":0", and opens a window.
+func openX11(display string) (*x11, error) {
+	if display == "" {
+		return nil, errors.New("x11: DISPLAY is not set")
+	}
+	host, screen, ok := strings.Cut(display, ":")
+	number, _, _ := strings.Cut(screen, ".")
+	n, err := strconv.Atoi(number)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("x11: invalid display %q", display)
+	}
+	var conn net.Conn
+	if host == "" || host == "unix" {
+		conn, err = net.Dial("unix", "/tmp/.X11-unix/X"+number)
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	authName, authData := x11Cookie(number)
+	b, err := newX11(conn, authName, authData)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// x11Cookie returns the name and the data of the authorization of the first entry of the authority file for a display
+// number, or nothing if there is none.
+func x11Cookie(number string) (name, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil
+	}
+	// each entry is a family, followed by an address, a display number, a name and data, each prefixed by its length
+	for len(b) >= 2 {
+		b = b[2:]
+		var fields [4][]byte
+		for i := range fields {
+			if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+				return nil, nil
+			}
+			n := 2 + int(binary.BigEndian.Uint16(b))
+			fields[i], b = b[2:n], b[n:]
+		}
+		if string(fields[1]) == number {
+			return fields[2], fields[3]
+		}
+	}
+	return nil, nil
+}
+
+// x11Pad pads b with zeros to a multiple of 4 bytes.
+func x11Pad(b []byte) []byte {
+	return append(b, make([]byte, -len(b)&3)...)
+}
+
+// newX11 sets up a connection to an X server, and opens a window.
+func newX11(conn net.Conn, authName, authData []byte) (*x11, error) {
+	// the client chooses the byte order of the connection: little endian
+	le := binary.LittleEndian
+	setup := []byte{'l', 0}
+	setup = le.AppendUint16(setup, 11)
+	setup = le.AppendUint16(setup, 0)
+	setup = le.AppendUint16(setup, uint16(len(authName)))
+	setup = le.AppendUint16(setup, uint16(len(authData)))
+	setup = append(setup, 0, 0)
+	setup = append(x11Pad(append(setup, authName...)), authData...)
+	if _, err := conn.Write(x11Pad(setup)); err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	body := make([]byte, 4*int(le.Uint16(head[6:])))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	if head[0] != 1 {
+		reason := body
+		if head[0] == 0 && int(head[1]) <= len(body) {
+			reason = body[:head[1]]
+		}
+		return nil, fmt.Errorf("x11: the server refused the connection: %s", strings.TrimRight(string(reason), "\x00\n"))
+	}
+
+	// the setup is followed by the vendor, the pixmap formats, and the screens, of which the first is used
+	if len(body) < 32 {
+		return nil, errors.New("x11: short setup")
+	}
+	idBase := le.Uint32(body[4:])
+	b := &x11{conn: conn, msbFirst: body[22] == 1, maxRequest: 4 * int(le.Uint16(body[18:]))}
+	minKeycode, maxKeycode := body[26], body[27]
+	formats := 32 + (int(le.Uint16(body[16:]))+3)&^3
+	screen := formats + 8*int(body[21])
+	if len(body) < screen+40 {
+		return nil, errors.New("x11: short setup")
+	}
+	root := le.Uint32(body[screen:])
+	black := le.Uint32(body[screen+12:])
+	b.depth = body[screen+38]
+	bpp := 0
+	for i := formats; i < screen; i += 8 {
+		if body[i] == b.depth {
+			bpp = int(body[i+1])
+		}
+	}
+	if b.depth != 24 || bpp != 32 {
+		return nil, fmt.Errorf("x11: the screen has %d bit pixels of depth %d, not 32 bit pixels of depth 24", bpp, b.depth)
+	}
+
+	// the atoms of the message closing the window, and the keysyms of each keycode
+	b.send(x11InternAtom, 0, []uint32{uint32(len("WM_PROTOCOLS"))}, []byte("WM_PROTOCOLS"))
+	b.send(x11InternAtom, 0, []uint32{uint32(len("WM_DELETE_WINDOW"))}, []byte("WM_DELETE_WINDOW"))
+	count := int(maxKeycode) - int(minKeycode) + 1
+	b.send(x11GetKeyboardMapping, 0, []uint32{uint32(minKeycode) | uint32(count)<<8}, nil)
+	for _, atom := range []*uint32{&b.protocols, &b.deleteWindow} {
+		reply, err := b.reply()
+		if err != nil {
+			return nil, err
+		}
+		*atom = le.Uint32(reply[8:])
+	}
+	reply, err := b.reply()
+	if err != nil {
+		return nil, err
+	}
+	perKeycode := int(reply[1])
+	keysyms := reply[32:]
+	if len(keysyms) < 4*perKeycode*count {
+		return nil, errors.New("x11: short keyboard mapping")
+	}
+	for i := 0; i < count; i++ {
+		// the first keysym of a keycode is that of the key without modifiers
+		keysym := le.Uint32(keysyms[4*perKeycode*i:])
+		b.buttons[int(minKeycode)+i] = x11Keys[keysym]
+		b.quits[int(minKeycode)+i] = keysym == x11Quit
+	}
+
+	b.window, b.gc = idBase|1, idBase|2
+	w, h := uint32(ppu.Width*x11Scale), uint32(ppu.Height*x11Scale)
+	// the background pixel, and the events of the keyboard, of the focus and of the structure of the window
+	const values = 1<<1 | 1<<11
+	const events = 1<<0 | 1<<1 | 1<<17 | 1<<21
+	b.send(x11CreateWindow, b.depth, []uint32{b.window, root, 0, w | h<<16, 1 << 16, 0, values, black, events}, nil)
+	b.send(x11ChangeProperty, 0, []uint32{b.window, x11AtomWMName, x11AtomString, 8, uint32(len("gopherpocket"))},
+		[]byte("gopherpocket"))
+	b.send(x11ChangeProperty, 0, []uint32{b.window, b.protocols, x11AtomAtom, 32, 1},
+		le.AppendUint32(nil, b.deleteWindow))
+	// the window keeps its size, with a minimum and a maximum
+	hints := make([]byte, 0, 18*4)
+	for _, v := range []uint32{1<<4 | 1<<5, 0, 0, 0, 0, w, h, w, h} {
+		hints = le.AppendUint32(hints, v)
+	}
+	hints = append(hints, make([]byte, cap(hints)-len(hints))...)
+	b.send(x11ChangeProperty, 0, []uint32{b.window, x11AtomNormalHints, x11AtomSizeHints, 32, 18}, hints)
+	b.send(x11CreateGC, 0, []uint32{b.gc, b.window, 0}, nil)
+	if err := b.send(x11MapWindow, 0, []uint32{b.window}, nil); err != nil {
+		return nil, err
+	}
+	go b.readEvents()
+	return b, nil
+}
+
+// send sends a request of an opcode, whose second byte is detail, with fields of 32 bits, followed by data.
+func (b *x11) send(opcode, detail uint8, fields []uint32, data []byte) error {
+	le := binary.LittleEndian
+	req := []byte{opcode, detail, 0, 0}
+	for _, f := range fields {
+		req = le.AppendUint32(req, f)
+	}
+	req = x11Pad(append(req, data...))
+	le.PutUint16(req[2:], uint16(len(req)/4))
+	if _, err := b.conn.Write(req); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// reply reads the reply to a request, while no events are selected.
+func (b *x11) reply() ([]byte, error) {
+	for {
+		reply := make([]byte, 32)
+		if _, err := io.ReadFull(b.conn, reply); err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		switch reply[0] {
+		case x11Error:
+			return nil, fmt.Errorf("x11: error %d in request %d", reply[1], reply[10])
+		case x11Reply:
+			reply = append(reply, make([]byte, 4*binary.LittleEndian.Uint32(reply[4:]))...)
+			if _, err := io.ReadFull(b.conn, reply[32:]); err != nil {
+				return nil, fmt.Errorf("x11: %w", err)
+			}
+			return reply, nil
+		}
+	}
+}
+
+// readEvents reads the events of the window until the connection closes.
+func (b *x11) readEvents() {
+	le := binary.LittleEndian
+	event := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(b.conn, event); err != nil {
+			b.mu.Lock()
+			b.quit = true
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Lock()
+		switch event[0] &^ 0x80 {
+		case x11Error:
+			if b.err == nil {
+				b.err = fmt.Errorf("x11: error %d in request %d", event[1], event[10])
+			}
+		case x11KeyPress, x11KeyRelease:
+			b.held[event[1]] = event[0]&^0x80 == x11KeyPress
+		case x11FocusOut:
+			// the keys released while the window is not focused are not reported
+			b.held = [256]bool{}
+		case x11ClientMessage:
+			b.quit = b.quit || le.Uint32(event[8:]) == b.protocols && le.Uint32(event[12:]) == b.deleteWindow
+		case x11DestroyNotify:
+			b.quit = true
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Present implements Backend, scaling the frame up to the window in strips of rows as long as requests may be.
+func (b *x11) Present(frame *image.RGBA) error {
+	b.mu.Lock()
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bounds := frame.Bounds()
+	w, h := bounds.Dx()*x11Scale, bounds.Dy()*x11Scale
+	if len(b.image) != 4*w*h {
+		b.image = make([]byte, 4*w*h)
+	}
+	i := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := frame.RGBAAt(bounds.Min.X+x/x11Scale, bounds.Min.Y+y/x11Scale)
+			if b.msbFirst {
+				b.image[i], b.image[i+1], b.image[i+2], b.image[i+3] = 0, c.R, c.G, c.B
+			} else {
+				b.image[i], b.image[i+1], b.image[i+2], b.image[i+3] = c.B, c.G, c.R, 0
+			}
+			i += 4
+		}
+	}
+
+	rows := (b.maxRequest - 24) / (4 * w)
+	for y := 0; y < h; y += rows {
+		n := rows
+		if y+n > h {
+			n = h - y
+		}
+		// ZPixmap images, drawn at the left of the row
+		fields := []uint32{b.window, b.gc, uint32(w) | uint32(n)<<16, uint32(y) << 16, uint32(b.depth) << 8}
+		if err := b.send(x11PutImage, 2, fields, b.image[4*w*y:4*w*(y+n)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Audio implements Backend.
+func (b *x11) Audio() Audio { return b.audio }
+
+// Poll implements Backend.
+func (b *x11) Poll() (joypad.Button, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var buttons joypad.Button
+	quit := b.quit
+	for code, held := range b.held {
+		if held {
+			buttons |= b.buttons[code]
+			quit = quit || b.quits[code]
+		}
+	}
+	return buttons, quit
+}
+
+// Close implements Backend, closing the window with the connection.
+func (b *x11) Close() error {
+	if a, ok := b.audio.(io.Closer); ok {
+		a.Close()
+	}
+	return b.conn.Close()
+}
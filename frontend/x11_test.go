@@ -0,0 +1,239 @@
+//go:build (linux || freebsd || netbsd || openbsd) && !nox11
+
+package frontend
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeX11 is an X server with a screen of depth 24 and a keyboard of 3 keys, X, Escape and Down, which records the
+// requests it reads.
+type fakeX11 struct {
+	conn     net.Conn
+	auth     string
+	requests chan []byte
+}
+
+// serveX11 accepts a connection to a fake X server.
+func serveX11(t *testing.T, l net.Listener) *fakeX11 {
+	le := binary.LittleEndian
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	s := &fakeX11{conn: conn, requests: make(chan []byte, 1000)}
+
+	setup := make([]byte, 12)
+	_, err = io.ReadFull(conn, setup)
+	require.NoError(t, err)
+	require.Equal(t, byte('l'), setup[0])
+	auth := make([]byte, (int(le.Uint16(setup[6:]))+3)&^3+(int(le.Uint16(setup[8:]))+3)&^3)
+	_, err = io.ReadFull(conn, auth)
+	require.NoError(t, err)
+	s.auth = string(auth[:le.Uint16(setup[6:])])
+
+	// the fixed fields, the vendor, a pixmap format and a screen
+	body := make([]byte, 32+4+8+40)
+	le.PutUint32(body[4:], 0x200000)
+	le.PutUint32(body[8:], 0x1FFFFF)
+	le.PutUint16(body[16:], 4)
+	// requests of at most 16 KiB, which a frame takes strips of
+	le.PutUint16(body[18:], 0x1000)
+	body[20], body[21], body[26], body[27] = 1, 1, 8, 10
+	copy(body[32:], "fake")
+	copy(body[36:], []byte{24, 32, 32})
+	le.PutUint32(body[44:], 0x100)
+	body[44+38] = 24
+	head := []byte{1, 0, 11, 0, 0, 0, 0, 0}
+	le.PutUint16(head[6:], uint16(len(body)/4))
+	_, err = conn.Write(append(head, body...))
+	require.NoError(t, err)
+
+	go func() {
+		defer close(s.requests)
+		for {
+			req := make([]byte, 4)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			req = append(req, make([]byte, 4*int(le.Uint16(req[2:]))-4)...)
+			if _, err := io.ReadFull(conn, req[4:]); err != nil {
+				return
+			}
+			reply := make([]byte, 32)
+			reply[0] = x11Reply
+			switch req[0] {
+			case x11InternAtom:
+				le.PutUint32(reply[8:], 300+uint32(len(req[8:8+le.Uint16(req[4:])])))
+			case x11GetKeyboardMapping:
+				reply[1] = 2
+				for _, keysym := range []uint32{'x', 'X', x11Quit, 0, 0xFF54, 0} {
+					reply = le.AppendUint32(reply, keysym)
+				}
+				le.PutUint32(reply[4:], uint32(len(reply)-32)/4)
+			default:
+				reply = nil
+			}
+			if reply != nil {
+				if _, err := conn.Write(reply); err != nil {
+					return
+				}
+			}
+			s.requests <- req
+		}
+	}()
+	return s
+}
+
+// event sends an event of a code, whose second byte is detail, with data from its 8th byte.
+func (s *fakeX11) event(t *testing.T, code, detail uint8, data ...uint32) {
+	event := make([]byte, 8, 32)
+	event[0], event[1] = code, detail
+	for _, v := range data {
+		event = binary.LittleEndian.AppendUint32(event, v)
+	}
+	_, err := s.conn.Write(event[:32])
+	require.NoError(t, err)
+}
+
+// next returns the next request the server read.
+func (s *fakeX11) next(t *testing.T) []byte {
+	select {
+	case req := <-s.requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request")
+		return nil
+	}
+}
+
+func TestX11(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	opened := make(chan error, 1)
+	var b *x11
+	go func() {
+		var err error
+		b, err = newX11(conn, []byte("MIT-MAGIC-COOKIE-1"), []byte("0123456789abcdef"))
+		opened <- err
+	}()
+	s := serveX11(t, l)
+	require.NoError(t, <-opened)
+	assert.Equal(t, "MIT-MAGIC-COOKIE-1", s.auth)
+	assert.Equal(t, uint32(300+len("WM_PROTOCOLS")), b.protocols)
+	assert.Equal(t, uint32(300+len("WM_DELETE_WINDOW")), b.deleteWindow)
+
+	var opcodes []uint8
+	for i := 0; i < 9; i++ {
+		opcodes = append(opcodes, s.next(t)[0])
+	}
+	assert.Equal(t, []uint8{x11InternAtom, x11InternAtom, x11GetKeyboardMapping, x11CreateWindow, x11ChangeProperty,
+		x11ChangeProperty, x11ChangeProperty, x11CreateGC, x11MapWindow}, opcodes)
+
+	// the frame is scaled up in strips
+	frame := image.NewRGBA(image.Rect(0, 0, ppu.Width, ppu.Height))
+	frame.SetRGBA(0, 0, color.RGBA{R: 1, G: 2, B: 3, A: 0xFF})
+	require.NoError(t, b.Present(frame))
+	rows := 0
+	for rows < ppu.Height*x11Scale {
+		req := s.next(t)
+		require.Equal(t, uint8(x11PutImage), req[0])
+		require.LessOrEqual(t, len(req), 0x4000)
+		le := binary.LittleEndian
+		assert.Equal(t, ppu.Width*x11Scale, int(le.Uint16(req[12:])))
+		assert.Equal(t, rows, int(le.Uint16(req[18:])))
+		if rows == 0 {
+			assert.Equal(t, []byte{3, 2, 1, 0, 3, 2, 1, 0, 3, 2, 1, 0, 0, 0, 0, 0}, req[24:40])
+		}
+		rows += int(le.Uint16(req[14:]))
+	}
+	assert.Equal(t, ppu.Height*x11Scale, rows)
+
+	// the keys held press buttons, until the window loses the focus
+	poll := func(buttons joypad.Button, quit bool) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			got, q := b.Poll()
+			return got == buttons && q == quit
+		}, 5*time.Second, time.Millisecond)
+	}
+	s.event(t, x11KeyPress, 8)
+	poll(joypad.A, false)
+	s.event(t, x11KeyPress, 10)
+	poll(joypad.A|joypad.Down, false)
+	s.event(t, x11KeyRelease, 8)
+	poll(joypad.Down, false)
+	s.event(t, x11FocusOut, 0)
+	poll(0, false)
+
+	// Escape quits, as does closing the window
+	s.event(t, x11KeyPress, 9)
+	poll(0, true)
+	s.event(t, x11KeyRelease, 9)
+	poll(0, false)
+	s.event(t, x11ClientMessage, 32, b.protocols, b.deleteWindow)
+	poll(0, true)
+
+	// errors of requests are returned by the next frame
+	s.event(t, x11Error, 8, x11PutImage<<16)
+	assert.Eventually(t, func() bool { return b.Present(frame) != nil }, 5*time.Second, time.Millisecond)
+	assert.NoError(t, b.Close())
+}
+
+func TestX11Open(t *testing.T) {
+	_, err := openX11("")
+	assert.EqualError(t, err, "x11: DISPLAY is not set")
+	_, err = openX11("nope")
+	assert.EqualError(t, err, `x11: invalid display "nope"`)
+
+	// the cookie of the display is read from the authority file
+	var file []byte
+	for _, entry := range [][]string{{"host", "1", "MIT-MAGIC-COOKIE-1", "one"}, {"host", "0", "XDM", "zero"}} {
+		file = append(file, 1, 0)
+		for _, field := range entry {
+			file = append(binary.BigEndian.AppendUint16(file, uint16(len(field))), field...)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	require.NoError(t, os.WriteFile(path, file, 0o600))
+	t.Setenv("XAUTHORITY", path)
+	name, data := x11Cookie("0")
+	assert.Equal(t, "XDM", string(name))
+	assert.Equal(t, "zero", string(data))
+	name, _ = x11Cookie("2")
+	assert.Nil(t, name)
+}
+
+func TestCommandAudio(t *testing.T) {
+	_, err := openCommandAudio([][]string{{"gopherpocket-no-such-command"}}, 48000)
+	assert.Error(t, err)
+	a, err := openCommandAudio([][]string{{"gopherpocket-no-such-command"}, {"cat"}}, 48000)
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	a.now = func() time.Time { return now }
+
+	// the samples queued are those written, less those played since
+	require.NoError(t, a.Queue(make([]int16, 2*4800)))
+	assert.Equal(t, 4800, a.Queued())
+	now = now.Add(50 * time.Millisecond)
+	assert.Equal(t, 2400, a.Queued())
+	now = now.Add(time.Second)
+	assert.Equal(t, 0, a.Queued())
+	require.NoError(t, a.Queue(make([]int16, 2*480)))
+	assert.Equal(t, 480, a.Queued())
+	assert.NoError(t, a.Close())
+}
@@ -0,0 +1,372 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/debugger"
+)
+
+// sigill is reported when the CPU fails to execute an instruction.
+const sigill = 4
+
+// Types of the Z and z packets.
+const (
+	swBreakpoint     = '0'
+	hwBreakpoint     = '1'
+	writeWatchpoint  = '2'
+	readWatchpoint   = '3'
+	accessWatchpoint = '4'
+)
+
+// watchAccess are the kinds of access of each type of watchpoint.
+var watchAccess = map[byte]cpu.Access{
+	writeWatchpoint:  cpu.AccessWrite,
+	readWatchpoint:   cpu.AccessRead,
+	accessWatchpoint: cpu.AccessRead | cpu.AccessWrite,
+}
+
+// handle handles a packet and returns the reply. Packets are received from packets while the target runs, to
+// interrupt it.
+func (s *Server) handle(p string, packets <-chan packet) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+	args := p[1:]
+	switch p[0] {
+	case '?':
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	case 'g':
+		return s.readRegisters(), nil
+	case 'G':
+		return s.writeRegisters(args), nil
+	case 'p':
+		return s.readRegister(args), nil
+	case 'P':
+		return s.writeRegister(args), nil
+	case 'm':
+		return s.readMemory(args), nil
+	case 'M', 'X':
+		return s.writeMemory(args, p[0] == 'X'), nil
+	case 'Z':
+		return s.insert(args), nil
+	case 'z':
+		return s.remove(args), nil
+	case 's':
+		s.jump(args)
+		return s.step(), nil
+	case 'c':
+		s.jump(args)
+		return s.resume(packets)
+	case 'H', 'T':
+		// there is a single thread
+		return "OK", nil
+	case 'D':
+		return "OK", errDetach
+	case 'k':
+		return "", errDetach
+	case 'q', 'Q', 'v':
+		return s.query(p, packets)
+	default:
+		return "", nil
+	}
+}
+
+// query handles the general query and multi-letter packets.
+func (s *Server) query(p string, packets <-chan packet) (string, error) {
+	name, args, _ := strings.Cut(p, ":")
+	switch name {
+	case "qSupported":
+		return "PacketSize=1000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+;vContSupported+", nil
+	case "QStartNoAckMode":
+		s.noAck = true
+		return "OK", nil
+	case "qXfer":
+		return s.features(args), nil
+	case "qAttached":
+		return "1", nil
+	case "qC":
+		return "QC1", nil
+	case "qfThreadInfo":
+		return "m1", nil
+	case "qsThreadInfo":
+		return "l", nil
+	case "vCont?":
+		return "vCont;c;C;s;S", nil
+	}
+
+	if action, ok := strings.CutPrefix(p, "vCont;"); ok {
+		// the action for the single thread, without a thread ID
+		action, _, _ = strings.Cut(action, ":")
+		action, _, _ = strings.Cut(action, ";")
+		switch {
+		case strings.HasPrefix(action, "s"), strings.HasPrefix(action, "S"):
+			return s.step(), nil
+		case strings.HasPrefix(action, "c"), strings.HasPrefix(action, "C"):
+			return s.resume(packets)
+		}
+		return "E01", nil
+	}
+	return "", nil
+}
+
+// features serves the target description: qXfer:features:read:target.xml:offset,length.
+func (s *Server) features(args string) string {
+	annex, ok := strings.CutPrefix(args, "features:read:target.xml:")
+	if !ok {
+		return ""
+	}
+	offStr, lenStr, _ := strings.Cut(annex, ",")
+	off, err1 := strconv.ParseUint(offStr, 16, 32)
+	n, err2 := strconv.ParseUint(lenStr, 16, 32)
+	if err1 != nil || err2 != nil {
+		return "E01"
+	}
+	if off >= uint64(len(TargetXML)) {
+		return "l"
+	}
+	chunk := TargetXML[off:]
+	if uint64(len(chunk)) > n {
+		return "m" + chunk[:n]
+	}
+	return "l" + chunk
+}
+
+func (s *Server) register(i int) cpu.Register {
+	c := s.d.Machine.CPU
+	return *[]*cpu.Register{&c.AF, &c.BC, &c.DE, &c.HL, &c.SP, &c.PC}[i]
+}
+
+// encodeRegister encodes a register as little endian hexadecimal.
+func encodeRegister(r cpu.Register) string {
+	return fmt.Sprintf("%02x%02x", r.Lo(), r.Hi())
+}
+
+// decodeRegister decodes a register from little endian hexadecimal.
+func decodeRegister(s string) (int, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 2 {
+		return 0, false
+	}
+	return int(b[0]) | int(b[1])<<8, true
+}
+
+func (s *Server) readRegisters() string {
+	var b strings.Builder
+	for i := range registers {
+		b.WriteString(encodeRegister(s.register(i)))
+	}
+	return b.String()
+}
+
+func (s *Server) writeRegisters(args string) string {
+	if len(args) != 4*len(registers) {
+		return "E01"
+	}
+	for i, name := range registers {
+		v, ok := decodeRegister(args[4*i : 4*i+4])
+		if !ok {
+			return "E01"
+		}
+		_ = s.d.SetRegister(name, v)
+	}
+	return "OK"
+}
+
+func (s *Server) readRegister(args string) string {
+	i, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || int(i) >= len(registers) {
+		return "E01"
+	}
+	return encodeRegister(s.register(int(i)))
+}
+
+func (s *Server) writeRegister(args string) string {
+	n, value, _ := strings.Cut(args, "=")
+	i, err := strconv.ParseUint(n, 16, 8)
+	if err != nil || int(i) >= len(registers) {
+		return "E01"
+	}
+	v, ok := decodeRegister(value)
+	if !ok {
+		return "E01"
+	}
+	_ = s.d.SetRegister(registers[i], v)
+	return "OK"
+}
+
+// addrLength parses the addr,length arguments of memory and breakpoint packets.
+func addrLength(args string) (uint16, int, bool) {
+	a, l, _ := strings.Cut(args, ",")
+	addr, err1 := strconv.ParseUint(a, 16, 16)
+	n, err2 := strconv.ParseUint(l, 16, 17)
+	if err1 != nil || err2 != nil || addr+n > 0x10000 {
+		return 0, 0, false
+	}
+	return uint16(addr), int(n), true
+}
+
+func (s *Server) readMemory(args string) string {
+	addr, n, ok := addrLength(args)
+	if !ok {
+		return "E01"
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = s.d.Machine.Memory.Peek(addr + uint16(i))
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) writeMemory(args string, binary bool) string {
+	args, data, _ := strings.Cut(args, ":")
+	addr, n, ok := addrLength(args)
+	if !ok {
+		return "E01"
+	}
+	b := []byte(data)
+	if !binary {
+		var err error
+		if b, err = hex.DecodeString(data); err != nil {
+			return "E01"
+		}
+	}
+	if len(b) != n {
+		return "E01"
+	}
+	for i, v := range b {
+		s.d.Machine.Memory.Poke(addr+uint16(i), v)
+	}
+	return "OK"
+}
+
+// parsePoint parses the type,addr,kind arguments of the Z and z packets.
+func parsePoint(args string) (point, bool) {
+	if len(args) < 2 || args[1] != ',' {
+		return point{}, false
+	}
+	addr, n, ok := addrLength(args[2:])
+	if !ok {
+		return point{}, false
+	}
+	return point{kind: args[0], addr: addr, size: n}, true
+}
+
+func (s *Server) insert(args string) string {
+	p, ok := parsePoint(args)
+	if !ok {
+		return "E01"
+	}
+	if _, ok := s.points[p]; ok {
+		return "OK"
+	}
+	switch p.kind {
+	case swBreakpoint, hwBreakpoint:
+		s.points[p] = s.d.AddBreakpoint(debugger.AnyBank, p.addr, nil).ID
+	case writeWatchpoint, readWatchpoint, accessWatchpoint:
+		if p.size == 0 {
+			return "E01"
+		}
+		end := p.addr + uint16(p.size-1)
+		s.points[p] = s.d.AddWatchpoint(p.addr, end, watchAccess[p.kind], nil).ID
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+func (s *Server) remove(args string) string {
+	p, ok := parsePoint(args)
+	if !ok {
+		return "E01"
+	}
+	id, ok := s.points[p]
+	if !ok {
+		return "OK"
+	}
+	delete(s.points, p)
+	if p.kind == swBreakpoint || p.kind == hwBreakpoint {
+		_ = s.d.RemoveBreakpoint(id)
+	} else {
+		_ = s.d.RemoveWatchpoint(id)
+	}
+	return "OK"
+}
+
+// jump sets PC to the optional address of the s and c packets.
+func (s *Server) jump(args string) {
+	if addr, err := strconv.ParseUint(args, 16, 16); err == nil {
+		s.d.Machine.CPU.PC = cpu.Register(addr)
+	}
+}
+
+func (s *Server) step() string {
+	return s.stopReply(s.d.Step())
+}
+
+// resume continues until the target stops, or the debugger interrupts it.
+func (s *Server) resume(packets <-chan packet) (string, error) {
+	type result struct {
+		stop debugger.Stop
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stop, err := s.d.Continue()
+		done <- result{stop, err}
+	}()
+
+	for {
+		select {
+		case r := <-done:
+			return s.stopReply(r.stop, r.err), nil
+		case p, ok := <-packets:
+			if !ok {
+				// the connection closed: stop the target, and end the session
+				s.d.Interrupt()
+				<-done
+				return "", errDetach
+			}
+			if p.interrupt {
+				s.d.Interrupt()
+			}
+		}
+	}
+}
+
+// stopReply reports why the target stopped.
+func (s *Server) stopReply(stop debugger.Stop, err error) string {
+	switch {
+	case err != nil && stop.Breakpoint == nil && stop.Watchpoint == nil:
+		return fmt.Sprintf("S%02x", sigill)
+
+	case stop.Interrupted:
+		return fmt.Sprintf("S%02x", sigint)
+
+	case stop.Breakpoint != nil:
+		for p, id := range s.points {
+			if id == stop.Breakpoint.ID && p.kind == hwBreakpoint {
+				return fmt.Sprintf("T%02xhwbreak:;", sigtrap)
+			}
+		}
+		return fmt.Sprintf("T%02xswbreak:;", sigtrap)
+
+	case stop.Watchpoint != nil:
+		kind := "awatch"
+		switch stop.Watchpoint.Access {
+		case cpu.AccessWrite:
+			kind = "watch"
+		case cpu.AccessRead:
+			kind = "rwatch"
+		case cpu.AccessExecute:
+			return fmt.Sprintf("T%02xhwbreak:;", sigtrap)
+		}
+		return fmt.Sprintf("T%02x%s:%x;", sigtrap, kind, stop.Addr)
+
+	default:
+		return fmt.Sprintf("S%02x", sigtrap)
+	}
+}
@@ -0,0 +1,236 @@
+// Package gdbstub serves the GDB remote serial protocol, so that GDB and editors integrating it can debug a machine
+// through a [debugger.Debugger].
+//
+// The stub supports reading and writing the registers AF, BC, DE, HL, SP and PC, and memory; software and hardware
+// breakpoints, which are the same; read, write and access watchpoints; single stepping and continuing, which may be
+// interrupted; and describes the registers with a target description.
+package gdbstub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/debugger"
+)
+
+// TargetXML is the target description of the SM83, naming its registers in the order of the g packet.
+const TargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gopherpocket.sm83">
+    <reg name="af" bitsize="16" type="uint16" regnum="0"/>
+    <reg name="bc" bitsize="16" type="uint16" regnum="1"/>
+    <reg name="de" bitsize="16" type="uint16" regnum="2"/>
+    <reg name="hl" bitsize="16" type="uint16" regnum="3"/>
+    <reg name="sp" bitsize="16" type="data_ptr" regnum="4"/>
+    <reg name="pc" bitsize="16" type="code_ptr" regnum="5"/>
+  </feature>
+</target>
+`
+
+// registers are the registers in the order of the target description.
+var registers = []string{"AF", "BC", "DE", "HL", "SP", "PC"}
+
+// Signals reported in stop replies.
+const (
+	sigint  = 2
+	sigtrap = 5
+)
+
+// interruptByte is sent by GDB to stop a running target.
+const interruptByte = 0x03
+
+// Server serves the GDB remote serial protocol for a debugger.
+type Server struct {
+	d *debugger.Debugger
+
+	// points maps the type, address and length of a Z packet to the debugger breakpoint or watchpoint it added.
+	points map[point]int
+	// noAck is set once the debugger turned acknowledgements off.
+	noAck bool
+}
+
+type point struct {
+	kind byte
+	addr uint16
+	size int
+}
+
+// New constructs a new Server debugging with d.
+func New(d *debugger.Debugger) *Server {
+	return &Server{d: d, points: make(map[point]int)}
+}
+
+// ListenAndServe listens on the TCP address addr, such as localhost:2345, and serves one debugger connection at a
+// time until the listener fails.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.Serve(conn)
+		conn.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+}
+
+// Serve serves a single debugger session over conn, until the debugger detaches or the connection is closed.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	s.noAck = false
+	packets := make(chan packet)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		errs <- read(bufio.NewReader(conn), packets, done)
+		close(packets)
+	}()
+
+	for p := range packets {
+		switch {
+		case p.interrupt:
+			// interrupting a stopped target does nothing
+			continue
+		case !p.valid:
+			if !s.noAck {
+				if _, err := io.WriteString(conn, "-"); err != nil {
+					return err
+				}
+			}
+			continue
+		case !s.noAck:
+			if _, err := io.WriteString(conn, "+"); err != nil {
+				return err
+			}
+		}
+
+		reply, err := s.handle(p.data, packets)
+		if err != nil && err != errDetach {
+			return err
+		}
+		if err := send(conn, reply); err != nil {
+			return err
+		}
+		if err == errDetach {
+			return nil
+		}
+	}
+	return <-errs
+}
+
+// errDetach ends a session after its reply.
+var errDetach = errors.New("detach")
+
+// packet is a packet received from the debugger, or a request to interrupt the target.
+type packet struct {
+	data      string
+	valid     bool
+	interrupt bool
+}
+
+// read reads packets until the connection fails, or the session is done.
+func read(r *bufio.Reader, packets chan<- packet, done <-chan struct{}) error {
+	deliver := func(p packet) bool {
+		select {
+		case packets <- p:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case interruptByte:
+			if !deliver(packet{interrupt: true}) {
+				return nil
+			}
+			continue
+		case '$':
+		default:
+			// acknowledgements, and noise between packets
+			continue
+		}
+
+		data, err := r.ReadString('#')
+		if err != nil {
+			return err
+		}
+		data = data[:len(data)-1]
+		var sum [2]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return err
+		}
+		want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+		if !deliver(packet{data: unescape(data), valid: err == nil && uint8(want) == checksum(data)}) {
+			return nil
+		}
+	}
+}
+
+func checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// unescape decodes the binary escapes of a packet: } followed by a byte xor 0x20.
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			b.WriteByte(data[i] ^ 0x20)
+			continue
+		}
+		b.WriteByte(data[i])
+	}
+	return b.String()
+}
+
+// send sends a reply.
+func send(w io.Writer, reply string) error {
+	reply = escape(reply)
+	_, err := fmt.Fprintf(w, "$%s#%02x", reply, checksum(reply))
+	return err
+}
+
+// escape escapes the characters of a reply that have a meaning in the protocol.
+func escape(data string) string {
+	if !strings.ContainsAny(data, "#$}*") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(c ^ 0x20)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/debugger"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $C000
+.loop
+	inc [hl]
+	ld a, [hl]
+	cp 3
+	jr nz, .loop
+.spin
+	jr .spin
+`
+
+// client is the debugger end of a connection.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	ack  bool
+}
+
+func newClient(t *testing.T) (*client, *debugger.Debugger) {
+	t.Helper()
+	d := debugger.New(testrig.Machine(t, testSource, link.Options{Title: "GDB", Type: cartridge.ROMOnly}))
+
+	server, conn := net.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- New(d).Serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		if err := <-errs; err != nil && err != io.EOF && err != io.ErrClosedPipe {
+			t.Error(err)
+		}
+	})
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn), ack: true}, d
+}
+
+func (c *client) send(data string) {
+	c.t.Helper()
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	_, err := fmt.Fprintf(c.conn, "$%s#%02x", data, sum)
+	require.NoError(c.t, err)
+}
+
+func (c *client) reply() string {
+	c.t.Helper()
+	if c.ack {
+		b, err := c.r.ReadByte()
+		require.NoError(c.t, err)
+		require.Equal(c.t, byte('+'), b)
+	}
+	b, err := c.r.ReadByte()
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte('$'), b)
+	data, err := c.r.ReadString('#')
+	require.NoError(c.t, err)
+	var sum [2]byte
+	_, err = io.ReadFull(c.r, sum[:])
+	require.NoError(c.t, err)
+	return data[:len(data)-1]
+}
+
+func (c *client) exchange(data string) string {
+	c.t.Helper()
+	c.send(data)
+	return c.reply()
+}
+
+func TestServer(t *testing.T) {
+	c, d := newClient(t)
+
+	assert.Contains(t, c.exchange("qSupported:multiprocess+;swbreak+"), "qXfer:features:read+")
+	assert.Equal(t, "OK", c.exchange("QStartNoAckMode"))
+	c.ack = false
+	assert.Equal(t, "S05", c.exchange("?"))
+
+	xml := c.exchange("qXfer:features:read:target.xml:0,20")
+	assert.Equal(t, "m"+TargetXML[:0x20], xml)
+	assert.Equal(t, "l", c.exchange(fmt.Sprintf("qXfer:features:read:target.xml:%x,20", len(TargetXML))))
+
+	// AF BC DE HL SP PC
+	assert.Equal(t, "b0011300d8004d01feff0001", c.exchange("g"))
+	assert.Equal(t, "OK", c.exchange("P3=3412"))
+	assert.Equal(t, "3412", c.exchange("p3"))
+	assert.Equal(t, uint16(0x1234), uint16(d.Machine.CPU.HL))
+	assert.Equal(t, "E01", c.exchange("p6"))
+
+	assert.Equal(t, "OK", c.exchange("Mc010,3:010203"))
+	assert.Equal(t, "010203", c.exchange("mc010,3"))
+	assert.Equal(t, "OK", c.exchange("Xc013,2:}\x03}]"))
+	assert.Equal(t, "237d", c.exchange("mc013,2"))
+	assert.Equal(t, "00c3", c.exchange("m100,2"))
+
+	assert.Equal(t, "S05", c.exchange("s"))
+	assert.Equal(t, "0101", c.exchange("p5"))
+
+	// stop on the second write to $C000
+	assert.Equal(t, "OK", c.exchange("Z2,c000,1"))
+	assert.Equal(t, "T05watch:c000;", c.exchange("c"))
+	assert.Equal(t, "T05watch:c000;", c.exchange("vCont;c:1"))
+	assert.Equal(t, "02", c.exchange("mc000,1"))
+	assert.Equal(t, "OK", c.exchange("z2,c000,1"))
+
+	assert.Equal(t, "OK", c.exchange("Z0,159,1"))
+	assert.Equal(t, "T05swbreak:;", c.exchange("c"))
+	assert.Equal(t, "5901", c.exchange("p5"))
+	assert.Equal(t, "OK", c.exchange("z0,159,1"))
+
+	// interrupt the spinning program
+	c.send("c")
+	_, err := c.conn.Write([]byte{0x03})
+	require.NoError(t, err)
+	assert.Equal(t, "S02", c.reply())
+
+	assert.Equal(t, "", c.exchange("qUnknown"))
+	assert.Equal(t, "OK", c.exchange("D"))
+}
+
+func TestChecksum(t *testing.T) {
+	c, _ := newClient(t)
+	_, err := io.WriteString(c.conn, "$g#00")
+	require.NoError(t, err)
+	b, err := c.r.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte('-'), b)
+	assert.Equal(t, "S05", c.exchange("?"))
+}
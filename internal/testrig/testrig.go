@@ -0,0 +1,40 @@
+// Package testrig builds the ROMs and machines that tests run from assembly source, failing the test on errors.
+package testrig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// ROM assembles src, and links it into an image with opts, fixing up its header.
+func ROM(t testing.TB, src string, opts link.Options) *link.Image {
+	t.Helper()
+	obj, err := asm.AssembleObject("test.asm", strings.NewReader(src))
+	require.NoError(t, err)
+	opts.Fix = true
+	img, err := link.Link(opts, obj)
+	require.NoError(t, err)
+	return img
+}
+
+// Machine returns a machine running the ROM of src, linked with opts.
+func Machine(t testing.TB, src string, opts link.Options) *machine.Machine {
+	t.Helper()
+	m, err := machine.New(ROM(t, src, opts).ROM)
+	require.NoError(t, err)
+	return m
+}
+
+// SaveState returns a save state of m.
+func SaveState(t testing.TB, m *machine.Machine) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, m.SaveState(&buf))
+	return buf.Bytes()
+}
@@ -0,0 +1,96 @@
+// Package joypad implements the joypad of the Gameboy, whose buttons are read through the P1 register.
+package joypad
+
+import (
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// P1 is the address of the joypad register.
+const P1 = 0xFF00
+
+// Button is a set of buttons.
+type Button uint8
+
+// The buttons of the joypad, ordered as P1 reports them: the action buttons, then the directions.
+const (
+	A Button = 1 << iota
+	B
+	Select
+	Start
+	Right
+	Left
+	Up
+	Down
+)
+
+// buttonNames are the names of the buttons, in the order of their bits.
+var buttonNames = [8]string{"A", "B", "Select", "Start", "Right", "Left", "Up", "Down"}
+
+// String implements fmt.Stringer, returning the names of the buttons joined by +, such as "A+Up".
+func (b Button) String() string {
+	var names []string
+	for i, name := range buttonNames {
+		if b&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// Bits of P1 that select which buttons it reports.
+const (
+	selectDirections = 1 << 4
+	selectActions    = 1 << 5
+)
+
+// Joypad is the joypad, mapped at P1.
+type Joypad struct {
+	mem *cpu.Memory
+	// sel holds the selection bits of P1, which are 0 to select.
+	sel     uint8
+	pressed Button
+}
+
+// New constructs a Joypad with no button pressed, which requests interrupts from mem.
+func New(mem *cpu.Memory) *Joypad {
+	return &Joypad{mem: mem, sel: selectDirections | selectActions}
+}
+
+// Pressed returns the buttons that are pressed.
+func (j *Joypad) Pressed() Button {
+	return j.pressed
+}
+
+// Press sets the buttons that are pressed, releasing the others. Pressing a button that P1 selects requests a joypad
+// interrupt.
+func (j *Joypad) Press(b Button) {
+	before := j.lines()
+	j.pressed = b
+	if before&^j.lines() != 0 {
+		cpu.RequestInterrupt(j.mem, cpu.Joypad)
+	}
+}
+
+// lines returns the input lines of P1, which are 0 where a selected button is pressed.
+func (j *Joypad) lines() uint8 {
+	var low uint8
+	if j.sel&selectActions == 0 {
+		low |= uint8(j.pressed) & 0x0F
+	}
+	if j.sel&selectDirections == 0 {
+		low |= uint8(j.pressed) >> 4
+	}
+	return ^low & 0x0F
+}
+
+// Read implements cpu.Device.
+func (j *Joypad) Read(addr uint16) uint8 {
+	return 0xC0 | j.sel | j.lines()
+}
+
+// Write implements cpu.Device.
+func (j *Joypad) Write(addr uint16, v uint8) {
+	j.sel = v & (selectDirections | selectActions)
+}
@@ -0,0 +1,45 @@
+package joypad
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoypad(t *testing.T) {
+	mem := cpu.NewMemory()
+	j := New(mem)
+	mem.Map(P1, P1, j)
+	assert.Equal(t, uint8(0xFF), mem.Read(P1))
+
+	// pressing buttons that are not selected does not request an interrupt
+	j.Press(A | Down)
+	assert.Equal(t, uint8(0xFF), mem.Read(P1))
+	assert.Zero(t, mem.Read(cpu.IFAddr))
+
+	mem.Write(P1, 0x10)
+	assert.Equal(t, uint8(0xDE), mem.Read(P1))
+	mem.Write(P1, 0x20)
+	assert.Equal(t, uint8(0xE7), mem.Read(P1))
+	mem.Write(P1, 0x00)
+	assert.Equal(t, uint8(0xC6), mem.Read(P1))
+
+	// pressing a selected button does
+	j.Press(A | Down | Select)
+	assert.Equal(t, uint8(0xC2), mem.Read(P1))
+	assert.Equal(t, uint8(cpu.Joypad), mem.Read(cpu.IFAddr))
+	mem.Write(cpu.IFAddr, 0)
+
+	// and releasing one does not
+	j.Press(Select)
+	assert.Equal(t, uint8(0xCB), mem.Read(P1))
+	assert.Zero(t, mem.Read(cpu.IFAddr))
+	assert.Equal(t, Select, j.Pressed())
+}
+
+func TestButtonString(t *testing.T) {
+	assert.Equal(t, "", Button(0).String())
+	assert.Equal(t, "A+Up", (Up | A).String())
+	assert.Equal(t, "A+B+Select+Start+Right+Left+Up+Down", Button(0xFF).String())
+}
@@ -0,0 +1,25 @@
+package joypad
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of the joypad in save states.
+const StateVersion = 1
+
+// SaveState encodes the selection of P1, and the buttons that are pressed.
+func (j *Joypad) SaveState(e *state.Encoder) {
+	e.Uint8(j.sel)
+	e.Uint8(uint8(j.pressed))
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (j *Joypad) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("joypad: unsupported state version %d", version)
+	}
+	j.sel, j.pressed = d.Uint8(), Button(d.Uint8())
+	return d.Err()
+}
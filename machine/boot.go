@@ -0,0 +1,231 @@
+package machine
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/timer"
+)
+
+// Model is a model of the Gameboy.
+type Model int
+
+// Models of the Gameboy, which differ in the state their boot ROMs leave them in.
+const (
+	// DMG is the original Gameboy.
+	DMG Model = iota
+	// DMG0 is the early revision of the original Gameboy sold in Japan.
+	DMG0
+	// MGB is the Gameboy Pocket.
+	MGB
+	// SGB is the Super Gameboy.
+	SGB
+	// CGB is the Gameboy Color.
+	CGB
+	// AGB is the Gameboy Advance, running Gameboy software.
+	AGB
+	// Auto selects the model for a cartridge: see ModelFor.
+	Auto
+)
+
+var modelNames = []string{"DMG", "DMG0", "MGB", "SGB", "CGB", "AGB", "Auto"}
+
+// ModelFor returns the model to run a cartridge on: a CGB if its header has the CGB flag, and a DMG otherwise.
+func ModelFor(h *cartridge.Header) Model {
+	if h.CGBFlag&cartridge.CGBSupported != 0 {
+		return CGB
+	}
+	return DMG
+}
+
+// Color reports whether a model has the color screen, palettes and other hardware of the CGB.
+func (m Model) Color() bool {
+	return m == CGB || m == AGB
+}
+
+// String implements fmt.Stringer
+func (m Model) String() string {
+	if m < 0 || int(m) >= len(modelNames) {
+		return fmt.Sprintf("Model(%d)", int(m))
+	}
+	return modelNames[m]
+}
+
+// BANK is the address of the register that unmaps the boot ROM.
+const BANK = 0xFF50
+
+// Sizes of boot ROMs.
+const (
+	// DMGBootROMSize is the size of the boot ROMs of the DMG, MGB and SGB, mapped at $0000-$00FF.
+	DMGBootROMSize = 0x100
+	// CGBBootROMSize is the size of the boot ROMs of the CGB and AGB, mapped at $0000-$00FF and $0200-$08FF. The
+	// bytes from $0100 to $01FF are not mapped, since the cartridge header is there.
+	CGBBootROMSize = 0x900
+)
+
+// bootROM maps a boot ROM over the cartridge until BANK is written.
+type bootROM struct {
+	rom     []byte
+	mem     *cpu.Memory
+	cart    cartridge.Cartridge
+	enabled bool
+}
+
+// newBootROM maps rom over the cartridge.
+func newBootROM(mem *cpu.Memory, cart cartridge.Cartridge, rom []byte) (*bootROM, error) {
+	if len(rom) != DMGBootROMSize && len(rom) != CGBBootROMSize {
+		return nil, fmt.Errorf("machine: a boot ROM has %d or %d bytes, not %d", DMGBootROMSize, CGBBootROMSize, len(rom))
+	}
+	b := &bootROM{rom: rom, mem: mem, cart: cart}
+	mem.Map(BANK, BANK, b)
+	b.setEnabled(true)
+	return b, nil
+}
+
+// setEnabled maps the boot ROM, or the cartridge back.
+func (b *bootROM) setEnabled(enabled bool) {
+	b.enabled = enabled
+	var d cpu.Device = b.cart
+	if enabled {
+		d = b
+	}
+	b.mem.Map(0x0000, 0x00FF, d)
+	if len(b.rom) == CGBBootROMSize {
+		b.mem.Map(0x0200, 0x08FF, d)
+	}
+}
+
+// maps reports whether the boot ROM is at an address, while it is enabled.
+func (b *bootROM) maps(addr uint16) bool {
+	return addr < 0x0100 || len(b.rom) == CGBBootROMSize && addr >= 0x0200 && addr < CGBBootROMSize
+}
+
+// Read implements cpu.Device.
+func (b *bootROM) Read(addr uint16) uint8 {
+	if addr == BANK {
+		return 0xFF
+	}
+	return b.rom[addr]
+}
+
+// Write implements cpu.Device. Writes to the boot ROM reach the registers of the cartridge, and writing a value other
+// than 0 to BANK unmaps the boot ROM, until the machine is reset.
+func (b *bootROM) Write(addr uint16, v uint8) {
+	switch {
+	case addr != BANK:
+		b.cart.Write(addr, v)
+	case v != 0 && b.enabled:
+		b.setEnabled(false)
+	}
+}
+
+// ioValue is the value of an I/O register.
+type ioValue struct {
+	addr uint16
+	v    uint8
+}
+
+// postBootIO holds the values the boot ROM of the DMG leaves in the I/O registers, other than those of the PPU's
+// state, which it keeps itself.
+var postBootIO = []ioValue{
+	{0xFF00, 0xCF}, {0xFF01, 0x00}, {0xFF02, 0x7E}, {0xFF04, 0xAB}, {0xFF05, 0x00}, {0xFF06, 0x00}, {0xFF07, 0xF8},
+	{0xFF0F, 0xE1},
+	// sound
+	{0xFF10, 0x80}, {0xFF11, 0xBF}, {0xFF12, 0xF3}, {0xFF13, 0xFF}, {0xFF14, 0xBF}, {0xFF16, 0x3F}, {0xFF17, 0x00},
+	{0xFF18, 0xFF}, {0xFF19, 0xBF}, {0xFF1A, 0x7F}, {0xFF1B, 0xFF}, {0xFF1C, 0x9F}, {0xFF1D, 0xFF}, {0xFF1E, 0xBF},
+	{0xFF20, 0xFF}, {0xFF21, 0x00}, {0xFF22, 0x00}, {0xFF23, 0xBF}, {0xFF24, 0x77}, {0xFF25, 0xF3}, {0xFF26, 0xF1},
+	// LCD
+	{0xFF40, 0x91}, {0xFF42, 0x00}, {0xFF43, 0x00}, {0xFF45, 0x00}, {0xFF47, 0xFC}, {0xFF4A, 0x00}, {0xFF4B, 0x00},
+	{0xFFFF, 0x00},
+}
+
+// postBootModelIO holds the values that differ from postBootIO on other models. The divider of the SGB, CGB and AGB
+// depends on how long their boot ROMs run, so it is left as on the DMG.
+var postBootModelIO = map[Model][]ioValue{
+	DMG0: {{0xFF04, 0x18}},
+	SGB:  {{0xFF26, 0xF0}},
+	CGB:  postBootCGBIO,
+	AGB:  postBootCGBIO,
+}
+
+var postBootCGBIO = []ioValue{
+	{0xFF02, 0x7F}, {0xFF4D, 0x7E}, {0xFF4F, 0xFE}, {0xFF51, 0xFF}, {0xFF52, 0xFF}, {0xFF53, 0xFF}, {0xFF54, 0xFF},
+	{0xFF55, 0xFF}, {0xFF56, 0x3E}, {0xFF70, 0xF8},
+}
+
+// postBootRegisters holds the values the boot ROMs leave in AF, BC, DE and HL. The DMG and MGB also set the H and C
+// flags, unless the header checksum is 0.
+var postBootRegisters = map[Model][4]cpu.Register{
+	DMG0: {0x0100, 0xFF13, 0x00C1, 0x8403},
+	DMG:  {0x0180, 0x0013, 0x00D8, 0x014D},
+	MGB:  {0xFF80, 0x0013, 0x00D8, 0x014D},
+	SGB:  {0x0100, 0x0014, 0x0000, 0xC060},
+	CGB:  {0x1180, 0x0000, 0xFF56, 0x000D},
+	AGB:  {0x1100, 0x0100, 0xFF56, 0x000D},
+}
+
+// postBootCompatRegisters holds the values the boot ROMs of the CGB and AGB leave in AF, BC, DE and HL when they start
+// a cartridge for the DMG. B also holds the checksum of the title of cartridges licensed by Nintendo, which chose
+// their palettes.
+var postBootCompatRegisters = map[Model][4]cpu.Register{
+	CGB: {0x1180, 0x0000, 0x0008, 0x007C},
+	AGB: {0x1100, 0x0100, 0x0008, 0x007C},
+}
+
+// Colors of the compatibility palettes the boot ROM of the CGB gives cartridges for the DMG. It chooses them by the
+// checksum of the title of cartridges licensed by Nintendo, from a table that is not reproduced here, and gives the
+// others these.
+var (
+	compatBGPalette  = [4]uint16{0x7FFF, 0x1BEF, 0x6180, 0x0000}
+	compatObjPalette = [4]uint16{0x7FFF, 0x421F, 0x1CF2, 0x0000}
+)
+
+// postBoot sets the registers of the CPU, the I/O registers and the palettes to the values the boot ROM of the model
+// leaves them with when it starts the cartridge.
+func (m *Machine) postBoot() {
+	c := m.CPU
+	regs := postBootRegisters[m.Model]
+	if m.Model.Color() && !m.CGBMode {
+		regs = postBootCompatRegisters[m.Model]
+		if m.nintendoLicensed() {
+			var sum uint8
+			for addr := uint16(cartridge.TitleOffset); addr < cartridge.TitleOffset+cartridge.TitleLength; addr++ {
+				sum += m.Memory.Peek(addr)
+			}
+			regs[1] += cpu.Register(sum) << 8
+		}
+	}
+	c.AF, c.BC, c.DE, c.HL = regs[0], regs[1], regs[2], regs[3]
+	if (m.Model == DMG || m.Model == MGB) && m.Cartridge.Header().HeaderChecksum != 0 {
+		c.AF |= 0x30
+	}
+	c.SP, c.PC = 0xFFFE, 0x0100
+
+	for _, r := range append(postBootIO, postBootModelIO[m.Model]...) {
+		if r.addr == timer.DIV {
+			// writing DIV resets it
+			m.Timer.SetCounter(uint16(r.v) << 8)
+			continue
+		}
+		m.Memory.Poke(r.addr, r.v)
+	}
+
+	switch {
+	case m.CGBMode:
+		// the background is white
+		for i := 0; i < 8; i++ {
+			m.PPU.SetPalette(false, i, [4]uint16{0x7FFF, 0x7FFF, 0x7FFF, 0x7FFF})
+		}
+	case m.Model.Color():
+		m.PPU.SetPalette(false, 0, compatBGPalette)
+		m.PPU.SetPalette(true, 0, compatObjPalette)
+		m.PPU.SetPalette(true, 1, compatObjPalette)
+	}
+}
+
+// nintendoLicensed reports whether the header names Nintendo as the licensee.
+func (m *Machine) nintendoLicensed() bool {
+	h := m.Cartridge.Header()
+	return h.OldLicensee == 0x01 || h.OldLicensee == 0x33 && h.NewLicensee == "01"
+}
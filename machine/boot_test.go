@@ -0,0 +1,172 @@
+package machine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBootROM returns a boot ROM of size bytes that stores $42 at $C000, and unmaps itself from $00FE.
+func testBootROM(size int) []byte {
+	rom := make([]byte, size)
+	copy(rom, []byte{
+		0x3E, 0x42, // LD A, $42
+		0xEA, 0x00, 0xC0, // LD [$C000], A
+		0x3E, 0x01, // LD A, 1
+		0xC3, 0xFE, 0x00, // JP $00FE
+	})
+	copy(rom[0xFE:], []byte{0xE0, 0x50}) // LDH [$50], A
+	for i := 0x200; i < size; i++ {
+		rom[i] = 0xB0
+	}
+	return rom
+}
+
+func TestBootROM(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "BOOT"})
+	require.NoError(t, err)
+
+	for _, size := range []int{machine.DMGBootROMSize, machine.CGBBootROMSize} {
+		m, err := machine.NewWithOptions(img.ROM, machine.Options{BootROM: testBootROM(size)})
+		require.NoError(t, err)
+		assert.Equal(t, cpu.Register(0), m.CPU.PC)
+		assert.Equal(t, cpu.Register(0), m.CPU.AF)
+		assert.Equal(t, uint8(0), m.Memory.Read(0xFF40))
+		assert.Equal(t, uint8(0x3E), m.Memory.Read(0x0000))
+		assert.Equal(t, img.ROM[0x0150], m.Memory.Read(0x0150))
+		if size == machine.CGBBootROMSize {
+			assert.Equal(t, uint8(0xB0), m.Memory.Read(0x0200))
+		} else {
+			assert.Equal(t, img.ROM[0x0200], m.Memory.Read(0x0200))
+		}
+
+		// writes to the boot ROM reach the cartridge
+		m.Memory.Write(0x0000, 0x0A)
+		assert.Equal(t, uint8(0x3E), m.Memory.Read(0x0000))
+
+		// a save state restores the boot ROM
+		var saved bytes.Buffer
+		require.NoError(t, m.SaveState(&saved))
+
+		for m.CPU.PC != 0x0100 {
+			_, err := m.Step()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, uint8(0x42), m.Memory.Read(0xC000))
+		assert.Equal(t, img.ROM[0x0000], m.Memory.Read(0x0000))
+		assert.Equal(t, img.ROM[0x0200], m.Memory.Read(0x0200))
+		assert.Equal(t, uint8(0xFF), m.Memory.Read(machine.BANK))
+
+		// the boot ROM cannot be mapped back
+		m.Memory.Write(machine.BANK, 0)
+		assert.Equal(t, img.ROM[0x0000], m.Memory.Read(0x0000))
+
+		require.NoError(t, m.LoadState(bytes.NewReader(saved.Bytes())))
+		assert.Equal(t, uint8(0x3E), m.Memory.Read(0x0000))
+
+		other, err := machine.New(img.ROM)
+		require.NoError(t, err)
+		assert.EqualError(t, other.LoadState(bytes.NewReader(saved.Bytes())),
+			`machine: section "MACH": the save state is running the boot ROM, and the machine has none`)
+	}
+
+	_, err = machine.NewWithOptions(img.ROM, machine.Options{BootROM: make([]byte, 0x200)})
+	assert.Error(t, err)
+}
+
+func TestPostBoot(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "POST BOOT"})
+	require.NoError(t, err)
+	cgb, err := link.Link(link.Options{Fix: true, Title: "POST BOOT", CGBFlag: cartridge.CGBSupported})
+	require.NoError(t, err)
+	licensed, err := link.Link(link.Options{Fix: true, Title: "POST BOOT", Licensee: "01"})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name           string
+		model          machine.Model
+		rom            []byte
+		af, bc, de, hl cpu.Register
+		io             map[uint16]uint8
+	}{
+		{"DMG0", machine.DMG0, img.ROM, 0x0100, 0xFF13, 0x00C1, 0x8403, map[uint16]uint8{0xFF04: 0x18, 0xFF26: 0xF1}},
+		{"DMG", machine.DMG, img.ROM, 0x01B0, 0x0013, 0x00D8, 0x014D, map[uint16]uint8{0xFF04: 0xAB, 0xFF02: 0x7E, 0xFF0F: 0xE1}},
+		{"MGB", machine.MGB, img.ROM, 0xFFB0, 0x0013, 0x00D8, 0x014D, map[uint16]uint8{0xFF04: 0xAB}},
+		{"SGB", machine.SGB, img.ROM, 0x0100, 0x0014, 0x0000, 0xC060, map[uint16]uint8{0xFF26: 0xF0}},
+		{"CGB", machine.CGB, cgb.ROM, 0x1180, 0x0000, 0xFF56, 0x000D, map[uint16]uint8{0xFF02: 0x7F, 0xFF70: 0xF8}},
+		{"AGB", machine.AGB, cgb.ROM, 0x1100, 0x0100, 0xFF56, 0x000D, map[uint16]uint8{0xFF4D: 0x7E}},
+		// cartridges for the DMG run in the compatibility mode of the CGB
+		{"CGB compat", machine.CGB, img.ROM, 0x1180, 0x0000, 0x0008, 0x007C, nil},
+		{"AGB compat", machine.AGB, img.ROM, 0x1100, 0x0100, 0x0008, 0x007C, nil},
+		// B has the checksum of the title of cartridges licensed by Nintendo, $9A for "POST BOOT"
+		{"CGB licensed", machine.CGB, licensed.ROM, 0x1180, 0x9A00, 0x0008, 0x007C, nil},
+		{"AGB licensed", machine.AGB, licensed.ROM, 0x1100, 0x9B00, 0x0008, 0x007C, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := machine.NewWithOptions(test.rom, machine.Options{Model: test.model})
+			require.NoError(t, err)
+			c := m.CPU
+			assert.Equal(t, []cpu.Register{test.af, test.bc, test.de, test.hl, 0xFFFE, 0x0100},
+				[]cpu.Register{c.AF, c.BC, c.DE, c.HL, c.SP, c.PC})
+			assert.Equal(t, uint8(0x91), m.Memory.Read(0xFF40))
+			assert.Equal(t, uint8(0xFC), m.Memory.Read(0xFF47))
+			assert.Equal(t, uint8(0xCF), m.Memory.Read(0xFF00))
+			for addr, v := range test.io {
+				assert.Equal(t, v, m.Memory.Read(addr), "$%04X", addr)
+			}
+		})
+	}
+
+	// the DMG clears H and C if the header checksum is 0
+	rom := append([]byte(nil), img.ROM...)
+	rom[0x14D] = 0
+	m, err := machine.New(rom)
+	require.NoError(t, err)
+	assert.Equal(t, cpu.Register(0x0180), m.CPU.AF)
+
+	// save states are for a model
+	var saved bytes.Buffer
+	require.NoError(t, m.SaveState(&saved))
+	other, err := machine.NewWithOptions(rom, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	assert.EqualError(t, other.LoadState(&saved), `machine: section "MACH": the save state is for a DMG, not a CGB`)
+
+	_, err = machine.NewWithOptions(img.ROM, machine.Options{Model: machine.Model(42)})
+	assert.Error(t, err)
+	assert.Equal(t, "Model(42)", machine.Model(42).String())
+}
+
+func TestModelSelection(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "MODEL"})
+	require.NoError(t, err)
+	cgb, err := link.Link(link.Options{Fix: true, Title: "MODEL", CGBFlag: cartridge.CGBSupported})
+	require.NoError(t, err)
+
+	m, err := machine.NewWithOptions(img.ROM, machine.Options{Model: machine.Auto})
+	require.NoError(t, err)
+	assert.Equal(t, machine.DMG, m.Model)
+	assert.False(t, m.CGBMode)
+	assert.NotNil(t, m.CPU.IDU)
+
+	m, err = machine.NewWithOptions(cgb.ROM, machine.Options{Model: machine.Auto})
+	require.NoError(t, err)
+	assert.Equal(t, machine.CGB, m.Model)
+	assert.True(t, m.CGBMode)
+	assert.Nil(t, m.CPU.IDU)
+	// the background palettes are white in CGB mode
+	assert.Equal(t, uint16(0x7FFF), m.PPU.Color(7<<2|3))
+
+	// cartridges for the DMG get the compatibility palettes
+	m, err = machine.NewWithOptions(img.ROM, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	assert.False(t, m.CGBMode)
+	assert.Equal(t, []uint16{0x7FFF, 0x1BEF, 0x421F, 0x1CF2},
+		[]uint16{m.PPU.Color(0), m.PPU.Color(1), m.PPU.Color(ppu.PixelObject | 1), m.PPU.Color(ppu.PixelObject | 1<<2 | 2)})
+}
@@ -0,0 +1,257 @@
+// Package machine assembles a complete Gameboy: the CPU, its memory, the cartridge and the other devices on the bus.
+package machine
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/gopherpocket/gopherpocket/serial"
+	"github.com/gopherpocket/gopherpocket/timer"
+)
+
+// ClockRate is the number of clock cycles a Gameboy executes per second. The CPU of the CGB executes twice as many at
+// double speed.
+const ClockRate = 4194304
+
+// Machine is a Gameboy with a cartridge inserted.
+type Machine struct {
+	CPU       *cpu.SimpleCore
+	Memory    *cpu.Memory
+	Cartridge cartridge.Cartridge
+	PPU       *ppu.PPU
+	Joypad    *joypad.Joypad
+	Timer     *timer.Timer
+	Serial    *serial.Serial
+
+	// Model is the model of Gameboy the machine emulates.
+	Model Model
+	// CGBMode is set when a CGB or AGB runs a cartridge for the CGB, rather than in the compatibility mode of the DMG.
+	CGBMode bool
+
+	// Cycles counts the clock cycles executed since the machine was started, at normal speed, which the PPU runs at
+	// even while the CPU of the CGB is at double speed.
+	Cycles uint64
+
+	// Tracer, if not nil, is called before each instruction is executed.
+	Tracer Tracer
+
+	// clock is the real time clock of the cartridge, or nil if it has none.
+	clock cartridge.Clock
+	// boot is the boot ROM, or nil if the machine started without one.
+	boot *bootROM
+	// speed is the speed of the CPU, switched through KEY1 on the CGB.
+	speed speed
+	wram  *wram
+	// io holds the I/O registers no other device is mapped to.
+	io *ioRegisters
+	// stepped counts the clock cycles at normal speed of the current step.
+	stepped int
+	// core executes the instructions of CPU: CPU itself, or a cpu.CachedCore sharing its state.
+	core cpu.Core
+}
+
+// Options configure a Machine.
+type Options struct {
+	// Model is the model of Gameboy to emulate, or Auto to choose from the header of the cartridge.
+	Model Model
+	// BootROM, if not nil, is a boot ROM for the model, which the machine runs from power on. Otherwise the machine
+	// starts in the state the boot ROM of the model leaves it in.
+	BootROM []byte
+
+	// CycleAccurate selects the cycle accurate mode of the CPU, where the other devices advance between the memory
+	// accesses of each instruction, rather than after it. It is slower, and needed by tests of the timing of accesses.
+	CycleAccurate bool
+
+	// FastCore executes instructions with a cpu.CachedCore, which caches them decoded, rather than decoding each one
+	// it executes. It runs the same, faster, and is meant for running many ROMs, such as in tests.
+	FastCore bool
+
+	// Seed, if not 0, seeds the pseudo random values work RAM and high RAM start filled with, as they are on the
+	// hardware. Otherwise they start zeroed. Either way a machine starts the same every time.
+	Seed int64
+}
+
+// Tracer traces the instructions a machine executes, such as the Logger of package trace. Tracing is off while the
+// Tracer of the machine is nil, which costs a single comparison per step.
+type Tracer interface {
+	// Trace is called with the machine in its state before an instruction is executed.
+	Trace(m *Machine) error
+}
+
+// New constructs a DMG running rom, in the state the boot ROM leaves it in when it starts the cartridge.
+func New(rom []byte) (*Machine, error) {
+	return NewWithOptions(rom, Options{})
+}
+
+// NewWithOptions constructs a Machine running rom, configured by opts.
+func NewWithOptions(rom []byte, opts Options) (*Machine, error) {
+	cart, err := cartridge.New(rom)
+	if err != nil {
+		return nil, err
+	}
+	model := opts.Model
+	if model == Auto {
+		model = ModelFor(cart.Header())
+	}
+	if _, ok := postBootRegisters[model]; !ok {
+		return nil, fmt.Errorf("machine: unknown model %v", model)
+	}
+	cgbMode := model.Color() && cart.Header().CGBFlag&cartridge.CGBSupported != 0
+
+	mem := cpu.NewMemory()
+	w := newWRAM(model.Color(), cgbMode)
+	if opts.Seed != 0 {
+		r := rand.New(rand.NewSource(opts.Seed))
+		for i := range w.data {
+			w.data[i] = uint8(r.Intn(256))
+		}
+		for addr := 0xFF80; addr <= 0xFFFE; addr++ {
+			mem.Poke(uint16(addr), uint8(r.Intn(256)))
+		}
+	}
+	io := newIORegisters(model.Color())
+	mem.Map(0xFF00, 0xFF7F, io)
+	mem.Map(0x0000, 0x7FFF, cart)
+	mem.Map(0xA000, 0xBFFF, cart)
+	mem.Map(0xC000, 0xFDFF, w)
+	mem.Map(0xFEA0, 0xFEFF, unusable{model.Color()})
+
+	p := ppu.New(mem)
+	if model.Color() {
+		p = ppu.NewColor(mem, cgbMode)
+		mem.Map(ppu.BCPS, ppu.OCPD, p)
+	}
+	mem.Map(0x8000, 0x9FFF, p)
+	mem.Map(0xFE00, 0xFE9F, p)
+	mem.Map(ppu.LCDC, ppu.WX, p)
+
+	j := joypad.New(mem)
+	mem.Map(joypad.P1, joypad.P1, j)
+	tm := timer.New(mem)
+	mem.Map(timer.DIV, timer.TAC, tm)
+	s := serial.New(mem, model.Color())
+	mem.Map(serial.SB, serial.SC, s)
+
+	m := &Machine{
+		CPU:       cpu.NewSimpleCore(mem),
+		Memory:    mem,
+		Cartridge: cart,
+		PPU:       p,
+		Joypad:    j,
+		Timer:     tm,
+		Serial:    s,
+		Model:     model,
+		CGBMode:   cgbMode,
+		wram:      w,
+		io:        io,
+	}
+	m.clock, _ = cart.(cartridge.Clock)
+	if opts.CycleAccurate {
+		m.CPU.Tick = m.tick
+	}
+	m.core = m.CPU
+	if opts.FastCore {
+		cc := cpu.NewCachedCore(m.CPU)
+		cc.Bank = m.codeBank
+		m.core = cc
+	}
+	if model.Color() {
+		m.speed.enabled = cgbMode
+		mem.Map(KEY1, KEY1, &m.speed)
+		mem.Map(SVBK, SVBK, w)
+	} else {
+		m.CPU.IDU = p.CorruptOAM
+	}
+
+	if opts.BootROM != nil {
+		// the CPU starts from $0000 with its registers cleared
+		if m.boot, err = newBootROM(mem, cart, opts.BootROM); err != nil {
+			return nil, err
+		}
+	} else {
+		m.postBoot()
+	}
+	return m, nil
+}
+
+// Step executes a single instruction, returning the number of clock cycles it took at normal speed. The timer, the
+// serial port and OAM DMA are clocked by the CPU, so they advance twice as many cycles at double speed.
+func (m *Machine) Step() (int, error) {
+	if m.speed.pause > 0 {
+		// the CPU and the divider are paused while the speed switches
+		m.speed.pause -= 4
+		m.advance(4)
+		return 4, nil
+	}
+	if m.Tracer != nil && !m.CPU.Halted {
+		if err := m.Tracer.Trace(m); err != nil {
+			return 0, err
+		}
+	}
+	m.stepped = 0
+	cycles, err := m.core.Step()
+	if m.CPU.Stopped && m.speed.armed {
+		m.switchSpeed()
+	}
+	m.tick(cycles)
+	return m.stepped, err
+}
+
+// tick advances the devices by a number of clock cycles of the CPU.
+func (m *Machine) tick(cycles int) {
+	m.Timer.Step(cycles)
+	m.Serial.Step(cycles)
+	if m.speed.double {
+		cycles /= 2
+	}
+	m.stepped += cycles
+	m.advance(cycles)
+}
+
+// advance advances the devices clocked at normal speed by a number of clock cycles.
+func (m *Machine) advance(cycles int) {
+	m.Cycles += uint64(cycles)
+	m.PPU.Step(cycles)
+	if m.clock != nil {
+		m.clock.Step(cycles)
+	}
+}
+
+// RunFrame runs until the PPU completes a frame.
+func (m *Machine) RunFrame() error {
+	frames := m.PPU.Frames
+	for m.PPU.Frames == frames {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// codeBank returns the bank of ROM mapped at an address of $0000-$7FFF, or -1 while the boot ROM is mapped there.
+func (m *Machine) codeBank(addr uint16) int {
+	if m.boot != nil && m.boot.enabled && m.boot.maps(addr) {
+		return -1
+	}
+	if addr >= 0x4000 {
+		return m.Cartridge.ROMBank()
+	}
+	if c, ok := m.Cartridge.(cartridge.LowBanked); ok {
+		return c.LowROMBank()
+	}
+	return 0
+}
+
+// CycleAccurate reports whether the machine was constructed with the cycle accurate mode of the CPU.
+func (m *Machine) CycleAccurate() bool {
+	return m.CPU.Tick != nil
+}
+
+// ROMBank returns the ROM bank mapped into $4000-$7FFF.
+func (m *Machine) ROMBank() int {
+	return m.Cartridge.ROMBank()
+}
@@ -0,0 +1,206 @@
+package machine_test
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachine(t *testing.T) {
+	img := testrig.ROM(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, BANK(Far)
+	ld [$2000], a
+	call Far
+	halt
+
+SECTION "Far", ROMX[$4000], BANK[3]
+Far:
+	ld a, $42
+	ld [$C000], a
+	ret
+`, link.Options{Title: "MACHINE", Type: cartridge.MBC1})
+
+	m, err := machine.New(img.ROM)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.ROMBank())
+
+	for !m.CPU.Halted {
+		_, err := m.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, m.ROMBank())
+	assert.Equal(t, uint8(0x42), m.Memory.Read(0xC000))
+	// NOP, JP, LD, LD, CALL, LD, LD, RET, HALT
+	assert.Equal(t, uint64(4+16+8+16+24+8+16+16+4), m.Cycles)
+}
+
+func TestSeed(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "SEED"})
+	require.NoError(t, err)
+	wram := func(seed int64) []uint8 {
+		m, err := machine.NewWithOptions(img.ROM, machine.Options{Seed: seed})
+		require.NoError(t, err)
+		var b []uint8
+		for addr := uint16(0xC000); addr < 0xC100; addr++ {
+			b = append(b, m.Memory.Read(addr))
+		}
+		return b
+	}
+	assert.Equal(t, make([]uint8, 0x100), wram(0))
+	assert.Equal(t, wram(1), wram(1))
+	assert.NotEqual(t, wram(1), wram(2))
+	assert.NotEqual(t, make([]uint8, 0x100), wram(1))
+}
+
+func TestCycleAccurate(t *testing.T) {
+	img := testrig.ROM(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, [$FF04]
+	halt
+`, link.Options{Title: "ACCURATE"})
+
+	// the divider increments during the LD, before its read in the cycle accurate mode
+	for _, accurate := range []bool{false, true} {
+		m, err := machine.NewWithOptions(img.ROM, machine.Options{CycleAccurate: accurate})
+		require.NoError(t, err)
+		m.CPU.PC = 0x150
+		m.Timer.SetCounter(0x100 - 8)
+		cycles, err := m.Step()
+		require.NoError(t, err)
+		assert.Equal(t, 16, cycles)
+		assert.Equal(t, uint64(16), m.Cycles)
+		want := uint8(0)
+		if accurate {
+			want = 1
+		}
+		assert.Equal(t, want, m.CPU.AF.Hi(), "accurate: %v", accurate)
+	}
+}
+
+// fastSource calls into two banks of ROM alternately, and into code it copies to HRAM and modifies.
+const fastSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $FF80
+	ld de, Routine
+	ld b, RoutineEnd - Routine
+.copy
+	ld a, [de]
+	ld [hl+], a
+	inc de
+	dec b
+	jr nz, .copy
+.loop
+	ld a, 2
+	ld [$2000], a
+	call $4000
+	ld a, 3
+	ld [$2000], a
+	call $4000
+	call $FF80
+	ld hl, $FF81
+	inc [hl]
+	jr .loop
+
+Routine:
+	ld a, 0
+	add a, c
+	ld c, a
+	ret
+RoutineEnd:
+
+SECTION "Two", ROMX[$4000], BANK[2]
+	inc d
+	ret
+
+SECTION "Three", ROMX[$4000], BANK[3]
+	dec e
+	ret
+`
+
+func TestFastCore(t *testing.T) {
+	img := testrig.ROM(t, fastSource, link.Options{Title: "FAST", Type: cartridge.MBC1})
+
+	for _, boot := range [][]byte{nil, testBootROM(machine.DMGBootROMSize)} {
+		slow, err := machine.NewWithOptions(img.ROM, machine.Options{BootROM: boot})
+		require.NoError(t, err)
+		fast, err := machine.NewWithOptions(img.ROM, machine.Options{BootROM: boot, FastCore: true})
+		require.NoError(t, err)
+		assert.Equal(t, record(t, slow, 20000), record(t, fast, 20000))
+	}
+}
+
+// benchmarkSource runs a loop of common instructions, calling into banks of ROM.
+const benchmarkSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $C000
+	ld b, 0
+.loop
+	ld a, [hl]
+	add a, b
+	ld [hl+], a
+	xor c
+	ld c, a
+	cp $80
+	jr c, .skip
+	ld a, 2
+	ld [$2000], a
+	call $4000
+.skip
+	push bc
+	pop bc
+	dec b
+	jr nz, .loop
+	ld hl, $C000
+	jr .loop
+
+SECTION "Two", ROMX[$4000], BANK[2]
+	inc d
+	ret
+`
+
+func BenchmarkRunFrame(b *testing.B) {
+	img := testrig.ROM(b, benchmarkSource, link.Options{Title: "FAST", Type: cartridge.MBC1})
+	for _, bench := range []struct {
+		name string
+		opts machine.Options
+	}{
+		{"Simple", machine.Options{}},
+		{"Fast", machine.Options{FastCore: true}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			m, err := machine.NewWithOptions(img.ROM, bench.opts)
+			require.NoError(b, err)
+			for i := 0; i < b.N; i++ {
+				if err := m.RunFrame(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package machine
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// SVBK is the address of the register of the CGB that selects the bank of work RAM at $D000-$DFFF.
+const SVBK = 0xFF70
+
+// memoryStateVersion is the version of the encoding of work RAM and the I/O registers in save states.
+const memoryStateVersion = 1
+
+// wramBankSize is the size of a bank of work RAM.
+const wramBankSize = 0x1000
+
+// wram is work RAM, at $C000-$DFFF and mirrored by echo RAM at $E000-$FDFF. Bank 0 is at $C000-$CFFF, and at
+// $D000-$DFFF the bank SVBK selects from 1 to 7 in CGB mode, or bank 1 otherwise.
+type wram struct {
+	data []uint8
+	// svbk holds the bank SVBK selects, where 0 selects bank 1.
+	svbk uint8
+	// banked is set in CGB mode, where SVBK switches banks.
+	banked bool
+}
+
+// newWRAM constructs the work RAM of the DMG, or the 8 banks of the CGB if color is set.
+func newWRAM(color, cgbMode bool) *wram {
+	banks := 2
+	if color {
+		banks = 8
+	}
+	return &wram{data: make([]uint8, banks*wramBankSize), banked: cgbMode}
+}
+
+// offset returns the offset in data of an address of work RAM or echo RAM.
+func (w *wram) offset(addr uint16) int {
+	off := int(addr-0xC000) & 0x1FFF
+	if off < wramBankSize {
+		return off
+	}
+	bank := int(w.svbk)
+	if bank == 0 || !w.banked {
+		bank = 1
+	}
+	return bank*wramBankSize + off - wramBankSize
+}
+
+// Read implements cpu.Device.
+func (w *wram) Read(addr uint16) uint8 {
+	if addr == SVBK {
+		return 0xF8 | w.svbk
+	}
+	return w.data[w.offset(addr)]
+}
+
+// Write implements cpu.Device.
+func (w *wram) Write(addr uint16, v uint8) {
+	if addr == SVBK {
+		w.svbk = v & 7
+		return
+	}
+	w.data[w.offset(addr)] = v
+}
+
+// SaveState encodes the contents of work RAM and its selected bank.
+func (w *wram) SaveState(e *state.Encoder) {
+	e.Slice(w.data)
+	e.Uint8(w.svbk)
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (w *wram) LoadState(d *state.Decoder, version int) error {
+	if version > memoryStateVersion {
+		return fmt.Errorf("unsupported work RAM state version %d", version)
+	}
+	d.Slice(w.data)
+	w.svbk = d.Uint8()
+	return d.Err()
+}
+
+// loadLegacyMemory copies work RAM and the I/O registers from the memory section of a save state from before they
+// were devices of their own, when the machine section was older than version 4, and bank 1 was at $D000.
+func (m *Machine) loadLegacyMemory(d *state.Decoder) error {
+	var buf [0x10000]uint8
+	d.Slice(buf[:])
+	if err := d.Err(); err != nil {
+		return err
+	}
+	copy(m.wram.data[:2*wramBankSize], buf[0xC000:0xE000])
+	m.wram.svbk = 0
+	copy(m.io.regs[:], buf[0xFF00:0xFF80])
+	return nil
+}
+
+// unusable is the area at $FEA0-$FEFF after OAM, which ignores writes. The DMG reads it as 0, and the CGB as the high
+// nibble of the low byte of the address, repeated.
+type unusable struct {
+	color bool
+}
+
+// Read implements cpu.Device.
+func (u unusable) Read(addr uint16) uint8 {
+	if !u.color {
+		return 0
+	}
+	return (uint8(addr) >> 4) * 0x11
+}
+
+// Write implements cpu.Device.
+func (u unusable) Write(addr uint16, v uint8) {}
+
+// ioRegisters holds the I/O registers at $FF00-$FF7F that no device is mapped to: the interrupt flags, and those of
+// hardware that is not emulated, such as sound. Bits that are unused, and registers that do not exist, read as 1.
+type ioRegisters struct {
+	regs [0x80]uint8
+	// unused holds the bits of each register that read as 1.
+	unused *[0x80]uint8
+}
+
+// dmgUnusedIO and cgbUnusedIO hold the bits of the I/O registers of the DMG and CGB that read as 1.
+var dmgUnusedIO, cgbUnusedIO = unusedIO(false), unusedIO(true)
+
+// unusedIO returns the bits of the I/O registers that read as 1, on the DMG or the CGB.
+func unusedIO(color bool) *[0x80]uint8 {
+	var unused [0x80]uint8
+	for i := range unused {
+		unused[i] = 0xFF
+	}
+	// IF
+	unused[0x0F] = 0xE0
+	// sound, and wave RAM at $FF30-$FF3F
+	copy(unused[0x10:], []uint8{
+		0x80, 0x3F, 0x00, 0xFF, 0xBF, 0xFF, 0x3F, 0x00, 0xFF, 0xBF, 0x7F, 0xFF, 0x9F, 0xFF, 0xBF, 0xFF,
+		0xFF, 0x00, 0x00, 0xBF, 0x00, 0x00, 0x70, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if color {
+		// VBK, RP, OPRI and the undocumented registers at $FF72-$FF75
+		unused[0x4F] = 0xFE
+		unused[0x56] = 0x3E
+		unused[0x6C] = 0xFE
+		unused[0x72], unused[0x73], unused[0x74], unused[0x75] = 0x00, 0x00, 0x00, 0x8F
+	}
+	return &unused
+}
+
+// newIORegisters constructs the I/O registers of the DMG, or of the CGB if color is set.
+func newIORegisters(color bool) *ioRegisters {
+	if color {
+		return &ioRegisters{unused: cgbUnusedIO}
+	}
+	return &ioRegisters{unused: dmgUnusedIO}
+}
+
+// Read implements cpu.Device.
+func (r *ioRegisters) Read(addr uint16) uint8 {
+	return r.regs[addr-0xFF00] | r.unused[addr-0xFF00]
+}
+
+// Write implements cpu.Device.
+func (r *ioRegisters) Write(addr uint16, v uint8) {
+	r.regs[addr-0xFF00] = v
+}
+
+// SaveState encodes the values of the registers.
+func (r *ioRegisters) SaveState(e *state.Encoder) {
+	e.Slice(r.regs[:])
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (r *ioRegisters) LoadState(d *state.Decoder, version int) error {
+	if version > memoryStateVersion {
+		return fmt.Errorf("unsupported I/O state version %d", version)
+	}
+	d.Slice(r.regs[:])
+	return d.Err()
+}
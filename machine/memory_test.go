@@ -0,0 +1,95 @@
+package machine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWRAM(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "WRAM", CGBFlag: cartridge.CGBSupported})
+	require.NoError(t, err)
+	m, err := machine.NewWithOptions(img.ROM, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	mem := m.Memory
+	assert.Equal(t, uint8(0xF8), mem.Read(machine.SVBK))
+
+	// echo RAM mirrors work RAM
+	mem.Write(0xC123, 0x42)
+	assert.Equal(t, uint8(0x42), mem.Read(0xE123))
+	mem.Write(0xFDFF, 0x43)
+	assert.Equal(t, uint8(0x43), mem.Read(0xDDFF))
+
+	// SVBK selects the bank at $D000, where 0 selects bank 1
+	for bank := uint8(0); bank < 8; bank++ {
+		mem.Write(machine.SVBK, bank)
+		mem.Write(0xD000, 0x10+bank)
+	}
+	assert.Equal(t, uint8(0xFF), mem.Read(machine.SVBK))
+	for bank := uint8(1); bank < 8; bank++ {
+		mem.Write(machine.SVBK, bank)
+		assert.Equal(t, 0x10+bank, mem.Read(0xD000))
+		assert.Equal(t, 0x10+bank, mem.Read(0xF000))
+		assert.Equal(t, uint8(0x42), mem.Read(0xC123))
+	}
+	mem.Write(machine.SVBK, 0)
+	assert.Equal(t, uint8(0x11), mem.Read(0xD000))
+
+	// save states keep the banks
+	var saved bytes.Buffer
+	require.NoError(t, m.SaveState(&saved))
+	restored, err := machine.NewWithOptions(img.ROM, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&saved))
+	restored.Memory.Write(machine.SVBK, 5)
+	assert.Equal(t, uint8(0x15), restored.Memory.Read(0xD000))
+
+	// in the compatibility mode SVBK does not switch banks
+	m, err = machine.NewWithOptions(speedROM(t, 0), machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	m.Memory.Write(0xD000, 1)
+	m.Memory.Write(machine.SVBK, 2)
+	assert.Equal(t, uint8(1), m.Memory.Read(0xD000))
+}
+
+func TestUnusedMemory(t *testing.T) {
+	img, err := link.Link(link.Options{Fix: true, Title: "UNUSED"})
+	require.NoError(t, err)
+	dmg, err := machine.New(img.ROM)
+	require.NoError(t, err)
+	cgb, err := machine.NewWithOptions(img.ROM, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+
+	// $FEA0-$FEFF ignores writes, and reads as 0 on the DMG, and the high nibble of the address on the CGB
+	for _, m := range []*machine.Machine{dmg, cgb} {
+		m.Memory.Write(0xFEB4, 0x42)
+	}
+	assert.Equal(t, uint8(0x00), dmg.Memory.Read(0xFEB4))
+	assert.Equal(t, uint8(0xBB), cgb.Memory.Read(0xFEB4))
+
+	// registers that do not exist read as $FF, and unused bits as 1
+	for _, m := range []*machine.Machine{dmg, cgb} {
+		for _, addr := range []uint16{0xFF03, 0xFF08, 0xFF27, 0xFF4C, 0xFF7F} {
+			m.Memory.Write(addr, 0)
+			assert.Equal(t, uint8(0xFF), m.Memory.Read(addr), "$%04X", addr)
+		}
+		m.Memory.Write(0xFF0F, 0x01)
+		assert.Equal(t, uint8(0xE1), m.Memory.Read(0xFF0F))
+		m.Memory.Write(0xFF1A, 0x00)
+		assert.Equal(t, uint8(0x7F), m.Memory.Read(0xFF1A))
+		m.Memory.Write(0xFF30, 0x12)
+		assert.Equal(t, uint8(0x12), m.Memory.Read(0xFF30))
+		assert.Equal(t, uint8(0xFF), m.Memory.Read(machine.BANK))
+	}
+	dmg.Memory.Write(machine.SVBK, 0)
+	assert.Equal(t, uint8(0xFF), dmg.Memory.Read(machine.SVBK))
+	dmg.Memory.Write(0xFF72, 0)
+	assert.Equal(t, uint8(0xFF), dmg.Memory.Read(0xFF72))
+	cgb.Memory.Write(0xFF72, 0)
+	assert.Equal(t, uint8(0x00), cgb.Memory.Read(0xFF72))
+}
@@ -0,0 +1,63 @@
+package machine
+
+import (
+	"github.com/gopherpocket/gopherpocket/timer"
+)
+
+// KEY1 is the address of the register of the CGB that prepares a switch between normal and double speed, which STOP
+// then performs.
+const KEY1 = 0xFF4D
+
+// Bits of KEY1.
+const (
+	key1DoubleSpeed = 1 << 7
+	key1Armed       = 1 << 0
+)
+
+// SpeedSwitchCycles is the number of clock cycles the CPU is paused for while it switches speed.
+const SpeedSwitchCycles = 8200
+
+// speed is KEY1, and the speed of the CPU of the CGB.
+type speed struct {
+	// enabled is set in CGB mode, where the speed can be switched.
+	enabled bool
+	double  bool
+	armed   bool
+	// pause counts the clock cycles left of the pause of a speed switch.
+	pause int
+}
+
+// Read implements cpu.Device.
+func (s *speed) Read(addr uint16) uint8 {
+	v := uint8(0x7E)
+	if s.double {
+		v |= key1DoubleSpeed
+	}
+	if s.armed {
+		v |= key1Armed
+	}
+	return v
+}
+
+// Write implements cpu.Device. Only bit 0 is writable, and only in CGB mode.
+func (s *speed) Write(addr uint16, v uint8) {
+	if s.enabled {
+		s.armed = v&key1Armed != 0
+	}
+}
+
+// DoubleSpeed reports whether the CPU runs at double speed.
+func (m *Machine) DoubleSpeed() bool {
+	return m.speed.double
+}
+
+// switchSpeed switches the speed of the CPU after STOP is executed with KEY1 armed. Rather than stopping, the CPU
+// pauses, with the divider reset and stopped, and then continues at the other speed.
+func (m *Machine) switchSpeed() {
+	m.CPU.Stopped = false
+	m.speed.armed = false
+	m.speed.double = !m.speed.double
+	m.speed.pause = SpeedSwitchCycles
+	m.PPU.DoubleSpeed = m.speed.double
+	m.Timer.Write(timer.DIV, 0)
+}
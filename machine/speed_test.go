@@ -0,0 +1,99 @@
+package machine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/timer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// speedROM returns a ROM that switches to double speed, and then spins.
+func speedROM(t *testing.T, flag uint8) []byte {
+	t.Helper()
+	return testrig.ROM(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, 1
+	ldh [$4D], a
+	stop
+	nop
+.spin
+	jr .spin
+`, link.Options{Title: "SPEED", CGBFlag: flag}).ROM
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	m, err := machine.NewWithOptions(speedROM(t, cartridge.CGBSupported), machine.Options{Model: machine.Auto})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x7E), m.Memory.Read(machine.KEY1))
+
+	for m.CPU.PC != 0x0154 {
+		_, err := m.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint8(0x7F), m.Memory.Read(machine.KEY1))
+	_, err = m.Step()
+	require.NoError(t, err)
+	assert.True(t, m.DoubleSpeed())
+	assert.False(t, m.CPU.Stopped)
+	assert.Equal(t, uint8(0xFE), m.Memory.Read(machine.KEY1))
+
+	// the CPU and the divider pause while the speed switches
+	pc := m.CPU.PC
+	for i := 0; i < machine.SpeedSwitchCycles/4; i++ {
+		cycles, err := m.Step()
+		require.NoError(t, err)
+		assert.Equal(t, 4, cycles)
+	}
+	assert.Equal(t, pc, m.CPU.PC)
+	assert.Equal(t, uint8(0), m.Memory.Read(timer.DIV))
+
+	// the CPU and the timer run at twice the rate of the PPU
+	start, ly := m.Cycles, m.Memory.Read(0xFF44)
+	for m.Cycles-start < 2*456 {
+		_, err := m.Step()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint8(2*2*456/256), m.Memory.Read(timer.DIV))
+	assert.Equal(t, (ly+2)%154, m.Memory.Read(0xFF44))
+
+	// OAM DMA transfers copy a byte every 2 cycles
+	m.Memory.Write(0xFF46, 0xC0)
+	m.Memory.Poke(0xC000, 0x42)
+	m.PPU.Step(2)
+	assert.Equal(t, uint8(0x42), m.Memory.Peek(0xFE00))
+
+	// save states keep the speed
+	var saved bytes.Buffer
+	require.NoError(t, m.SaveState(&saved))
+	restored, err := machine.NewWithOptions(speedROM(t, cartridge.CGBSupported), machine.Options{Model: machine.Auto})
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&saved))
+	assert.True(t, restored.DoubleSpeed())
+	assert.True(t, restored.PPU.DoubleSpeed)
+}
+
+func TestSpeedSwitchDMG(t *testing.T) {
+	// the speed cannot be switched on the DMG, or in the compatibility mode of the CGB
+	for _, model := range []machine.Model{machine.DMG, machine.CGB} {
+		m, err := machine.NewWithOptions(speedROM(t, 0), machine.Options{Model: model})
+		require.NoError(t, err)
+		for !m.CPU.Stopped {
+			_, err := m.Step()
+			require.NoError(t, err)
+		}
+		assert.False(t, m.DoubleSpeed())
+		assert.Equal(t, cpu.Register(0x0156), m.CPU.PC)
+	}
+}
@@ -0,0 +1,147 @@
+package machine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/gopherpocket/gopherpocket/serial"
+	"github.com/gopherpocket/gopherpocket/state"
+	"github.com/gopherpocket/gopherpocket/timer"
+)
+
+// stateVersion is the version of the encoding of the machine section of save states. Before version 4, work RAM and
+// the I/O registers were saved in the memory section.
+const stateVersion = 4
+
+// subsystem is a part of the machine saved in its own section.
+type subsystem struct {
+	tag     string
+	version int
+	save    func(e *state.Encoder)
+	load    func(d *state.Decoder, version int) error
+}
+
+// subsystems returns the parts of the machine, in the order they are saved. There is no APU to save yet.
+func (m *Machine) subsystems() []subsystem {
+	return []subsystem{
+		{"MACH", stateVersion, m.saveState, m.loadState},
+		{"CPU ", cpu.StateVersion, m.CPU.SaveState, m.CPU.LoadState},
+		{"MEM ", cpu.StateVersion, m.Memory.SaveState, m.Memory.LoadState},
+		{"WRAM", memoryStateVersion, m.wram.SaveState, m.wram.LoadState},
+		{"IO  ", memoryStateVersion, m.io.SaveState, m.io.LoadState},
+		{"CART", cartridge.StateVersion, m.Cartridge.SaveState, m.Cartridge.LoadState},
+		{"PPU ", ppu.StateVersion, m.PPU.SaveState, m.PPU.LoadState},
+		{"JOYP", joypad.StateVersion, m.Joypad.SaveState, m.Joypad.LoadState},
+		{"TIMR", timer.StateVersion, m.Timer.SaveState, m.Timer.LoadState},
+		{"SERL", serial.StateVersion, m.Serial.SaveState, m.Serial.LoadState},
+	}
+}
+
+// SaveState writes the state of the machine.
+func (m *Machine) SaveState(w io.Writer) error {
+	var sections []state.Section
+	for _, s := range m.subsystems() {
+		var e state.Encoder
+		s.save(&e)
+		sections = append(sections, state.Section{Tag: s.tag, Version: s.version, Data: e.Bytes()})
+	}
+	return state.Write(w, sections)
+}
+
+// LoadState restores a state written by SaveState for the same ROM. Sections of subsystems the machine does not have
+// are ignored, and subsystems without a section keep their state. If the state cannot be loaded, the machine may be
+// left partly restored.
+func (m *Machine) LoadState(r io.Reader) error {
+	sections, err := state.Read(r)
+	if err != nil {
+		return err
+	}
+	if len(sections) == 0 || sections[0].Tag != "MACH" {
+		return errors.New("machine: save state has no machine section")
+	}
+
+	if cc, ok := m.core.(*cpu.CachedCore); ok {
+		cc.Flush()
+	}
+	subsystems := m.subsystems()
+	for _, sec := range sections {
+		for _, s := range subsystems {
+			if s.tag != sec.Tag {
+				continue
+			}
+			if err := s.load(state.NewDecoder(sec.Data), sec.Version); err != nil {
+				return fmt.Errorf("machine: section %q: %w", sec.Tag, err)
+			}
+		}
+	}
+	if sections[0].Version < 4 {
+		for _, sec := range sections {
+			if sec.Tag != "MEM " {
+				continue
+			}
+			if err := m.loadLegacyMemory(state.NewDecoder(sec.Data)); err != nil {
+				return fmt.Errorf("machine: section %q: %w", sec.Tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// romID identifies the ROM of a save state by its title and checksums.
+func (m *Machine) romID() []byte {
+	h := m.Cartridge.Header()
+	return []byte(fmt.Sprintf("%s/%02X/%04X", h.Title, h.HeaderChecksum, h.GlobalChecksum))
+}
+
+func (m *Machine) saveState(e *state.Encoder) {
+	e.Slice(m.romID())
+	e.Uint64(m.Cycles)
+	e.Int(int(m.Model))
+	e.Bool(m.boot != nil && m.boot.enabled)
+	e.Bool(m.speed.double)
+	e.Bool(m.speed.armed)
+	e.Int(m.speed.pause)
+}
+
+func (m *Machine) loadState(d *state.Decoder, version int) error {
+	if version > stateVersion {
+		return fmt.Errorf("unsupported state version %d", version)
+	}
+	id := m.romID()
+	got := make([]byte, len(id))
+	d.Slice(got)
+	if d.Err() != nil || !bytes.Equal(got, id) {
+		return errors.New("the save state is for another ROM")
+	}
+	m.Cycles = d.Uint64()
+	if version < 2 {
+		return d.Err()
+	}
+
+	model, boot := Model(d.Int()), d.Bool()
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if model != m.Model {
+		return fmt.Errorf("the save state is for a %v, not a %v", model, m.Model)
+	}
+	if boot && m.boot == nil {
+		return errors.New("the save state is running the boot ROM, and the machine has none")
+	}
+	if m.boot != nil {
+		m.boot.setEnabled(boot)
+	}
+	if version < 3 {
+		return nil
+	}
+
+	m.speed.double, m.speed.armed, m.speed.pause = d.Bool(), d.Bool(), d.Int()
+	m.PPU.DoubleSpeed = m.speed.double
+	return d.Err()
+}
@@ -0,0 +1,139 @@
+package machine_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records the registers and cycles before each instruction.
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) Trace(m *machine.Machine) error {
+	c := m.CPU
+	r.lines = append(r.lines, fmt.Sprintf("%04X %04X %04X %04X %04X %04X %d", c.AF, c.BC, c.DE, c.HL, c.SP, c.PC, m.Cycles))
+	return nil
+}
+
+func newStateMachine(t *testing.T, title string) *machine.Machine {
+	t.Helper()
+	return testrig.Machine(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, $0A
+	ld [$0000], a
+	ld hl, $A000
+.loop
+	ld a, [$FF44]
+	ld [hl+], a
+	inc b
+	ld [$8000], a
+	ld a, h
+	cp $C0
+	jr nz, .loop
+	ld hl, $A000
+	jr .loop
+`, link.Options{Title: title, Type: cartridge.MBC1RAM, RAMSize: 0x02})
+}
+
+// record runs m for n instructions, and returns their trace.
+func record(t *testing.T, m *machine.Machine, n int) []string {
+	t.Helper()
+	var r recorder
+	m.Tracer = &r
+	defer func() { m.Tracer = nil }()
+	for i := 0; i < n; i++ {
+		_, err := m.Step()
+		require.NoError(t, err)
+	}
+	return r.lines
+}
+
+func TestState(t *testing.T) {
+	m := newStateMachine(t, "STATE")
+	require.NoError(t, m.RunFrame())
+	var saved bytes.Buffer
+	require.NoError(t, m.SaveState(&saved))
+	want := record(t, m, 50000)
+
+	restored := newStateMachine(t, "STATE")
+	require.NoError(t, restored.LoadState(bytes.NewReader(saved.Bytes())))
+	assert.Equal(t, want, record(t, restored, 50000))
+	assert.Equal(t, m.Cycles, restored.Cycles)
+	assert.Equal(t, m.PPU.Frames, restored.PPU.Frames)
+	assert.Equal(t, m.PPU.Frame(), restored.PPU.Frame())
+	assert.Equal(t, m.Memory.Peek(0xA123), restored.Memory.Peek(0xA123))
+
+	// a state loads again into the machine it was saved from
+	require.NoError(t, m.LoadState(bytes.NewReader(saved.Bytes())))
+	assert.Equal(t, want, record(t, m, 50000))
+
+	other := newStateMachine(t, "OTHER")
+	assert.EqualError(t, other.LoadState(bytes.NewReader(saved.Bytes())), `machine: section "MACH": the save state is for another ROM`)
+}
+
+func TestStateSections(t *testing.T) {
+	m := newStateMachine(t, "STATE")
+	require.NoError(t, m.RunFrame())
+	var saved bytes.Buffer
+	require.NoError(t, m.SaveState(&saved))
+	sections, err := state.Read(&saved)
+	require.NoError(t, err)
+
+	// sections of unknown subsystems are ignored
+	var buf bytes.Buffer
+	require.NoError(t, state.Write(&buf, append(sections, state.Section{Tag: "NEW ", Version: 1, Data: []byte{1}})))
+	restored := newStateMachine(t, "STATE")
+	require.NoError(t, restored.LoadState(&buf))
+	assert.Equal(t, m.Cycles, restored.Cycles)
+
+	// the machine section comes first
+	buf.Reset()
+	require.NoError(t, state.Write(&buf, sections[1:]))
+	assert.EqualError(t, restored.LoadState(&buf), "machine: save state has no machine section")
+
+	// newer versions of a section are rejected
+	newer := append([]state.Section(nil), sections...)
+	newer[1].Version++
+	buf.Reset()
+	require.NoError(t, state.Write(&buf, newer))
+	assert.Error(t, restored.LoadState(&buf))
+
+	assert.Error(t, restored.LoadState(strings.NewReader("not a save state")))
+}
+
+func TestLegacyState(t *testing.T) {
+	// v3.state.gz was saved with the machine section at version 3, when work RAM and the I/O registers were in the
+	// memory section, after 1000 instructions and writing $42 to $C000, $99 to $D123, $E5 to IF and $17 to $FF80
+	f, err := os.Open("testdata/v3.state.gz")
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	m := newStateMachine(t, "OLD STATE")
+	require.NoError(t, m.LoadState(r))
+	assert.Equal(t, uint64(9728), m.Cycles)
+	assert.Equal(t, []uint8{0x42, 0x99, 0xE5, 0x17},
+		[]uint8{m.Memory.Read(0xC000), m.Memory.Read(0xD123), m.Memory.Read(0xFF0F), m.Memory.Read(0xFF80)})
+	assert.Equal(t, uint8(0x99), m.Memory.Read(0xF123))
+	_, err = m.Step()
+	assert.NoError(t, err)
+}
@@ -1,5 +1,259 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm/sym"
+	"github.com/gopherpocket/gopherpocket/dap"
+	"github.com/gopherpocket/gopherpocket/debugger"
+	"github.com/gopherpocket/gopherpocket/frontend"
+	"github.com/gopherpocket/gopherpocket/gdbstub"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/movie"
+	"github.com/gopherpocket/gopherpocket/trace"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gopherpocket <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  run [-backend name] [-frames n] [-fast] rom.gb")
+	fmt.Fprintln(os.Stderr, "                                           run a ROM in real time")
+	fmt.Fprintln(os.Stderr, "  debug [-sym file] rom.gb                 debug a ROM interactively")
+	fmt.Fprintln(os.Stderr, "  gdb [-addr host:port] [-sym file] rom.gb serve the GDB remote protocol")
+	fmt.Fprintln(os.Stderr, "  dap [-addr host:port]                    serve the Debug Adapter Protocol")
+	fmt.Fprintln(os.Stderr, "  tracediff [-context n] ours reference    find where two instruction traces diverge")
+	fmt.Fprintln(os.Stderr, "  replay rom.gb movie                      replay a movie, checking its frames")
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "run":
+		err = run(args)
+	case "debug":
+		err = debug(args)
+	case "gdb":
+		err = gdb(args)
+	case "dap":
+		err = serveDAP(args)
+	case "tracediff":
+		err = traceDiff(args)
+	case "replay":
+		err = replay(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gopherpocket: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs a ROM in real time through a frontend backend, until the user quits or interrupts it. It refuses to run
+// headless unless asked to with -backend null.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	backend := flags.String("backend", frontend.DefaultBackend(),
+		fmt.Sprintf("present the machine through the backend `name`, one of %v", frontend.Backends()))
+	frames := flags.Int("frames", 0, "stop after `n` frames, or never if 0")
+	fast := flags.Bool("fast", false, "run as fast as possible rather than in real time")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || *frames < 0 {
+		return fmt.Errorf("usage: gopherpocket run [-backend name] [-frames n] [-fast] rom.gb")
+	}
+	if *backend == "" {
+		// the null backend runs invisibly, so it has to be asked for
+		return fmt.Errorf("no video backend is built in; pass -backend null to run headless")
+	}
+	rom, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := frontend.Open(*backend)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	m, err := machine.NewWithOptions(rom, machine.Options{Model: machine.Auto, FastCore: true})
+	if err != nil {
+		return err
+	}
+
+	f := frontend.New(m, b)
+	f.Frames, f.Unthrottled = *frames, *fast
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			f.Stop()
+		}
+	}()
+	return f.Run()
+}
+
+// load loads a ROM into a new machine of the model its header selects, to debug. The symbols of rom.gb are read from
+// symFile, or from rom.sym beside it if it exists.
+func load(romFile, symFile string) (*debugger.Debugger, error) {
+	rom, err := os.ReadFile(romFile)
+	if err != nil {
+		return nil, err
+	}
+	m, err := machine.NewWithOptions(rom, machine.Options{Model: machine.Auto})
+	if err != nil {
+		return nil, err
+	}
+	d := debugger.New(m)
+
+	if symFile == "" {
+		if f := strings.TrimSuffix(romFile, ".gb") + ".sym"; f != romFile {
+			if _, err := os.Stat(f); err == nil {
+				symFile = f
+			}
+		}
+	}
+	if symFile != "" {
+		f, err := os.Open(symFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if d.Symbols, err = sym.Read(f); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// debug runs the interactive debugger.
+func debug(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	symFile := flags.String("sym", "", "read symbols from `file`")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: gopherpocket debug [-sym file] rom.gb")
+	}
+	d, err := load(flags.Arg(0), *symFile)
+	if err != nil {
+		return err
+	}
+
+	// ^C stops the running program rather than the debugger
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			d.Interrupt()
+		}
+	}()
+
+	return d.REPL(os.Stdin, os.Stdout)
+}
+
+// gdb serves the GDB remote serial protocol, for GDB to connect to with target remote host:port.
+func gdb(args []string) error {
+	flags := flag.NewFlagSet("gdb", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:2345", "listen on `host:port`")
+	symFile := flags.String("sym", "", "read symbols from `file`")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: gopherpocket gdb [-addr host:port] [-sym file] rom.gb")
+	}
+	d, err := load(flags.Arg(0), *symFile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "listening for GDB on %s\n", *addr)
+	return gdbstub.New(d).ListenAndServe(*addr)
+}
+
+// serveDAP serves the Debug Adapter Protocol on stdin and stdout, or on a TCP address. The ROM is named by the launch
+// request of the editor.
+func serveDAP(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	addr := flags.String("addr", "", "listen on `host:port` rather than stdin and stdout")
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: gopherpocket dap [-addr host:port]")
+	}
+	if *addr == "" {
+		return dap.Serve(os.Stdin, os.Stdout)
+	}
+	fmt.Fprintf(os.Stderr, "listening for DAP clients on %s\n", *addr)
+	return dap.ListenAndServe(*addr)
+}
+
+// traceDiff compares our instruction trace with a reference trace, both in the Gameboy Doctor format, and reports the
+// first instruction where they diverge.
+func traceDiff(args []string) error {
+	flags := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := flags.Int("context", 10, "show `n` instructions before the divergence")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 || *context < 0 {
+		return fmt.Errorf("usage: gopherpocket tracediff [-context n] ours reference")
+	}
+	ours, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer ours.Close()
+	reference, err := os.Open(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer reference.Close()
+
+	d, err := trace.Diff(ours, reference, *context)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		fmt.Println("traces are the same")
+		return nil
+	}
+	fmt.Print(d)
+	return errors.New("traces differ")
+}
+
+// replay replays a movie of a ROM, and reports whether its frames match the checkpoints it was recorded with.
+func replay(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: gopherpocket replay rom.gb movie")
+	}
+	rom, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	mv, err := movie.Read(f)
+	if err != nil {
+		return err
+	}
 
+	m, err := mv.Start(rom)
+	if err != nil {
+		return err
+	}
+	if err := mv.Play(m); err != nil {
+		return err
+	}
+	fmt.Printf("replayed %d frames, %d checkpoints match\n", mv.Len(), len(mv.Checkpoints))
+	return nil
 }
@@ -0,0 +1,273 @@
+// Package movie records the input of the joypad frame by frame into movies, and replays them exactly.
+//
+// A movie holds what it takes to reproduce a run of a ROM: the hash of the ROM, the model of the machine and the seed
+// it was powered on with, or the save state it started from, the buttons held during each frame, and the hashes of
+// frames at regular checkpoints, which replays compare to detect when they diverge from the recording. Replays are
+// exact because the machine is deterministic: its RAM starts from a seeded generator, and the clock of the cartridge
+// counts emulated cycles rather than time.
+//
+// Movies are useful to attach to bug reports, and as long-running regression tests:
+//
+//	mv, err := movie.Read(f)
+//	m, err := mv.Start(rom)
+//	err = mv.Play(m)
+package movie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"runtime/debug"
+	"sort"
+
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// Magic starts every movie.
+const Magic = "GPMOVIE\x00"
+
+// version is the version of the encoding of movies. Version 2 added the model and the cycle accurate mode to the
+// header, which were DMG and off before.
+const version = 2
+
+// DefaultInterval is the number of frames between the checkpoints of new movies, a second.
+const DefaultInterval = 60
+
+// Version identifies the build of the emulator recording movies. It defaults to the version of the main module and
+// its VCS revision, if the binary was built with them.
+var Version = buildVersion()
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			v += " " + s.Value
+		}
+	}
+	return v
+}
+
+// Checkpoint is the hash of a frame of a movie.
+type Checkpoint struct {
+	// Frame is the index of the frame in the movie.
+	Frame int
+	Hash  uint64
+}
+
+// Movie is a recording of the input of a ROM.
+type Movie struct {
+	// Emulator is the Version of the emulator that recorded the movie.
+	Emulator string
+	// ROM is the SHA-256 hash of the ROM.
+	ROM [sha256.Size]byte
+	// Model, CycleAccurate and Seed are the options of the machine.
+	Model         machine.Model
+	CycleAccurate bool
+	Seed          int64
+	// State is the save state the movie starts from, or nil if it starts from power on.
+	State []byte
+
+	// Inputs holds the buttons pressed during each frame.
+	Inputs []joypad.Button
+	// Checkpoints holds hashes of frames, in order.
+	Checkpoints []Checkpoint
+	// Interval is the number of frames between the checkpoints Record adds, or 0 for none.
+	Interval int
+}
+
+// New constructs an empty movie of rom, powered on as a DMG with seed.
+func New(rom []byte, seed int64) *Movie {
+	return NewWithOptions(rom, machine.Options{Seed: seed})
+}
+
+// NewWithOptions constructs an empty movie of rom, powered on with the model, cycle accurate mode and seed of opts.
+func NewWithOptions(rom []byte, opts machine.Options) *Movie {
+	return &Movie{Emulator: Version, ROM: sha256.Sum256(rom), Model: opts.Model, CycleAccurate: opts.CycleAccurate,
+		Seed: opts.Seed, Interval: DefaultInterval}
+}
+
+// NewFromState constructs an empty movie starting from the current state of m, which runs rom.
+func NewFromState(m *machine.Machine, rom []byte) (*Movie, error) {
+	var buf bytes.Buffer
+	if err := m.SaveState(&buf); err != nil {
+		return nil, err
+	}
+	mv := NewWithOptions(rom, machine.Options{Model: m.Model, CycleAccurate: m.CycleAccurate()})
+	mv.State = buf.Bytes()
+	return mv, nil
+}
+
+// ErrROM is returned when starting a movie with another ROM than it was recorded with.
+var ErrROM = errors.New("movie: the movie was recorded with another ROM")
+
+// Start constructs the machine running rom the movie starts with.
+func (mv *Movie) Start(rom []byte) (*machine.Machine, error) {
+	if sha256.Sum256(rom) != mv.ROM {
+		return nil, ErrROM
+	}
+	m, err := machine.NewWithOptions(rom, machine.Options{Model: mv.Model, CycleAccurate: mv.CycleAccurate, Seed: mv.Seed})
+	if err != nil {
+		return nil, err
+	}
+	if mv.State != nil {
+		if err := m.LoadState(bytes.NewReader(mv.State)); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Len returns the number of frames of the movie.
+func (mv *Movie) Len() int {
+	return len(mv.Inputs)
+}
+
+// Record runs m for a frame with buttons pressed, and appends them to the movie, with a checkpoint every Interval
+// frames.
+func (mv *Movie) Record(m *machine.Machine, buttons joypad.Button) error {
+	m.Joypad.Press(buttons)
+	if err := m.RunFrame(); err != nil {
+		return err
+	}
+	mv.Inputs = append(mv.Inputs, buttons)
+	if frame := len(mv.Inputs) - 1; mv.Interval > 0 && frame%mv.Interval == 0 {
+		mv.Checkpoints = append(mv.Checkpoints, Checkpoint{Frame: frame, Hash: Hash(m.PPU.Frame())})
+	}
+	return nil
+}
+
+// Desync is the error of a replay that diverged from its recording.
+type Desync struct {
+	Frame     int
+	Got, Want uint64
+	// Emulator and Recorded are the versions of the emulator replaying and recording the movie.
+	Emulator, Recorded string
+}
+
+func (e *Desync) Error() string {
+	msg := fmt.Sprintf("movie: frame %d has hash %016x, but %016x was recorded", e.Frame, e.Got, e.Want)
+	if e.Emulator != e.Recorded {
+		msg += fmt.Sprintf(" by %s", e.Recorded)
+	}
+	return msg
+}
+
+// PlayFrame runs m for frame i of the movie with the buttons recorded for it, and compares the frame with its
+// checkpoint if it has one, returning a *Desync error if they differ.
+func (mv *Movie) PlayFrame(m *machine.Machine, i int) error {
+	m.Joypad.Press(mv.Inputs[i])
+	if err := m.RunFrame(); err != nil {
+		return err
+	}
+	j := sort.Search(len(mv.Checkpoints), func(j int) bool { return mv.Checkpoints[j].Frame >= i })
+	if j == len(mv.Checkpoints) || mv.Checkpoints[j].Frame != i {
+		return nil
+	}
+	if got, want := Hash(m.PPU.Frame()), mv.Checkpoints[j].Hash; got != want {
+		return &Desync{Frame: i, Got: got, Want: want, Emulator: Version, Recorded: mv.Emulator}
+	}
+	return nil
+}
+
+// Play replays the whole movie on m, which Start constructed, stopping at the first frame that differs from its
+// checkpoint.
+func (mv *Movie) Play(m *machine.Machine) error {
+	for i := range mv.Inputs {
+		if err := mv.PlayFrame(m, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash returns the FNV-1a hash of a frame.
+func Hash(f *ppu.Frame) uint64 {
+	h := fnv.New64a()
+	for y := range f {
+		h.Write(f[y][:])
+	}
+	return h.Sum64()
+}
+
+// Write writes the movie.
+func (mv *Movie) Write(w io.Writer) error {
+	var header state.Encoder
+	header.String(mv.Emulator)
+	header.Slice(mv.ROM[:])
+	header.Uint64(uint64(mv.Seed))
+	header.Int(mv.Interval)
+	header.Int(int(mv.Model))
+	header.Bool(mv.CycleAccurate)
+
+	inputs := make([]byte, len(mv.Inputs))
+	for i, b := range mv.Inputs {
+		inputs[i] = uint8(b)
+	}
+	var checkpoints state.Encoder
+	for _, c := range mv.Checkpoints {
+		checkpoints.Int(c.Frame)
+		checkpoints.Uint64(c.Hash)
+	}
+
+	sections := []state.Section{{Tag: "MOVI", Version: version, Data: header.Bytes()}}
+	if mv.State != nil {
+		sections = append(sections, state.Section{Tag: "INIT", Version: version, Data: mv.State})
+	}
+	sections = append(sections,
+		state.Section{Tag: "INPT", Version: version, Data: inputs},
+		state.Section{Tag: "CHCK", Version: version, Data: checkpoints.Bytes()})
+	return state.WriteMagic(w, Magic, sections)
+}
+
+// Read reads a movie written by Write.
+func Read(r io.Reader) (*Movie, error) {
+	sections, err := state.ReadMagic(r, Magic)
+	if err != nil {
+		return nil, fmt.Errorf("movie: %w", err)
+	}
+	if len(sections) == 0 || sections[0].Tag != "MOVI" {
+		return nil, errors.New("movie: no movie header")
+	}
+
+	mv := &Movie{}
+	for _, s := range sections {
+		if s.Version > version {
+			return nil, fmt.Errorf("movie: section %q has unsupported version %d", s.Tag, s.Version)
+		}
+		d := state.NewDecoder(s.Data)
+		switch s.Tag {
+		case "MOVI":
+			mv.Emulator = d.String()
+			d.Slice(mv.ROM[:])
+			mv.Seed, mv.Interval = int64(d.Uint64()), d.Int()
+			if s.Version >= 2 {
+				mv.Model, mv.CycleAccurate = machine.Model(d.Int()), d.Bool()
+			}
+		case "INIT":
+			mv.State = s.Data
+		case "INPT":
+			mv.Inputs = make([]joypad.Button, len(s.Data))
+			for i, b := range s.Data {
+				mv.Inputs[i] = joypad.Button(b)
+			}
+		case "CHCK":
+			for len(mv.Checkpoints) < len(s.Data)/16 {
+				mv.Checkpoints = append(mv.Checkpoints, Checkpoint{Frame: d.Int(), Hash: d.Uint64()})
+			}
+		}
+		if err := d.Err(); err != nil {
+			return nil, fmt.Errorf("movie: section %q: %w", s.Tag, err)
+		}
+	}
+	return mv, nil
+}
@@ -0,0 +1,184 @@
+package movie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSource draws the directions pressed into the tile of the background, with the palette of the first byte of
+// work RAM.
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, [$C000]
+	ld [$FF47], a
+	ld hl, $8000
+.loop
+	ld a, $20
+	ld [$FF00], a
+	ld a, [$FF00]
+	ld [hl+], a
+	res 4, l
+	jr .loop
+`
+
+// inputs returns the inputs of a movie of n frames.
+func inputs(n int) []joypad.Button {
+	var b []joypad.Button
+	for i := 0; i < n; i++ {
+		b = append(b, joypad.Button(i/7)<<4)
+	}
+	return b
+}
+
+func TestMovie(t *testing.T) {
+	rom := testrig.ROM(t, testSource, link.Options{Title: "MOVIE"}).ROM
+	mv := New(rom, 42)
+	mv.Interval = 10
+	m, err := mv.Start(rom)
+	require.NoError(t, err)
+	for _, b := range inputs(120) {
+		require.NoError(t, mv.Record(m, b))
+	}
+	assert.Equal(t, 120, mv.Len())
+	assert.Len(t, mv.Checkpoints, 12)
+	want := testrig.SaveState(t, m)
+
+	var buf bytes.Buffer
+	require.NoError(t, mv.Write(&buf))
+	played, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, mv, played)
+
+	m, err = played.Start(rom)
+	require.NoError(t, err)
+	require.NoError(t, played.Play(m))
+	assert.True(t, bytes.Equal(want, testrig.SaveState(t, m)))
+
+	// other inputs show in the frames
+	played.Inputs[30] = joypad.Down
+	m, err = played.Start(rom)
+	require.NoError(t, err)
+	var desync *Desync
+	require.ErrorAs(t, played.Play(m), &desync)
+	assert.Equal(t, 30, desync.Frame)
+
+	// as does another seed
+	played.Inputs[30] = mv.Inputs[30]
+	played.Seed = 43
+	m, err = played.Start(rom)
+	require.NoError(t, err)
+	require.ErrorAs(t, played.Play(m), &desync)
+	assert.Equal(t, 0, desync.Frame)
+
+	_, err = mv.Start(testrig.ROM(t, testSource, link.Options{Title: "OTHER"}).ROM)
+	assert.ErrorIs(t, err, ErrROM)
+}
+
+func TestMovieFromState(t *testing.T) {
+	rom := testrig.ROM(t, testSource, link.Options{Title: "MOVIE"}).ROM
+	m, err := machine.NewWithOptions(rom, machine.Options{Seed: 7})
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		m.Joypad.Press(joypad.Left)
+		require.NoError(t, m.RunFrame())
+	}
+
+	mv, err := NewFromState(m, rom)
+	require.NoError(t, err)
+	for _, b := range inputs(60) {
+		require.NoError(t, mv.Record(m, b))
+	}
+	want := testrig.SaveState(t, m)
+
+	var buf bytes.Buffer
+	require.NoError(t, mv.Write(&buf))
+	played, err := Read(&buf)
+	require.NoError(t, err)
+	m, err = played.Start(rom)
+	require.NoError(t, err)
+	require.NoError(t, played.Play(m))
+	assert.True(t, bytes.Equal(want, testrig.SaveState(t, m)))
+}
+
+func TestReadErrors(t *testing.T) {
+	mv := New(testrig.ROM(t, testSource, link.Options{Title: "MOVIE"}).ROM, 1)
+	mv.Inputs = inputs(3)
+	mv.Checkpoints = []Checkpoint{{0, 1}}
+	var buf bytes.Buffer
+	require.NoError(t, mv.Write(&buf))
+	valid := buf.Bytes()
+
+	for name, data := range map[string][]byte{
+		"empty":      nil,
+		"save state": []byte("GPSTATE\x00\x01\x00"),
+		"no header":  []byte(Magic + "\x01\x00"),
+		"truncated":  valid[:len(valid)-1],
+	} {
+		_, err := Read(bytes.NewReader(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMovieModel(t *testing.T) {
+	rom := testrig.ROM(t, testSource, link.Options{Title: "MOVIE"}).ROM
+	mv := NewWithOptions(rom, machine.Options{Model: machine.CGB, CycleAccurate: true, Seed: 5})
+	m, err := mv.Start(rom)
+	require.NoError(t, err)
+	assert.Equal(t, machine.CGB, m.Model)
+	assert.True(t, m.CycleAccurate())
+	for _, b := range inputs(30) {
+		require.NoError(t, mv.Record(m, b))
+	}
+
+	// movies from a state start with the model of the machine it was saved from
+	fromState, err := NewFromState(m, rom)
+	require.NoError(t, err)
+	for _, b := range inputs(30) {
+		require.NoError(t, fromState.Record(m, b))
+	}
+	want := testrig.SaveState(t, m)
+
+	for _, mv := range []*Movie{mv, fromState} {
+		var buf bytes.Buffer
+		require.NoError(t, mv.Write(&buf))
+		played, err := Read(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, mv, played)
+		m, err := played.Start(rom)
+		require.NoError(t, err)
+		assert.Equal(t, machine.CGB, m.Model)
+		assert.True(t, m.CycleAccurate())
+		require.NoError(t, played.Play(m))
+	}
+	m, err = fromState.Start(rom)
+	require.NoError(t, err)
+	require.NoError(t, fromState.Play(m))
+	assert.True(t, bytes.Equal(want, testrig.SaveState(t, m)))
+
+	// movies of version 1 were recorded on a DMG
+	var header state.Encoder
+	header.String("v1")
+	header.Slice(mv.ROM[:])
+	header.Uint64(5)
+	header.Int(DefaultInterval)
+	var buf bytes.Buffer
+	require.NoError(t, state.WriteMagic(&buf, Magic, []state.Section{{Tag: "MOVI", Version: 1, Data: header.Bytes()}}))
+	old, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, machine.DMG, old.Model)
+	assert.False(t, old.CycleAccurate)
+	assert.Equal(t, int64(5), old.Seed)
+}
@@ -0,0 +1,125 @@
+package ppu
+
+import (
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// Addresses of the color palette registers of the CGB.
+const (
+	BCPS = 0xFF68
+	BCPD = 0xFF69
+	OCPS = 0xFF6A
+	OCPD = 0xFF6B
+)
+
+// paletteAutoIncrement is the bit of BCPS and OCPS that advances the index after each write to the data register.
+const paletteAutoIncrement = 1 << 7
+
+// Bits of the pixels of frames drawn by the PPU of the CGB, besides the shade in bits 0 and 1.
+const (
+	// PixelPalette holds the number of the color palette of a pixel.
+	PixelPalette = 7 << 2
+	// PixelObject is set for pixels of objects, whose color palettes are separate from the background's.
+	PixelObject = 1 << 5
+)
+
+// NewColor constructs the PPU of the CGB, in CGB mode or in the DMG compatibility mode the CGB runs cartridges for the
+// DMG in. Its frames have the palette of each pixel, and the colors of the palettes are in palette RAM, written
+// through BCPS, BCPD, OCPS and OCPD: see Color.
+//
+// In the compatibility mode the DMG palettes apply first, and their shades index the colors of background palette 0
+// and object palettes 0 and 1. In CGB mode the colors index the palettes directly. The attributes of CGB mode, and
+// the second bank of VRAM, are not emulated, so background tiles use palette 0, and objects the palette in the low
+// bits of their attributes.
+func NewColor(mem *cpu.Memory, cgbMode bool) *PPU {
+	return &PPU{mem: mem, color: true, cgbMode: cgbMode}
+}
+
+// palettes returns the palette RAM and the index register of the background or object palettes.
+func (p *PPU) palettes(object bool) (*[64]uint8, *uint8) {
+	if object {
+		return &p.objPalettes, &p.ocps
+	}
+	return &p.bgPalettes, &p.bcps
+}
+
+// readPalette reads a palette index or data register.
+func (p *PPU) readPalette(addr uint16) uint8 {
+	ram, index := p.palettes(addr >= OCPS)
+	if addr == BCPS || addr == OCPS {
+		return *index | 0x40
+	}
+	return ram[*index&0x3F]
+}
+
+// writePalette writes a palette index or data register.
+func (p *PPU) writePalette(addr uint16, v uint8) {
+	ram, index := p.palettes(addr >= OCPS)
+	if addr == BCPS || addr == OCPS {
+		*index = v &^ 0x40
+		return
+	}
+	ram[*index&0x3F] = v
+	if *index&paletteAutoIncrement != 0 {
+		*index = paletteAutoIncrement | (*index+1)&0x3F
+	}
+}
+
+// SetPalette sets a color palette, as the boot ROM does, to colors in the RGB555 format of Color.
+func (p *PPU) SetPalette(object bool, palette int, colors [4]uint16) {
+	ram, _ := p.palettes(object)
+	for i, c := range colors {
+		ram[8*palette+2*i] = uint8(c)
+		ram[8*palette+2*i+1] = uint8(c >> 8)
+	}
+}
+
+// Color returns the color of a pixel of a frame of the CGB, from palette RAM, in the RGB555 format: 5 bits of red in
+// bits 0-4, of green in bits 5-9, and of blue in bits 10-14.
+func (p *PPU) Color(pixel uint8) uint16 {
+	ram, _ := p.palettes(pixel&PixelObject != 0)
+	i := 8*int(pixel&PixelPalette>>2) + 2*int(pixel&3)
+	return uint16(ram[i]) | uint16(ram[i+1]&0x7F)<<8
+}
+
+// pixel returns the pixel of a frame for a color, from 0 to 3, drawn with a DMG palette, and on the CGB with a color
+// palette.
+func (p *PPU) pixel(dmgPalette, color uint8, palette uint8, object bool) uint8 {
+	if !p.color {
+		return shade(dmgPalette, color)
+	}
+	v := palette << 2 & PixelPalette
+	if object {
+		v |= PixelObject
+	}
+	if p.cgbMode {
+		return v | color
+	}
+	return v | shade(dmgPalette, color)
+}
+
+// oamRows is the number of rows of 8 bytes OAM is read in during the OAM scan, one per 4 clock cycles.
+const oamRows = 0xA0 / 8
+
+// CorruptOAM corrupts OAM as the DMG does when the CPU puts an address of OAM on the bus during the OAM scan, through
+// its increment and decrement unit: the row of OAM the PPU is reading is mixed with the row before it, differently
+// for reads and writes. The PPU of the CGB does not corrupt OAM.
+func (p *PPU) CorruptOAM(addr uint16, access cpu.Access) {
+	if p.color || addr < 0xFE00 || addr > 0xFEFF || p.lcdc&lcdcEnable == 0 || p.ly >= Height || p.dot >= oamScanEnd {
+		return
+	}
+	row := p.dot / 4
+	if row == 0 || row >= oamRows {
+		return
+	}
+	word := func(row, i int) uint16 {
+		return uint16(p.oam[8*row+2*i]) | uint16(p.oam[8*row+2*i+1])<<8
+	}
+	a, b, c := word(row, 0), word(row-1, 0), word(row-1, 2)
+	v := ((a ^ c) & (b ^ c)) ^ c
+	if access == cpu.AccessRead {
+		v = b | a&c
+	}
+	p.oam[8*row], p.oam[8*row+1] = uint8(v), uint8(v>>8)
+	copy(p.oam[8*row+2:8*row+8], p.oam[8*row-6:8*row])
+}
@@ -0,0 +1,114 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func newColorPPU(cgbMode bool) (*PPU, *cpu.Memory) {
+	mem := cpu.NewMemory()
+	p := NewColor(mem, cgbMode)
+	mem.Map(0x8000, 0x9FFF, p)
+	mem.Map(0xFE00, 0xFE9F, p)
+	mem.Map(LCDC, WX, p)
+	mem.Map(BCPS, OCPD, p)
+	return p, mem
+}
+
+func TestPalettes(t *testing.T) {
+	p, mem := newColorPPU(true)
+
+	// writes to the data register advance the index with auto-increment
+	mem.Write(BCPS, paletteAutoIncrement|8)
+	for _, v := range []uint8{0x1F, 0x00, 0xE0, 0x03} {
+		mem.Write(BCPD, v)
+	}
+	assert.Equal(t, uint8(0xC0|12), mem.Read(BCPS))
+	assert.Equal(t, uint16(0x001F), p.Color(1<<2|0))
+	assert.Equal(t, uint16(0x03E0), p.Color(1<<2|1))
+
+	// and not without it
+	mem.Write(OCPS, 63)
+	mem.Write(OCPD, 0xFF)
+	mem.Write(OCPD, 0x7C)
+	assert.Equal(t, uint8(0x40|63), mem.Read(OCPS))
+	assert.Equal(t, uint8(0x7C), mem.Read(OCPD))
+	assert.Equal(t, uint16(0x7C00), p.Color(PixelObject|7<<2|3))
+	assert.Equal(t, uint8(0), mem.Read(BCPD+2*0))
+
+	p.SetPalette(true, 2, [4]uint16{0x7FFF, 0x1234, 0, 0})
+	assert.Equal(t, uint16(0x1234), p.Color(PixelObject|2<<2|1))
+}
+
+func TestDrawColor(t *testing.T) {
+	for _, cgbMode := range []bool{false, true} {
+		p, mem := newColorPPU(cgbMode)
+		tile(mem, 0x8010, 1)
+		for i := uint16(0); i < 32*32; i++ {
+			mem.Write(0x9800+i, 1)
+		}
+		mem.Write(BGP, 0x1B)
+		// an object with palette 3 in its CGB attributes, and DMG palette 1
+		for j, v := range []uint8{16, 8, 1, attrPalette | 3} {
+			mem.Write(0xFE00+uint16(j), v)
+		}
+		mem.Write(OBP1, 0xE4)
+
+		mem.Write(LCDC, lcdcEnable|lcdcBGEnable|lcdcObjEnable|lcdcUnsignedTiles)
+		p.Step(FrameCycles)
+		f := p.Frame()
+		if cgbMode {
+			// colors index the palettes directly
+			assert.Equal(t, uint8(1), f[0][8])
+			assert.Equal(t, uint8(PixelObject|3<<2|1), f[0][0])
+		} else {
+			// shades of the DMG palettes index background palette 0 and object palette 1
+			assert.Equal(t, uint8(2), f[0][8])
+			assert.Equal(t, uint8(PixelObject|1<<2|1), f[0][0])
+		}
+	}
+}
+
+func TestCorruptOAM(t *testing.T) {
+	p, mem := newPPU()
+	for i := uint16(0); i < 0xA0; i++ {
+		mem.Write(0xFE00+i, uint8(i))
+	}
+	mem.Write(LCDC, lcdcEnable)
+
+	// row 0 is not corrupted, nor is OAM outside the OAM scan or for other addresses
+	p.CorruptOAM(0xFE00, cpu.AccessWrite)
+	p.Step(oamScanEnd)
+	p.CorruptOAM(0xFE00, cpu.AccessWrite)
+	p.CorruptOAM(0xC000, cpu.AccessWrite)
+	p.Step(LineCycles - oamScanEnd)
+	p.CorruptOAM(0xC000, cpu.AccessWrite)
+	for i := 0; i < 0xA0; i++ {
+		assert.Equal(t, uint8(i), p.oam[i])
+	}
+
+	// a write mixes the first word of the row with the first and third of the row before, and copies the rest of it
+	p.Step(8)
+	p.CorruptOAM(0xFE00, cpu.AccessWrite)
+	a, b, c := uint16(0x1110), uint16(0x0908), uint16(0x0D0C)
+	v := ((a ^ c) & (b ^ c)) ^ c
+	assert.Equal(t, []uint8{uint8(v), uint8(v >> 8), 10, 11, 12, 13, 14, 15}, p.oam[16:24])
+
+	// a read mixes them differently
+	p.Step(4)
+	p.CorruptOAM(0xFEFF, cpu.AccessRead)
+	a, b, c = 0x1918, v, 0x0D0C
+	v = b | a&c
+	assert.Equal(t, []uint8{uint8(v), uint8(v >> 8), 10, 11, 12, 13, 14, 15}, p.oam[24:32])
+}
+
+func TestCorruptOAMColor(t *testing.T) {
+	p, mem := newColorPPU(false)
+	mem.Write(0xFE08, 0xFF)
+	mem.Write(LCDC, lcdcEnable)
+	p.Step(8)
+	p.CorruptOAM(0xFE00, cpu.AccessWrite)
+	assert.Equal(t, uint8(0), p.oam[16])
+}
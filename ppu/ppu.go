@@ -0,0 +1,489 @@
+// Package ppu implements the picture processing unit of the Gameboy, which draws the screen line by line from the
+// tiles, tile maps and objects in VRAM and OAM.
+//
+// Each line is drawn at once when the PPU leaves mode 3, rather than pixel by pixel, so changes to the registers in the
+// middle of mode 3 take effect from the next line. The CPU may access VRAM and OAM in every mode.
+package ppu
+
+import (
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// Size of the screen in pixels.
+const (
+	Width  = 160
+	Height = 144
+)
+
+// Timing of the screen in clock cycles, which are also the dots the PPU draws.
+const (
+	// LineCycles is the duration of a line, including its horizontal blank.
+	LineCycles = 456
+	// Lines is the number of lines of a frame, including the 10 lines of the vertical blank.
+	Lines = 154
+	// FrameCycles is the duration of a frame.
+	FrameCycles = LineCycles * Lines
+
+	// oamScanEnd and drawEnd are the dots at which mode 2 and mode 3 end.
+	oamScanEnd = 80
+	drawEnd    = oamScanEnd + 172
+)
+
+// Addresses of the registers of the PPU.
+const (
+	LCDC = 0xFF40
+	STAT = 0xFF41
+	SCY  = 0xFF42
+	SCX  = 0xFF43
+	LY   = 0xFF44
+	LYC  = 0xFF45
+	DMA  = 0xFF46
+	BGP  = 0xFF47
+	OBP0 = 0xFF48
+	OBP1 = 0xFF49
+	WY   = 0xFF4A
+	WX   = 0xFF4B
+)
+
+// Bits of LCDC.
+const (
+	lcdcBGEnable      = 1 << 0
+	lcdcObjEnable     = 1 << 1
+	lcdcObjTall       = 1 << 2
+	lcdcBGMap         = 1 << 3
+	lcdcUnsignedTiles = 1 << 4
+	lcdcWindowEnable  = 1 << 5
+	lcdcWindowMap     = 1 << 6
+	lcdcEnable        = 1 << 7
+)
+
+// Bits of STAT, besides the mode in bits 0 and 1.
+const (
+	statCoincidence = 1 << 2
+	statHBlankInt   = 1 << 3
+	statVBlankInt   = 1 << 4
+	statOAMInt      = 1 << 5
+	statLYCInt      = 1 << 6
+	statWritable    = statHBlankInt | statVBlankInt | statOAMInt | statLYCInt
+)
+
+// Modes of the PPU, in STAT.
+const (
+	ModeHBlank = 0
+	ModeVBlank = 1
+	ModeOAM    = 2
+	ModeDraw   = 3
+)
+
+// Bits of the attributes of an object.
+const (
+	attrCGBPalette = 7
+	attrPalette    = 1 << 4
+	attrFlipX      = 1 << 5
+	attrFlipY      = 1 << 6
+	attrBehind     = 1 << 7
+)
+
+// maxObjectsPerLine is the number of objects the PPU draws on a line, in the order of OAM.
+const maxObjectsPerLine = 10
+
+// dmaLength is the number of bytes an OAM DMA transfer copies, one per 4 clock cycles.
+const dmaLength = 0xA0
+
+// Frame is an image of the screen, as the shades of its pixels from 0 for white to 3 for black, row by row. Frames of
+// the CGB also have the palette of each pixel: see NewColor.
+type Frame [Height][Width]uint8
+
+// PPU is the picture processing unit, mapped into VRAM at $8000-$9FFF, OAM at $FE00-$FE9F and its registers at
+// $FF40-$FF4B, and on the CGB its palette registers at $FF68-$FF6B.
+type PPU struct {
+	mem *cpu.Memory
+
+	vram [0x2000]uint8
+	oam  [0xA0]uint8
+
+	lcdc, stat, scy, scx, ly, lyc, dma, bgp, obp0, obp1, wy, wx uint8
+
+	// dot is the position in the current line, in clock cycles.
+	dot int
+	// windowLine is the line of the window to draw next, which only advances on lines where the window is drawn.
+	windowLine int
+	// statLine is the STAT interrupt line, which requests an interrupt when it rises.
+	statLine bool
+
+	// dmaCycles are the cycles left of the current OAM DMA transfer, or 0 if there is none.
+	dmaCycles int
+	// offCycles counts the cycles since the last frame while the LCD is off.
+	offCycles int
+
+	back, front Frame
+	// Frames counts the frames the PPU completed.
+	Frames uint64
+	// DoubleSpeed is set while the CPU of the CGB runs at double speed. OAM DMA transfers are clocked by the CPU, so
+	// they then copy a byte every 2 clock cycles of the PPU.
+	DoubleSpeed bool
+
+	// color is set for the PPU of the CGB, and cgbMode when it runs in CGB mode.
+	color, cgbMode bool
+	// bgPalettes and objPalettes are the palette RAM of the CGB, indexed by bcps and ocps.
+	bgPalettes, objPalettes [64]uint8
+	bcps, ocps              uint8
+}
+
+// New constructs the PPU of the DMG with the LCD off, requesting interrupts and copying OAM DMA transfers from mem.
+func New(mem *cpu.Memory) *PPU {
+	return &PPU{mem: mem}
+}
+
+// Frame returns the last frame the PPU completed. It changes when the next frame completes.
+func (p *PPU) Frame() *Frame {
+	return &p.front
+}
+
+// Mode returns the current mode.
+func (p *PPU) Mode() int {
+	return int(p.stat & 3)
+}
+
+// Read implements cpu.Device.
+func (p *PPU) Read(addr uint16) uint8 {
+	switch {
+	case addr >= 0x8000 && addr < 0xA000:
+		return p.vram[addr-0x8000]
+	case addr >= 0xFE00 && addr < 0xFEA0:
+		return p.oam[addr-0xFE00]
+	}
+	switch addr {
+	case LCDC:
+		return p.lcdc
+	case STAT:
+		return p.stat | 0x80
+	case SCY:
+		return p.scy
+	case SCX:
+		return p.scx
+	case LY:
+		return p.ly
+	case LYC:
+		return p.lyc
+	case DMA:
+		return p.dma
+	case BGP:
+		return p.bgp
+	case OBP0:
+		return p.obp0
+	case OBP1:
+		return p.obp1
+	case WY:
+		return p.wy
+	case WX:
+		return p.wx
+	case BCPS, BCPD, OCPS, OCPD:
+		if p.color {
+			return p.readPalette(addr)
+		}
+	}
+	return 0xFF
+}
+
+// Write implements cpu.Device.
+func (p *PPU) Write(addr uint16, v uint8) {
+	switch {
+	case addr >= 0x8000 && addr < 0xA000:
+		p.vram[addr-0x8000] = v
+		return
+	case addr >= 0xFE00 && addr < 0xFEA0:
+		p.oam[addr-0xFE00] = v
+		return
+	}
+	switch addr {
+	case LCDC:
+		p.setLCDC(v)
+	case STAT:
+		p.stat = p.stat&^statWritable | v&statWritable
+		p.updateStat()
+	case SCY:
+		p.scy = v
+	case SCX:
+		p.scx = v
+	case LYC:
+		p.lyc = v
+		p.updateStat()
+	case DMA:
+		p.dma = v
+		p.dmaCycles = 4 * dmaLength
+	case BGP:
+		p.bgp = v
+	case OBP0:
+		p.obp0 = v
+	case OBP1:
+		p.obp1 = v
+	case WY:
+		p.wy = v
+	case WX:
+		p.wx = v
+	case BCPS, BCPD, OCPS, OCPD:
+		if p.color {
+			p.writePalette(addr, v)
+		}
+	}
+}
+
+// setLCDC writes LCDC, turning the LCD on or off.
+func (p *PPU) setLCDC(v uint8) {
+	was := p.lcdc & lcdcEnable
+	p.lcdc = v
+	switch {
+	case was != 0 && v&lcdcEnable == 0:
+		// the screen goes blank, and the PPU stays at the start of the first line
+		p.ly, p.dot, p.windowLine, p.offCycles = 0, 0, 0, 0
+		p.setMode(ModeHBlank)
+		p.front = Frame{}
+	case was == 0 && v&lcdcEnable != 0:
+		p.ly, p.dot, p.windowLine = 0, 0, 0
+		p.setMode(ModeOAM)
+	}
+}
+
+// Step advances the PPU by a number of clock cycles.
+func (p *PPU) Step(cycles int) {
+	if p.DoubleSpeed {
+		p.stepDMA(2 * cycles)
+	} else {
+		p.stepDMA(cycles)
+	}
+
+	if p.lcdc&lcdcEnable == 0 {
+		// frames still complete while the LCD is off, so that the screen is shown blank
+		p.offCycles += cycles
+		for p.offCycles >= FrameCycles {
+			p.offCycles -= FrameCycles
+			p.Frames++
+		}
+		return
+	}
+
+	for cycles > 0 {
+		next := LineCycles
+		if p.ly < Height {
+			switch {
+			case p.dot < oamScanEnd:
+				next = oamScanEnd
+			case p.dot < drawEnd:
+				next = drawEnd
+			}
+		}
+		n := next - p.dot
+		if n > cycles {
+			n = cycles
+		}
+		p.dot += n
+		cycles -= n
+		if p.dot == next {
+			p.advance()
+		}
+	}
+}
+
+// advance moves the PPU past the end of a mode.
+func (p *PPU) advance() {
+	switch {
+	case p.dot == oamScanEnd && p.ly < Height:
+		p.setMode(ModeDraw)
+		return
+	case p.dot == drawEnd && p.ly < Height:
+		p.drawLine()
+		p.setMode(ModeHBlank)
+		return
+	}
+
+	// the end of a line
+	p.dot = 0
+	p.ly++
+	switch {
+	case p.ly == Height:
+		p.front = p.back
+		p.Frames++
+		p.windowLine = 0
+		cpu.RequestInterrupt(p.mem, cpu.VBlank)
+		p.setMode(ModeVBlank)
+	case p.ly == Lines:
+		p.ly = 0
+		p.setMode(ModeOAM)
+	case p.ly < Height:
+		p.setMode(ModeOAM)
+	default:
+		p.updateStat()
+	}
+}
+
+// setMode changes the mode in STAT.
+func (p *PPU) setMode(mode uint8) {
+	p.stat = p.stat&^3 | mode
+	p.updateStat()
+}
+
+// updateStat updates the coincidence flag, and requests a STAT interrupt when one of its enabled sources rises while
+// none of the others is active.
+func (p *PPU) updateStat() {
+	p.stat &^= statCoincidence
+	if p.ly == p.lyc && p.lcdc&lcdcEnable != 0 {
+		p.stat |= statCoincidence
+	}
+
+	line := false
+	switch p.stat & 3 {
+	case ModeHBlank:
+		line = p.stat&statHBlankInt != 0
+	case ModeVBlank:
+		line = p.stat&statVBlankInt != 0
+	case ModeOAM:
+		line = p.stat&statOAMInt != 0
+	}
+	if p.stat&statCoincidence != 0 && p.stat&statLYCInt != 0 {
+		line = true
+	}
+	line = line && p.lcdc&lcdcEnable != 0
+
+	if line && !p.statLine {
+		cpu.RequestInterrupt(p.mem, cpu.STAT)
+	}
+	p.statLine = line
+}
+
+// stepDMA copies the bytes of an OAM DMA transfer due in a number of clock cycles of the CPU.
+func (p *PPU) stepDMA(cycles int) {
+	for ; cycles > 0 && p.dmaCycles > 0; cycles-- {
+		p.dmaCycles--
+		if p.dmaCycles%4 == 0 {
+			i := dmaLength - 1 - p.dmaCycles/4
+			p.oam[i] = p.mem.Peek(uint16(p.dma)<<8 | uint16(i))
+		}
+	}
+}
+
+// tilePixel returns the color, from 0 to 3, of a pixel of a tile whose data starts at the offset in VRAM.
+func (p *PPU) tilePixel(offset int, x, y int) uint8 {
+	lo, hi := p.vram[offset+2*y], p.vram[offset+2*y+1]
+	bit := 7 - x
+	return (hi>>bit&1)<<1 | lo>>bit&1
+}
+
+// bgTile returns the offset in VRAM of the data of a background or window tile.
+func (p *PPU) bgTile(index uint8) int {
+	if p.lcdc&lcdcUnsignedTiles != 0 {
+		return int(index) * 16
+	}
+	return 0x1000 + int(int8(index))*16
+}
+
+// shade applies a palette to a color.
+func shade(palette, color uint8) uint8 {
+	return palette >> (2 * color) & 3
+}
+
+// drawLine draws the line LY into the back frame.
+func (p *PPU) drawLine() {
+	line := &p.back[p.ly]
+	// colors are the background and window colors before the palette, which decide the priority of objects
+	var colors [Width]uint8
+
+	if p.lcdc&lcdcBGEnable != 0 {
+		bgMap := 0x1800
+		if p.lcdc&lcdcBGMap != 0 {
+			bgMap = 0x1C00
+		}
+		y := int(p.scy + p.ly)
+		for x := 0; x < Width; x++ {
+			bx := int(p.scx + uint8(x))
+			tile := p.vram[bgMap+y/8*32+bx/8]
+			colors[x] = p.tilePixel(p.bgTile(tile), bx%8, y%8)
+		}
+
+		if p.lcdc&lcdcWindowEnable != 0 && p.ly >= p.wy && p.wx < Width+7 {
+			winMap := 0x1800
+			if p.lcdc&lcdcWindowMap != 0 {
+				winMap = 0x1C00
+			}
+			y := p.windowLine
+			start := int(p.wx) - 7
+			if start < 0 {
+				start = 0
+			}
+			for x := start; x < Width; x++ {
+				wx := x - (int(p.wx) - 7)
+				tile := p.vram[winMap+y/8*32+wx/8]
+				colors[x] = p.tilePixel(p.bgTile(tile), wx%8, y%8)
+			}
+			p.windowLine++
+		}
+	}
+	for x := range line {
+		line[x] = p.pixel(p.bgp, colors[x], 0, false)
+	}
+
+	if p.lcdc&lcdcObjEnable != 0 {
+		p.drawObjects(line, &colors)
+	}
+}
+
+// drawObjects draws the objects on the line LY over the background and window.
+func (p *PPU) drawObjects(line *[Width]uint8, colors *[Width]uint8) {
+	height := 8
+	if p.lcdc&lcdcObjTall != 0 {
+		height = 16
+	}
+
+	// the first objects in OAM on the line, by priority: the object with the lowest X, then the first in OAM
+	var objects [maxObjectsPerLine]int
+	n := 0
+	for i := 0; i < len(p.oam) && n < maxObjectsPerLine; i += 4 {
+		y := int(p.ly) + 16 - int(p.oam[i])
+		if y < 0 || y >= height {
+			continue
+		}
+		j := n
+		for ; j > 0 && p.oam[objects[j-1]+1] > p.oam[i+1]; j-- {
+			objects[j] = objects[j-1]
+		}
+		objects[j] = i
+		n++
+	}
+
+	for x := 0; x < Width; x++ {
+		for _, i := range objects[:n] {
+			ox := x + 8 - int(p.oam[i+1])
+			if ox < 0 || ox >= 8 {
+				continue
+			}
+			attr := p.oam[i+3]
+			oy := int(p.ly) + 16 - int(p.oam[i])
+			if attr&attrFlipX != 0 {
+				ox = 7 - ox
+			}
+			if attr&attrFlipY != 0 {
+				oy = height - 1 - oy
+			}
+			tile := int(p.oam[i+2])
+			if height == 16 {
+				tile &^= 1
+			}
+			color := p.tilePixel(tile*16, ox, oy)
+			if color == 0 {
+				// transparent, showing objects of lower priority
+				continue
+			}
+			if attr&attrBehind == 0 || colors[x] == 0 {
+				palette, number := p.obp0, uint8(0)
+				if attr&attrPalette != 0 {
+					palette, number = p.obp1, 1
+				}
+				if p.cgbMode {
+					number = attr & attrCGBPalette
+				}
+				line[x] = p.pixel(palette, color, number, true)
+			}
+			break
+		}
+	}
+}
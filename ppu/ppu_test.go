@@ -0,0 +1,163 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPPU() (*PPU, *cpu.Memory) {
+	mem := cpu.NewMemory()
+	p := New(mem)
+	mem.Map(0x8000, 0x9FFF, p)
+	mem.Map(0xFE00, 0xFE9F, p)
+	mem.Map(LCDC, WX, p)
+	return p, mem
+}
+
+func TestTiming(t *testing.T) {
+	p, mem := newPPU()
+	mem.Write(LYC, 2)
+	mem.Write(STAT, statLYCInt)
+	mem.Write(LCDC, lcdcEnable)
+	assert.Equal(t, ModeOAM, p.Mode())
+
+	p.Step(oamScanEnd)
+	assert.Equal(t, ModeDraw, p.Mode())
+	p.Step(drawEnd - oamScanEnd)
+	assert.Equal(t, ModeHBlank, p.Mode())
+	p.Step(LineCycles - drawEnd)
+	assert.Equal(t, ModeOAM, p.Mode())
+	assert.Equal(t, uint8(1), mem.Read(LY))
+	assert.Zero(t, mem.Read(cpu.IFAddr))
+
+	// reaching LYC requests a STAT interrupt
+	p.Step(LineCycles)
+	assert.Equal(t, uint8(2), mem.Read(LY))
+	assert.Equal(t, uint8(0x80|statLYCInt|statCoincidence|ModeOAM), mem.Read(STAT))
+	assert.Equal(t, uint8(cpu.STAT), mem.Read(cpu.IFAddr))
+	mem.Write(cpu.IFAddr, 0)
+
+	// the vertical blank completes a frame
+	p.Step((Height - 2) * LineCycles)
+	assert.Equal(t, uint8(Height), mem.Read(LY))
+	assert.Equal(t, ModeVBlank, p.Mode())
+	assert.Equal(t, uint64(1), p.Frames)
+	assert.Equal(t, uint8(cpu.VBlank), mem.Read(cpu.IFAddr))
+
+	p.Step((Lines - Height) * LineCycles)
+	assert.Equal(t, uint8(0), mem.Read(LY))
+	assert.Equal(t, ModeOAM, p.Mode())
+
+	// frames complete at the same rate with the LCD off
+	mem.Write(LCDC, 0)
+	assert.Equal(t, uint8(0), mem.Read(LY))
+	assert.Equal(t, ModeHBlank, p.Mode())
+	p.Step(FrameCycles - 1)
+	assert.Equal(t, uint64(1), p.Frames)
+	p.Step(1)
+	assert.Equal(t, uint64(2), p.Frames)
+	assert.Equal(t, uint8(0), mem.Read(LY))
+}
+
+func TestDMA(t *testing.T) {
+	p, mem := newPPU()
+	for i := uint16(0); i < dmaLength; i++ {
+		mem.Write(0xC000+i, uint8(i))
+	}
+	mem.Write(DMA, 0xC0)
+	assert.Equal(t, uint8(0xC0), mem.Read(DMA))
+	p.Step(4)
+	assert.Equal(t, uint8(0), mem.Read(0xFE00))
+	assert.Equal(t, uint8(0), mem.Read(0xFE01))
+	p.Step(4*dmaLength - 4)
+	for i := uint16(0); i < dmaLength; i++ {
+		require.Equal(t, uint8(i), mem.Read(0xFE00+i))
+	}
+}
+
+// tile writes the data of a tile of a single color to VRAM.
+func tile(mem *cpu.Memory, addr uint16, color uint8) {
+	for i := uint16(0); i < 16; i += 2 {
+		mem.Write(addr+i, 0xFF*(color&1))
+		mem.Write(addr+i+1, 0xFF*(color>>1))
+	}
+}
+
+func TestDraw(t *testing.T) {
+	p, mem := newPPU()
+	// tile 1 is color 1, tile 2 color 2, and tile 3 a diagonal of color 3 from the top left
+	tile(mem, 0x8010, 1)
+	tile(mem, 0x8020, 2)
+	for i := uint16(0); i < 8; i++ {
+		mem.Write(0x8030+2*i, 0x80>>i)
+		mem.Write(0x8030+2*i+1, 0x80>>i)
+	}
+
+	// the background is tile 1 with tile 2 at the second row and column, scrolled by 4 pixels
+	for i := uint16(0); i < 32*32; i++ {
+		mem.Write(0x9800+i, 1)
+	}
+	mem.Write(0x9800+32+1, 2)
+	mem.Write(SCX, 4)
+	mem.Write(SCY, 4)
+	mem.Write(BGP, 0xE4)
+
+	// the window is tile 3 from (100, 100)
+	for i := uint16(0); i < 32*32; i++ {
+		mem.Write(0x9C00+i, 3)
+	}
+	mem.Write(WX, 107)
+	mem.Write(WY, 100)
+
+	// objects: tile 3 at (20, 20), flipped horizontally at (40, 20), and behind the background at (60, 20)
+	objects := [][4]uint8{
+		{36, 28, 3, 0},
+		{36, 48, 3, attrFlipX},
+		{36, 68, 3, attrBehind},
+		// with palette 1, hidden under the first object, except where it is transparent
+		{36, 29, 2, attrPalette},
+	}
+	for i, o := range objects {
+		for j, v := range o {
+			mem.Write(0xFE00+uint16(4*i+j), v)
+		}
+	}
+	mem.Write(OBP0, 0xE4)
+	mem.Write(OBP1, 0x00)
+
+	mem.Write(LCDC, lcdcEnable|lcdcBGEnable|lcdcObjEnable|lcdcUnsignedTiles|lcdcWindowEnable|lcdcWindowMap)
+	p.Step(FrameCycles)
+	f := p.Frame()
+
+	assert.Equal(t, uint8(1), f[0][0])
+	assert.Equal(t, uint8(2), f[4][4])
+	assert.Equal(t, uint8(2), f[11][11])
+	assert.Equal(t, uint8(1), f[12][12])
+
+	assert.Equal(t, uint8(3), f[100][100])
+	assert.Equal(t, uint8(0), f[100][101])
+	assert.Equal(t, uint8(3), f[143][143])
+	assert.Equal(t, uint8(1), f[99][100])
+
+	assert.Equal(t, uint8(3), f[20][20])
+	assert.Equal(t, uint8(3), f[21][21])
+	// the transparent pixel of the first object shows the second
+	assert.Equal(t, uint8(0), f[21][22])
+	assert.Equal(t, uint8(3), f[20][47])
+	assert.Equal(t, uint8(1), f[20][60])
+	assert.Equal(t, uint8(1), f[21][61])
+
+	// at most 10 objects are drawn on a line
+	for i := 0; i < 12; i++ {
+		mem.Write(0xFE00+uint16(4*i), 16)
+		mem.Write(0xFE00+uint16(4*i+1), uint8(8+8*i))
+		mem.Write(0xFE00+uint16(4*i+2), 2)
+		mem.Write(0xFE00+uint16(4*i+3), 0)
+	}
+	p.Step(FrameCycles)
+	assert.Equal(t, uint8(2), f[0][72])
+	assert.Equal(t, uint8(1), f[0][80])
+}
@@ -0,0 +1,67 @@
+package ppu
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of the PPU in save states.
+const StateVersion = 2
+
+// SaveState encodes VRAM, OAM, the registers, the position of the PPU in the frame, the frames it draws, and the
+// palette RAM of the CGB.
+func (p *PPU) SaveState(e *state.Encoder) {
+	e.Slice(p.vram[:])
+	e.Slice(p.oam[:])
+	for _, r := range p.registers() {
+		e.Uint8(*r)
+	}
+	e.Int(p.dot)
+	e.Int(p.windowLine)
+	e.Bool(p.statLine)
+	e.Int(p.dmaCycles)
+	e.Int(p.offCycles)
+	for _, f := range []*Frame{&p.back, &p.front} {
+		for y := range f {
+			e.Slice(f[y][:])
+		}
+	}
+	e.Uint64(p.Frames)
+	e.Slice(p.bgPalettes[:])
+	e.Slice(p.objPalettes[:])
+	e.Uint8(p.bcps)
+	e.Uint8(p.ocps)
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (p *PPU) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("ppu: unsupported state version %d", version)
+	}
+	d.Slice(p.vram[:])
+	d.Slice(p.oam[:])
+	for _, r := range p.registers() {
+		*r = d.Uint8()
+	}
+	p.dot, p.windowLine, p.statLine = d.Int(), d.Int(), d.Bool()
+	p.dmaCycles, p.offCycles = d.Int(), d.Int()
+	for _, f := range []*Frame{&p.back, &p.front} {
+		for y := range f {
+			d.Slice(f[y][:])
+		}
+	}
+	p.Frames = d.Uint64()
+	// version 1 had no palette RAM, which is kept as it is
+	if version >= 2 {
+		d.Slice(p.bgPalettes[:])
+		d.Slice(p.objPalettes[:])
+		p.bcps, p.ocps = d.Uint8(), d.Uint8()
+	}
+	return d.Err()
+}
+
+// registers returns the registers, in the order of their addresses.
+func (p *PPU) registers() []*uint8 {
+	return []*uint8{&p.lcdc, &p.stat, &p.scy, &p.scx, &p.ly, &p.lyc, &p.dma, &p.bgp, &p.obp0, &p.obp1, &p.wy, &p.wx}
+}
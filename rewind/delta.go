@@ -0,0 +1,85 @@
+package rewind
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Deltas encode a state as its XOR with a keyframe, which is mostly zeros since little of a machine changes in a few
+// frames, compressed as runs: the length of the state, then pairs of a run of zeros and a run of literal bytes, each
+// starting with its length as a uvarint.
+
+var errCorrupt = errors.New("rewind: corrupt delta")
+
+// encode encodes s as a delta against key.
+func encode(key, s []byte) []byte {
+	d := binary.AppendUvarint(nil, uint64(len(s)))
+	for i := 0; i < len(s); {
+		zeros := i
+		for i < len(s) && s[i] == at(key, i) {
+			i++
+		}
+		literal := i
+		for i < len(s) && s[i] != at(key, i) {
+			i++
+		}
+		d = binary.AppendUvarint(d, uint64(literal-zeros))
+		d = binary.AppendUvarint(d, uint64(i-literal))
+		for j := literal; j < i; j++ {
+			d = append(d, s[j]^at(key, j))
+		}
+	}
+	return d
+}
+
+// decode decodes a delta against key.
+func decode(key, d []byte) ([]byte, error) {
+	n, err := uvarint(&d)
+	if err != nil {
+		return nil, err
+	}
+	s := make([]byte, 0, n)
+	for uint64(len(s)) < n {
+		zeros, err := uvarint(&d)
+		if err != nil {
+			return nil, err
+		}
+		literal, err := uvarint(&d)
+		if err != nil {
+			return nil, err
+		}
+		rest := n - uint64(len(s))
+		if zeros+literal == 0 || zeros > rest || literal > rest-zeros || literal > uint64(len(d)) {
+			return nil, errCorrupt
+		}
+		for j := uint64(0); j < zeros; j++ {
+			s = append(s, at(key, len(s)))
+		}
+		for _, b := range d[:literal] {
+			s = append(s, b^at(key, len(s)))
+		}
+		d = d[literal:]
+	}
+	if len(d) != 0 {
+		return nil, errCorrupt
+	}
+	return s, nil
+}
+
+// at returns the byte of key at i, or 0 past its end.
+func at(key []byte, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return 0
+}
+
+// uvarint consumes a uvarint from d.
+func uvarint(d *[]byte) (uint64, error) {
+	v, n := binary.Uvarint(*d)
+	if n <= 0 {
+		return 0, errCorrupt
+	}
+	*d = (*d)[n:]
+	return v, nil
+}
@@ -0,0 +1,37 @@
+package rewind
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelta(t *testing.T) {
+	key := bytes.Repeat([]byte{1, 2, 3, 4}, 64)
+	for name, s := range map[string][]byte{
+		"same":    key,
+		"empty":   nil,
+		"shorter": key[:100],
+		"longer":  append(append([]byte(nil), key...), 5, 6, 7),
+		"changed": append(append(append([]byte(nil), key[:10]...), 9, 9), key[12:]...),
+		"zeros":   make([]byte, len(key)),
+	} {
+		d := encode(key, s)
+		got, err := decode(key, d)
+		require.NoError(t, err, name)
+		assert.Equal(t, len(s), len(got), name)
+		assert.True(t, bytes.Equal(s, got), name)
+	}
+
+	// a state close to its keyframe encodes in a few bytes
+	s := append([]byte(nil), key...)
+	s[100] = 0xFF
+	assert.Equal(t, []byte{0x80, 0x02, 100, 1, 0xFF ^ 1, 0x9B, 0x01, 0}, encode(key, s))
+
+	for _, d := range [][]byte{{}, {4}, {4, 0, 0}, {4, 2, 3, 1, 2}, {4, 4, 0, 9}, {4, 0xFF}} {
+		_, err := decode(key, d)
+		assert.Error(t, err, "%v", d)
+	}
+}
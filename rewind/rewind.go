@@ -0,0 +1,150 @@
+// Package rewind keeps the recent history of a machine, so that it can be stepped backward frame by frame.
+//
+// A Buffer runs the machine a frame at a time, and saves its state every few frames. To keep the memory bounded, most
+// states are stored as compressed deltas against the last full state, their keyframe, and the oldest states are
+// dropped once the buffer holds more history than it was asked to. Stepping back restores the last state saved at or
+// before the previous frame, and runs the machine forward to it with the buttons that were pressed during each frame,
+// which reproduces the frame exactly, since the machine is deterministic.
+package rewind
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/gopherpocket/gopherpocket/machine"
+)
+
+// ErrNoHistory is returned when stepping back past the oldest frame of a Buffer.
+var ErrNoHistory = errors.New("rewind: no more history")
+
+// keyframeInterval is the number of states between keyframes.
+const keyframeInterval = 16
+
+// group is a keyframe and the deltas against it of the states saved after it.
+type group struct {
+	// frame is the frame of the keyframe. The deltas follow every interval frames.
+	frame  uint64
+	key    []byte
+	deltas [][]byte
+	// inputs holds the buttons pressed during each frame from the keyframe.
+	inputs []joypad.Button
+}
+
+// Buffer is a ring buffer of the recent states of a machine.
+type Buffer struct {
+	m        *machine.Machine
+	frames   int
+	interval int
+	groups   []*group
+	// frame counts the frames run since the buffer was constructed.
+	frame uint64
+}
+
+// New constructs a Buffer holding the last frames frames of m, saving its state every interval frames.
+func New(m *machine.Machine, frames, interval int) (*Buffer, error) {
+	if interval < 1 {
+		interval = 1
+	}
+	b := &Buffer{m: m, frames: frames, interval: interval}
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Frame returns the number of frames run since the buffer was constructed, less those stepped back.
+func (b *Buffer) Frame() uint64 {
+	return b.frame
+}
+
+// Len returns the number of frames the buffer can step back.
+func (b *Buffer) Len() int {
+	return int(b.frame - b.groups[0].frame)
+}
+
+// Size returns the number of bytes of the states held by the buffer.
+func (b *Buffer) Size() int {
+	n := 0
+	for _, g := range b.groups {
+		n += len(g.key)
+		for _, d := range g.deltas {
+			n += len(d)
+		}
+	}
+	return n
+}
+
+// RunFrame runs the machine for a frame with the buttons of its joypad pressed, saving its state if it is due.
+func (b *Buffer) RunFrame() error {
+	pressed := b.m.Joypad.Pressed()
+	if err := b.m.RunFrame(); err != nil {
+		return err
+	}
+	g := b.groups[len(b.groups)-1]
+	g.inputs = append(g.inputs, pressed)
+	b.frame++
+	if b.frame%uint64(b.interval) != 0 {
+		return nil
+	}
+	return b.save()
+}
+
+// save saves the state of the machine at the current frame, and drops the oldest states that are no longer needed.
+func (b *Buffer) save() error {
+	var buf bytes.Buffer
+	if err := b.m.SaveState(&buf); err != nil {
+		return err
+	}
+	if len(b.groups) > 0 {
+		if last := b.groups[len(b.groups)-1]; len(last.deltas) < keyframeInterval-1 {
+			last.deltas = append(last.deltas, encode(last.key, buf.Bytes()))
+			return nil
+		}
+	}
+	b.groups = append(b.groups, &group{frame: b.frame, key: buf.Bytes()})
+
+	// a group is dropped with its keyframe, once the next one holds enough history by itself
+	for len(b.groups) > 1 && b.frame-b.groups[1].frame >= uint64(b.frames) {
+		b.groups[0] = nil
+		b.groups = b.groups[1:]
+	}
+	return nil
+}
+
+// Back steps the machine back a frame, returning ErrNoHistory if the previous frame is older than the buffer holds.
+func (b *Buffer) Back() error {
+	if b.Len() == 0 {
+		return ErrNoHistory
+	}
+	target := b.frame - 1
+
+	// drop the states saved after the target, which are saved again when it runs forward
+	g := b.groups[len(b.groups)-1]
+	for g.frame > target {
+		b.groups = b.groups[:len(b.groups)-1]
+		g = b.groups[len(b.groups)-1]
+	}
+	i := int((target - g.frame) / uint64(b.interval))
+	g.deltas = g.deltas[:i]
+	g.inputs = g.inputs[:target-g.frame]
+
+	s := g.key
+	if i > 0 {
+		var err error
+		if s, err = decode(g.key, g.deltas[i-1]); err != nil {
+			return err
+		}
+	}
+	if err := b.m.LoadState(bytes.NewReader(s)); err != nil {
+		return err
+	}
+	for f := g.frame + uint64(i*b.interval); f < target; f++ {
+		b.m.Joypad.Press(g.inputs[f-g.frame])
+		if err := b.m.RunFrame(); err != nil {
+			return err
+		}
+	}
+	b.frame = target
+	return nil
+}
@@ -0,0 +1,72 @@
+package rewind
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/joypad"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSource keeps counting into WRAM and VRAM, so that every frame differs.
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $C000
+.loop
+	ld a, [$FF44]
+	add a, b
+	ld [hl+], a
+	ld [$8800], a
+	inc b
+	ld a, h
+	cp $E0
+	jr nz, .loop
+	ld hl, $C000
+	jr .loop
+`
+
+func TestBuffer(t *testing.T) {
+	m := testrig.Machine(t, testSource, link.Options{Title: "REWIND"})
+	b, err := New(m, 40, 4)
+	require.NoError(t, err)
+	states := [][]byte{testrig.SaveState(t, m)}
+	for i := 0; i < 200; i++ {
+		m.Joypad.Press(joypad.Button(i))
+		require.NoError(t, b.RunFrame())
+		states = append(states, testrig.SaveState(t, m))
+	}
+	assert.Equal(t, uint64(200), b.Frame())
+	// keyframes are saved every 64 frames, and dropped once the next holds 40 frames
+	assert.Equal(t, 200-128, b.Len())
+	// the deltas take a fraction of the size of full states
+	assert.Less(t, b.Size(), (b.Len()/4+1)*len(states[0])/4)
+
+	// stepping back restores every frame exactly
+	for f := 199; b.Len() > 0; f-- {
+		require.NoError(t, b.Back())
+		require.Equal(t, uint64(f), b.Frame())
+		require.True(t, bytes.Equal(states[f], testrig.SaveState(t, m)), "frame %d", f)
+	}
+	assert.Equal(t, uint64(128), b.Frame())
+	assert.ErrorIs(t, b.Back(), ErrNoHistory)
+
+	// the machine runs forward again from where it was stepped back to
+	f := int(b.Frame())
+	for i := 1; i <= 10; i++ {
+		m.Joypad.Press(joypad.Button(f + i - 1))
+		require.NoError(t, b.RunFrame())
+		require.True(t, bytes.Equal(states[f+i], testrig.SaveState(t, m)), "frame %d", f+i)
+	}
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, b.Back())
+	}
+	assert.True(t, bytes.Equal(states[f], testrig.SaveState(t, m)))
+}
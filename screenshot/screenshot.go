@@ -0,0 +1,168 @@
+// Package screenshot renders frames of the PPU to images, and compares them with golden images for regression tests.
+//
+// Tests run a ROM for some frames, and compare the last frame with a PNG image in testdata with [Golden]. When they
+// differ, the test fails, and the frame and an image of the differences are written beside the golden image. Tests
+// usually pass an -update flag to Golden, to rewrite the golden images instead:
+//
+//	var update = flag.Bool("update", false, "rewrite the golden images")
+package screenshot
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/ppu"
+)
+
+// Palette maps the four shades of a frame, from white to black, to colors.
+type Palette [4]color.RGBA
+
+// Palettes of DMG screens.
+var (
+	// Gray is a palette of evenly spaced grays, as most test suites expect.
+	Gray = Palette{{0xFF, 0xFF, 0xFF, 0xFF}, {0xAA, 0xAA, 0xAA, 0xFF}, {0x55, 0x55, 0x55, 0xFF}, {0x00, 0x00, 0x00, 0xFF}}
+	// Green is the palette of the green screen of the original Gameboy.
+	Green = Palette{{0x9B, 0xBC, 0x0F, 0xFF}, {0x8B, 0xAC, 0x0F, 0xFF}, {0x30, 0x62, 0x30, 0xFF}, {0x0F, 0x38, 0x0F, 0xFF}}
+)
+
+// Image renders a frame with a palette.
+func Image(f *ppu.Frame, p Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ppu.Width, ppu.Height))
+	for y, row := range f {
+		for x, shade := range row {
+			img.SetRGBA(x, y, p[shade&3])
+		}
+	}
+	return img
+}
+
+// ColorImage renders the last frame of the PPU of a CGB with the colors of its palettes.
+func ColorImage(p *ppu.PPU) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ppu.Width, ppu.Height))
+	for y, row := range p.Frame() {
+		for x, pixel := range row {
+			c := p.Color(pixel)
+			img.SetRGBA(x, y, color.RGBA{expand(c), expand(c >> 5), expand(c >> 10), 0xFF})
+		}
+	}
+	return img
+}
+
+// expand expands the 5 bits of a component of an RGB555 color to 8 bits.
+func expand(c uint16) uint8 {
+	v := uint8(c & 0x1F)
+	return v<<3 | v>>2
+}
+
+// Run runs m for a number of frames, and renders the last one with a palette, or in color on a CGB.
+func Run(m *machine.Machine, frames int, p Palette) (*image.RGBA, error) {
+	for i := 0; i < frames; i++ {
+		if err := m.RunFrame(); err != nil {
+			return nil, err
+		}
+	}
+	if m.Model.Color() {
+		return ColorImage(m.PPU), nil
+	}
+	return Image(m.PPU.Frame(), p), nil
+}
+
+// diffColor marks the pixels that differ in a diff image.
+var diffColor = color.RGBA{0xFF, 0x00, 0x00, 0xFF}
+
+// Diff compares two images pixel by pixel, returning the number of pixels that differ, and an image of the
+// differences: the pixels that differ in red, over a faded gray copy of want. Images of different sizes differ in
+// every pixel.
+func Diff(got, want image.Image) (int, *image.RGBA) {
+	bounds := want.Bounds()
+	diff := image.NewRGBA(bounds)
+	if got.Bounds() != bounds {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				diff.SetRGBA(x, y, diffColor)
+			}
+		}
+		return bounds.Dx() * bounds.Dy(), diff
+	}
+
+	n := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			g, w := color.RGBAModel.Convert(got.At(x, y)), color.RGBAModel.Convert(want.At(x, y))
+			if g != w {
+				n++
+				diff.SetRGBA(x, y, diffColor)
+				continue
+			}
+			gray := color.GrayModel.Convert(w).(color.Gray).Y
+			faded := 0xC0 + gray/4
+			diff.SetRGBA(x, y, color.RGBA{faded, faded, faded, 0xFF})
+		}
+	}
+	return n, diff
+}
+
+// Golden compares got with the golden PNG image at path. If they differ, the test fails, and got and the image of
+// their differences are written beside the golden image, with the suffixes .got.png and .diff.png. If update is set,
+// the golden image is rewritten instead.
+func Golden(t testing.TB, path string, got image.Image, update bool) {
+	t.Helper()
+	base := strings.TrimSuffix(path, ".png")
+	if update {
+		if err := Write(path, got); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(base + ".got.png")
+		_ = os.Remove(base + ".diff.png")
+		return
+	}
+
+	want, err := Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("no golden image %s: run the test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, diff := Diff(got, want)
+	if n == 0 {
+		return
+	}
+	if err := Write(base+".got.png", got); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(base+".diff.png", diff); err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("%d pixels differ from %s: see %s.got.png and %s.diff.png", n, path, base, base)
+}
+
+// Read reads a PNG image.
+func Read(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// Write writes an image as PNG.
+func Write(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
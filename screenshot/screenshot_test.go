@@ -0,0 +1,239 @@
+package screenshot
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/gopherpocket/gopherpocket/ppu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	update = flag.Bool("update", false, "rewrite the golden images")
+	romDir = flag.String("roms", "../testrom/testdata/roms", "run the acid2 test ROM under `dir`")
+)
+
+// scene draws a checkerboard background scrolled by 3 pixels, a white window in the bottom right corner, and an
+// object over both.
+const scene = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $8010
+	ld de, Tiles
+	ld b, TilesEnd - Tiles
+.copy
+	ld a, [de]
+	ld [hl+], a
+	inc de
+	dec b
+	jr nz, .copy
+
+	; tile 1 or 2 by the parity of the row and column
+	ld hl, $9800
+.map
+	ld a, l
+	swap a
+	rrca
+	xor l
+	and 1
+	inc a
+	ld [hl+], a
+	ld a, h
+	cp $9C
+	jr nz, .map
+
+	ld hl, $FE00
+	ld a, 16 + 60
+	ld [hl+], a
+	ld a, 8 + 116
+	ld [hl+], a
+	ld a, 3
+	ld [hl+], a
+	xor a
+	ld [hl+], a
+
+	ld a, 3
+	ldh [$FF43], a
+	ld a, 104
+	ldh [$FF4A], a
+	ld a, 7 + 120
+	ldh [$FF4B], a
+	ld a, $E4
+	ldh [$FF47], a
+	ldh [$FF48], a
+	ld a, $F3
+	ldh [$FF40], a
+.spin
+	jr .spin
+
+Tiles:
+	db $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00
+	db $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF, $00, $FF
+	db $81, $81, $42, $42, $24, $24, $18, $18, $18, $18, $24, $24, $42, $42, $81, $81
+TilesEnd:
+`
+
+func TestScene(t *testing.T) {
+	m := testrig.Machine(t, scene, link.Options{Title: "SCENE", Type: cartridge.ROMOnly})
+	got, err := Run(m, 3, Gray)
+	require.NoError(t, err)
+	Golden(t, filepath.Join("testdata", "scene.png"), got, *update)
+}
+
+func TestAcid2(t *testing.T) {
+	rom, err := os.ReadFile(filepath.Join(*romDir, "dmg-acid2.gb"))
+	if err != nil {
+		t.Skipf("no test ROM: %v", err)
+	}
+	m, err := machine.New(rom)
+	require.NoError(t, err)
+	got, err := Run(m, 60, Gray)
+	require.NoError(t, err)
+	Golden(t, filepath.Join("testdata", "dmg-acid2.png"), got, *update)
+}
+
+func TestImage(t *testing.T) {
+	var f ppu.Frame
+	f[1][2] = 3
+	f[ppu.Height-1][ppu.Width-1] = 1
+	img := Image(&f, Green)
+	assert.Equal(t, image.Rect(0, 0, ppu.Width, ppu.Height), img.Bounds())
+	assert.Equal(t, Green[0], img.RGBAAt(0, 0))
+	assert.Equal(t, Green[3], img.RGBAAt(2, 1))
+	assert.Equal(t, Green[1], img.RGBAAt(ppu.Width-1, ppu.Height-1))
+}
+
+func TestColorImage(t *testing.T) {
+	// tile 0 is color 1, a light shade with BGP $E4
+	img := testrig.ROM(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld hl, $8000
+	ld b, 8
+.tile
+	ld a, $FF
+	ld [hl+], a
+	xor a
+	ld [hl+], a
+	dec b
+	jr nz, .tile
+	ld a, $E4
+	ldh [$47], a
+.spin
+	jr .spin
+`, link.Options{Title: "COLOR"})
+
+	// the CGB shows cartridges for the DMG with its compatibility palettes
+	m, err := machine.NewWithOptions(img.ROM, machine.Options{Model: machine.Auto})
+	require.NoError(t, err)
+	got, err := Run(m, 2, Gray)
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF}, got.RGBAAt(0, 0))
+
+	m, err = machine.NewWithOptions(img.ROM, machine.Options{Model: machine.CGB})
+	require.NoError(t, err)
+	got, err = Run(m, 2, Gray)
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{0x7B, 0xFF, 0x31, 0xFF}, got.RGBAAt(0, 0))
+	assert.Equal(t, got, ColorImage(m.PPU))
+}
+
+func TestDiff(t *testing.T) {
+	want := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	got := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for _, img := range []*image.RGBA{want, got} {
+		for i := range img.Pix {
+			img.Pix[i] = 0xFF
+		}
+	}
+	n, diff := Diff(got, want)
+	assert.Zero(t, n)
+	assert.Equal(t, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, diff.RGBAAt(0, 0))
+
+	got.SetRGBA(1, 1, color.RGBA{0, 0, 0, 0xFF})
+	n, diff = Diff(got, want)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, diffColor, diff.RGBAAt(1, 1))
+	assert.NotEqual(t, diffColor, diff.RGBAAt(0, 1))
+
+	n, _ = Diff(image.NewRGBA(image.Rect(0, 0, 2, 2)), want)
+	assert.Equal(t, 8, n)
+}
+
+// recorder records the failures of a test.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// golden calls Golden in its own goroutine, which fatal failures end.
+func (r *recorder) golden(path string, got image.Image, update bool) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Golden(r, path, got, update)
+	}()
+	<-done
+}
+
+func TestGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.png")
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.SetRGBA(0, 0, color.RGBA{0xFF, 0, 0, 0xFF})
+
+	r := &recorder{TB: t}
+	r.golden(path, img, false)
+	require.Len(t, r.failures, 1)
+	assert.Contains(t, r.failures[0], "-update")
+
+	// updating writes the golden image, which then matches
+	r.golden(path, img, true)
+	r.failures = nil
+	r.golden(path, img, false)
+	assert.Empty(t, r.failures)
+
+	// a mismatch writes the image and the differences beside the golden image
+	changed := image.NewRGBA(img.Bounds())
+	r.golden(path, changed, false)
+	require.Len(t, r.failures, 1)
+	assert.Contains(t, r.failures[0], "1 pixels differ")
+	got, err := Read(filepath.Join(filepath.Dir(path), "golden.got.png"))
+	require.NoError(t, err)
+	n, _ := Diff(got, changed)
+	assert.Zero(t, n)
+	_, err = os.Stat(filepath.Join(filepath.Dir(path), "golden.diff.png"))
+	assert.NoError(t, err)
+
+	// updating removes them
+	r.golden(path, changed, true)
+	_, err = os.Stat(filepath.Join(filepath.Dir(path), "golden.diff.png"))
+	assert.True(t, os.IsNotExist(err))
+}
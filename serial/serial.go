@@ -0,0 +1,97 @@
+// Package serial implements the serial port of the Gameboy, with nothing connected to it: transfers clocked by the
+// Gameboy shift in 1s, and those clocked by the other end never complete.
+package serial
+
+import (
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// Addresses of the serial port registers.
+const (
+	SB = 0xFF01
+	SC = 0xFF02
+)
+
+// Bits of SC.
+const (
+	scTransfer = 1 << 7
+	// scFast selects the fast clock of the CGB.
+	scFast = 1 << 1
+	// scInternal selects the clock of the Gameboy rather than the other end's.
+	scInternal = 1 << 0
+)
+
+// Clock cycles of the CPU per bit shifted, at 8192Hz, and 262144Hz with the fast clock of the CGB, at normal speed.
+const (
+	bitCycles     = 512
+	fastBitCycles = 16
+)
+
+// Serial is the serial port, mapped at SB and SC. Its clock is derived from the CPU's, so it runs twice as fast while
+// the CGB is at double speed.
+type Serial struct {
+	mem   *cpu.Memory
+	color bool
+	sb    uint8
+	sc    uint8
+	// bits are the bits left to shift of the current transfer, and cycles the clock cycles until the next one.
+	bits, cycles int
+}
+
+// New constructs the Serial port of the DMG, or of the CGB if color is set, which requests interrupts from mem.
+func New(mem *cpu.Memory, color bool) *Serial {
+	return &Serial{mem: mem, color: color}
+}
+
+// period returns the clock cycles per bit shifted.
+func (s *Serial) period() int {
+	if s.sc&scFast != 0 {
+		return fastBitCycles
+	}
+	return bitCycles
+}
+
+// Step advances a transfer by a number of clock cycles of the CPU, requesting a serial interrupt when it completes.
+func (s *Serial) Step(cycles int) {
+	for s.bits > 0 && cycles > 0 {
+		if cycles < s.cycles {
+			s.cycles -= cycles
+			return
+		}
+		cycles -= s.cycles
+		s.cycles = s.period()
+		s.sb = s.sb<<1 | 1
+		if s.bits--; s.bits == 0 {
+			s.sc &^= scTransfer
+			cpu.RequestInterrupt(s.mem, cpu.Serial)
+		}
+	}
+}
+
+// Read implements cpu.Device.
+func (s *Serial) Read(addr uint16) uint8 {
+	if addr == SB {
+		return s.sb
+	}
+	if s.color {
+		return 0x7C | s.sc
+	}
+	return 0x7E | s.sc
+}
+
+// Write implements cpu.Device. Setting the transfer bit of SC with the internal clock starts a transfer.
+func (s *Serial) Write(addr uint16, v uint8) {
+	if addr == SB {
+		s.sb = v
+		return
+	}
+	mask := uint8(scTransfer | scInternal)
+	if s.color {
+		mask |= scFast
+	}
+	s.sc = v & mask
+	s.bits, s.cycles = 0, 0
+	if s.sc&(scTransfer|scInternal) == scTransfer|scInternal {
+		s.bits, s.cycles = 8, s.period()
+	}
+}
@@ -0,0 +1,47 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransfer(t *testing.T) {
+	mem := cpu.NewMemory()
+	s := New(mem, false)
+	mem.Map(SB, SC, s)
+	assert.Equal(t, uint8(0x7E), mem.Read(SC))
+
+	// transfers clocked by the other end never complete
+	mem.Write(SB, 0x0F)
+	mem.Write(SC, scTransfer)
+	s.Step(16 * bitCycles)
+	assert.Equal(t, uint8(0xFE), mem.Read(SC))
+	assert.Equal(t, uint8(0x0F), mem.Read(SB))
+
+	// those clocked by the Gameboy shift in 1s, a bit at a time
+	mem.Write(SC, scTransfer|scInternal|scFast)
+	assert.Equal(t, uint8(0xFF), mem.Read(SC))
+	s.Step(4 * bitCycles)
+	assert.Equal(t, uint8(0xFF), mem.Read(SB))
+	s.Step(4*bitCycles - 4)
+	assert.Equal(t, uint8(0xFF), mem.Read(SC))
+	assert.Zero(t, mem.Read(cpu.IFAddr))
+	s.Step(4)
+	assert.Equal(t, uint8(0x7F), mem.Read(SC))
+	assert.Equal(t, uint8(cpu.Serial), mem.Read(cpu.IFAddr))
+}
+
+func TestFastTransfer(t *testing.T) {
+	mem := cpu.NewMemory()
+	s := New(mem, true)
+	mem.Map(SB, SC, s)
+	mem.Write(SB, 0)
+	mem.Write(SC, scTransfer|scInternal|scFast)
+	assert.Equal(t, uint8(0xFF), mem.Read(SC))
+	s.Step(4 * fastBitCycles)
+	assert.Equal(t, uint8(0x0F), mem.Read(SB))
+	s.Step(4 * fastBitCycles)
+	assert.Equal(t, uint8(0x7F), mem.Read(SC))
+}
@@ -0,0 +1,28 @@
+package serial
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of the serial port in save states.
+const StateVersion = 1
+
+// SaveState encodes the registers of the serial port, and the progress of its transfer.
+func (s *Serial) SaveState(e *state.Encoder) {
+	e.Uint8(s.sb)
+	e.Uint8(s.sc)
+	e.Int(s.bits)
+	e.Int(s.cycles)
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (s *Serial) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("serial: unsupported state version %d", version)
+	}
+	s.sb, s.sc = d.Uint8(), d.Uint8()
+	s.bits, s.cycles = d.Int(), d.Int()
+	return d.Err()
+}
@@ -0,0 +1,243 @@
+// Package state implements the binary format of save states.
+//
+// A save state starts with a magic string and the version of the format, followed by sections. Each section has a
+// four character tag naming the subsystem it holds, such as "CPU ", the version of that subsystem's encoding, and the
+// length of its data, so that readers skip the sections they do not know, and subsystems decode the older versions of
+// their own sections. All integers are little endian.
+//
+// Sound is not emulated yet, so save states have no section for the APU: the values written to the sound registers
+// and wave RAM are saved with the other I/O registers, but the internal state of the channels, such as their length
+// counters, envelopes and frequency timers, and the frame sequencer, is not saved. The APU will add a section of its
+// own, which states saved before it lack.
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic starts every save state.
+const Magic = "GPSTATE\x00"
+
+// Version is the version of the format of the container, not of its sections.
+const Version = 1
+
+// Section is a tagged section of a save state.
+type Section struct {
+	Tag     string
+	Version int
+	Data    []byte
+}
+
+// Write writes a save state made of sections.
+func Write(w io.Writer, sections []Section) error {
+	return WriteMagic(w, Magic, sections)
+}
+
+// WriteMagic writes sections in the format of save states, starting with another magic string, for files of other
+// kinds made of sections, such as movies.
+func WriteMagic(w io.Writer, magic string, sections []Section) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(magic)
+	_ = binary.Write(bw, binary.LittleEndian, uint16(Version))
+	for _, s := range sections {
+		if len(s.Tag) != 4 {
+			return fmt.Errorf("state: tag %q is not 4 characters", s.Tag)
+		}
+		bw.WriteString(s.Tag)
+		_ = binary.Write(bw, binary.LittleEndian, uint16(s.Version))
+		_ = binary.Write(bw, binary.LittleEndian, uint32(len(s.Data)))
+		bw.Write(s.Data)
+	}
+	return bw.Flush()
+}
+
+// Read reads the sections of a save state.
+func Read(r io.Reader) ([]Section, error) {
+	sections, err := ReadMagic(r, Magic)
+	if errors.Is(err, errMagic) {
+		return nil, errors.New("state: not a save state")
+	}
+	return sections, err
+}
+
+var errMagic = errors.New("state: unknown file format")
+
+// ReadMagic reads the sections of a file written by WriteMagic with magic.
+func ReadMagic(r io.Reader, magic string) ([]Section, error) {
+	br := bufio.NewReader(r)
+	got := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != magic {
+		return nil, errMagic
+	}
+	var version uint16
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("state: %w", noEOF(err))
+	}
+	if version > Version {
+		return nil, fmt.Errorf("state: unsupported version %d", version)
+	}
+
+	var sections []Section
+	for {
+		var header struct {
+			Tag     [4]byte
+			Version uint16
+			Length  uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &header); err == io.EOF {
+			return sections, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("state: %w", noEOF(err))
+		}
+		// the length is not trusted to allocate the data before reading it, as a damaged file may claim up to 4 GiB
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, br, int64(header.Length)); err != nil {
+			return nil, fmt.Errorf("state: section %q: %w", header.Tag[:], noEOF(err))
+		}
+		sections = append(sections, Section{Tag: string(header.Tag[:]), Version: int(header.Version), Data: data.Bytes()})
+	}
+}
+
+// noEOF turns an EOF in the middle of a save state into an unexpected EOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Encoder encodes the data of a section.
+type Encoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded data.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *Encoder) Uint16(v uint16) {
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, v)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+// Int encodes an int as 64 bits.
+func (e *Encoder) Int(v int) {
+	e.Uint64(uint64(v))
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Uint8(1)
+	} else {
+		e.Uint8(0)
+	}
+}
+
+// Slice encodes a slice of bytes with its length.
+func (e *Encoder) Slice(b []byte) {
+	e.Uint32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// String encodes a string with its length.
+func (e *Encoder) String(s string) {
+	e.Slice([]byte(s))
+}
+
+// Decoder decodes the data of a section. The first error is kept, after which every value decodes as zero.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+// NewDecoder constructs a new Decoder of data.
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{buf: data}
+}
+
+// Err returns the first error.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// next consumes n bytes.
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if len(d.buf) < n {
+		d.fail(io.ErrUnexpectedEOF)
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// fail sets the error, unless there is one already.
+func (d *Decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *Decoder) Uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *Decoder) Uint16() uint16 {
+	return binary.LittleEndian.Uint16(d.next(2))
+}
+
+func (d *Decoder) Uint32() uint32 {
+	return binary.LittleEndian.Uint32(d.next(4))
+}
+
+func (d *Decoder) Uint64() uint64 {
+	return binary.LittleEndian.Uint64(d.next(8))
+}
+
+func (d *Decoder) Int() int {
+	return int(d.Uint64())
+}
+
+func (d *Decoder) Bool() bool {
+	return d.Uint8() != 0
+}
+
+// Slice decodes a slice of bytes into dst, which must have the length it was encoded with.
+func (d *Decoder) Slice(dst []byte) {
+	n := int(d.Uint32())
+	if d.err == nil && n != len(dst) {
+		d.err = fmt.Errorf("state: %d bytes where %d are expected", n, len(dst))
+		return
+	}
+	copy(dst, d.next(n))
+}
+
+// String decodes a string encoded with its length.
+func (d *Decoder) String() string {
+	n := int(d.Uint32())
+	if n > len(d.buf) {
+		d.fail(io.ErrUnexpectedEOF)
+		return ""
+	}
+	return string(d.next(n))
+}
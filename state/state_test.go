@@ -0,0 +1,105 @@
+package state
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	var e Encoder
+	e.Uint8(1)
+	e.Uint16(0x0203)
+	e.Uint32(0x04050607)
+	e.Uint64(0x08090A0B0C0D0E0F)
+	e.Int(-2)
+	e.Bool(true)
+	e.Slice([]byte("abc"))
+	e.String("de")
+
+	sections := []Section{{Tag: "TEST", Version: 3, Data: e.Bytes()}, {Tag: "NONE", Version: 1}}
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, sections))
+	assert.Equal(t, []byte(Magic+"\x01\x00TEST\x03\x00\x25\x00\x00\x00\x01"), buf.Bytes()[:len(Magic)+13])
+
+	got, err := Read(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "TEST", got[0].Tag)
+	assert.Equal(t, 3, got[0].Version)
+	assert.Equal(t, "NONE", got[1].Tag)
+	assert.Empty(t, got[1].Data)
+
+	d := NewDecoder(got[0].Data)
+	assert.Equal(t, uint8(1), d.Uint8())
+	assert.Equal(t, uint16(0x0203), d.Uint16())
+	assert.Equal(t, uint32(0x04050607), d.Uint32())
+	assert.Equal(t, uint64(0x08090A0B0C0D0E0F), d.Uint64())
+	assert.Equal(t, -2, d.Int())
+	assert.True(t, d.Bool())
+	s := make([]byte, 3)
+	d.Slice(s)
+	assert.Equal(t, "abc", string(s))
+	assert.Equal(t, "de", d.String())
+	require.NoError(t, d.Err())
+
+	// reading past the end keeps the error, and decodes zeros
+	assert.Zero(t, d.Uint32())
+	assert.ErrorIs(t, d.Err(), io.ErrUnexpectedEOF)
+	assert.False(t, d.Bool())
+
+	// slices must have the length they were encoded with
+	d = NewDecoder(got[0].Data[len(got[0].Data)-13:])
+	d.Slice(make([]byte, 2))
+	assert.Error(t, d.Err())
+
+	// as must strings
+	d = NewDecoder([]byte{0xFF, 0xFF, 0xFF, 0xFF, 'a'})
+	assert.Equal(t, "", d.String())
+	assert.ErrorIs(t, d.Err(), io.ErrUnexpectedEOF)
+
+	assert.Error(t, Write(&buf, []Section{{Tag: "LONG TAG"}}))
+
+	// other kinds of files have their own magic
+	buf.Reset()
+	require.NoError(t, WriteMagic(&buf, "OTHER", sections))
+	got, err = ReadMagic(bytes.NewReader(buf.Bytes()), "OTHER")
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+	_, err = Read(bytes.NewReader(buf.Bytes()))
+	assert.EqualError(t, err, "state: not a save state")
+}
+
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []Section{{Tag: "TEST", Version: 1, Data: []byte{1, 2, 3}}}))
+	valid := buf.Bytes()
+
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"magic":          append([]byte("NOTSTATE"), valid[len(Magic):]...),
+		"version":        append([]byte(Magic+"\x02\x00"), valid[len(Magic)+2:]...),
+		"short version":  valid[:len(Magic)+1],
+		"short header":   valid[:len(Magic)+5],
+		"short section":  valid[:len(valid)-1],
+		"truncated data": valid[:len(Magic)+2+10+1],
+	} {
+		_, err := Read(bytes.NewReader(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestReadLength(t *testing.T) {
+	// a section claiming 4 GiB of data in a short file is an error, rather than an allocation of its length
+	data := []byte(Magic + "\x01\x00TEST\x01\x00\xFF\xFF\xFF\xFF\x01\x02")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Read(bytes.NewReader(data))
+	runtime.ReadMemStats(&after)
+	assert.EqualError(t, err, `state: section "TEST": unexpected EOF`)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
@@ -0,0 +1,220 @@
+// Package testrom runs test ROMs headlessly, and detects whether they passed or failed through the protocols of the
+// common test suites:
+//
+//   - Blargg's tests write their results to the serial port, ending with "Passed" or "Failed".
+//   - Blargg's newer tests also store their results in cartridge RAM: the signature $DE $B0 $61 at $A001-$A003, a status
+//     at $A000 that is $80 while the test runs and 0 if it passed, and their text from $A004 up to a NUL.
+//   - Mooneye's tests execute LD B, B when they finish, with the Fibonacci numbers 3, 5, 8, 13, 21 and 34 in B, C, D, E,
+//     H and L if they passed, or $42 in all of them if they failed.
+//
+// The tests of this package run the ROMs of the blargg and mooneye directories of testdata/roms, or of the directory
+// named by the -roms flag, and are skipped if they do not exist:
+//
+//	go test ./testrom -roms ~/gb-test-roms
+package testrom
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/gopherpocket/gopherpocket/machine"
+)
+
+// Status is the outcome of a test ROM.
+type Status int
+
+const (
+	// Timeout is the status of a ROM that did not report a result in time.
+	Timeout Status = iota
+	Passed
+	Failed
+)
+
+// String implements fmt.Stringer
+func (s Status) String() string {
+	switch s {
+	case Passed:
+		return "passed"
+	case Failed:
+		return "failed"
+	default:
+		return "timed out"
+	}
+}
+
+// Protocols through which ROMs report their results.
+const (
+	Serial  = "serial"
+	Memory  = "memory"
+	Mooneye = "mooneye"
+)
+
+// Result is the result of a test ROM.
+type Result struct {
+	Status Status
+	// Protocol is the protocol the result was reported through, or "" after a timeout.
+	Protocol string
+	// Output is the text the ROM wrote to the serial port, or to cartridge RAM if it reported its result there.
+	Output string
+	// Cycles is the number of clock cycles the ROM ran for.
+	Cycles uint64
+}
+
+// Addresses of the serial port registers.
+const (
+	sbAddr = 0xFF01
+	scAddr = 0xFF02
+)
+
+// signature marks the results of blargg's tests in cartridge RAM, at $A001.
+var signature = []byte{0xDE, 0xB0, 0x61}
+
+// statusRunning is the status of blargg's tests in cartridge RAM while they run.
+const statusRunning = 0x80
+
+// ldBB is the opcode of LD B, B.
+const ldBB = 0x40
+
+// Run runs rom for up to maxCycles clock cycles, until it reports its result, on the model its header selects, with
+// the fast core.
+func Run(rom []byte, maxCycles uint64) (Result, error) {
+	m, err := machine.NewWithOptions(rom, machine.Options{Model: machine.Auto, FastCore: true})
+	if err != nil {
+		return Result{}, err
+	}
+	return RunMachine(m, maxCycles)
+}
+
+// RunMachine runs the ROM in m for up to maxCycles more clock cycles, until it reports its result.
+func RunMachine(m *machine.Machine, maxCycles uint64) (Result, error) {
+	var r Result
+	var serial bytes.Buffer
+	mem := m.Memory
+
+	// a transfer started with the internal clock sends SB
+	removeSerial := mem.AddHook(scAddr, scAddr, cpu.AccessWrite, func(_ uint16, v uint8, _ cpu.Access) {
+		if v&0x81 != 0x81 {
+			return
+		}
+		serial.WriteByte(mem.Peek(sbAddr))
+		switch out := serial.String(); {
+		case strings.Contains(out, "Passed"):
+			r.Status, r.Protocol = Passed, Serial
+		case strings.Contains(out, "Failed"):
+			r.Status, r.Protocol = Failed, Serial
+		}
+	})
+	defer removeSerial()
+
+	removeMemory := mem.AddHook(0xA000, 0xA000, cpu.AccessWrite, func(_ uint16, v uint8, _ cpu.Access) {
+		if v == statusRunning {
+			return
+		}
+		for i, b := range signature {
+			if mem.Peek(0xA001+uint16(i)) != b {
+				return
+			}
+		}
+		r.Status, r.Protocol = Failed, Memory
+		if v == 0 {
+			r.Status = Passed
+		}
+	})
+	defer removeMemory()
+
+	ldbb := false
+	removeMooneye := mem.AddHook(0x0000, 0xFFFF, cpu.AccessExecute, func(_ uint16, v uint8, _ cpu.Access) {
+		ldbb = v == ldBB
+	})
+	defer removeMooneye()
+
+	start := m.Cycles
+	for r.Status == Timeout && m.Cycles-start < maxCycles {
+		if _, err := m.Step(); err != nil {
+			r.Cycles = m.Cycles - start
+			r.Output = serial.String()
+			return r, err
+		}
+		if ldbb {
+			ldbb = false
+			r.Status, r.Protocol = mooneye(m.CPU), Mooneye
+		}
+	}
+
+	r.Cycles = m.Cycles - start
+	r.Output = serial.String()
+	if r.Protocol == Memory {
+		var text []byte
+		for addr := uint16(0xA004); addr < 0xC000; addr++ {
+			b := mem.Peek(addr)
+			if b == 0 {
+				break
+			}
+			text = append(text, b)
+		}
+		r.Output = string(text)
+	}
+	return r, nil
+}
+
+// mooneye returns the status reported by the registers when a mooneye test executed LD B, B.
+func mooneye(c *cpu.SimpleCore) Status {
+	regs := []uint8{c.BC.Hi(), c.BC.Lo(), c.DE.Hi(), c.DE.Lo(), c.HL.Hi(), c.HL.Lo()}
+	for i, want := range []uint8{3, 5, 8, 13, 21, 34} {
+		if regs[i] != want {
+			return Failed
+		}
+	}
+	return Passed
+}
+
+// RunDir runs each ROM under dir for up to maxCycles clock cycles as a subtest of t named by its path within dir,
+// which fails unless the ROM passes. t is skipped if dir does not exist.
+func RunDir(t *testing.T, dir string, maxCycles uint64) {
+	t.Helper()
+	if _, err := os.Stat(dir); err != nil {
+		t.Skipf("no test ROMs: %v", err)
+	}
+
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".gb" && ext != ".gbc" {
+			return nil
+		}
+		name, _ := filepath.Rel(dir, path)
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			t.Parallel()
+			rom, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Run(rom, maxCycles)
+			if err != nil {
+				t.Fatalf("%v after %d cycles\n%s", err, r.Cycles, r.Output)
+			}
+			if r.Status != Passed {
+				t.Errorf("%s after %d cycles\n%s", describe(r), r.Cycles, r.Output)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// describe describes the status of a result and its protocol.
+func describe(r Result) string {
+	if r.Protocol == "" {
+		return r.Status.String()
+	}
+	return fmt.Sprintf("%s (%s)", r.Status, r.Protocol)
+}
@@ -0,0 +1,185 @@
+package testrom
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var romDir = flag.String("roms", "testdata/roms", "run the test ROMs under `dir`")
+
+// maxSeconds is the longest a test ROM of the suites runs for.
+const maxSeconds = 60
+
+func TestBlargg(t *testing.T) {
+	RunDir(t, filepath.Join(*romDir, "blargg"), maxSeconds*machine.ClockRate)
+}
+
+func TestMooneye(t *testing.T) {
+	RunDir(t, filepath.Join(*romDir, "mooneye"), maxSeconds*machine.ClockRate)
+}
+
+// build assembles a test ROM from main, which runs after the header.
+func build(t *testing.T, main string) []byte {
+	t.Helper()
+	return buildWith(t, main, 0)
+}
+
+// buildWith assembles a test ROM from main with a CGB flag in its header.
+func buildWith(t *testing.T, main string, cgbFlag uint8) []byte {
+	t.Helper()
+	return testrig.ROM(t, `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+`+main, link.Options{Title: "TESTROM", Type: cartridge.MBC1RAM, RAMSize: 0x02, CGBFlag: cgbFlag}).ROM
+}
+
+// serial prints the NUL terminated string at Message to the serial port.
+const serial = `
+	ld hl, Message
+.next
+	ld a, [hl+]
+	and a
+	jr z, .done
+	ldh [$FF01], a
+	ld a, $81
+	ldh [$FF02], a
+	jr .next
+.done
+	jr .done
+`
+
+// memory stores a status and the NUL terminated string at Message in cartridge RAM.
+const memory = `
+	ld a, $0A
+	ld [$0000], a
+	ld a, $80
+	ld [$A000], a
+	ld a, $DE
+	ld [$A001], a
+	ld a, $B0
+	ld [$A002], a
+	ld a, $61
+	ld [$A003], a
+	ld hl, Message
+	ld de, $A004
+.next
+	ld a, [hl+]
+	ld [de], a
+	inc de
+	and a
+	jr nz, .next
+	ld a, STATUS
+	ld [$A000], a
+.done
+	jr .done
+`
+
+// fibonacci loads the registers mooneye's tests pass with.
+const fibonacci = `
+	ld b, 3
+	ld c, 5
+	ld d, 8
+	ld e, 13
+	ld h, 21
+	ld l, 34
+`
+
+func TestRun(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		main   string
+		want   Result
+		cycles uint64
+	}{
+		{
+			name: "serial passed",
+			main: serial + `Message: db "cpu_instrs", 10, 10, "Passed", 10, 0`,
+			want: Result{Status: Passed, Protocol: Serial, Output: "cpu_instrs\n\nPassed"},
+		},
+		{
+			name: "serial failed",
+			main: serial + `Message: db "01:ok 02:01 ", 10, 10, "Failed 1 tests.", 0`,
+			want: Result{Status: Failed, Protocol: Serial, Output: "01:ok 02:01 \n\nFailed"},
+		},
+		{
+			name: "memory passed",
+			main: "STATUS EQU 0\n" + memory + `Message: db "Passed", 10, 0`,
+			want: Result{Status: Passed, Protocol: Memory, Output: "Passed\n"},
+		},
+		{
+			name: "memory failed",
+			main: "STATUS EQU 1\n" + memory + `Message: db "Failed #1", 10, 0`,
+			want: Result{Status: Failed, Protocol: Memory, Output: "Failed #1\n"},
+		},
+		{
+			name: "mooneye passed",
+			main: fibonacci + "\tld b, b\n.spin\n\tjr .spin\n",
+			want: Result{Status: Passed, Protocol: Mooneye},
+		},
+		{
+			name: "mooneye failed",
+			main: "\tld a, $42\n\tld b, a\n\tld c, a\n\tld d, a\n\tld e, a\n\tld h, a\n\tld l, a\n\tld b, b\n.spin\n\tjr .spin\n",
+			want: Result{Status: Failed, Protocol: Mooneye},
+		},
+		{
+			name:   "timeout",
+			main:   fibonacci + ".spin\n\tjr .spin\n",
+			want:   Result{Status: Timeout},
+			cycles: 1000,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cycles := test.cycles
+			if cycles == 0 {
+				cycles = 100000
+			}
+			r, err := Run(build(t, test.main), cycles)
+			require.NoError(t, err)
+			if test.want.Status == Timeout {
+				assert.GreaterOrEqual(t, r.Cycles, cycles)
+			} else {
+				assert.Less(t, r.Cycles, cycles)
+			}
+			r.Cycles = 0
+			assert.Equal(t, test.want, r)
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	// $D3 is not an instruction
+	r, err := Run(build(t, "\tdb $D3\n"), 1000)
+	assert.Error(t, err)
+	assert.Equal(t, Timeout, r.Status)
+}
+
+func TestRunModel(t *testing.T) {
+	// A is $11 after the boot ROM of the CGB
+	main := `
+	cp $11
+	jr nz, .fail
+` + fibonacci + `
+.fail
+	ld b, b
+.spin
+	jr .spin
+`
+	r, err := Run(buildWith(t, main, cartridge.CGBSupported), 100000)
+	require.NoError(t, err)
+	assert.Equal(t, Passed, r.Status)
+	r, err = Run(build(t, main), 100000)
+	require.NoError(t, err)
+	assert.Equal(t, Failed, r.Status)
+}
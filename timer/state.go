@@ -0,0 +1,30 @@
+package timer
+
+import (
+	"fmt"
+
+	"github.com/gopherpocket/gopherpocket/state"
+)
+
+// StateVersion is the version of the encoding of the timer in save states.
+const StateVersion = 1
+
+// SaveState encodes the counter and the registers of the timer.
+func (t *Timer) SaveState(e *state.Encoder) {
+	e.Uint16(t.counter)
+	e.Uint8(t.tima)
+	e.Uint8(t.tma)
+	e.Uint8(t.tac)
+	e.Bool(t.reload)
+}
+
+// LoadState decodes a state encoded by SaveState.
+func (t *Timer) LoadState(d *state.Decoder, version int) error {
+	if version > StateVersion {
+		return fmt.Errorf("timer: unsupported state version %d", version)
+	}
+	t.counter = d.Uint16()
+	t.tima, t.tma, t.tac = d.Uint8(), d.Uint8(), d.Uint8()
+	t.reload = d.Bool()
+	return d.Err()
+}
@@ -0,0 +1,107 @@
+// Package timer implements the timer of the Gameboy: the divider, DIV, and the counter, TIMA, which requests timer
+// interrupts when it overflows.
+package timer
+
+import (
+	"github.com/gopherpocket/gopherpocket/cpu"
+)
+
+// Addresses of the timer registers.
+const (
+	DIV  = 0xFF04
+	TIMA = 0xFF05
+	TMA  = 0xFF06
+	TAC  = 0xFF07
+)
+
+// Bits of TAC.
+const (
+	tacEnable = 1 << 2
+	tacClock  = 3
+)
+
+// clockBits are the bits of the counter whose falling edges increment TIMA, for each clock TAC selects: 4096Hz,
+// 262144Hz, 65536Hz and 16384Hz at normal speed.
+var clockBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+// Timer is the timer, mapped at DIV-TAC. It counts the clock cycles of the CPU, so it runs twice as fast while the
+// CGB is at double speed.
+type Timer struct {
+	mem *cpu.Memory
+	// counter is incremented every clock cycle. DIV is its high byte.
+	counter        uint16
+	tima, tma, tac uint8
+	// reload is set for the 4 clock cycles after TIMA overflows, which it reads as 0 before it is reloaded from TMA and
+	// the interrupt is requested.
+	reload bool
+}
+
+// New constructs a Timer, which requests interrupts from mem.
+func New(mem *cpu.Memory) *Timer {
+	return &Timer{mem: mem}
+}
+
+// SetCounter sets the internal counter, whose high byte DIV reads, as the boot ROM leaves it.
+func (t *Timer) SetCounter(counter uint16) {
+	t.counter = counter
+}
+
+// signal returns the input of TIMA, which increments it when it falls.
+func (t *Timer) signal() bool {
+	return t.tac&tacEnable != 0 && t.counter&clockBits[t.tac&tacClock] != 0
+}
+
+// increment increments TIMA.
+func (t *Timer) increment() {
+	t.tima++
+	t.reload = t.tima == 0
+}
+
+// Step advances the timer by a number of clock cycles of the CPU, a multiple of 4.
+func (t *Timer) Step(cycles int) {
+	for ; cycles > 0; cycles -= 4 {
+		if t.reload {
+			t.reload = false
+			t.tima = t.tma
+			cpu.RequestInterrupt(t.mem, cpu.Timer)
+		}
+		before := t.signal()
+		t.counter += 4
+		if before && !t.signal() {
+			t.increment()
+		}
+	}
+}
+
+// Read implements cpu.Device.
+func (t *Timer) Read(addr uint16) uint8 {
+	switch addr {
+	case DIV:
+		return uint8(t.counter >> 8)
+	case TIMA:
+		return t.tima
+	case TMA:
+		return t.tma
+	default:
+		return 0xF8 | t.tac
+	}
+}
+
+// Write implements cpu.Device. Resetting DIV, or changing TAC, can increment TIMA, since its input may fall.
+func (t *Timer) Write(addr uint16, v uint8) {
+	before := t.signal()
+	switch addr {
+	case DIV:
+		t.counter = 0
+	case TIMA:
+		// writing TIMA cancels a pending reload
+		t.tima, t.reload = v, false
+	case TMA:
+		t.tma = v
+	default:
+		t.tac = v & (tacEnable | tacClock)
+	}
+	if before && !t.signal() {
+		t.increment()
+	}
+}
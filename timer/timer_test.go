@@ -0,0 +1,70 @@
+package timer
+
+import (
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTimer() (*Timer, *cpu.Memory) {
+	mem := cpu.NewMemory()
+	t := New(mem)
+	mem.Map(DIV, TAC, t)
+	return t, mem
+}
+
+func TestDivider(t *testing.T) {
+	tm, mem := newTimer()
+	tm.Step(255 * 4)
+	assert.Equal(t, uint8(3), mem.Read(DIV))
+	tm.SetCounter(0xAB00)
+	assert.Equal(t, uint8(0xAB), mem.Read(DIV))
+
+	mem.Write(DIV, 0x42)
+	assert.Equal(t, uint8(0), mem.Read(DIV))
+	assert.Equal(t, uint8(0xF8), mem.Read(TAC))
+}
+
+func TestCounter(t *testing.T) {
+	tm, mem := newTimer()
+	mem.Write(TMA, 0xFE)
+	mem.Write(TIMA, 0xFE)
+	mem.Write(TAC, tacEnable|1)
+	assert.Equal(t, uint8(0xFD), mem.Read(TAC))
+
+	// TIMA counts every 16 cycles at 262144Hz
+	tm.Step(16)
+	assert.Equal(t, uint8(0xFF), mem.Read(TIMA))
+	tm.Step(16)
+	assert.Equal(t, uint8(0), mem.Read(TIMA))
+	assert.Zero(t, mem.Read(cpu.IFAddr))
+
+	// it is reloaded from TMA, and requests an interrupt, 4 cycles after it overflows
+	tm.Step(4)
+	assert.Equal(t, uint8(0xFE), mem.Read(TIMA))
+	assert.Equal(t, uint8(cpu.Timer), mem.Read(cpu.IFAddr))
+
+	// resetting the divider when the selected bit is set increments it
+	tm.Step(8)
+	mem.Write(DIV, 0)
+	assert.Equal(t, uint8(0xFF), mem.Read(TIMA))
+
+	// and so does disabling it
+	tm.Step(8)
+	mem.Write(TAC, 1)
+	assert.Equal(t, uint8(0), mem.Read(TIMA))
+
+	// writing TIMA cancels the reload
+	mem.Write(TIMA, 0x12)
+	tm.Step(4)
+	assert.Equal(t, uint8(0x12), mem.Read(TIMA))
+
+	// the 4096Hz clock counts every 1024 cycles
+	mem.Write(DIV, 0)
+	mem.Write(TAC, tacEnable)
+	tm.Step(1020)
+	assert.Equal(t, uint8(0x12), mem.Read(TIMA))
+	tm.Step(4)
+	assert.Equal(t, uint8(0x13), mem.Read(TIMA))
+}
@@ -0,0 +1,247 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+)
+
+// registerNames are the names of the 8-bit registers of an Entry, in the order of a line.
+var registerNames = [8]string{"A", "F", "B", "C", "D", "E", "H", "L"}
+
+// Entry is a parsed line of a trace.
+type Entry struct {
+	// Line is the line number of the entry in its trace, from 1.
+	Line int
+
+	// Registers are A, F, B, C, D, E, H and L.
+	Registers [8]uint8
+	SP, PC    uint16
+	// PCMem are the four bytes at PC.
+	PCMem [4]byte
+}
+
+// Parse parses a line in the Doctor or Verbose format. Fields of the Verbose format are ignored, so that traces in
+// either format can be compared.
+func Parse(line string) (Entry, error) {
+	var e Entry
+	const all = 1<<11 - 1
+	seen := 0
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "A", "F", "B", "C", "D", "E", "H", "L":
+			i := strings.Index("AFBCDEHL", key)
+			e.Registers[i], err = parseHex8(value)
+			seen |= 1 << i
+		case "SP":
+			e.SP, err = parseHex16(value)
+			seen |= 1 << 8
+		case "PC":
+			e.PC, err = parseHex16(value)
+			seen |= 1 << 9
+		case "PCMEM":
+			values := strings.Split(value, ",")
+			if len(values) != len(e.PCMem) {
+				return e, fmt.Errorf("trace: PCMEM needs %d bytes: %q", len(e.PCMem), value)
+			}
+			for i, b := range values {
+				if e.PCMem[i], err = parseHex8(b); err != nil {
+					break
+				}
+			}
+			seen |= 1 << 10
+		}
+		if err != nil {
+			return e, fmt.Errorf("trace: bad %s: %q", key, value)
+		}
+	}
+	if seen != all {
+		return e, fmt.Errorf("trace: missing fields: %q", line)
+	}
+	return e, nil
+}
+
+func parseHex8(s string) (uint8, error) {
+	v, err := strconv.ParseUint(s, 16, 8)
+	return uint8(v), err
+}
+
+func parseHex16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 16, 16)
+	return uint16(v), err
+}
+
+// Equal reports whether two entries have the same state, regardless of their lines.
+func (e Entry) Equal(o Entry) bool {
+	return e.Registers == o.Registers && e.SP == o.SP && e.PC == o.PC && e.PCMem == o.PCMem
+}
+
+// String formats the entry in the Doctor format.
+func (e Entry) String() string {
+	var b strings.Builder
+	for i, name := range registerNames {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s:%02X", name, e.Registers[i])
+	}
+	fmt.Fprintf(&b, " SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X", e.SP, e.PC, e.PCMem[0], e.PCMem[1], e.PCMem[2],
+		e.PCMem[3])
+	return b.String()
+}
+
+// Instruction disassembles the instruction at PC.
+func (e Entry) Instruction() string {
+	if instr, err := asm.Decode(e.PCMem[:]); err == nil {
+		return instr.String()
+	}
+	return fmt.Sprintf("db $%02X", e.PCMem[0])
+}
+
+// deltas lists the registers that differ between two entries, as name:old>new, ignoring PC.
+func deltas(from, to Entry) []string {
+	var d []string
+	for i, name := range registerNames {
+		if from.Registers[i] != to.Registers[i] {
+			d = append(d, fmt.Sprintf("%s:%02X>%02X", name, from.Registers[i], to.Registers[i]))
+		}
+	}
+	if from.SP != to.SP {
+		d = append(d, fmt.Sprintf("SP:%04X>%04X", from.SP, to.SP))
+	}
+	return d
+}
+
+// Divergence is the first entry at which two traces differ.
+type Divergence struct {
+	// Context are the last entries before the divergence, which are the same in both traces, oldest first.
+	Context []Entry
+	// Ours and Reference are the divergent entries. One of them is nil if its trace ended first.
+	Ours, Reference *Entry
+}
+
+// Diff reads two traces until their first divergent entry, keeping the context entries before it. It returns nil if
+// the traces are the same.
+func Diff(ours, reference io.Reader, context int) (*Divergence, error) {
+	a, b := bufio.NewScanner(ours), bufio.NewScanner(reference)
+	recent := make([]Entry, 0, context)
+	for line := 1; ; line++ {
+		x, err := next(a, line)
+		if err != nil {
+			return nil, fmt.Errorf("ours: %w", err)
+		}
+		y, err := next(b, line)
+		if err != nil {
+			return nil, fmt.Errorf("reference: %w", err)
+		}
+		switch {
+		case x == nil && y == nil:
+			return nil, nil
+		case x == nil || y == nil || !x.Equal(*y):
+			return &Divergence{Context: recent, Ours: x, Reference: y}, nil
+		}
+
+		if context == 0 {
+			continue
+		}
+		if len(recent) == context {
+			copy(recent, recent[1:])
+			recent = recent[:context-1]
+		}
+		recent = append(recent, *x)
+	}
+}
+
+// next parses the next line of a trace, or returns nil at its end.
+func next(s *bufio.Scanner, line int) (*Entry, error) {
+	if !s.Scan() {
+		return nil, s.Err()
+	}
+	e, err := Parse(s.Text())
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	e.Line = line
+	return &e, nil
+}
+
+// String reports the divergence: the context entries with the registers each instruction changed, both divergent
+// entries with their differences marked, and the differences.
+func (d *Divergence) String() string {
+	var b strings.Builder
+	line := 1
+	switch {
+	case d.Ours != nil:
+		line = d.Ours.Line
+	case d.Reference != nil:
+		line = d.Reference.Line
+	}
+	fmt.Fprintf(&b, "traces diverge at line %d\n\n", line)
+
+	for i, e := range d.Context {
+		changes := ""
+		if i > 0 {
+			changes = strings.Join(deltas(d.Context[i-1], e), " ")
+		}
+		fmt.Fprintf(&b, "  %8d  %04X  %-18s %s\n", e.Line, e.PC, e.Instruction(), changes)
+	}
+	if len(d.Context) > 0 {
+		b.WriteByte('\n')
+	}
+
+	for _, side := range []struct {
+		name string
+		e    *Entry
+	}{{"ours", d.Ours}, {"reference", d.Reference}} {
+		if side.e == nil {
+			fmt.Fprintf(&b, "  %-9s (end of trace)\n", side.name)
+			continue
+		}
+		fmt.Fprintf(&b, "  %-9s %s  %s\n", side.name, side.e, side.e.Instruction())
+	}
+	if d.Ours == nil || d.Reference == nil {
+		return b.String()
+	}
+
+	// mark the values of the differing fields, which are aligned
+	x, y := strings.Fields(d.Ours.String()), strings.Fields(d.Reference.String())
+	var marks strings.Builder
+	for i := range x {
+		key, value, _ := strings.Cut(x[i], ":")
+		mark := " "
+		if x[i] != y[i] {
+			mark = "^"
+		}
+		marks.WriteString(strings.Repeat(" ", len(key)+1) + strings.Repeat(mark, len(value)) + " ")
+	}
+	fmt.Fprintf(&b, "  %-9s %s\n\n", "", strings.TrimRight(marks.String(), " "))
+
+	for i, name := range registerNames {
+		if v, w := d.Ours.Registers[i], d.Reference.Registers[i]; v != w {
+			fmt.Fprintf(&b, "  %s: $%02X, reference $%02X", name, v, w)
+			if name == "F" {
+				fmt.Fprintf(&b, " (ZNHC %04b, reference %04b)", v>>4, w>>4)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if d.Ours.SP != d.Reference.SP {
+		fmt.Fprintf(&b, "  SP: $%04X, reference $%04X\n", d.Ours.SP, d.Reference.SP)
+	}
+	if d.Ours.PC != d.Reference.PC {
+		fmt.Fprintf(&b, "  PC: $%04X, reference $%04X\n", d.Ours.PC, d.Reference.PC)
+	}
+	if d.Ours.PCMem != d.Reference.PCMem {
+		fmt.Fprintf(&b, "  PCMEM: %s, reference %s\n", d.Ours.Instruction(), d.Reference.Instruction())
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	e, err := Parse("A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01 CYC:0 BANK:00 NOP")
+	require.NoError(t, err)
+	assert.Equal(t, [8]uint8{0x01, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}, e.Registers)
+	assert.Equal(t, uint16(0xFFFE), e.SP)
+	assert.Equal(t, uint16(0x0100), e.PC)
+	assert.Equal(t, [4]byte{0x00, 0xC3, 0x50, 0x01}, e.PCMem)
+	assert.Equal(t, "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01", e.String())
+
+	for _, line := range []string{
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100",
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50",
+		"A:1FF F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01",
+		"",
+	} {
+		_, err := Parse(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	// our trace in the verbose format compares equal to the same trace in the Doctor format
+	trace := func(format Format) string {
+		m := testrig.Machine(t, testSource, testOptions)
+		var out bytes.Buffer
+		m.Tracer = New(&out, format)
+		for i := 0; i < 8; i++ {
+			_, err := m.Step()
+			require.NoError(t, err)
+		}
+		return out.String()
+	}
+	ours, reference := trace(Verbose), trace(Doctor)
+	d, err := Diff(strings.NewReader(ours), strings.NewReader(reference), 3)
+	require.NoError(t, err)
+	assert.Nil(t, d)
+
+	// the reference sets the carry flag and leaves B alone at line 7
+	wrong := strings.Replace(reference, "A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFC",
+		"A:02 F:90 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFC", 1)
+	d, err = Diff(strings.NewReader(ours), strings.NewReader(wrong), 3)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, 7, d.Ours.Line)
+	assert.Equal(t, `traces diverge at line 7
+
+         4  0152  LD [$2000], A      
+         5  0155  CALL $4000         
+         6  4000  LD B, $42          SP:FFFE>FFFC
+
+  ours      A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4002 PCMEM:C9,00,00,00  RET
+  reference A:02 F:90 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4002 PCMEM:C9,00,00,00  RET
+                   ^^   ^^
+
+  F: $B0, reference $90 (ZNHC 1011, reference 1001)
+  B: $42, reference $00
+`, d.String())
+
+	// a trace ending early diverges from a longer one
+	d, err = Diff(strings.NewReader(ours), strings.NewReader(reference[:strings.LastIndex(reference[:len(reference)-1], "\n")+1]), 0)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Nil(t, d.Reference)
+	assert.Equal(t, `traces diverge at line 8
+
+  ours      A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0158 PCMEM:18,FE,00,00  JR @
+  reference (end of trace)
+`, d.String())
+
+	_, err = Diff(strings.NewReader(ours), strings.NewReader("PC:0100\n"), 0)
+	assert.ErrorContains(t, err, "reference: line 1")
+}
@@ -0,0 +1,117 @@
+// Package trace logs the instructions a machine executes, one line per instruction, to compare its execution with
+// reference emulators.
+//
+// The Doctor format is the format of Gameboy Doctor, which many emulators can log:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01
+//
+// The Verbose format adds the clock cycles executed before the instruction, the ROM bank mapped into $4000-$7FFF and
+// the disassembled instruction:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01 CYC:0 BANK:01 NOP
+package trace
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/gopherpocket/gopherpocket/cpu/asm"
+	"github.com/gopherpocket/gopherpocket/machine"
+)
+
+// Format is the format of the lines of a trace.
+type Format int
+
+const (
+	// Doctor is the Gameboy Doctor format.
+	Doctor Format = iota
+	// Verbose is the Doctor format followed by the cycles, bank and instruction.
+	Verbose
+)
+
+// Logger writes a line to a writer for each instruction a machine executes. It traces a machine once set as its
+// Tracer, and stops when the Tracer is set back to nil.
+type Logger struct {
+	w      io.Writer
+	format Format
+	buf    []byte
+}
+
+// New constructs a new Logger writing lines in format to w. Each line is written with a single call to Write, so w
+// should usually be buffered.
+func New(w io.Writer, format Format) *Logger {
+	return &Logger{w: w, format: format}
+}
+
+// Trace implements machine.Tracer
+func (l *Logger) Trace(m *machine.Machine) error {
+	l.buf = Append(l.buf[:0], m, l.format)
+	l.buf = append(l.buf, '\n')
+	_, err := l.w.Write(l.buf)
+	return err
+}
+
+// Append appends the line of the next instruction of m in format to b, without a newline.
+func Append(b []byte, m *machine.Machine, format Format) []byte {
+	c := m.CPU
+	regs := [...]struct {
+		name  string
+		value uint8
+	}{
+		{"A:", c.AF.Hi()}, {" F:", c.AF.Lo()}, {" B:", c.BC.Hi()}, {" C:", c.BC.Lo()},
+		{" D:", c.DE.Hi()}, {" E:", c.DE.Lo()}, {" H:", c.HL.Hi()}, {" L:", c.HL.Lo()},
+	}
+	for _, r := range regs {
+		b = append(b, r.name...)
+		b = appendHex(b, uint64(r.value), 2)
+	}
+	b = append(b, " SP:"...)
+	b = appendHex(b, uint64(c.SP), 4)
+	b = append(b, " PC:"...)
+	b = appendHex(b, uint64(c.PC), 4)
+
+	var mem [4]byte
+	for i := range mem {
+		mem[i] = m.Memory.Peek(uint16(c.PC) + uint16(i))
+	}
+	b = append(b, " PCMEM:"...)
+	for i, v := range mem {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendHex(b, uint64(v), 2)
+	}
+	if format != Verbose {
+		return b
+	}
+
+	b = append(b, " CYC:"...)
+	b = strconv.AppendUint(b, m.Cycles, 10)
+	bank := 0
+	if c.PC >= 0x4000 && c.PC < 0x8000 {
+		bank = m.ROMBank()
+	}
+	b = append(b, " BANK:"...)
+	if bank > 0xFF {
+		b = appendHex(b, uint64(bank), 3)
+	} else {
+		b = appendHex(b, uint64(bank), 2)
+	}
+	b = append(b, ' ')
+	if instr, err := asm.Decode(mem[:]); err == nil {
+		b = append(b, instr.String()...)
+	} else {
+		b = append(b, "db $"...)
+		b = appendHex(b, uint64(mem[0]), 2)
+	}
+	return b
+}
+
+// appendHex appends v as upper case hexadecimal, zero padded to width digits.
+func appendHex(b []byte, v uint64, width int) []byte {
+	const digits = "0123456789ABCDEF"
+	for shift := 4 * (width - 1); shift >= 0; shift -= 4 {
+		b = append(b, digits[v>>shift&0xF])
+	}
+	return b
+}
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/gopherpocket/gopherpocket/cartridge"
+	"github.com/gopherpocket/gopherpocket/cpu/asm/link"
+	"github.com/gopherpocket/gopherpocket/internal/testrig"
+	"github.com/gopherpocket/gopherpocket/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = `
+SECTION "Header", ROM0[$100]
+	nop
+	jp Main
+
+SECTION "Main", ROM0[$150]
+Main:
+	ld a, BANK(Far)
+	ld [$2000], a
+	call Far
+.spin
+	jr .spin
+
+SECTION "Far", ROMX[$4000], BANK[2]
+Far:
+	ld b, $42
+	ret
+`
+
+// testOptions link testSource into the ROM of a cartridge with a memory bank controller.
+var testOptions = link.Options{Title: "TRACE", Type: cartridge.MBC1}
+
+func TestLogger(t *testing.T) {
+	for _, test := range []struct {
+		format Format
+		want   string
+	}{
+		{Doctor, `A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01
+A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,50,01,CE
+A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:3E,02,EA,00
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0152 PCMEM:EA,00,20,CD
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0155 PCMEM:CD,00,40,18
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4000 PCMEM:06,42,C9,00
+A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4002 PCMEM:C9,00,00,00
+A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0158 PCMEM:18,FE,00,00
+`},
+		{Verbose, `A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01 CYC:0 BANK:00 NOP
+A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,50,01,CE CYC:4 BANK:00 JP $150
+A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:3E,02,EA,00 CYC:20 BANK:00 LD A, $2
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0152 PCMEM:EA,00,20,CD CYC:28 BANK:00 LD [$2000], A
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0155 PCMEM:CD,00,40,18 CYC:44 BANK:00 CALL $4000
+A:02 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4000 PCMEM:06,42,C9,00 CYC:68 BANK:02 LD B, $42
+A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:4002 PCMEM:C9,00,00,00 CYC:76 BANK:02 RET
+A:02 F:B0 B:42 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0158 PCMEM:18,FE,00,00 CYC:92 BANK:00 JR @
+`},
+	} {
+		m := testrig.Machine(t, testSource, testOptions)
+		var out bytes.Buffer
+		m.Tracer = New(&out, test.format)
+		for i := 0; i < 8; i++ {
+			_, err := m.Step()
+			require.NoError(t, err)
+		}
+
+		// tracing stops when the tracer is removed
+		m.Tracer = nil
+		_, err := m.Step()
+		require.NoError(t, err)
+		assert.Equal(t, test.want, out.String())
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		tracer machine.Tracer
+	}{
+		{"Off", nil},
+		{"Doctor", New(io.Discard, Doctor)},
+		{"Verbose", New(io.Discard, Verbose)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			m := testrig.Machine(b, testSource, testOptions)
+			m.Tracer = bench.tracer
+			for i := 0; i < b.N; i++ {
+				_, _ = m.Step()
+			}
+		})
+	}
+}